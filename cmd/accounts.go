@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/auth"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// accountsCmd represents the accounts command
+	accountsCmd = &cobra.Command{
+		Use:   "accounts",
+		Short: "Manage the Google accounts that are logged in",
+	}
+
+	// accountsListCmd represents the accounts list command
+	accountsListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the logged in Google accounts by name",
+		Run: func(cmd *cobra.Command, args []string) {
+			accounts, err := auth.Accounts()
+			if err != nil {
+				log.Fatalf("Unable to list accounts: %v", err)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tACCESS")
+			for _, account := range accounts {
+				access := "read-write"
+				switch {
+				case account.Legacy:
+					access = "login " + account.Name
+				case account.ReadOnly:
+					access = "read-only"
+				}
+				fmt.Fprintf(w, "%s\t%s\n", account.Name, access)
+			}
+			w.Flush()
+		},
+	}
+
+	// accountsRemoveCmd represents the accounts remove command
+	accountsRemoveCmd = &cobra.Command{
+		Use:   "remove <name>",
+		Short: "Forget an account's token without revoking it",
+		Long:  `Forget an account's token without revoking it. Use 'logout' to also revoke the token with Google.`,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := auth.RemoveAccount(args[0]); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("Removed account %s\n", args[0])
+		},
+	}
+)
+
+func init() {
+	accountsCmd.AddCommand(accountsListCmd)
+	accountsCmd.AddCommand(accountsRemoveCmd)
+	RootCmd.AddCommand(accountsCmd)
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/audit"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/config"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// auditCmd represents the audit command
+	auditCmd = &cobra.Command{
+		Use:   "audit",
+		Short: "Inspect the log of every change made to the calendars",
+	}
+
+	// auditShowCmd represents the audit show command
+	auditShowCmd = &cobra.Command{
+		Use:   "show",
+		Short: "Show the changes made to the calendars since a point in time",
+		Long: `Show the inserts, updates and deletes made to the calendars, oldest first, including the ones that failed.
+
+--since takes a duration back from now, like 24h or 7d, a date like 2026-03-10, or a time like 2026-03-10T09:00:00Z.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			sinceValue, err := cmd.Flags().GetString("since")
+			if err != nil {
+				log.Fatalf("Error parsing arg since: %v", err)
+			}
+			asJSON, err := cmd.Flags().GetBool("json")
+			if err != nil {
+				log.Fatalf("Error parsing arg json: %v", err)
+			}
+			since, err := parseSince(sinceValue, time.Now())
+			if err != nil {
+				log.Fatalf("Invalid value for --since: %v", err)
+			}
+
+			entries, err := openAudit().Read(since)
+			if err != nil {
+				log.Fatalf("Unable to read the audit log: %v", err)
+			}
+			if asJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				for _, entry := range entries {
+					encoder.Encode(entry)
+				}
+				return
+			}
+			if len(entries) == 0 {
+				fmt.Printf("No changes since %s\n", since.Local().Format(time.DateTime))
+				return
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "TIME\tACTION\tACCOUNT\tCALENDAR\tEVENT ID\tSOURCE HASH\tRESULT")
+			for _, entry := range entries {
+				result := entry.Result
+				if entry.Error != "" {
+					result += ": " + entry.Error
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", entry.Time.Local().Format(time.DateTime), entry.Action, entry.Account, entry.Calendar,
+					entry.EventId, entry.SourceIdHash, result)
+			}
+			w.Flush()
+		},
+	}
+)
+
+// openAudit returns the audit log in the config directory.
+func openAudit() *audit.Log {
+	return audit.Open(config.Path("audit.jsonl"))
+}
+
+// auditChanges makes the sync client log its changes, naming the accounts
+// chosen with the flags the command has.
+func auditChanges(cmd *cobra.Command, syncClient *sync.SyncClient) {
+	syncClient.Audit = openAudit()
+	if cmd.Flags().Lookup("destination-mode") != nil {
+		syncClient.DestinationAccount = destinationAccount(cmd)
+	}
+	if cmd.Flags().Lookup("source-account") != nil {
+		syncClient.SourceAccount = sourceAccount(cmd)
+	}
+}
+
+func destinationAccount(cmd *cobra.Command) string {
+	destinationMode, err := cmd.Flags().GetString("destination-mode")
+	if err != nil {
+		log.Fatalf("Error parsing arg destination-mode: %v", err)
+	}
+	flag := ""
+	switch destinationMode {
+	case "google":
+		flag = "destination-account"
+	case "service-account":
+		flag = "impersonate"
+	default:
+		return destinationMode
+	}
+	account, err := cmd.Flags().GetString(flag)
+	if err != nil {
+		log.Fatalf("Error parsing arg %s: %v", flag, err)
+	}
+	return account
+}
+
+func sourceAccount(cmd *cobra.Command) string {
+	if cmd.Flags().Lookup("source-mode") != nil {
+		sourceMode, err := cmd.Flags().GetString("source-mode")
+		if err != nil {
+			log.Fatalf("Error parsing arg source-mode: %v", err)
+		}
+		if sourceMode != "events" && sourceMode != "freebusy" {
+			return sourceMode
+		}
+	}
+	account, err := cmd.Flags().GetString("source-account")
+	if err != nil {
+		log.Fatalf("Error parsing arg source-account: %v", err)
+	}
+	return account
+}
+
+// parseSince reads a duration back from now, with d for days, a date or a
+// time.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+	if date, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return date, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a duration like 24h or 7d, a date nor an RFC 3339 time", value)
+}
+
+func init() {
+	auditShowCmd.Flags().String("since", "24h", "Show the changes since this long ago, or since a date or time")
+	auditShowCmd.Flags().Bool("json", false, "Print the entries as JSON Lines")
+	auditCmd.AddCommand(auditShowCmd)
+	RootCmd.AddCommand(auditCmd)
+}
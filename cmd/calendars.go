@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/auth"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// calendarsCmd represents the calendars command
+	calendarsCmd = &cobra.Command{
+		Use:   "calendars",
+		Short: "Inspect the calendars of the logged in accounts",
+	}
+
+	// calendarsListCmd represents the calendars list command
+	calendarsListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the calendars of a logged in Google account",
+		Long: `List the calendars of a Google account with their IDs, names and your access to them.
+
+Either the ID or the name can be passed to --source-calendar and --destination-calendar. The destination calendar needs writer or owner access.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			service := googleService(cmd, "account")
+			entries, err := service.(sync.CalendarListService).ListCalendars()
+			if err != nil {
+				log.Fatalf("Unable to list calendars: %v", err)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tNAME\tACCESS\tPRIMARY")
+			for _, entry := range entries {
+				primary := ""
+				if entry.Primary {
+					primary = "yes"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.Id, sync.CalendarName(entry), entry.AccessRole, primary)
+			}
+			w.Flush()
+		},
+	}
+)
+
+func init() {
+	calendarsListCmd.Flags().String("account", auth.SourceAccount, "Account whose calendars to list, a name from 'accounts list'")
+	calendarsCmd.AddCommand(calendarsListCmd)
+	RootCmd.AddCommand(calendarsCmd)
+}
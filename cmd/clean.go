@@ -19,12 +19,27 @@ var cleanCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalf("Error parsing arg dry-run: %v", err)
 		}
-		syncClient := sync.NewSyncClient()
-		syncClient.Clean(dryRun)
+		syncClient := &sync.SyncClient{DestinationCalendarService: destinationService(cmd), Logger: logger}
+		syncClient.DestinationCalendarId = calendarId(cmd, "destination-calendar", syncClient.DestinationCalendarService)
+		auditChanges(cmd, syncClient)
+		release := lockSync(cmd, syncClient)
+		store := openState(cmd, syncClient)
+		err = syncClient.Clean(dryRun)
+		if store != nil {
+			store.Close()
+		}
+		release()
+		flushTraces()
+		if err != nil {
+			log.Fatal(err)
+		}
 	},
 }
 
 func init() {
 	cleanCmd.Flags().Bool("dry-run", false, "Print out the created events instead of writing them to the destination calendar")
+	addDestinationFlags(cleanCmd)
+	addStateFlags(cleanCmd)
+	addLockFlags(cleanCmd)
 	RootCmd.AddCommand(cleanCmd)
 }
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/config"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/state"
+	"github.com/spf13/cobra"
+	"google.golang.org/api/calendar/v3"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history [run-id]",
+	Short: "List the past runs that changed blocks, or the changes of one run",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := state.Open(config.Path("state.db"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer store.Close()
+
+		if len(args) == 1 {
+			printRun(store, parseRunId(args[0]))
+			return
+		}
+
+		limit, err := cmd.Flags().GetInt("limit")
+		if err != nil {
+			log.Fatalf("Error parsing arg limit: %v", err)
+		}
+		history, err := store.History("")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(history) == 0 {
+			fmt.Println("No runs recorded yet")
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "RUN\tSTARTED\tCOMMAND\tCALENDAR\tCREATED\tUPDATED\tDELETED\tSTATUS")
+		for _, run := range history[:min(limit, len(history))] {
+			counts := map[string]int{}
+			for _, change := range run.Changes {
+				counts[change.Kind]++
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%d\t%d\t%s\n", run.ID, run.Started.Local().Format(time.DateTime), run.Command, run.CalendarKey,
+				counts[state.OperationInsert], counts[state.OperationPatch], counts[state.OperationDelete], runStatus(run))
+		}
+		w.Flush()
+	},
+}
+
+// printRun prints the changes a run made.
+func printRun(store *state.Store, runId uint64) {
+	run, err := store.Run(runId)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if run == nil {
+		log.Fatalf("Run %d isn't in the history", runId)
+	}
+	fmt.Printf("Run %d: %s on %s at %s, %s\n\n", run.ID, run.Command, run.CalendarKey, run.Started.Local().Format(time.DateTime), runStatus(run))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANGE\tEVENT ID\tTITLE\tBEFORE\tAFTER")
+	for _, change := range run.Changes {
+		before, after := changeEvent(change.Before), changeEvent(change.After)
+		title := ""
+		for _, event := range []*calendar.Event{after, before} {
+			if event != nil && title == "" {
+				title = event.Summary
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", change.Kind, change.EventId, title, eventTimeRange(before), eventTimeRange(after))
+	}
+	w.Flush()
+}
+
+func runStatus(run *state.Run) string {
+	switch {
+	case run.UndoneBy != 0:
+		return fmt.Sprintf("undone by %d", run.UndoneBy)
+	case run.Error != "":
+		return "failed: " + run.Error
+	case run.Finished.IsZero():
+		return "unfinished"
+	default:
+		return "ok"
+	}
+}
+
+func changeEvent(data json.RawMessage) *calendar.Event {
+	if len(data) == 0 {
+		return nil
+	}
+	event := &calendar.Event{}
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil
+	}
+	return event
+}
+
+func eventTimeRange(event *calendar.Event) string {
+	if event == nil || event.Start == nil || event.End == nil {
+		return "-"
+	}
+	if event.Start.DateTime == "" {
+		return event.Start.Date + " - " + event.End.Date
+	}
+	return event.Start.DateTime + " - " + event.End.DateTime
+}
+
+func parseRunId(arg string) uint64 {
+	runId, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil || runId == 0 {
+		log.Fatalf("Invalid run ID %q, see 'history' for the runs", arg)
+	}
+	return runId
+}
+
+func init() {
+	historyCmd.Flags().Int("limit", 20, "Number of runs to list")
+	RootCmd.AddCommand(historyCmd)
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/config"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/lock"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+// defaultLockWait is how long to wait for another sync without --wait or
+// --no-wait, long enough for a scheduled sync to finish.
+const defaultLockWait = time.Minute
+
+// lockSync keeps other syncs from running until the returned function is
+// called: syncs on this machine with a lock file in the config directory, and
+// syncs elsewhere with a lease on the destination calendar if --lease is set.
+func lockSync(cmd *cobra.Command, syncClient *sync.SyncClient) func() {
+	wait := lockWait(cmd)
+	fileLock, err := lock.Acquire(config.Path("sync.lock"), cmd.CommandPath(), wait)
+	var held *lock.HeldError
+	if errors.As(err, &held) {
+		log.Fatalf("Another sync is running: %v. Pass --wait to wait for it to finish", err)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if fileLock.Stale != nil {
+		logger.Warn("A sync stopped without finishing, run 'repair' if blocks look wrong", "sync", fileLock.Stale)
+	}
+
+	useLease, err := cmd.Flags().GetBool("lease")
+	if err != nil {
+		log.Fatalf("Error parsing arg lease: %v", err)
+	}
+	if !useLease {
+		return func() { fileLock.Release() }
+	}
+	if destinationMode, _ := cmd.Flags().GetString("destination-mode"); destinationMode == "ics" {
+		log.Fatal("--lease needs a destination calendar, an iCalendar feed is only written on this machine")
+	}
+	ttl, err := cmd.Flags().GetDuration("lease-ttl")
+	if err != nil {
+		log.Fatalf("Error parsing arg lease-ttl: %v", err)
+	}
+	host, _ := os.Hostname()
+	lease, err := syncClient.AcquireLease(fmt.Sprintf("%s/%d", host, os.Getpid()), ttl, wait)
+	if err != nil {
+		fileLock.Release()
+		log.Fatalf("Unable to lease the destination calendar: %v", err)
+	}
+	return func() {
+		if err := lease.Release(); err != nil {
+			logger.Warn("Unable to release the lease", "expires_in", ttl, "error", err)
+		}
+		fileLock.Release()
+	}
+}
+
+// lockWait returns how long to wait for another sync, negative for as long as
+// it takes.
+func lockWait(cmd *cobra.Command) time.Duration {
+	wait, err := cmd.Flags().GetBool("wait")
+	if err != nil {
+		log.Fatalf("Error parsing arg wait: %v", err)
+	}
+	noWait, err := cmd.Flags().GetBool("no-wait")
+	if err != nil {
+		log.Fatalf("Error parsing arg no-wait: %v", err)
+	}
+	switch {
+	case wait:
+		return -1
+	case noWait:
+		return 0
+	default:
+		return defaultLockWait
+	}
+}
+
+// addLockFlags adds the flags read by lockSync.
+func addLockFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("wait", false, "Wait for another sync to finish however long it takes, instead of up to a minute")
+	cmd.Flags().Bool("no-wait", false, "Fail right away if another sync is running")
+	cmd.MarkFlagsMutuallyExclusive("wait", "no-wait")
+	cmd.Flags().Bool("lease", false, "Also hold a lease on the destination calendar, for syncing it from several machines")
+	cmd.Flags().Duration("lease-ttl", 15*time.Minute, "How long a lease lasts without being renewed, after which a sync that didn't release it is taken over")
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"log"
+	"log/slog"
+	"os"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/auth"
+	"github.com/spf13/cobra"
+)
+
+// logger is the logger of the command, set up from --log-level, --log-format
+// and --quiet. Logs go to stderr, so they don't mix with the output of the
+// command.
+var logger = slog.Default()
+
+// setupLogging sets up logger and makes it the default, for the packages that
+// log without one being passed to them.
+func setupLogging(cmd *cobra.Command) {
+	levelName, err := cmd.Flags().GetString("log-level")
+	if err != nil {
+		log.Fatalf("Error parsing arg log-level: %v", err)
+	}
+	format, err := cmd.Flags().GetString("log-format")
+	if err != nil {
+		log.Fatalf("Error parsing arg log-format: %v", err)
+	}
+	quiet, err := cmd.Flags().GetBool("quiet")
+	if err != nil {
+		log.Fatalf("Error parsing arg quiet: %v", err)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(levelName)); err != nil {
+		log.Fatalf("Unknown log level %q, expected debug, info, warn or error", levelName)
+	}
+	if quiet {
+		level = slog.LevelError
+	}
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		log.Fatalf("Unknown log format %q, expected text or json", format)
+	}
+
+	logger = slog.New(handler)
+	slog.SetDefault(logger)
+	// All that's left going through the log package are the errors commands
+	// exit on
+	slog.SetLogLoggerLevel(slog.LevelError)
+	auth.SetLogger(logger)
+}
+
+func init() {
+	RootCmd.PersistentFlags().String("log-level", "info", "Log messages of this level and up: debug, info, warn or error. Titles of source events are only logged at debug")
+	RootCmd.PersistentFlags().String("log-format", "text", "Log as text or json")
+	RootCmd.PersistentFlags().BoolP("quiet", "q", false, "Only log errors, e.g. when running from cron")
+}
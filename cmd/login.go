@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
+	"log"
+	"os"
+	"strings"
 
 	"github.com/davidpimentel/gcal-busy-blocker/internal/auth"
 	"github.com/spf13/cobra"
@@ -20,8 +24,15 @@ var (
 		Use:   "source",
 		Short: "Login to source Google Calendar",
 		Run: func(cmd *cobra.Command, args []string) {
+			if loginCalDAV(cmd, "source") {
+				return
+			}
+			write, err := cmd.Flags().GetBool("write")
+			if err != nil {
+				log.Fatalf("Error parsing arg write: %v", err)
+			}
 			fmt.Println("Authenticating source calendar account...")
-			auth.GetSourceTokenFromWeb()
+			auth.GetSourceTokenFromWeb(write)
 		},
 	}
 
@@ -30,14 +41,105 @@ var (
 		Use:   "destination",
 		Short: "Login to destination Google Calendar",
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Println("Authenticating destination calendar account...")
-			auth.GetDestinationTokenFromWeb()
+			if loginCalDAV(cmd, "destination") {
+				return
+			}
+			provider, err := cmd.Flags().GetString("provider")
+			if err != nil {
+				log.Fatalf("Error parsing arg provider: %v", err)
+			}
+			switch provider {
+			case "google":
+				fmt.Println("Authenticating destination calendar account...")
+				auth.GetDestinationTokenFromWeb()
+			case "microsoft":
+				clientId, err := cmd.Flags().GetString("client-id")
+				if err != nil {
+					log.Fatalf("Error parsing arg client-id: %v", err)
+				}
+				tenant, err := cmd.Flags().GetString("tenant")
+				if err != nil {
+					log.Fatalf("Error parsing arg tenant: %v", err)
+				}
+				if clientId == "" {
+					log.Fatal("Logging in to Microsoft needs the application (client) ID of your app registration, set it with --client-id")
+				}
+				fmt.Println("Authenticating destination Microsoft 365 account...")
+				auth.GetMicrosoftDestinationTokenFromWeb(clientId, tenant)
+			default:
+				log.Fatalf("Unknown provider %q, expected google or microsoft", provider)
+			}
+		},
+	}
+
+	// loginAddCmd represents the login add command
+	loginAddCmd = &cobra.Command{
+		Use:   "add <name>",
+		Short: "Login to a Google account and save it under a name",
+		Long: `Login to a Google account and save it under a name, e.g. personal, family or work-acme.
+
+Named accounts can be used as either side of a sync with --source-account and --destination-account. They're allowed to write events unless --read-only is set, in which case they can only be used as sources.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			readOnly, err := cmd.Flags().GetBool("read-only")
+			if err != nil {
+				log.Fatalf("Error parsing arg read-only: %v", err)
+			}
+			fmt.Printf("Authenticating account %s...\n", args[0])
+			if err := auth.GetAccountTokenFromWeb(args[0], readOnly); err != nil {
+				log.Fatal(err)
+			}
 		},
 	}
 )
 
+// loginCalDAV saves CalDAV credentials for the account when --caldav-url is
+// set, asking for the password on stdin. It returns false if the account is a
+// Google one.
+func loginCalDAV(cmd *cobra.Command, account string) bool {
+	calendarURL, err := cmd.Flags().GetString("caldav-url")
+	if err != nil {
+		log.Fatalf("Error parsing arg caldav-url: %v", err)
+	}
+	if calendarURL == "" {
+		return false
+	}
+	username, err := cmd.Flags().GetString("caldav-username")
+	if err != nil {
+		log.Fatalf("Error parsing arg caldav-username: %v", err)
+	}
+	if username == "" {
+		log.Fatal("A CalDAV login needs --caldav-username")
+	}
+
+	fmt.Printf("Enter the password (or app password) for %s:\n", username)
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		log.Fatalf("Unable to read password: %v", err)
+	}
+	err = auth.SaveCalDAVCredentials(account, &auth.CalDAVCredentials{
+		CalendarURL: calendarURL,
+		Username:    username,
+		Password:    strings.TrimRight(password, "\r\n"),
+	})
+	if err != nil {
+		log.Fatalf("Unable to save CalDAV credentials: %v", err)
+	}
+	return true
+}
+
 func init() {
+	loginSourceCmd.Flags().Bool("write", false, "Also allow modifying source events, which the mark command needs")
+	loginDestinationCmd.Flags().String("provider", "google", "Calendar provider of the destination account: google or microsoft")
+	loginDestinationCmd.Flags().String("client-id", "", "Application (client) ID of the Entra ID app registration, for the microsoft provider")
+	loginDestinationCmd.Flags().String("tenant", "common", "Entra ID tenant to sign in to, for the microsoft provider")
+	for _, cmd := range []*cobra.Command{loginSourceCmd, loginDestinationCmd} {
+		cmd.Flags().String("caldav-url", "", "Log in to a CalDAV calendar (e.g. Fastmail or Nextcloud) at this collection URL instead of Google")
+		cmd.Flags().String("caldav-username", "", "Username for the CalDAV calendar")
+	}
 	RootCmd.AddCommand(loginCmd)
 	loginCmd.AddCommand(loginSourceCmd)
 	loginCmd.AddCommand(loginDestinationCmd)
+	loginAddCmd.Flags().Bool("read-only", false, "Only allow reading events, the account can then only be used as a source")
+	loginCmd.AddCommand(loginAddCmd)
 }
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/auth"
+	"github.com/spf13/cobra"
+)
+
+// logoutCmd represents the logout command
+var logoutCmd = &cobra.Command{
+	Use:   "logout <name>",
+	Short: "Revoke a Google account's token and remove it",
+	Long:  `Revoke a Google account's token with Google and remove it. The name is one from 'accounts list', including source and destination.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := auth.Logout(args[0]); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Logged out of account %s\n", args[0])
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(logoutCmd)
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+// markCmd represents the mark command
+var markCmd = &cobra.Command{
+	Use:   "mark <event-id> block|noblock|clear",
+	Short: "Force a source event to be blocked or not blocked, regardless of the rules",
+	Long: `Set a marker on a single source event. "block" always blocks the event, "noblock" never does and "clear" removes the marker so the rules apply again.
+
+Event IDs are listed by 'rules test'. Marking events needs write access to the source calendar, see 'login source --write'.`,
+	Args:      cobra.ExactArgs(2),
+	ValidArgs: []string{sync.MarkerBlock, sync.MarkerNoBlock, "clear"},
+	Run: func(cmd *cobra.Command, args []string) {
+		eventId, marker := args[0], args[1]
+		if marker == "clear" {
+			marker = ""
+		}
+
+		syncClient := &sync.SyncClient{SourceCalendarService: googleService(cmd, "source-account"), Logger: logger}
+		syncClient.SourceCalendarId = calendarId(cmd, "source-calendar", syncClient.SourceCalendarService)
+		auditChanges(cmd, syncClient)
+		err := syncClient.MarkSourceEvent(eventId, marker)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Updated marker on event %s\n", eventId)
+	},
+}
+
+func init() {
+	addSourceFlags(markCmd)
+	RootCmd.AddCommand(markCmd)
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/metrics"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/tracing"
+	"github.com/spf13/cobra"
+	"golang.org/x/oauth2"
+)
+
+// syncMetrics measures the syncs of this process and the API errors of the
+// clients made for them.
+var syncMetrics = metrics.NewSyncMetrics()
+
+// tokenSources are the token sources of the clients made, by account, for
+// reporting when their tokens expire.
+var tokenSources = map[string]oauth2.TokenSource{}
+
+// instrumentClient counts the API errors of an account's client and traces
+// its token refreshes.
+func instrumentClient(account string, client *http.Client) *http.Client {
+	tracing.Instrument(account, client)
+	if source := syncMetrics.Instrument(client); source != nil {
+		tokenSources[account] = source
+	}
+	return client
+}
+
+// metricsTextfile returns the file set with --metrics-textfile, or "" if
+// there isn't one. The times of the last successful syncs are read back from
+// it, so a failed sync doesn't lose them.
+func metricsTextfile(cmd *cobra.Command) string {
+	path, err := cmd.Flags().GetString("metrics-textfile")
+	if err != nil {
+		log.Fatalf("Error parsing arg metrics-textfile: %v", err)
+	}
+	if path == "" {
+		return ""
+	}
+	if err := syncMetrics.RestoreLastSuccess(path); err != nil {
+		logger.Warn("Unable to read the previous metrics", "error", err)
+	}
+	return path
+}
+
+// writeMetricsTextfile writes the metrics to the file returned by
+// metricsTextfile, if any.
+func writeMetricsTextfile(path string) {
+	if path == "" {
+		return
+	}
+	for account, source := range tokenSources {
+		syncMetrics.ObserveToken(account, source)
+	}
+	if err := syncMetrics.WriteTextfile(path); err != nil {
+		logger.Warn("Unable to write the metrics", "file", path, "error", err)
+	}
+}
+
+// addMetricsFlags adds the flags read by metricsTextfile.
+func addMetricsFlags(cmd *cobra.Command) {
+	cmd.Flags().String("metrics-textfile", "", "Write Prometheus metrics of the sync to this file for the node exporter's textfile collector, e.g. /var/lib/node_exporter/gcal-busy-blocker.prom")
+}
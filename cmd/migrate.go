@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Rewrite the blocks on the destination calendar to the current metadata schema",
+	Long: `Blocks carry the version of the metadata they were written with. When a new version of gcal-busy-blocker changes that metadata, migrate rewrites the existing blocks so they keep being recognized.
+
+Syncs refuse to run on blocks written by a newer version, upgrade instead. Run it with --dry-run first to see what would change.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatalf("Error parsing arg dry-run: %v", err)
+		}
+		syncClient := &sync.SyncClient{DestinationCalendarService: destinationService(cmd), Logger: logger}
+		syncClient.DestinationCalendarId = calendarId(cmd, "destination-calendar", syncClient.DestinationCalendarService)
+		auditChanges(cmd, syncClient)
+		release := lockSync(cmd, syncClient)
+		store := openState(cmd, syncClient)
+		migrated, err := syncClient.Migrate(dryRun)
+		if store != nil {
+			store.Close()
+		}
+		release()
+		flushTraces()
+		if err != nil {
+			log.Fatal(err)
+		}
+		switch {
+		case migrated == 0:
+			fmt.Println("All blocks are up to date")
+		case dryRun:
+			fmt.Printf("%d blocks would be migrated, run without --dry-run to migrate them\n", migrated)
+		default:
+			fmt.Printf("Migrated %d blocks\n", migrated)
+		}
+	},
+}
+
+func init() {
+	migrateCmd.Flags().Bool("dry-run", false, "Show the blocks that would be migrated without changing anything")
+	addDestinationFlags(migrateCmd)
+	addStateFlags(migrateCmd)
+	addLockFlags(migrateCmd)
+	RootCmd.AddCommand(migrateCmd)
+}
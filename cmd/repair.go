@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// repairCmd represents the repair command
+var repairCmd = &cobra.Command{
+	Use:   "repair",
+	Short: "Find and fix duplicate, orphaned and misplaced blocks on the destination calendar",
+	Long: `Checks the blocks on the destination calendar against the source events and fixes:
+
+  duplicate  more than one block for the same source event, which are deleted
+  orphaned   blocks whose properties were lost or garbled, which are deleted
+  diverged   blocks whose times differ from their source event, which are moved
+
+Run it with --dry-run first to see what would change.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		daysAhead, err := cmd.Flags().GetInt("days-ahead")
+		if err != nil {
+			log.Fatalf("Error parsing arg days-ahead: %v", err)
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatalf("Error parsing arg dry-run: %v", err)
+		}
+		skipBusy, err := cmd.Flags().GetBool("skip-busy")
+		if err != nil {
+			log.Fatalf("Error parsing arg skip-busy: %v", err)
+		}
+		loc := location(cmd)
+		syncClient := newSyncClient(cmd, loc)
+		syncClient.Location = loc
+		syncClient.Rules = loadRules(cmd)
+		syncClient.SkipBusy = skipBusy
+		release := lockSync(cmd, syncClient)
+		store := openState(cmd, syncClient)
+
+		problems, err := syncClient.Repair(daysAhead, dryRun)
+		if store != nil {
+			store.Close()
+		}
+		release()
+		flushTraces()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(problems) == 0 {
+			fmt.Println("No problems found")
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PROBLEM\tBLOCK ID\tSTART\tDETAIL")
+		for _, problem := range problems {
+			start := problem.Block.Start.DateTime
+			if start == "" {
+				start = problem.Block.Start.Date
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", problem.Problem, problem.Block.Id, start, problem.Detail)
+		}
+		w.Flush()
+		if dryRun {
+			fmt.Println("Nothing was changed, run without --dry-run to fix these")
+		}
+	},
+}
+
+func init() {
+	repairCmd.Flags().Bool("dry-run", false, "Show the problems and how they would be fixed without changing anything")
+	repairCmd.Flags().IntP("days-ahead", "d", 30, "Specify how many days into the future to check")
+	repairCmd.Flags().String("tz", "", "IANA time zone (e.g. America/New_York) used for the sync window and block times, defaults to the system zone")
+	repairCmd.Flags().Bool("skip-busy", false, "The blocks were synced with --skip-busy, so a source event can have several blocks")
+	repairCmd.Flags().String("rules", "", "Path to the rules file, defaults to rules.json in the config directory")
+	addSourceModeFlags(repairCmd)
+	addDestinationFlags(repairCmd)
+	addStateFlags(repairCmd)
+	addLockFlags(repairCmd)
+	RootCmd.AddCommand(repairCmd)
+}
@@ -24,4 +24,9 @@ func Execute() {
 
 func init() {
 	RootCmd.CompletionOptions.DisableDefaultCmd = true
+	RootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		setupLogging(cmd)
+		setupTracing(cmd)
+	}
+	RootCmd.PersistentPostRun = func(cmd *cobra.Command, args []string) { flushTraces() }
 }
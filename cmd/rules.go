@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/config"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+const defaultRulesFile = "rules.json"
+
+var (
+	// rulesCmd represents the rules command
+	rulesCmd = &cobra.Command{
+		Use:   "rules",
+		Short: "Inspect the rules deciding which source events are blocked",
+	}
+
+	// rulesTestCmd represents the rules test command
+	rulesTestCmd = &cobra.Command{
+		Use:   "test",
+		Short: "Show which rule matches each upcoming source event",
+		Run: func(cmd *cobra.Command, args []string) {
+			daysAhead, err := cmd.Flags().GetInt("days-ahead")
+			if err != nil {
+				log.Fatalf("Error parsing arg days-ahead: %v", err)
+			}
+			syncClient := &sync.SyncClient{SourceCalendarService: googleService(cmd, "source-account"), Logger: logger}
+			syncClient.SourceCalendarId = calendarId(cmd, "source-calendar", syncClient.SourceCalendarService)
+			syncClient.Rules = loadRules(cmd)
+
+			results, err := syncClient.TestRules(daysAhead)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "START\tEVENT ID\tTITLE\tRULE\tACTION")
+			for _, result := range results {
+				start := result.Event.Start.DateTime
+				if start == "" {
+					start = result.Event.Start.Date
+				}
+				ruleName, action := "(none)", sync.ActionInclude
+				if result.Rule != nil {
+					ruleName, action = result.Rule.Name, result.Rule.Action
+				}
+				switch result.Marker {
+				case sync.MarkerBlock:
+					action = sync.ActionInclude + " (#block)"
+				case sync.MarkerNoBlock:
+					action = sync.ActionExclude + " (#noblock)"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", start, result.Event.Id, result.Event.Summary, ruleName, action)
+			}
+			w.Flush()
+		},
+	}
+)
+
+// loadRules reads the rules file passed with --rules, falling back to the one
+// in the config directory if it exists.
+func loadRules(cmd *cobra.Command) *sync.Rules {
+	path, err := cmd.Flags().GetString("rules")
+	if err != nil {
+		log.Fatalf("Error parsing arg rules: %v", err)
+	}
+	if path == "" {
+		path = config.Path(defaultRulesFile)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+	}
+
+	rules, err := sync.LoadRules(path)
+	if err != nil {
+		log.Fatalf("Unable to load rules: %v", err)
+	}
+	return rules
+}
+
+func init() {
+	rulesTestCmd.Flags().IntP("days-ahead", "d", 30, "Specify how many days into the future to check")
+	rulesTestCmd.Flags().String("rules", "", "Path to the rules file, defaults to rules.json in the config directory")
+	addSourceFlags(rulesTestCmd)
+	rulesCmd.AddCommand(rulesTestCmd)
+	RootCmd.AddCommand(rulesCmd)
+}
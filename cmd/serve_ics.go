@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/ics"
+	"github.com/spf13/cobra"
+)
+
+var serveICSCmd = &cobra.Command{
+	Use:   "serve-ics",
+	Short: "Serve the iCalendar feed written by 'sync --destination-mode ics' over HTTP",
+	Run: func(cmd *cobra.Command, args []string) {
+		addr, err := cmd.Flags().GetString("addr")
+		if err != nil {
+			log.Fatalf("Error parsing arg addr: %v", err)
+		}
+		output, err := cmd.Flags().GetString("ics-output")
+		if err != nil {
+			log.Fatalf("Error parsing arg ics-output: %v", err)
+		}
+		token, err := cmd.Flags().GetString("token")
+		if err != nil {
+			log.Fatalf("Error parsing arg token: %v", err)
+		}
+		if output == "" {
+			output = defaultICSOutput()
+		}
+
+		feedPath := "/" + filepath.Base(output)
+		if token != "" {
+			// The token is what keeps the feed private, so it stays out of
+			// the logs and is only printed for whoever started the server
+			logger.Info("Serving feed", "file", output, "url", "http://"+addr+"/<token>"+feedPath)
+			fmt.Printf("Subscribe to http://%s/%s%s\n", addr, token, feedPath)
+		} else {
+			logger.Info("Serving feed", "file", output, "url", "http://"+addr+feedPath)
+		}
+		log.Fatal(http.ListenAndServe(addr, ics.NewFeedHandler(output, token)))
+	},
+}
+
+func init() {
+	serveICSCmd.Flags().String("addr", "localhost:8080", "Address to listen on")
+	serveICSCmd.Flags().String("ics-output", "", "Path of the iCalendar feed to serve, defaults to busy.ics in the config directory")
+	serveICSCmd.Flags().String("token", "", "Secret path segment the feed is served under, so only people given the URL can subscribe")
+	RootCmd.AddCommand(serveICSCmd)
+}
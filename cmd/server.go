@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/auth"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/config"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/server"
+	"github.com/spf13/cobra"
+)
+
+// serverCmd represents the server command
+var serverCmd = &cobra.Command{
+	Use:   "server",
+	Short: "Host a web UI where a team connects their accounts and gets synced on a schedule",
+	Long: `Host a web UI and API where each user connects their source and destination Google accounts. Every connected user is synced on a schedule, each with their own clients, and can start a sync themselves no more often than --min-sync-interval.
+
+Tokens are stored encrypted with the key in --key-file, which is created on first run. The OAuth client in credentials.json must allow <public-url>/oauth/callback as a redirect URI.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		addr, err := cmd.Flags().GetString("addr")
+		if err != nil {
+			log.Fatalf("Error parsing arg addr: %v", err)
+		}
+		publicURL, err := cmd.Flags().GetString("public-url")
+		if err != nil {
+			log.Fatalf("Error parsing arg public-url: %v", err)
+		}
+		dataDir, err := cmd.Flags().GetString("data-dir")
+		if err != nil {
+			log.Fatalf("Error parsing arg data-dir: %v", err)
+		}
+		keyFile, err := cmd.Flags().GetString("key-file")
+		if err != nil {
+			log.Fatalf("Error parsing arg key-file: %v", err)
+		}
+		interval, err := cmd.Flags().GetDuration("interval")
+		if err != nil {
+			log.Fatalf("Error parsing arg interval: %v", err)
+		}
+		minSyncInterval, err := cmd.Flags().GetDuration("min-sync-interval")
+		if err != nil {
+			log.Fatalf("Error parsing arg min-sync-interval: %v", err)
+		}
+		workers, err := cmd.Flags().GetInt("workers")
+		if err != nil {
+			log.Fatalf("Error parsing arg workers: %v", err)
+		}
+		daysAhead, err := cmd.Flags().GetInt("days-ahead")
+		if err != nil {
+			log.Fatalf("Error parsing arg days-ahead: %v", err)
+		}
+		metricsAddr, err := cmd.Flags().GetString("metrics-addr")
+		if err != nil {
+			log.Fatalf("Error parsing arg metrics-addr: %v", err)
+		}
+		maxSyncAge, err := cmd.Flags().GetDuration("max-sync-age")
+		if err != nil {
+			log.Fatalf("Error parsing arg max-sync-age: %v", err)
+		}
+		if maxSyncAge == 0 {
+			maxSyncAge = 2 * interval
+		}
+		if publicURL == "" {
+			publicURL = "http://" + addr
+		}
+		if dataDir == "" {
+			dataDir = config.Path("server")
+		}
+		if keyFile == "" {
+			keyFile = config.Path("server.key")
+		}
+
+		redirectURL := strings.TrimSuffix(publicURL, "/") + "/oauth/callback"
+		sourceConfig, err := auth.WebOauthConfig(auth.SourceAccount, redirectURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		destinationConfig, err := auth.WebOauthConfig(auth.DestinationAccount, redirectURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		key, err := server.LoadOrCreateKey(keyFile)
+		if err != nil {
+			log.Fatalf("Unable to load encryption key: %v", err)
+		}
+		store, err := server.NewStore(dataDir, key)
+		if err != nil {
+			log.Fatalf("Unable to open user store: %v", err)
+		}
+		// Sessions are signed with a key of their own, derived from the
+		// encryption key so there's only one secret to keep
+		sessionKey := sha256.Sum256(append([]byte("session:"), key...))
+
+		options := server.Options{
+			Store:             store,
+			SourceConfig:      sourceConfig,
+			DestinationConfig: destinationConfig,
+			SessionKey:        sessionKey[:],
+			DaysAhead:         daysAhead,
+			MinSyncInterval:   minSyncInterval,
+			Workers:           workers,
+			Audit:             openAudit(),
+		}
+		if metricsAddr != "" {
+			options.Metrics = syncMetrics
+		}
+		s := server.New(options)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		go s.RunScheduler(ctx, interval)
+
+		// Metrics are served apart from the UI, so they needn't be public
+		if metricsAddr != "" {
+			mux := http.NewServeMux()
+			mux.Handle("GET /metrics", syncMetrics.Handler())
+			mux.Handle("GET /healthz", syncMetrics.HealthHandler(maxSyncAge, time.Now()))
+			metricsServer := &http.Server{Addr: metricsAddr, Handler: mux}
+			go func() {
+				<-ctx.Done()
+				metricsServer.Shutdown(context.Background())
+			}()
+			go func() {
+				if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					log.Fatalf("Unable to serve metrics: %v", err)
+				}
+			}()
+			logger.Info("Serving metrics", "addr", metricsAddr)
+		}
+
+		httpServer := &http.Server{Addr: addr, Handler: s.Handler()}
+		go func() {
+			<-ctx.Done()
+			httpServer.Shutdown(context.Background())
+		}()
+		logger.Info("Serving", "addr", addr, "url", publicURL)
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+		logger.Info("Waiting for running syncs to finish")
+		s.Wait()
+	},
+}
+
+func init() {
+	serverCmd.Flags().String("addr", "localhost:8080", "Address to listen on")
+	serverCmd.Flags().String("public-url", "", "URL users reach the server at, defaults to http://<addr>")
+	serverCmd.Flags().String("data-dir", "", "Directory holding the encrypted users, defaults to server in the config directory")
+	serverCmd.Flags().String("key-file", "", "File holding the encryption key, defaults to server.key in the config directory")
+	serverCmd.Flags().Duration("interval", 15*time.Minute, "How often every user is synced")
+	serverCmd.Flags().Duration("min-sync-interval", 5*time.Minute, "How often a single user may be synced")
+	serverCmd.Flags().Int("workers", 4, "How many users are synced at once")
+	serverCmd.Flags().IntP("days-ahead", "d", 30, "Specify how many days into the future to sync")
+	serverCmd.Flags().String("metrics-addr", "", "Address to serve Prometheus metrics on at /metrics, with a health check at /healthz, e.g. localhost:9090")
+	serverCmd.Flags().Duration("max-sync-age", 0, "How long ago the last successful sync may be for /healthz to report healthy, defaults to twice --interval")
+	RootCmd.AddCommand(serverCmd)
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/config"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/state"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+// openState opens the local state database for the sync client's calendars,
+// unless --no-state was given. The returned store has to be closed, and is
+// nil when the state isn't used.
+func openState(cmd *cobra.Command, syncClient *sync.SyncClient) *state.Store {
+	if cmd.Flags().Lookup("no-state") != nil {
+		noState, err := cmd.Flags().GetBool("no-state")
+		if err != nil {
+			log.Fatalf("Error parsing arg no-state: %v", err)
+		}
+		if noState {
+			return nil
+		}
+	}
+	store, err := state.Open(config.Path("state.db"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	syncClient.State = store
+	syncClient.DestinationStateKey = destinationStateKey(cmd, syncClient.DestinationCalendarId)
+	if cmd.Flags().Lookup("state-max-age") != nil {
+		maxAge, err := cmd.Flags().GetDuration("state-max-age")
+		if err != nil {
+			log.Fatalf("Error parsing arg state-max-age: %v", err)
+		}
+		syncClient.StateMaxAge = maxAge
+	}
+	if cmd.Flags().Lookup("source-mode") != nil {
+		syncClient.SourceStateKey = sourceStateKey(cmd, syncClient.SourceCalendarId)
+	}
+
+	if cmd.Flags().Lookup("rebuild-state") != nil {
+		rebuild, err := cmd.Flags().GetBool("rebuild-state")
+		if err != nil {
+			log.Fatalf("Error parsing arg rebuild-state: %v", err)
+		}
+		if rebuild {
+			forgetState(store, syncClient.DestinationStateKey)
+			forgetState(store, syncClient.SourceStateKey)
+		}
+	}
+	return store
+}
+
+func forgetState(store *state.Store, key string) {
+	if key == "" {
+		return
+	}
+	if err := store.Forget(key); err != nil {
+		log.Fatalf("Unable to reset the local state: %v", err)
+	}
+}
+
+// destinationStateKey names the destination calendar in the state database,
+// so switching accounts or calendars doesn't mix up their blocks.
+func destinationStateKey(cmd *cobra.Command, calendarId string) string {
+	destinationMode, err := cmd.Flags().GetString("destination-mode")
+	if err != nil {
+		log.Fatalf("Error parsing arg destination-mode: %v", err)
+	}
+	switch destinationMode {
+	case "google":
+		account, err := cmd.Flags().GetString("destination-account")
+		if err != nil {
+			log.Fatalf("Error parsing arg destination-account: %v", err)
+		}
+		return "google/" + account + "/" + calendarId
+	case "service-account":
+		subject, err := cmd.Flags().GetString("impersonate")
+		if err != nil {
+			log.Fatalf("Error parsing arg impersonate: %v", err)
+		}
+		return "service-account/" + subject + "/" + calendarId
+	case "ics":
+		output, err := cmd.Flags().GetString("ics-output")
+		if err != nil {
+			log.Fatalf("Error parsing arg ics-output: %v", err)
+		}
+		if output == "" {
+			output = defaultICSOutput()
+		}
+		if absolute, err := filepath.Abs(output); err == nil {
+			output = absolute
+		}
+		return "ics/" + output
+	default:
+		return destinationMode + "/destination/" + calendarId
+	}
+}
+
+// sourceStateKey names the source calendar, which two-way syncs block too.
+// Only sources that can be written to have one.
+func sourceStateKey(cmd *cobra.Command, calendarId string) string {
+	sourceMode, err := cmd.Flags().GetString("source-mode")
+	if err != nil {
+		log.Fatalf("Error parsing arg source-mode: %v", err)
+	}
+	switch sourceMode {
+	case "events":
+		account, err := cmd.Flags().GetString("source-account")
+		if err != nil {
+			log.Fatalf("Error parsing arg source-account: %v", err)
+		}
+		return "google/" + account + "/" + calendarId
+	case "caldav":
+		return "caldav/source/" + calendarId
+	default:
+		return ""
+	}
+}
+
+// addStateFlags adds the flags read by openState.
+func addStateFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("no-state", false, "Don't use the local state database, list the destination calendar on every run instead")
+	cmd.Flags().Duration("state-max-age", time.Hour, "Rebuild the local state from the destination calendar once it's older than this, so blocks deleted by hand are created again, 0 to never rebuild it")
+}
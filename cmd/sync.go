@@ -2,7 +2,12 @@ package cmd
 
 import (
 	"log"
+	"net/http"
+	"time"
 
+	"github.com/davidpimentel/gcal-busy-blocker/internal/auth"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/caldav"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/config"
 	"github.com/davidpimentel/gcal-busy-blocker/internal/sync"
 	"github.com/spf13/cobra"
 )
@@ -20,8 +25,42 @@ var (
 			if err != nil {
 				log.Fatalf("Error parsing arg dry-run: %v", err)
 			}
-			syncClient := sync.NewSyncClient()
-			err = syncClient.RunSync(daysAhead, dryRun)
+			mirrorRecurring, err := cmd.Flags().GetBool("mirror-recurring")
+			if err != nil {
+				log.Fatalf("Error parsing arg mirror-recurring: %v", err)
+			}
+			skipBusy, err := cmd.Flags().GetBool("skip-busy")
+			if err != nil {
+				log.Fatalf("Error parsing arg skip-busy: %v", err)
+			}
+			twoWay, err := cmd.Flags().GetBool("two-way")
+			if err != nil {
+				log.Fatalf("Error parsing arg two-way: %v", err)
+			}
+			if twoWay {
+				checkTwoWayModes(cmd)
+			}
+			textfile := metricsTextfile(cmd)
+			loc := location(cmd)
+			syncClient := newSyncClient(cmd, loc)
+			syncClient.Location = loc
+			syncClient.Metrics = syncMetrics
+			syncClient.MirrorRecurring = mirrorRecurring
+			syncClient.Rules = loadRules(cmd)
+			syncClient.SkipBusy = skipBusy
+			release := lockSync(cmd, syncClient)
+			store := openState(cmd, syncClient)
+			if twoWay {
+				err = syncClient.RunTwoWaySync(daysAhead, dryRun)
+			} else {
+				err = syncClient.RunSync(daysAhead, dryRun)
+			}
+			if store != nil {
+				store.Close()
+			}
+			release()
+			writeMetricsTextfile(textfile)
+			flushTraces()
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -29,8 +68,240 @@ var (
 	}
 )
 
+// location returns the zone given with --tz, or nil for the system zone.
+func location(cmd *cobra.Command) *time.Location {
+	tz, err := cmd.Flags().GetString("tz")
+	if err != nil {
+		log.Fatalf("Error parsing arg tz: %v", err)
+	}
+	if tz == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		log.Fatalf("Unknown time zone %q: %v", tz, err)
+	}
+	return loc
+}
+
+// checkTwoWayModes makes sure both calendars can be written to and list the
+// properties that mark blocks. Free/busy times and feeds can't tell blocks
+// apart from events, so blocks would be copied back to where they came from.
+func checkTwoWayModes(cmd *cobra.Command) {
+	sourceMode, err := cmd.Flags().GetString("source-mode")
+	if err != nil {
+		log.Fatalf("Error parsing arg source-mode: %v", err)
+	}
+	destinationMode, err := cmd.Flags().GetString("destination-mode")
+	if err != nil {
+		log.Fatalf("Error parsing arg destination-mode: %v", err)
+	}
+	if sourceMode != "events" && sourceMode != "caldav" {
+		log.Fatalf("--two-way needs a source calendar that can be written to, not source mode %s", sourceMode)
+	}
+	if destinationMode == "ics" {
+		log.Fatal("--two-way can't read events back from an iCalendar feed destination")
+	}
+}
+
+// newSyncClient returns a sync client reading from the source chosen with
+// --source-mode and writing to the destination chosen with --destination-mode.
+// Times in the source without a zone are read in loc.
+func newSyncClient(cmd *cobra.Command, loc *time.Location) *sync.SyncClient {
+	syncClient := &sync.SyncClient{
+		SourceCalendarService:      sourceService(cmd, loc),
+		DestinationCalendarService: destinationService(cmd),
+		Logger:                     logger,
+	}
+	syncClient.SourceCalendarId = calendarId(cmd, "source-calendar", syncClient.SourceCalendarService)
+	syncClient.DestinationCalendarId = calendarId(cmd, "destination-calendar", syncClient.DestinationCalendarService)
+	auditChanges(cmd, syncClient)
+	return syncClient
+}
+
+// calendarId resolves the calendar ID or name given with the flag to the ID
+// of one of the service's calendars.
+func calendarId(cmd *cobra.Command, flag string, service sync.CalendarEventsService) string {
+	value, err := cmd.Flags().GetString(flag)
+	if err != nil {
+		log.Fatalf("Error parsing arg %s: %v", flag, err)
+	}
+	id, err := sync.ResolveCalendarId(service, value)
+	if err != nil {
+		log.Fatalf("Unable to find the calendar set with --%s: %v", flag, err)
+	}
+	return id
+}
+
+func sourceService(cmd *cobra.Command, loc *time.Location) sync.CalendarEventsService {
+	sourceMode, err := cmd.Flags().GetString("source-mode")
+	if err != nil {
+		log.Fatalf("Error parsing arg source-mode: %v", err)
+	}
+
+	switch sourceMode {
+	case "events":
+		return googleService(cmd, "source-account")
+	case "freebusy":
+		calendarIds, err := cmd.Flags().GetStringSlice("freebusy-calendar")
+		if err != nil {
+			log.Fatalf("Error parsing arg freebusy-calendar: %v", err)
+		}
+		service, err := sync.NewFreeBusyCalendarService(accountClient(cmd, "source-account"), calendarIds)
+		if err != nil {
+			log.Fatalf("Unable to retrieve source Calendar client: %v", err)
+		}
+		return service
+	case "ics":
+		source, err := cmd.Flags().GetString("ics-source")
+		if err != nil {
+			log.Fatalf("Error parsing arg ics-source: %v", err)
+		}
+		if source == "" {
+			log.Fatal("The ics source mode needs a file or URL to read, set it with --ics-source")
+		}
+		return sync.NewICSCalendarService(source, config.Path("ics-cache"), loc, logger)
+	case "caldav":
+		return caldavService("source", loc)
+	default:
+		log.Fatalf("Unknown source mode %q, expected events, freebusy, ics or caldav", sourceMode)
+	}
+	return nil
+}
+
+// destinationService returns the destination chosen with --destination-mode.
+func destinationService(cmd *cobra.Command) sync.CalendarEventsService {
+	destinationMode, err := cmd.Flags().GetString("destination-mode")
+	if err != nil {
+		log.Fatalf("Error parsing arg destination-mode: %v", err)
+	}
+
+	switch destinationMode {
+	case "google":
+		return googleService(cmd, "destination-account")
+	case "ics":
+		output, err := cmd.Flags().GetString("ics-output")
+		if err != nil {
+			log.Fatalf("Error parsing arg ics-output: %v", err)
+		}
+		if output == "" {
+			output = defaultICSOutput()
+		}
+		service, err := sync.NewICSFeedCalendarService(output)
+		if err != nil {
+			log.Fatalf("Unable to open the iCalendar feed: %v", err)
+		}
+		return service
+	case "caldav":
+		return caldavService("destination", nil)
+	case "service-account":
+		keyFile, err := cmd.Flags().GetString("service-account-key")
+		if err != nil {
+			log.Fatalf("Error parsing arg service-account-key: %v", err)
+		}
+		subject, err := cmd.Flags().GetString("impersonate")
+		if err != nil {
+			log.Fatalf("Error parsing arg impersonate: %v", err)
+		}
+		destClient, err := auth.ServiceAccountClient(keyFile, subject)
+		if err != nil {
+			log.Fatalf("Unable to get destination client: %v", err)
+		}
+		service, err := sync.NewCalendarEventsService(instrumentClient(subject, destClient))
+		if err != nil {
+			log.Fatalf("Unable to retrieve destination Calendar client: %v", err)
+		}
+		return service
+	case "microsoft":
+		destClient, err := auth.MicrosoftDestinationClient()
+		if err != nil {
+			log.Fatalf("Unable to get destination client: %v", err)
+		}
+		return sync.NewGraphCalendarService(instrumentClient("microsoft", destClient))
+	default:
+		log.Fatalf("Unknown destination mode %q, expected google, service-account, ics, caldav or microsoft", destinationMode)
+	}
+	return nil
+}
+
+// accountClient returns a client for the Google account named with the flag.
+func accountClient(cmd *cobra.Command, flag string) *http.Client {
+	account, err := cmd.Flags().GetString(flag)
+	if err != nil {
+		log.Fatalf("Error parsing arg %s: %v", flag, err)
+	}
+	client, err := auth.AccountClient(account)
+	if err != nil {
+		log.Fatalf("Unable to get %s client: %v", account, err)
+	}
+	return instrumentClient(account, client)
+}
+
+// googleService returns the Google calendars of the account named with the
+// flag.
+func googleService(cmd *cobra.Command, flag string) sync.CalendarEventsService {
+	service, err := sync.NewCalendarEventsService(accountClient(cmd, flag))
+	if err != nil {
+		log.Fatalf("Unable to retrieve Calendar client: %v", err)
+	}
+	return service
+}
+
+// caldavService returns the CalDAV calendar the source or destination account
+// logged in to.
+func caldavService(account string, loc *time.Location) sync.CalendarEventsService {
+	credentials, err := auth.LoadCalDAVCredentials(account)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := caldav.NewClient(instrumentClient("caldav", caldav.BasicAuthClient(credentials.Username, credentials.Password)), credentials.CalendarURL)
+	if err != nil {
+		log.Fatalf("Unable to create CalDAV client: %v", err)
+	}
+	return sync.NewCalDAVCalendarService(client, loc, logger)
+}
+
+func defaultICSOutput() string {
+	return config.Path("busy.ics")
+}
+
+// addDestinationFlags adds the flags read by destinationService.
+func addDestinationFlags(cmd *cobra.Command) {
+	cmd.Flags().String("destination-account", auth.DestinationAccount, "Google account to write blocks to in google destination mode, a name from 'accounts list'")
+	cmd.Flags().String("destination-mode", "google", "Where to write blocks: google for the destination account's calendar, service-account for a Workspace user's calendar, ics for an iCalendar feed file, caldav, or microsoft for Outlook / Microsoft 365")
+	cmd.Flags().String("ics-output", "", "Path of the iCalendar feed written in ics destination mode, defaults to busy.ics in the config directory")
+	cmd.Flags().String("service-account-key", "", "Path of the JSON key of a service account with domain-wide delegation, for the service-account destination mode")
+	cmd.Flags().String("impersonate", "", "Email of the Workspace user whose calendar the service account writes to")
+	cmd.Flags().String("destination-calendar", "primary", "ID or name of the destination account's calendar to write blocks to, see 'calendars list'")
+}
+
+// addSourceFlags adds the flags picking the source account and calendar.
+func addSourceFlags(cmd *cobra.Command) {
+	cmd.Flags().String("source-account", auth.SourceAccount, "Google account to read events from, a name from 'accounts list'")
+	cmd.Flags().String("source-calendar", "primary", "ID or name of the source account's calendar to read events from, see 'calendars list'")
+}
+
+// addSourceModeFlags adds the flags read by sourceService.
+func addSourceModeFlags(cmd *cobra.Command) {
+	cmd.Flags().String("source-mode", "events", "How to read the source calendar: events, freebusy for calendars only shared as free/busy, ics for an iCalendar feed, or caldav")
+	cmd.Flags().StringSlice("freebusy-calendar", []string{"primary"}, "Calendar IDs to read busy times from in freebusy source mode, can be repeated")
+	cmd.Flags().String("ics-source", "", "Path or URL (http, https or webcal) of the iCalendar feed to read in ics source mode")
+	addSourceFlags(cmd)
+}
+
 func init() {
 	runCmd.Flags().Bool("dry-run", false, "Print out the created events instead of writing them to the destination calendar")
 	runCmd.Flags().IntP("days-ahead", "d", 30, "Specify how many days into the future to sync")
+	runCmd.Flags().String("tz", "", "IANA time zone (e.g. America/New_York) used for the sync window and block times, defaults to the system zone")
+	runCmd.Flags().Bool("mirror-recurring", false, "Mirror recurring events as a single recurring block instead of one block per instance")
+	addSourceModeFlags(runCmd)
+	addDestinationFlags(runCmd)
+	runCmd.Flags().Bool("two-way", false, "Also block the source calendar with the destination's events, the source account needs 'login source --write'")
+	runCmd.Flags().Bool("skip-busy", false, "Only block the parts of an event that aren't already busy on the destination calendar")
+	runCmd.Flags().String("rules", "", "Path to the rules file, defaults to rules.json in the config directory")
+	addStateFlags(runCmd)
+	addLockFlags(runCmd)
+	addMetricsFlags(runCmd)
+	runCmd.Flags().Bool("rebuild-state", false, "Forget the local state and rebuild it from the calendars before syncing")
 	RootCmd.AddCommand(runCmd)
 }
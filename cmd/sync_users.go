@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/central"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// syncUsersCmd represents the sync-users command
+var syncUsersCmd = &cobra.Command{
+	Use:   "sync-users",
+	Short: "Block time on the work calendars of many users with a service account",
+	Long: `Sync every user in a config file, writing to their Workspace calendars through a service account with domain-wide delegation instead of each user's own login.
+
+A user whose sync fails doesn't stop the others. See the README for the config file format.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := cmd.Flags().GetString("config")
+		if err != nil {
+			log.Fatalf("Error parsing arg config: %v", err)
+		}
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatalf("Error parsing arg dry-run: %v", err)
+		}
+		if path == "" {
+			path = config.Path("users.json")
+		}
+
+		usersConfig, err := central.LoadConfig(path)
+		if err != nil {
+			log.Fatalf("Unable to load users: %v", err)
+		}
+		textfile := metricsTextfile(cmd)
+		runner := central.NewRunner(usersConfig, config.Path("ics-cache"))
+		runner.Audit = openAudit()
+		runner.Metrics = syncMetrics
+		runner.Logger = logger
+		err = runner.Run(dryRun)
+		writeMetricsTextfile(textfile)
+		flushTraces()
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	syncUsersCmd.Flags().String("config", "", "Path to the users config file, defaults to users.json in the config directory")
+	syncUsersCmd.Flags().Bool("dry-run", false, "Print out the created events instead of writing them to the destination calendars")
+	addMetricsFlags(syncUsersCmd)
+	RootCmd.AddCommand(syncUsersCmd)
+}
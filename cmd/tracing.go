@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/tracing"
+	"github.com/spf13/cobra"
+)
+
+// shutdownTracing flushes and stops the trace exporter set up for the
+// command.
+var shutdownTracing = func(context.Context) error { return nil }
+
+// setupTracing starts sending spans to the exporter chosen with
+// --trace-exporter.
+func setupTracing(cmd *cobra.Command) {
+	exporter, err := cmd.Flags().GetString("trace-exporter")
+	if err != nil {
+		log.Fatalf("Error parsing arg trace-exporter: %v", err)
+	}
+	// Spans go to stderr so they don't mix with the output of the command
+	shutdown, err := tracing.Setup(context.Background(), exporter, os.Stderr)
+	if err != nil {
+		log.Fatalf("Unable to set up tracing: %v", err)
+	}
+	shutdownTracing = shutdown
+}
+
+// flushTraces sends the spans that are left. Commands call it before exiting
+// on an error, as log.Fatal skips the post run.
+func flushTraces() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Warn("Unable to send the traces", "error", err)
+	}
+	shutdownTracing = func(context.Context) error { return nil }
+}
+
+func init() {
+	RootCmd.PersistentFlags().String("trace-exporter", tracing.ExporterNone, "Send OpenTelemetry traces to none, console (printed as JSON on stderr) or otlp. The otlp exporter is configured with the OTEL_EXPORTER_OTLP_* environment variables")
+}
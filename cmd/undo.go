@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+// undoCmd represents the undo command
+var undoCmd = &cobra.Command{
+	Use:   "undo [run-id]",
+	Short: "Reverse the changes a run made to the destination calendar, the latest run by default",
+	Long: `Reverses the changes a run made to the destination calendar: blocks it created are
+deleted, blocks it updated are changed back and blocks it deleted are created again.
+Without a run ID the latest run that changed anything is undone, see 'history' for
+the others.
+
+The next sync makes the same changes again unless whatever caused them, e.g. a rule,
+is fixed first.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatalf("Error parsing arg dry-run: %v", err)
+		}
+		var runId uint64
+		if len(args) == 1 {
+			runId = parseRunId(args[0])
+		}
+
+		syncClient := &sync.SyncClient{DestinationCalendarService: destinationService(cmd), Logger: logger}
+		syncClient.DestinationCalendarId = calendarId(cmd, "destination-calendar", syncClient.DestinationCalendarService)
+		auditChanges(cmd, syncClient)
+		release := lockSync(cmd, syncClient)
+		store := openState(cmd, syncClient)
+		run, err := syncClient.Undo(runId, dryRun)
+		store.Close()
+		release()
+		flushTraces()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if dryRun {
+			fmt.Printf("Run %d would be undone, run without --dry-run to undo it\n", run.ID)
+		} else {
+			fmt.Printf("Undid run %d, %d changes reversed\n", run.ID, len(run.Changes))
+		}
+	},
+}
+
+func init() {
+	undoCmd.Flags().Bool("dry-run", false, "Show what would be changed back without changing anything")
+	addDestinationFlags(undoCmd)
+	addLockFlags(undoCmd)
+	RootCmd.AddCommand(undoCmd)
+}
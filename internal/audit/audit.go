@@ -0,0 +1,161 @@
+// Package audit keeps an append-only JSON Lines log of every change made to a
+// calendar, to show what was written to it and when.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	gosync "sync"
+	"time"
+)
+
+// Actions on calendar events.
+const (
+	ActionInsert = "insert"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Results of an action.
+const (
+	ResultOK    = "ok"
+	ResultError = "error"
+)
+
+const (
+	defaultMaxSize  = 10 << 20
+	defaultMaxFiles = 5
+)
+
+// Entry is one change to a calendar. Nothing about the source event but a
+// hash of its ID is kept, so the log doesn't leak what's on the source
+// calendar.
+type Entry struct {
+	Time         time.Time `json:"time"`
+	Action       string    `json:"action"`
+	Account      string    `json:"account,omitempty"`
+	Calendar     string    `json:"calendar"`
+	EventId      string    `json:"eventId,omitempty"`
+	SourceIdHash string    `json:"sourceIdHash,omitempty"`
+	Result       string    `json:"result"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// HashSourceId hashes the ID of a source event for an entry.
+func HashSourceId(sourceId string) string {
+	if sourceId == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(sourceId))
+	return hex.EncodeToString(hash[:16])
+}
+
+// Log appends entries to a file, rotating it once it grows past MaxSize. The
+// rotated files are named after it with .1 for the newest up to MaxFiles.
+type Log struct {
+	path     string
+	MaxSize  int64
+	MaxFiles int
+
+	mu  gosync.Mutex
+	now func() time.Time
+}
+
+// Open returns a log writing to path.
+func Open(path string) *Log {
+	return &Log{path: path, MaxSize: defaultMaxSize, MaxFiles: defaultMaxFiles, now: time.Now}
+}
+
+// Record appends an entry, filling in its time.
+func (l *Log) Record(entry *Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry.Time = l.now().UTC()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := l.rotate(int64(len(data) + 1)); err != nil {
+		return fmt.Errorf("unable to rotate audit log: %v", err)
+	}
+
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	// A single write, so lines from processes appending at once don't mix
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// rotate moves the log aside if adding size bytes would take it past MaxSize.
+func (l *Log) rotate(size int64) error {
+	info, err := os.Stat(l.path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && (info.Size() == 0 || info.Size()+size <= l.MaxSize)) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := l.MaxFiles - 1; i >= 1; i-- {
+		err := os.Rename(l.rotatedPath(i), l.rotatedPath(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(l.path, l.rotatedPath(1))
+}
+
+func (l *Log) rotatedPath(n int) string {
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+// Read returns the entries recorded since the given time, oldest first,
+// including the ones in rotated files.
+func (l *Log) Read(since time.Time) ([]*Entry, error) {
+	paths := []string{l.path}
+	for i := 1; i <= l.MaxFiles; i++ {
+		paths = append(paths, l.rotatedPath(i))
+	}
+	slices.Reverse(paths)
+
+	entries := []*Entry{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		line := 0
+		for scanner.Scan() {
+			line++
+			entry := &Entry{}
+			if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+				file.Close()
+				return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+			}
+			if !entry.Time.Before(since) {
+				entries = append(entries, entry)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log := Open(path)
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	log.now = func() time.Time { return now }
+
+	log.Record(&Entry{Action: ActionInsert, Calendar: "primary", EventId: "block1", SourceIdHash: HashSourceId("dentist"), Result: ResultOK})
+	now = now.Add(time.Hour)
+	log.Record(&Entry{Action: ActionDelete, Calendar: "primary", EventId: "block2", Result: ResultError, Error: "not found"})
+
+	entries, err := log.Read(time.Time{})
+	if err != nil {
+		t.Fatalf("Unable to read log: %v", err)
+	}
+	if len(entries) != 2 || entries[0].EventId != "block1" || entries[1].Error != "not found" {
+		t.Fatalf("Expected both entries in order, got %+v", entries)
+	}
+	if !entries[0].Time.Equal(now.Add(-time.Hour)) {
+		t.Errorf("Expected the entry to be timestamped, got %v", entries[0].Time)
+	}
+
+	entries, _ = log.Read(now)
+	if len(entries) != 1 || entries[0].EventId != "block2" {
+		t.Errorf("Expected only the entries since the given time, got %+v", entries)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the log to only be readable by its owner, got %v (%v)", info.Mode(), err)
+	}
+}
+
+func TestLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log := Open(path)
+	log.MaxSize = 200
+	log.MaxFiles = 2
+
+	for i := range 10 {
+		if err := log.Record(&Entry{Action: ActionInsert, Calendar: "primary", EventId: string(rune('a' + i)), Result: ResultOK}); err != nil {
+			t.Fatalf("Unable to record entry: %v", err)
+		}
+	}
+
+	for _, rotated := range []string{path + ".1", path + ".2"} {
+		if _, err := os.Stat(rotated); err != nil {
+			t.Errorf("Expected %s to exist: %v", rotated, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("Expected only MaxFiles rotated files to be kept")
+	}
+	info, _ := os.Stat(path)
+	if info.Size() > log.MaxSize {
+		t.Errorf("Expected the log to be rotated before growing past %d bytes, got %d", log.MaxSize, info.Size())
+	}
+
+	// Rotated files are read oldest first, ending with the latest entry
+	entries, err := log.Read(time.Time{})
+	if err != nil {
+		t.Fatalf("Unable to read log: %v", err)
+	}
+	if len(entries) == 0 || len(entries) == 10 || entries[len(entries)-1].EventId != "j" {
+		t.Fatalf("Expected the newest entries to be kept, got %d", len(entries))
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].EventId <= entries[i-1].EventId {
+			t.Errorf("Expected entries in order, got %s after %s", entries[i].EventId, entries[i-1].EventId)
+		}
+	}
+}
+
+func TestHashSourceId(t *testing.T) {
+	if HashSourceId("") != "" {
+		t.Error("Expected no hash without a source event")
+	}
+	if hash := HashSourceId("dentist"); len(hash) != 32 || hash == HashSourceId("gym") || hash != HashSourceId("dentist") {
+		t.Errorf("Expected a stable 32 character hash, got %q", hash)
+	}
+}
@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/config"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+)
+
+// The accounts logged in to with 'login source' and 'login destination' keep
+// their original token files and can be referred to by these names
+const (
+	SourceAccount      = "source"
+	DestinationAccount = "destination"
+)
+
+const accountsDir = "accounts"
+
+// Named accounts can be used as either a source or a destination, so they're
+// allowed to write events unless logged in read only
+var (
+	accountScope         = []string{calendar.CalendarEventsScope, calendar.CalendarFreebusyScope, calendar.CalendarCalendarlistReadonlyScope}
+	accountReadOnlyScope = sourceScope
+)
+
+// Google's OAuth token revocation endpoint, replaced in tests
+var revokeURL = "https://oauth2.googleapis.com/revoke"
+
+var accountNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// Account is a Google account logged in to under a name.
+type Account struct {
+	Name string `json:"-"`
+	// Legacy is set for the source and destination accounts, whose access
+	// depends on how they were logged in to
+	Legacy   bool          `json:"-"`
+	ReadOnly bool          `json:"readOnly"`
+	Token    *oauth2.Token `json:"token"`
+}
+
+// ValidateAccountName checks a name can be used for an account and its file.
+func ValidateAccountName(name string) error {
+	if !accountNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid account name %q, use letters, digits, '-' and '_'", name)
+	}
+	return nil
+}
+
+func accountPath(name string) string {
+	return filepath.Join(config.Path(accountsDir), name+".json")
+}
+
+// legacyTokenFile returns the token file of the source or destination account,
+// or "" for named accounts.
+func legacyTokenFile(name string) string {
+	switch name {
+	case SourceAccount:
+		return sourceTokenFile
+	case DestinationAccount:
+		return destTokenFile
+	}
+	return ""
+}
+
+// GetAccountTokenFromWeb authorizes a Google account and saves it under name.
+// Read only accounts can only be used as sources.
+func GetAccountTokenFromWeb(name string, readOnly bool) error {
+	if err := ValidateAccountName(name); err != nil {
+		return err
+	}
+	if legacyTokenFile(name) != "" {
+		return fmt.Errorf("%q is reserved for 'login %s'", name, name)
+	}
+	scope := accountScope
+	if readOnly {
+		scope = accountReadOnlyScope
+	}
+	tok := exchangeTokenFromWeb(scope)
+	if err := saveAccount(&Account{Name: name, ReadOnly: readOnly, Token: tok}); err != nil {
+		return err
+	}
+	fmt.Printf("Authentication successful! Account saved as %s\n", name)
+	return nil
+}
+
+// AccountClient returns a client for Google Calendar signed in as the named
+// account.
+func AccountClient(name string) (*http.Client, error) {
+	switch name {
+	case SourceAccount:
+		return SourceClient()
+	case DestinationAccount:
+		return DestinationClient()
+	}
+	account, err := loadAccount(name)
+	if err != nil {
+		return nil, err
+	}
+	scope := accountScope
+	if account.ReadOnly {
+		scope = accountReadOnlyScope
+	}
+	return getOauthConfig(scope).Client(context.Background(), account.Token), nil
+}
+
+// Accounts lists the logged in accounts by name, including the source and
+// destination accounts if they're logged in.
+func Accounts() ([]*Account, error) {
+	accounts := []*Account{}
+	for _, name := range []string{SourceAccount, DestinationAccount} {
+		tok, err := tokenFromFile(legacyTokenFile(name))
+		if err == nil {
+			accounts = append(accounts, &Account{Name: name, Legacy: true, Token: tok})
+		}
+	}
+
+	// Listed in order of their names
+	files, err := os.ReadDir(config.Path(accountsDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return accounts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok || file.IsDir() {
+			continue
+		}
+		account, err := loadAccount(name)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// RemoveAccount forgets an account's token without revoking it.
+func RemoveAccount(name string) error {
+	path, err := accountTokenPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no account named %q is logged in", name)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Logout revokes an account's token with Google, so it can't be used anymore
+// even if a copy is left somewhere, then removes it.
+func Logout(name string) error {
+	path, err := accountTokenPath(name)
+	if err != nil {
+		return err
+	}
+	var tok *oauth2.Token
+	if file := legacyTokenFile(name); file != "" {
+		tok, err = tokenFromFile(file)
+	} else {
+		var account *Account
+		account, err = loadAccount(name)
+		if account != nil {
+			tok = account.Token
+		}
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no account named %q is logged in", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := revokeToken(tok); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// revokeToken revokes the refresh token, which also revokes the access tokens
+// issued with it.
+func revokeToken(tok *oauth2.Token) error {
+	value := tok.RefreshToken
+	if value == "" {
+		value = tok.AccessToken
+	}
+	resp, err := http.PostForm(revokeURL, url.Values{"token": {value}})
+	if err != nil {
+		return fmt.Errorf("unable to revoke token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// An expired or already revoked token is as good as revoked
+	if resp.StatusCode == http.StatusBadRequest {
+		body := struct {
+			Error string `json:"error"`
+		}{}
+		if json.NewDecoder(resp.Body).Decode(&body) == nil && body.Error == "invalid_token" {
+			return nil
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to revoke token: %s", resp.Status)
+	}
+	return nil
+}
+
+func accountTokenPath(name string) (string, error) {
+	if file := legacyTokenFile(name); file != "" {
+		return config.Path(file), nil
+	}
+	if err := ValidateAccountName(name); err != nil {
+		return "", err
+	}
+	return accountPath(name), nil
+}
+
+func loadAccount(name string) (*Account, error) {
+	if err := ValidateAccountName(name); err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(accountPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("account %q not found, please run 'login add %s' first: %w", name, name, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	account := &Account{Name: name}
+	if err := json.Unmarshal(b, account); err != nil {
+		return nil, fmt.Errorf("unable to read account %q: %v", name, err)
+	}
+	return account, nil
+}
+
+func saveAccount(account *Account) error {
+	if err := os.MkdirAll(config.Path(accountsDir), 0700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(accountPath(account.Name), b, 0600)
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/config"
+	"golang.org/x/oauth2"
+)
+
+// useTempConfigDir points the config directory at an empty temporary one.
+func useTempConfigDir(t *testing.T) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+}
+
+func TestValidateAccountName(t *testing.T) {
+	for _, name := range []string{"personal", "work-acme", "family_2", "A1"} {
+		if err := ValidateAccountName(name); err != nil {
+			t.Errorf("%q: unexpected error: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "-work", "../source", "my account", "work.json"} {
+		if err := ValidateAccountName(name); err == nil {
+			t.Errorf("%q: expected an error", name)
+		}
+	}
+}
+
+func TestAccounts(t *testing.T) {
+	useTempConfigDir(t)
+
+	accounts, err := Accounts()
+	if err != nil || len(accounts) != 0 {
+		t.Fatalf("Expected no accounts, got %d (%v)", len(accounts), err)
+	}
+
+	saveToken(sourceTokenFile, &oauth2.Token{RefreshToken: "source"})
+	for _, name := range []string{"work-acme", "family", "personal"} {
+		if err := saveAccount(&Account{Name: name, ReadOnly: name == "family", Token: &oauth2.Token{RefreshToken: name}}); err != nil {
+			t.Fatalf("Unable to save account: %v", err)
+		}
+	}
+
+	accounts, err = Accounts()
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	names := []string{}
+	for _, account := range accounts {
+		names = append(names, account.Name)
+	}
+	want := []string{"source", "family", "personal", "work-acme"}
+	if len(names) != len(want) {
+		t.Fatalf("Expected accounts %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("Expected accounts %v, got %v", want, names)
+		}
+	}
+	if !accounts[0].Legacy || accounts[1].Legacy || !accounts[1].ReadOnly || accounts[2].ReadOnly {
+		t.Error("Unexpected account details")
+	}
+	if accounts[3].Token.RefreshToken != "work-acme" {
+		t.Errorf("Expected the account's token to be loaded, got %q", accounts[3].Token.RefreshToken)
+	}
+
+	if err := RemoveAccount("family"); err != nil {
+		t.Fatalf("Unable to remove account: %v", err)
+	}
+	if err := RemoveAccount("family"); err == nil {
+		t.Error("Expected an error removing an account twice")
+	}
+	if _, err := loadAccount("family"); err == nil {
+		t.Error("Expected a removed account to be gone")
+	}
+}
+
+func TestGetAccountTokenFromWebReservedNames(t *testing.T) {
+	useTempConfigDir(t)
+	for _, name := range []string{SourceAccount, DestinationAccount, "../x"} {
+		if err := GetAccountTokenFromWeb(name, false); err == nil {
+			t.Errorf("%q: expected an error", name)
+		}
+	}
+}
+
+func TestLogout(t *testing.T) {
+	useTempConfigDir(t)
+
+	revoked := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.PostFormValue("token")
+		if token == "expired" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid_token", "error_description": "Token expired or revoked"}`))
+			return
+		}
+		if token == "broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		revoked = append(revoked, token)
+	}))
+	t.Cleanup(server.Close)
+	original := revokeURL
+	revokeURL = server.URL
+	t.Cleanup(func() { revokeURL = original })
+
+	saveAccount(&Account{Name: "personal", Token: &oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}})
+	saveAccount(&Account{Name: "old", Token: &oauth2.Token{RefreshToken: "expired"}})
+	saveAccount(&Account{Name: "broken", Token: &oauth2.Token{RefreshToken: "broken"}})
+	saveToken(destTokenFile, &oauth2.Token{AccessToken: "destination"})
+
+	if err := Logout("personal"); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(revoked) != 1 || revoked[0] != "refresh" {
+		t.Errorf("Expected the refresh token to be revoked, got %v", revoked)
+	}
+	if _, err := loadAccount("personal"); err == nil {
+		t.Error("Expected the account to be removed")
+	}
+
+	if err := Logout(DestinationAccount); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if _, err := os.Stat(config.Path(destTokenFile)); !os.IsNotExist(err) {
+		t.Error("Expected the destination token file to be removed")
+	}
+
+	if err := Logout("old"); err != nil {
+		t.Errorf("Expected an already revoked token to log out, got %v", err)
+	}
+	if err := Logout("broken"); err == nil {
+		t.Error("Expected an error when revoking fails")
+	}
+	if _, err := loadAccount("broken"); err != nil {
+		t.Error("Expected the account to be kept when revoking fails")
+	}
+	if err := Logout("missing"); err == nil {
+		t.Error("Expected an error for an account that isn't logged in")
+	}
+}
@@ -4,11 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"path/filepath"
 
+	"github.com/davidpimentel/gcal-busy-blocker/internal/config"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/calendar/v3"
@@ -22,32 +22,42 @@ const (
 
 // Google Calendar permission scopes
 var (
-	sourceScope      = []string{calendar.CalendarEventsReadonlyScope}
-	destinationScope = []string{calendar.CalendarEventsScope}
+	sourceScope      = []string{calendar.CalendarEventsReadonlyScope, calendar.CalendarFreebusyScope, calendar.CalendarCalendarlistReadonlyScope}
+	sourceWriteScope = []string{calendar.CalendarEventsScope, calendar.CalendarFreebusyScope, calendar.CalendarCalendarlistReadonlyScope}
+	destinationScope = []string{calendar.CalendarEventsScope, calendar.CalendarCalendarlistReadonlyScope}
 )
 
-func baseConfigPath() string {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		log.Fatal("cannot find $HOME directory!")
-	}
-	path := filepath.Join(homeDir, ".config", "gcal-busy-blocker")
-	err = os.MkdirAll(path, 0755)
-	if err != nil {
-		log.Fatalf("Cannot make config directory: %s", path)
+// logger receives the progress and errors of the package, slog.Default() when
+// nil. Prompts of the login flows are printed to stdout instead.
+var logger *slog.Logger
+
+// SetLogger makes the package log to l.
+func SetLogger(l *slog.Logger) {
+	logger = l
+}
+
+func currentLogger() *slog.Logger {
+	if logger == nil {
+		return slog.Default()
 	}
-	return path
+	return logger
+}
+
+// fatal logs an error the command can't carry on from and exits.
+func fatal(msg string, err error) {
+	currentLogger().Error(msg, "error", err)
+	os.Exit(1)
 }
 
 func getOauthConfig(scope []string) *oauth2.Config {
-	b, err := os.ReadFile(filepath.Join(baseConfigPath(), credentialsFile))
+	b, err := os.ReadFile(config.Path(credentialsFile))
 	if err != nil {
-		log.Fatalf("Unable to read credentials.json: %v", err)
+		fatal("Unable to read credentials.json", err)
 	}
 
 	config, err := google.ConfigFromJSON(b, scope...)
 	if err != nil {
-		log.Fatalf("unable to parse client secret file to config: %v", err)
+		fatal("Unable to parse client secret file to config", err)
 	}
 	config.RedirectURL = "urn:ietf:wg:oauth:2.0:oob"
 	return config
@@ -72,8 +82,14 @@ func getClient(tokenFile string, scope []string) (*http.Client, error) {
 	return config.Client(context.Background(), tok), nil
 }
 
-func GetSourceTokenFromWeb() {
-	getTokenFromWeb(sourceTokenFile, sourceScope)
+// GetSourceTokenFromWeb authorizes the source account. The source is only
+// read from unless write is set, which is needed to mark source events.
+func GetSourceTokenFromWeb(write bool) {
+	if write {
+		getTokenFromWeb(sourceTokenFile, sourceWriteScope)
+	} else {
+		getTokenFromWeb(sourceTokenFile, sourceScope)
+	}
 }
 
 func GetDestinationTokenFromWeb() {
@@ -81,6 +97,14 @@ func GetDestinationTokenFromWeb() {
 }
 
 func getTokenFromWeb(tokenFile string, scope []string) {
+	tok := exchangeTokenFromWeb(scope)
+	saveToken(tokenFile, tok)
+	fmt.Printf("Authentication successful! Token saved to %s\n", tokenFile)
+}
+
+// exchangeTokenFromWeb has the user authorize the scopes in their browser and
+// returns the token for them.
+func exchangeTokenFromWeb(scope []string) *oauth2.Token {
 	config := getOauthConfig(scope)
 
 	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
@@ -89,19 +113,18 @@ func getTokenFromWeb(tokenFile string, scope []string) {
 
 	var authCode string
 	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Fatalf("Unable to read authorization code: %v", err)
+		fatal("Unable to read authorization code", err)
 	}
 
 	tok, err := config.Exchange(context.TODO(), authCode)
 	if err != nil {
-		log.Fatalf("Unable to retrieve token from web: %v", err)
+		fatal("Unable to retrieve token from web", err)
 	}
-	saveToken(tokenFile, tok)
-	fmt.Printf("Authentication successful! Token saved to %s\n", tokenFile)
+	return tok
 }
 
 func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(filepath.Join(baseConfigPath(), file))
+	f, err := os.Open(config.Path(file))
 	if err != nil {
 		return nil, err
 	}
@@ -112,10 +135,10 @@ func tokenFromFile(file string) (*oauth2.Token, error) {
 }
 
 func saveToken(path string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", path)
-	f, err := os.OpenFile(filepath.Join(baseConfigPath(), path), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	currentLogger().Info("Saving credential file", "file", path)
+	f, err := os.OpenFile(config.Path(path), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		fatal("Unable to cache oauth token", err)
 	}
 	defer f.Close()
 	json.NewEncoder(f).Encode(token)
@@ -124,10 +147,10 @@ func saveToken(path string, token *oauth2.Token) {
 func CopyCredentialsFile(filePath string) error {
 	b, err := os.ReadFile(filePath)
 	if err != nil {
-		log.Fatalf("Unable to read credentials.json: %v", err)
+		fatal("Unable to read credentials.json", err)
 	}
 
-	err = os.WriteFile(filepath.Join(baseConfigPath(), credentialsFile), b, 0600) // 0644 sets file permissions
+	err = os.WriteFile(config.Path(credentialsFile), b, 0600) // 0644 sets file permissions
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/config"
+)
+
+// CalDAVCredentials are what's needed to reach a CalDAV calendar. Servers like
+// Fastmail and Nextcloud hand out app passwords for this.
+type CalDAVCredentials struct {
+	CalendarURL string `json:"calendarUrl"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+}
+
+func caldavCredentialsFile(account string) string {
+	return fmt.Sprintf("caldav_%s.json", account)
+}
+
+// SaveCalDAVCredentials stores the CalDAV credentials of the source or
+// destination account.
+func SaveCalDAVCredentials(account string, credentials *CalDAVCredentials) error {
+	b, err := json.Marshal(credentials)
+	if err != nil {
+		return err
+	}
+	path := config.Path(caldavCredentialsFile(account))
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("CalDAV credentials saved to %s\n", path)
+	return nil
+}
+
+// LoadCalDAVCredentials returns the CalDAV credentials of the source or
+// destination account.
+func LoadCalDAVCredentials(account string) (*CalDAVCredentials, error) {
+	b, err := os.ReadFile(config.Path(caldavCredentialsFile(account)))
+	if err != nil {
+		return nil, fmt.Errorf("CalDAV credentials not found, please run 'login %s --caldav-url <url>' first: %v", account, err)
+	}
+	credentials := &CalDAVCredentials{}
+	if err := json.Unmarshal(b, credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	gosync "sync"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/microsoft"
+)
+
+const microsoftDestTokenFile = "microsoft_destination_token.json"
+
+// Microsoft Graph permission scopes, offline_access is what gets a refresh token
+var microsoftDestinationScope = []string{"offline_access", "https://graph.microsoft.com/Calendars.ReadWrite"}
+
+// microsoftToken is saved along with the app it was issued to, since there's
+// no credentials file to read that from when refreshing it.
+type microsoftToken struct {
+	ClientID string        `json:"clientId"`
+	Tenant   string        `json:"tenant"`
+	Token    *oauth2.Token `json:"token"`
+}
+
+func getMicrosoftOauthConfig(clientId string, tenant string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID: clientId,
+		Endpoint: microsoft.AzureADEndpoint(tenant),
+		Scopes:   microsoftDestinationScope,
+	}
+}
+
+// GetMicrosoftDestinationTokenFromWeb authorizes a Microsoft 365 destination
+// account with the device code flow, using the app registered in Entra ID with
+// the given client ID.
+func GetMicrosoftDestinationTokenFromWeb(clientId string, tenant string) {
+	config := getMicrosoftOauthConfig(clientId, tenant)
+
+	response, err := config.DeviceAuth(context.Background())
+	if err != nil {
+		fatal("Unable to start Microsoft login", err)
+	}
+	fmt.Printf("Go to the following link in your browser:\n%v\n", response.VerificationURI)
+	fmt.Printf("Enter the code %s and sign in. Waiting...\n", response.UserCode)
+
+	tok, err := config.DeviceAccessToken(context.Background(), response)
+	if err != nil {
+		fatal("Unable to retrieve token from web", err)
+	}
+	saveMicrosoftToken(&microsoftToken{ClientID: clientId, Tenant: tenant, Token: tok})
+	fmt.Printf("Authentication successful! Token saved to %s\n", microsoftDestTokenFile)
+}
+
+// MicrosoftDestinationClient returns a client for Microsoft Graph signed in as
+// the destination account.
+func MicrosoftDestinationClient() (*http.Client, error) {
+	b, err := os.ReadFile(config.Path(microsoftDestTokenFile))
+	if err != nil {
+		return nil, fmt.Errorf("token not found, please run 'login destination --provider microsoft' first: %v", err)
+	}
+	saved := &microsoftToken{}
+	if err := json.Unmarshal(b, saved); err != nil {
+		return nil, err
+	}
+
+	config := getMicrosoftOauthConfig(saved.ClientID, saved.Tenant)
+	source := &savingTokenSource{
+		source: config.TokenSource(context.Background(), saved.Token),
+		saved:  saved,
+	}
+	return oauth2.NewClient(context.Background(), source), nil
+}
+
+// savingTokenSource writes refreshed tokens back to disk. Microsoft hands out a
+// new refresh token on every refresh and the old ones eventually expire.
+type savingTokenSource struct {
+	mu     gosync.Mutex
+	source oauth2.TokenSource
+	saved  *microsoftToken
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tok, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok.AccessToken != s.saved.Token.AccessToken {
+		currentLogger().Debug("Saving the refreshed Microsoft token")
+		s.saved.Token = tok
+		saveMicrosoftToken(s.saved)
+	}
+	return tok, nil
+}
+
+func saveMicrosoftToken(token *microsoftToken) {
+	f, err := os.OpenFile(config.Path(microsoftDestTokenFile), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		fatal("Unable to cache oauth token", err)
+	}
+	defer f.Close()
+	json.NewEncoder(f).Encode(token)
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServiceAccountClient(t *testing.T) {
+	claims := map[string]any{}
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.PostFormValue("assertion"), ".")
+		if len(parts) == 3 {
+			payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			json.Unmarshal(payload, &claims)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "alice-token", "token_type": "Bearer", "expires_in": 3600}`))
+	}))
+	t.Cleanup(tokenServer.Close)
+
+	var authorization string
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	t.Cleanup(apiServer.Close)
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "blocker@acme-project.iam.gserviceaccount.com",
+		"private_key_id": "1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":      tokenServer.URL,
+	})
+	keyFile := filepath.Join(t.TempDir(), "key.json")
+	os.WriteFile(keyFile, key, 0600)
+
+	client, err := ServiceAccountClient(keyFile, "alice@acme.com")
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	resp, err := client.Get(apiServer.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if claims["sub"] != "alice@acme.com" {
+		t.Errorf("Expected the token to be asked for as the impersonated user, got subject %v", claims["sub"])
+	}
+	if claims["iss"] != "blocker@acme-project.iam.gserviceaccount.com" {
+		t.Errorf("Expected the service account to sign the assertion, got issuer %v", claims["iss"])
+	}
+	if authorization != "Bearer alice-token" {
+		t.Errorf("Expected requests to carry the impersonated user's token, got %q", authorization)
+	}
+
+	if _, err := ServiceAccountClient(keyFile, ""); err == nil {
+		t.Error("Expected an error without a user to impersonate")
+	}
+	if _, err := ServiceAccountClient(filepath.Join(t.TempDir(), "missing.json"), "alice@acme.com"); err == nil {
+		t.Error("Expected an error for a missing key file")
+	}
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// WebOauthConfig returns the OAuth config a web server uses to connect a
+// source or destination account, with Google sending the user back to
+// redirectURL. The OAuth client in credentials.json has to allow that URL.
+func WebOauthConfig(account string, redirectURL string) (*oauth2.Config, error) {
+	var scope []string
+	switch account {
+	case SourceAccount:
+		scope = sourceScope
+	case DestinationAccount:
+		scope = destinationScope
+	default:
+		return nil, fmt.Errorf("unknown account %q, expected source or destination", account)
+	}
+
+	b, err := os.ReadFile(config.Path(credentialsFile))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read credentials.json: %v", err)
+	}
+	oauthConfig, err := google.ConfigFromJSON(b, scope...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse client secret file to config: %v", err)
+	}
+	oauthConfig.RedirectURL = redirectURL
+	return oauthConfig, nil
+}
@@ -0,0 +1,236 @@
+// Package caldavtest provides an in-process CalDAV server for tests, holding
+// a single calendar collection in memory.
+package caldavtest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/ics"
+)
+
+// CalendarPath is where the calendar collection lives on the server.
+const CalendarPath = "/dav/calendars/user/work/"
+
+// Server is a CalDAV server supporting calendar-query REPORTs with a time
+// range, and conditional GET, PUT and DELETE of calendar objects.
+type Server struct {
+	*httptest.Server
+	// Username and Password are required with basic auth when set
+	Username string
+	Password string
+
+	mu       gosync.Mutex
+	objects  map[string]*object
+	etag     int
+	requests []string
+}
+
+type object struct {
+	etag string
+	data string
+}
+
+// NewServer starts a server with an empty calendar. Close it when done.
+func NewServer() *Server {
+	s := &Server{objects: map[string]*object{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// CalendarURL is the URL of the calendar collection.
+func (s *Server) CalendarURL() string {
+	return s.URL + CalendarPath
+}
+
+// AddObject stores a calendar object with the given resource name, as if
+// another client created it.
+func (s *Server) AddObject(name string, data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[CalendarPath+name] = &object{etag: s.nextETag(), data: data}
+}
+
+// Object returns the data of a calendar object, if it exists.
+func (s *Server) Object(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.objects[CalendarPath+name]
+	if !ok {
+		return "", false
+	}
+	return o.data, true
+}
+
+// Objects returns the data of every calendar object, sorted by name.
+func (s *Server) Objects() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hrefs := []string{}
+	for href := range s.objects {
+		hrefs = append(hrefs, href)
+	}
+	sort.Strings(hrefs)
+	data := []string{}
+	for _, href := range hrefs {
+		data = append(data, s.objects[href].data)
+	}
+	return data
+}
+
+// Requests returns the method and path of every request received so far.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+func (s *Server) nextETag() string {
+	s.etag++
+	return fmt.Sprintf(`"%d"`, s.etag)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	if s.Username != "" {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != s.Username || password != s.Password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="caldav"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	switch {
+	case r.Method == "REPORT" && r.URL.Path == CalendarPath:
+		s.report(w, r)
+	case !strings.HasPrefix(r.URL.Path, CalendarPath) || r.URL.Path == CalendarPath:
+		http.NotFound(w, r)
+	case r.Method == http.MethodGet:
+		o, ok := s.objects[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", o.etag)
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		io.WriteString(w, o.data)
+	case r.Method == http.MethodPut:
+		s.put(w, r)
+	case r.Method == http.MethodDelete:
+		o, ok := s.objects[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if match := r.Header.Get("If-Match"); match != "" && match != o.etag {
+			http.Error(w, "etag mismatch", http.StatusPreconditionFailed)
+			return
+		}
+		delete(s.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request) {
+	existing, exists := s.objects[r.URL.Path]
+	if r.Header.Get("If-None-Match") == "*" && exists {
+		http.Error(w, "already exists", http.StatusPreconditionFailed)
+		return
+	}
+	if match := r.Header.Get("If-Match"); match != "" && (!exists || match != existing.etag) {
+		http.Error(w, "etag mismatch", http.StatusPreconditionFailed)
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := ics.ParseCalendar(strings.NewReader(string(data)), time.UTC); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	o := &object{etag: s.nextETag(), data: string(data)}
+	s.objects[r.URL.Path] = o
+	w.Header().Set("ETag", o.etag)
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+var (
+	timeRangeStart = regexp.MustCompile(`time-range[^>]*start="([0-9TZ]+)"`)
+	timeRangeEnd   = regexp.MustCompile(`time-range[^>]*end="([0-9TZ]+)"`)
+)
+
+func (s *Server) report(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !strings.Contains(string(body), "calendar-query") {
+		http.Error(w, "only calendar-query is supported", http.StatusBadRequest)
+		return
+	}
+
+	// An open range reaches far enough for any test
+	start := time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+	if match := timeRangeStart.FindSubmatch(body); match != nil {
+		start, _ = time.Parse("20060102T150405Z", string(match[1]))
+	}
+	if match := timeRangeEnd.FindSubmatch(body); match != nil {
+		end, _ = time.Parse("20060102T150405Z", string(match[1]))
+	}
+
+	hrefs := []string{}
+	for href := range s.objects {
+		hrefs = append(hrefs, href)
+	}
+	sort.Strings(hrefs)
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	b.WriteString(`<D:multistatus xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">`)
+	for _, href := range hrefs {
+		o := s.objects[href]
+		calendar, err := ics.ParseCalendar(strings.NewReader(o.data), time.UTC)
+		if err != nil || len(calendar.Expand(start, end, time.UTC)) == 0 {
+			continue
+		}
+		b.WriteString("<D:response><D:href>" + escape(href) + "</D:href><D:propstat><D:prop>")
+		b.WriteString("<D:getetag>" + escape(o.etag) + "</D:getetag>")
+		b.WriteString("<C:calendar-data>" + escape(o.data) + "</C:calendar-data>")
+		b.WriteString("</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>")
+	}
+	b.WriteString("</D:multistatus>")
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, b.String())
+}
+
+func escape(value string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(value))
+	return b.String()
+}
@@ -0,0 +1,253 @@
+// Package caldav is a small CalDAV client covering what syncing busy blocks
+// needs: querying a calendar collection for events in a time range and
+// creating, replacing and deleting calendar object resources.
+package caldav
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Object is a calendar object resource, i.e. one .ics file in the collection.
+type Object struct {
+	// Href is the path of the resource on the server
+	Href string
+	ETag string
+	// Data is the iCalendar content of the resource
+	Data string
+}
+
+// StatusError is returned when the server answers with an unexpected status.
+type StatusError struct {
+	Method     string
+	Href       string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.Href, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// PreconditionFailed reports whether the resource changed on the server since
+// its ETag was read.
+func (e *StatusError) PreconditionFailed() bool {
+	return e.StatusCode == http.StatusPreconditionFailed
+}
+
+// Client talks to a single calendar collection.
+type Client struct {
+	httpClient  *http.Client
+	calendarURL *url.URL
+}
+
+// NewClient returns a client for the calendar collection at calendarURL.
+func NewClient(httpClient *http.Client, calendarURL string) (*Client, error) {
+	u, err := url.Parse(calendarURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid calendar URL %q: %v", calendarURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid calendar URL %q: expected an http or https URL", calendarURL)
+	}
+	// Resources are resolved against the collection, which only works when it
+	// ends with a slash
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return &Client{httpClient: httpClient, calendarURL: u}, nil
+}
+
+// requestTimeout is how long a request to the server can take, so one that
+// stops answering fails the sync rather than hanging it
+var requestTimeout = time.Minute
+
+// BasicAuthClient returns an HTTP client that signs in with a username and
+// password, which is what most CalDAV servers use with app passwords.
+func BasicAuthClient(username string, password string) *http.Client {
+	return &http.Client{Transport: &basicAuthTransport{username: username, password: password}, Timeout: requestTimeout}
+}
+
+type basicAuthTransport struct {
+	username string
+	password string
+}
+
+func (t *basicAuthTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.SetBasicAuth(t.username, t.password)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// Href returns the path of a resource with the given name in the collection.
+func (c *Client) Href(name string) string {
+	return c.calendarURL.JoinPath(name).EscapedPath()
+}
+
+const calendarQueryTemplate = `<?xml version="1.0" encoding="utf-8"?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <D:getetag/>
+    <C:calendar-data/>
+  </D:prop>
+  <C:filter>
+    <C:comp-filter name="VCALENDAR">
+      <C:comp-filter name="VEVENT">%s</C:comp-filter>
+    </C:comp-filter>
+  </C:filter>
+</C:calendar-query>`
+
+// Query returns the objects with an event overlapping the time range. Either
+// end of the range may be left zero to leave it open.
+func (c *Client) Query(start time.Time, end time.Time) ([]*Object, error) {
+	timeRange := ""
+	if !start.IsZero() || !end.IsZero() {
+		timeRange = "<C:time-range"
+		if !start.IsZero() {
+			timeRange += fmt.Sprintf(` start="%s"`, start.UTC().Format("20060102T150405Z"))
+		}
+		if !end.IsZero() {
+			timeRange += fmt.Sprintf(` end="%s"`, end.UTC().Format("20060102T150405Z"))
+		}
+		timeRange += "/>"
+	}
+
+	req, err := http.NewRequest("REPORT", c.calendarURL.String(), strings.NewReader(fmt.Sprintf(calendarQueryTemplate, timeRange)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", "1")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, &StatusError{Method: req.Method, Href: c.calendarURL.Path, StatusCode: resp.StatusCode}
+	}
+
+	result := &multistatus{}
+	if err := xml.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("invalid REPORT response: %v", err)
+	}
+	objects := []*Object{}
+	for _, response := range result.Responses {
+		for _, propstat := range response.Propstats {
+			// Properties the server couldn't return come back with their own status
+			if !strings.Contains(propstat.Status, " 200 ") || propstat.Prop.CalendarData == "" {
+				continue
+			}
+			objects = append(objects, &Object{Href: response.Href, ETag: propstat.Prop.ETag, Data: propstat.Prop.CalendarData})
+		}
+	}
+	return objects, nil
+}
+
+// Get fetches a single object.
+func (c *Client) Get(href string) (*Object, error) {
+	resp, err := c.do(http.MethodGet, href, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Method: http.MethodGet, Href: href, StatusCode: resp.StatusCode}
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Object{Href: href, ETag: resp.Header.Get("ETag"), Data: string(data)}, nil
+}
+
+// Put stores an object and returns its new ETag. An empty etag creates the
+// object and fails if it already exists, otherwise the object is only replaced
+// if it still has that ETag.
+func (c *Client) Put(href string, data string, etag string) (string, error) {
+	header := http.Header{"Content-Type": {"text/calendar; charset=utf-8"}}
+	if etag == "" {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", etag)
+	}
+
+	resp, err := c.do(http.MethodPut, href, header, strings.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return "", &StatusError{Method: http.MethodPut, Href: href, StatusCode: resp.StatusCode}
+	}
+	if newETag := resp.Header.Get("ETag"); newETag != "" {
+		return newETag, nil
+	}
+
+	// Servers that change the data they're given don't return an ETag
+	object, err := c.Get(href)
+	if err != nil {
+		return "", err
+	}
+	return object.ETag, nil
+}
+
+// Delete removes an object if it still has the given ETag, or unconditionally
+// if etag is empty.
+func (c *Client) Delete(href string, etag string) error {
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-Match", etag)
+	}
+	resp, err := c.do(http.MethodDelete, href, header, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return &StatusError{Method: http.MethodDelete, Href: href, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+func (c *Client) do(method string, href string, header http.Header, body io.Reader) (*http.Response, error) {
+	// Hrefs come back from the server already escaped
+	ref, err := url.Parse(href)
+	if err != nil {
+		return nil, fmt.Errorf("invalid href %q: %v", href, err)
+	}
+	target := c.calendarURL.ResolveReference(ref)
+	req, err := http.NewRequest(method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	return c.httpClient.Do(req)
+}
+
+type multistatus struct {
+	XMLName   xml.Name   `xml:"DAV: multistatus"`
+	Responses []response `xml:"DAV: response"`
+}
+
+type response struct {
+	Href      string     `xml:"DAV: href"`
+	Propstats []propstat `xml:"DAV: propstat"`
+}
+
+type propstat struct {
+	Status string `xml:"DAV: status"`
+	Prop   prop   `xml:"DAV: prop"`
+}
+
+type prop struct {
+	ETag         string `xml:"DAV: getetag"`
+	CalendarData string `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+}
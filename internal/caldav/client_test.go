@@ -0,0 +1,142 @@
+package caldav
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/caldav/caldavtest"
+)
+
+func testEvent(uid string, start string, end string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nUID:" + uid + "\r\nDTSTART:" + start + "\r\nDTEND:" + end + "\r\nSUMMARY:Test\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+}
+
+func newTestClient(t *testing.T, server *caldavtest.Server) *Client {
+	t.Helper()
+	client, err := NewClient(BasicAuthClient("jane", "app-password"), server.URL+strings.TrimSuffix(caldavtest.CalendarPath, "/"))
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	return client
+}
+
+func TestNewClientInvalidURL(t *testing.T) {
+	for _, calendarURL := range []string{"", "ftp://example.com/cal/", "://"} {
+		if _, err := NewClient(http.DefaultClient, calendarURL); err == nil {
+			t.Errorf("%q: expected an error", calendarURL)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	server := caldavtest.NewServer()
+	defer server.Close()
+	server.Username, server.Password = "jane", "app-password"
+	server.AddObject("march.ics", testEvent("march", "20260310T090000Z", "20260310T100000Z"))
+	server.AddObject("april.ics", testEvent("april", "20260410T090000Z", "20260410T100000Z"))
+	client := newTestClient(t, server)
+
+	objects, err := client.Query(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(objects) != 1 || objects[0].Href != caldavtest.CalendarPath+"march.ics" || objects[0].ETag == "" {
+		t.Fatalf("Expected the march object, got %+v", objects)
+	}
+	if !strings.Contains(objects[0].Data, "UID:march\r\n") {
+		t.Errorf("Calendar data wasn't returned intact: %q", objects[0].Data)
+	}
+
+	all, err := client.Query(time.Time{}, time.Time{})
+	if err != nil || len(all) != 2 {
+		t.Errorf("Expected both objects without a time range, got %d (%v)", len(all), err)
+	}
+}
+
+func TestQueryUnauthorized(t *testing.T) {
+	server := caldavtest.NewServer()
+	defer server.Close()
+	server.Username, server.Password = "jane", "other-password"
+	client := newTestClient(t, server)
+
+	_, err := client.Query(time.Time{}, time.Time{})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a 401 error, got %v", err)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	timeout := requestTimeout
+	requestTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { requestTimeout = timeout })
+
+	hung := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer server.Close()
+	defer close(hung)
+
+	client, err := NewClient(BasicAuthClient("jane", "app-password"), server.URL+"/calendars/jane/personal/")
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if _, err := client.Query(time.Now(), time.Now().Add(time.Hour)); err == nil {
+		t.Error("Expected a server that doesn't answer to time out")
+	}
+}
+
+func TestPutAndDelete(t *testing.T) {
+	server := caldavtest.NewServer()
+	defer server.Close()
+	client := newTestClient(t, server)
+	href := client.Href("block.ics")
+	if href != caldavtest.CalendarPath+"block.ics" {
+		t.Fatalf("Unexpected href %s", href)
+	}
+
+	etag, err := client.Put(href, testEvent("block", "20260310T090000Z", "20260310T100000Z"), "")
+	if err != nil || etag == "" {
+		t.Fatalf("Expected the object to be created, got %q (%v)", etag, err)
+	}
+	// Creating it again must not overwrite it
+	if _, err := client.Put(href, testEvent("block", "20260310T090000Z", "20260310T100000Z"), ""); !isPreconditionFailed(err) {
+		t.Errorf("Expected creating an existing object to fail, got %v", err)
+	}
+
+	newETag, err := client.Put(href, testEvent("block", "20260310T110000Z", "20260310T120000Z"), etag)
+	if err != nil || newETag == etag {
+		t.Fatalf("Expected the object to be replaced, got %q (%v)", newETag, err)
+	}
+	if _, err := client.Put(href, testEvent("block", "20260310T130000Z", "20260310T140000Z"), etag); !isPreconditionFailed(err) {
+		t.Errorf("Expected a stale ETag to be rejected, got %v", err)
+	}
+	if data, _ := server.Object("block.ics"); !strings.Contains(data, "DTSTART:20260310T110000Z") {
+		t.Errorf("Unexpected object %q", data)
+	}
+
+	object, err := client.Get(href)
+	if err != nil || object.ETag != newETag {
+		t.Errorf("Expected to get the object back, got %+v (%v)", object, err)
+	}
+
+	if err := client.Delete(href, etag); !isPreconditionFailed(err) {
+		t.Errorf("Expected deleting with a stale ETag to fail, got %v", err)
+	}
+	if err := client.Delete(href, newETag); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if _, ok := server.Object("block.ics"); ok {
+		t.Error("Expected the object to be deleted")
+	}
+}
+
+func isPreconditionFailed(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.PreconditionFailed()
+}
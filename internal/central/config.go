@@ -0,0 +1,137 @@
+package central
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const defaultDaysAhead = 30
+
+// Config lists the users a central runner blocks time for. Their work
+// calendars are written to as them by a service account with domain-wide
+// delegation, so none of them has to log in.
+type Config struct {
+	// ServiceAccountKey is the path of the service account's JSON key
+	ServiceAccountKey string `json:"serviceAccountKey"`
+	// DaysAhead is how many days into the future to sync, 30 by default
+	DaysAhead int     `json:"daysAhead,omitempty"`
+	Users     []*User `json:"users"`
+}
+
+// User is the sync configuration of one person.
+type User struct {
+	// Email is the Workspace user whose calendar gets the blocks
+	Email  string  `json:"email"`
+	Source *Source `json:"source"`
+	// DestinationCalendar is the ID or name of the user's calendar to write
+	// to, their primary calendar by default
+	DestinationCalendar string `json:"destinationCalendar,omitempty"`
+	// TimeZone is the IANA zone of the sync window and block times, the
+	// runner's zone by default
+	TimeZone string `json:"timeZone,omitempty"`
+	// Rules is the path of the user's rules file
+	Rules    string `json:"rules,omitempty"`
+	SkipBusy bool   `json:"skipBusy,omitempty"`
+}
+
+// Source is where a user's events are read from: an iCalendar feed, such as
+// the secret address of a personal calendar, or a named account logged in to
+// on the machine running the sync.
+type Source struct {
+	// Mode is events, freebusy or ics, like the sync command's --source-mode
+	Mode string `json:"mode"`
+	// Account is the named account read in events and freebusy modes
+	Account string `json:"account,omitempty"`
+	// Calendar is the ID or name of the calendar read in events mode
+	Calendar string `json:"calendar,omitempty"`
+	// Calendars are the IDs read in freebusy mode, the primary one by default
+	Calendars []string `json:"calendars,omitempty"`
+	// ICS is the path or URL of the feed read in ics mode
+	ICS string `json:"ics,omitempty"`
+}
+
+// LoadConfig reads and checks a config file. Relative paths in it are taken
+// from the directory the file is in.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := json.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("unable to parse config file %s: %v", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	config.ServiceAccountKey = resolvePath(dir, config.ServiceAccountKey)
+	for _, user := range config.Users {
+		user.Rules = resolvePath(dir, user.Rules)
+		if !strings.Contains(user.Source.ICS, "://") {
+			user.Source.ICS = resolvePath(dir, user.Source.ICS)
+		}
+	}
+	if config.DaysAhead == 0 {
+		config.DaysAhead = defaultDaysAhead
+	}
+	return config, nil
+}
+
+func (c *Config) validate() error {
+	if c.ServiceAccountKey == "" {
+		return errors.New("serviceAccountKey is missing")
+	}
+	if c.DaysAhead < 0 {
+		return errors.New("daysAhead can't be negative")
+	}
+	if len(c.Users) == 0 {
+		return errors.New("no users")
+	}
+	emails := []string{}
+	for i, user := range c.Users {
+		if user.Email == "" {
+			return fmt.Errorf("user %d has no email", i+1)
+		}
+		if slices.Contains(emails, user.Email) {
+			return fmt.Errorf("user %s is listed more than once", user.Email)
+		}
+		emails = append(emails, user.Email)
+		if err := user.Source.validate(); err != nil {
+			return fmt.Errorf("user %s: %v", user.Email, err)
+		}
+	}
+	return nil
+}
+
+func (s *Source) validate() error {
+	if s == nil {
+		return errors.New("source is missing")
+	}
+	switch s.Mode {
+	case "events", "freebusy":
+		if s.Account == "" {
+			return fmt.Errorf("%s sources need an account", s.Mode)
+		}
+	case "ics":
+		if s.ICS == "" {
+			return errors.New("ics sources need a file or URL")
+		}
+	default:
+		return fmt.Errorf("unknown source mode %q, expected events, freebusy or ics", s.Mode)
+	}
+	return nil
+}
+
+func resolvePath(dir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
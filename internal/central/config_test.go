@@ -0,0 +1,81 @@
+package central
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `{
+		"serviceAccountKey": "key.json",
+		"users": [
+			{"email": "alice@acme.com", "source": {"mode": "ics", "ics": "https://calendar.example.com/alice.ics"}, "rules": "rules/alice.json"},
+			{"email": "bob@acme.com", "source": {"mode": "ics", "ics": "feeds/bob.ics"}, "destinationCalendar": "Busy", "timeZone": "Europe/Berlin"},
+			{"email": "carol@acme.com", "source": {"mode": "events", "account": "carol-personal", "calendar": "Family"}}
+		]
+	}`)
+	dir := filepath.Dir(path)
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if config.ServiceAccountKey != filepath.Join(dir, "key.json") {
+		t.Errorf("Expected the key path to be relative to the config file, got %s", config.ServiceAccountKey)
+	}
+	if config.DaysAhead != defaultDaysAhead {
+		t.Errorf("Expected %d days ahead by default, got %d", defaultDaysAhead, config.DaysAhead)
+	}
+	if len(config.Users) != 3 {
+		t.Fatalf("Expected 3 users, got %d", len(config.Users))
+	}
+	if config.Users[0].Source.ICS != "https://calendar.example.com/alice.ics" {
+		t.Errorf("Expected URLs to be left as they are, got %s", config.Users[0].Source.ICS)
+	}
+	if config.Users[0].Rules != filepath.Join(dir, "rules", "alice.json") {
+		t.Errorf("Unexpected rules path %s", config.Users[0].Rules)
+	}
+	if config.Users[1].Source.ICS != filepath.Join(dir, "feeds", "bob.ics") {
+		t.Errorf("Expected feed files to be relative to the config file, got %s", config.Users[1].Source.ICS)
+	}
+	if config.Users[2].Source.Account != "carol-personal" || config.Users[2].Rules != "" {
+		t.Error("Unexpected user details")
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := []struct {
+		config string
+		error  string
+	}{
+		{`{"users": [{"email": "alice@acme.com", "source": {"mode": "ics", "ics": "a.ics"}}]}`, "serviceAccountKey"},
+		{`{"serviceAccountKey": "key.json", "users": []}`, "no users"},
+		{`{"serviceAccountKey": "key.json", "users": [{"source": {"mode": "ics", "ics": "a.ics"}}]}`, "no email"},
+		{`{"serviceAccountKey": "key.json", "users": [{"email": "alice@acme.com"}]}`, "source is missing"},
+		{`{"serviceAccountKey": "key.json", "users": [{"email": "alice@acme.com", "source": {"mode": "events"}}]}`, "need an account"},
+		{`{"serviceAccountKey": "key.json", "users": [{"email": "alice@acme.com", "source": {"mode": "ics"}}]}`, "file or URL"},
+		{`{"serviceAccountKey": "key.json", "users": [{"email": "alice@acme.com", "source": {"mode": "caldav"}}]}`, "unknown source mode"},
+		{`{"serviceAccountKey": "key.json", "users": [
+			{"email": "alice@acme.com", "source": {"mode": "ics", "ics": "a.ics"}},
+			{"email": "alice@acme.com", "source": {"mode": "ics", "ics": "b.ics"}}
+		]}`, "more than once"},
+		{`{"serviceAccountKey": "key.json", "daysAhead": -1, "users": [{"email": "alice@acme.com", "source": {"mode": "ics", "ics": "a.ics"}}]}`, "negative"},
+	}
+	for _, test := range tests {
+		_, err := LoadConfig(writeConfig(t, test.config))
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("Expected an error about %q, got %v", test.error, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package central
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/audit"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/auth"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/metrics"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/sync"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/tracing"
+)
+
+// Runner syncs the calendars of every user in a config.
+type Runner struct {
+	config *Config
+	// cacheDir holds the downloaded iCalendar feeds
+	cacheDir string
+	// Audit logs the changes made to the users' calendars and Metrics
+	// measures their syncs, when set
+	Audit   *audit.Log
+	Metrics *metrics.SyncMetrics
+	// Logger receives the progress of the syncs, slog.Default() when nil
+	Logger *slog.Logger
+	// destination returns the calendars of a user, acting as them. Replaced
+	// in tests.
+	destination func(email string) (sync.CalendarEventsService, error)
+}
+
+// NewRunner returns a runner for the users in config, writing to their
+// calendars through the config's service account.
+func NewRunner(config *Config, cacheDir string) *Runner {
+	r := &Runner{config: config, cacheDir: cacheDir}
+	r.destination = func(email string) (sync.CalendarEventsService, error) {
+		client, err := auth.ServiceAccountClient(config.ServiceAccountKey, email)
+		if err != nil {
+			return nil, err
+		}
+		r.instrument(email, client)
+		return sync.NewCalendarEventsService(client)
+	}
+	return r
+}
+
+// Run syncs each user in turn. A user whose sync fails doesn't stop the
+// others, the error returned lists everyone who failed.
+func (r *Runner) Run(dryRun bool) error {
+	failed := []string{}
+	for _, user := range r.config.Users {
+		r.logger().Info("Syncing calendar", "account", user.Email)
+		if err := r.syncUser(user, dryRun); err != nil {
+			r.logger().Error("Sync failed", "account", user.Email, "error", err)
+			failed = append(failed, user.Email)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("sync failed for %d of %d users: %s", len(failed), len(r.config.Users), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (r *Runner) logger() *slog.Logger {
+	if r.Logger == nil {
+		return slog.Default()
+	}
+	return r.Logger
+}
+
+func (r *Runner) syncUser(user *User, dryRun bool) error {
+	var loc *time.Location
+	if user.TimeZone != "" {
+		var err error
+		loc, err = time.LoadLocation(user.TimeZone)
+		if err != nil {
+			return fmt.Errorf("unknown time zone %q: %v", user.TimeZone, err)
+		}
+	}
+
+	logger := r.logger().With("account", user.Email)
+	syncClient := &sync.SyncClient{Location: loc, SkipBusy: user.SkipBusy, Audit: r.Audit, Metrics: r.Metrics, DestinationAccount: user.Email, Logger: logger}
+	if user.Rules != "" {
+		rules, err := sync.LoadRules(user.Rules)
+		if err != nil {
+			return err
+		}
+		syncClient.Rules = rules
+	}
+
+	var err error
+	syncClient.SourceCalendarService, err = r.sourceService(user.Source, loc, logger)
+	if err != nil {
+		return fmt.Errorf("unable to open source calendar: %v", err)
+	}
+	syncClient.DestinationCalendarService, err = r.destination(user.Email)
+	if err != nil {
+		return fmt.Errorf("unable to open destination calendar: %v", err)
+	}
+
+	if user.Source.Mode == "events" {
+		syncClient.SourceCalendarId, err = sync.ResolveCalendarId(syncClient.SourceCalendarService, user.Source.Calendar)
+		if err != nil {
+			return err
+		}
+	}
+	syncClient.DestinationCalendarId, err = sync.ResolveCalendarId(syncClient.DestinationCalendarService, user.DestinationCalendar)
+	if err != nil {
+		return err
+	}
+	return syncClient.RunSync(r.config.DaysAhead, dryRun)
+}
+
+func (r *Runner) sourceService(source *Source, loc *time.Location, logger *slog.Logger) (sync.CalendarEventsService, error) {
+	switch source.Mode {
+	case "events":
+		client, err := auth.AccountClient(source.Account)
+		if err != nil {
+			return nil, err
+		}
+		r.instrument(source.Account, client)
+		return sync.NewCalendarEventsService(client)
+	case "freebusy":
+		client, err := auth.AccountClient(source.Account)
+		if err != nil {
+			return nil, err
+		}
+		r.instrument(source.Account, client)
+		calendarIds := source.Calendars
+		if len(calendarIds) == 0 {
+			calendarIds = []string{"primary"}
+		}
+		return sync.NewFreeBusyCalendarService(client, calendarIds)
+	case "ics":
+		return sync.NewICSCalendarService(source.ICS, r.cacheDir, loc, logger), nil
+	}
+	return nil, fmt.Errorf("unknown source mode %q", source.Mode)
+}
+
+// instrument traces the token refreshes of an account's client, and counts
+// its API errors when there are metrics.
+func (r *Runner) instrument(account string, client *http.Client) {
+	tracing.Instrument(account, client)
+	if r.Metrics != nil {
+		r.Metrics.Instrument(client)
+	}
+}
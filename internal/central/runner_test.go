@@ -0,0 +1,85 @@
+package central
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/sync"
+)
+
+// writeFeed writes an iCalendar file with an event starting tomorrow.
+func writeFeed(t *testing.T, dir string, name string, summary string) {
+	t.Helper()
+	start := time.Now().UTC().Add(24 * time.Hour).Truncate(time.Hour)
+	feed := fmt.Sprintf("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\nUID:%s@example.com\r\nDTSTAMP:20260101T000000Z\r\nDTSTART:%s\r\nDTEND:%s\r\nSUMMARY:%s\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+		name, start.Format("20060102T150405Z"), start.Add(time.Hour).Format("20060102T150405Z"), summary)
+	if err := os.WriteFile(filepath.Join(dir, name+".ics"), []byte(feed), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunnerRun(t *testing.T) {
+	dir := t.TempDir()
+	writeFeed(t, dir, "alice", "Dentist")
+	writeFeed(t, dir, "carol", "School pickup")
+
+	config := &Config{
+		ServiceAccountKey: filepath.Join(dir, "key.json"),
+		DaysAhead:         7,
+		Users: []*User{
+			{Email: "alice@acme.com", Source: &Source{Mode: "ics", ICS: filepath.Join(dir, "alice.ics")}},
+			{Email: "bob@acme.com", Source: &Source{Mode: "ics", ICS: filepath.Join(dir, "missing.ics")}},
+			{Email: "carol@acme.com", Source: &Source{Mode: "ics", ICS: filepath.Join(dir, "carol.ics")}, TimeZone: "America/New_York"},
+		},
+	}
+	runner := NewRunner(config, filepath.Join(dir, "cache"))
+	var logs bytes.Buffer
+	runner.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	// Each user's blocks go to a feed of their own instead of Google Calendar
+	runner.destination = func(email string) (sync.CalendarEventsService, error) {
+		return sync.NewICSFeedCalendarService(filepath.Join(dir, "busy-"+email+".ics"))
+	}
+
+	err := runner.Run(false)
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 users: bob@acme.com") {
+		t.Fatalf("Expected bob's sync to fail on its own, got %v", err)
+	}
+
+	for _, email := range []string{"alice@acme.com", "carol@acme.com"} {
+		feed, err := os.ReadFile(filepath.Join(dir, "busy-"+email+".ics"))
+		if err != nil {
+			t.Fatalf("Expected a block for %s: %v", email, err)
+		}
+		if strings.Count(string(feed), "BEGIN:VEVENT") != 1 {
+			t.Errorf("Expected one block for %s, got:\n%s", email, feed)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "busy-bob@acme.com.ics")); !os.IsNotExist(err) {
+		t.Error("Expected no blocks for bob")
+	}
+	if !strings.Contains(logs.String(), `msg="Sync failed" account=bob@acme.com`) {
+		t.Errorf("Expected bob's failure in the runner's logger, got:\n%s", logs.String())
+	}
+}
+
+func TestRunnerUnknownTimeZone(t *testing.T) {
+	dir := t.TempDir()
+	writeFeed(t, dir, "alice", "Dentist")
+	runner := NewRunner(&Config{DaysAhead: 7, Users: []*User{
+		{Email: "alice@acme.com", Source: &Source{Mode: "ics", ICS: filepath.Join(dir, "alice.ics")}, TimeZone: "Mars/Olympus_Mons"},
+	}}, dir)
+	runner.destination = func(email string) (sync.CalendarEventsService, error) {
+		t.Fatal("The destination shouldn't be opened")
+		return nil, nil
+	}
+	if err := runner.Run(false); err == nil {
+		t.Error("Expected an error for an unknown time zone")
+	}
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Dir returns the directory holding credentials, tokens and settings, creating
+// it if needed.
+func Dir() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		log.Fatal("cannot find $HOME directory!")
+	}
+	path := filepath.Join(homeDir, ".config", "gcal-busy-blocker")
+	err = os.MkdirAll(path, 0755)
+	if err != nil {
+		log.Fatalf("Cannot make config directory: %s", path)
+	}
+	return path
+}
+
+// Path returns the location of a file in the config directory.
+func Path(file string) string {
+	return filepath.Join(Dir(), file)
+}
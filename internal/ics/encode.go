@@ -0,0 +1,79 @@
+package ics
+
+import (
+	"bufio"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Lines longer than this many bytes are folded, as RFC 5545 asks
+const maxLineLength = 75
+
+// Encode writes a component and everything inside it as an iCalendar stream.
+func Encode(w io.Writer, c *Component) error {
+	buffered := bufio.NewWriter(w)
+	writeComponent(buffered, c)
+	return buffered.Flush()
+}
+
+func writeComponent(w *bufio.Writer, c *Component) {
+	writeLine(w, "BEGIN:"+c.Name)
+	for _, property := range c.Properties {
+		writeLine(w, formatContentLine(property))
+	}
+	for _, child := range c.Components {
+		writeComponent(w, child)
+	}
+	writeLine(w, "END:"+c.Name)
+}
+
+func formatContentLine(p *Property) string {
+	var b strings.Builder
+	b.WriteString(p.Name)
+	for _, name := range slices.Sorted(maps.Keys(p.Params)) {
+		value := p.Params[name]
+		b.WriteString(";" + name + "=")
+		if strings.ContainsAny(value, ";:,") {
+			value = `"` + value + `"`
+		}
+		b.WriteString(value)
+	}
+	b.WriteString(":" + p.Value)
+	return b.String()
+}
+
+// writeLine writes a content line, folding it without splitting a character.
+func writeLine(w *bufio.Writer, line string) {
+	limit := maxLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// The leading space of a continuation line counts towards its length
+		limit = maxLineLength - 1
+	}
+	w.WriteString(line + "\r\n")
+}
+
+// EscapeText encodes a TEXT value.
+func EscapeText(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return replacer.Replace(value)
+}
+
+// FormatDateTime formats an instant as a UTC DATE-TIME value.
+func FormatDateTime(t time.Time) string {
+	return t.UTC().Format(wallTimeLayout + "Z")
+}
+
+// FormatDate formats the date of t as a DATE value.
+func FormatDate(t time.Time) string {
+	return t.Format(dateLayout)
+}
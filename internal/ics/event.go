@@ -0,0 +1,292 @@
+package ics
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StatusCancelled = "CANCELLED"
+	wallTimeLayout  = "20060102T150405"
+	dateLayout      = "20060102"
+)
+
+// Calendar holds the events of a VCALENDAR, as written in the file. Expand
+// turns them into concrete instances.
+type Calendar struct {
+	Events []*Event
+	// Warnings describe parts of the file that were skipped
+	Warnings []string
+}
+
+// Event is a VEVENT, or an instance of one after expanding.
+type Event struct {
+	UID string
+	// RecurrenceID is the original start of the instance of a recurring event
+	// this is, or zero for events that don't recur
+	RecurrenceID time.Time
+	Summary      string
+	Description  string
+	Location     string
+	Status       string
+	Transparent  bool
+	// AllDay events start and end at midnight UTC on their dates, with End
+	// being the day after the last one. Other events hold the actual instants.
+	AllDay bool
+	Start  time.Time
+	End    time.Time
+	// XProperties holds any experimental X- properties by name
+	XProperties map[string]string
+
+	start        dateTime
+	end          dateTime
+	recurrenceID *dateTime
+	rule         *RRule
+	exdates      []dateTime
+	rdates       []dateTime
+}
+
+// dateTime is a DATE or DATE-TIME value as written, before it is resolved to
+// an instant.
+type dateTime struct {
+	wall time.Time
+	zone zone
+	date bool
+}
+
+func (d dateTime) instant() time.Time {
+	if d.date {
+		return d.wall
+	}
+	return d.zone.instant(d.wall)
+}
+
+// ParseCalendar reads the events of an iCalendar stream. Times without a zone
+// are taken to be in floating.
+func ParseCalendar(r io.Reader, floating *time.Location) (*Calendar, error) {
+	root, err := Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewCalendar(root, floating)
+}
+
+// NewCalendar reads the events of an already parsed VCALENDAR.
+func NewCalendar(root *Component, floating *time.Location) (*Calendar, error) {
+	if root.Name != "VCALENDAR" {
+		return nil, fmt.Errorf("expected VCALENDAR, found %s", root.Name)
+	}
+
+	parser := &calendarParser{floating: locationZone{loc: floating}, timezones: map[string]*vtimezone{}, zones: map[string]zone{}}
+	for _, component := range root.Components {
+		if component.Name != "VTIMEZONE" {
+			continue
+		}
+		tzid := component.PropertyValue("TZID")
+		timezone, err := parseVTimezone(component)
+		if err != nil {
+			parser.warn("skipping VTIMEZONE %s: %v", tzid, err)
+			continue
+		}
+		parser.timezones[tzid] = timezone
+	}
+
+	for _, component := range root.Components {
+		if component.Name != "VEVENT" {
+			continue
+		}
+		event, err := parser.parseEvent(component)
+		if err != nil {
+			parser.warn("skipping event %s: %v", component.PropertyValue("UID"), err)
+			continue
+		}
+		parser.calendar.Events = append(parser.calendar.Events, event)
+	}
+	return &parser.calendar, nil
+}
+
+type calendarParser struct {
+	calendar  Calendar
+	floating  zone
+	timezones map[string]*vtimezone
+	// zones caches resolved TZIDs, so times in the same zone compare equal
+	zones map[string]zone
+}
+
+func (p *calendarParser) warn(format string, args ...any) {
+	p.calendar.Warnings = append(p.calendar.Warnings, fmt.Sprintf(format, args...))
+}
+
+func (p *calendarParser) parseEvent(component *Component) (*Event, error) {
+	event := &Event{
+		UID:         component.PropertyValue("UID"),
+		Summary:     UnescapeText(component.PropertyValue("SUMMARY")),
+		Description: UnescapeText(component.PropertyValue("DESCRIPTION")),
+		Location:    UnescapeText(component.PropertyValue("LOCATION")),
+		Status:      strings.ToUpper(component.PropertyValue("STATUS")),
+		Transparent: strings.EqualFold(component.PropertyValue("TRANSP"), "TRANSPARENT"),
+		XProperties: map[string]string{},
+	}
+	if event.UID == "" {
+		return nil, fmt.Errorf("missing UID")
+	}
+	for _, property := range component.Properties {
+		if strings.HasPrefix(property.Name, "X-") {
+			event.XProperties[property.Name] = UnescapeText(property.Value)
+		}
+	}
+
+	dtstart := component.Property("DTSTART")
+	if dtstart == nil {
+		return nil, fmt.Errorf("missing DTSTART")
+	}
+	start, err := p.parseDateTimes(dtstart)
+	if err != nil {
+		return nil, err
+	}
+	event.start = start[0]
+
+	switch {
+	case component.Property("DTEND") != nil:
+		end, err := p.parseDateTimes(component.Property("DTEND"))
+		if err != nil {
+			return nil, err
+		}
+		event.end = end[0]
+	case component.Property("DURATION") != nil:
+		duration, err := parseDuration(component.PropertyValue("DURATION"))
+		if err != nil {
+			return nil, err
+		}
+		event.end = event.start
+		event.end.wall = event.start.wall.Add(duration)
+	case event.start.date:
+		event.end = event.start
+		event.end.wall = event.start.wall.AddDate(0, 0, 1)
+	default:
+		event.end = event.start
+	}
+
+	if property := component.Property("RECURRENCE-ID"); property != nil {
+		recurrenceID, err := p.parseDateTimes(property)
+		if err != nil {
+			return nil, err
+		}
+		event.recurrenceID = &recurrenceID[0]
+		event.RecurrenceID = recurrenceID[0].instant()
+	}
+
+	if value := component.PropertyValue("RRULE"); value != "" {
+		event.rule, err = ParseRRule(value)
+		if err != nil {
+			// Better to block the first occurrence than none at all
+			p.warn("event %s only blocks its first occurrence: %v", event.UID, err)
+		}
+	}
+	for _, property := range component.AllProperties("EXDATE") {
+		exdates, err := p.parseDateTimes(property)
+		if err != nil {
+			return nil, err
+		}
+		event.exdates = append(event.exdates, exdates...)
+	}
+	for _, property := range component.AllProperties("RDATE") {
+		if strings.EqualFold(property.Param("VALUE"), "PERIOD") {
+			p.warn("event %s: RDATE periods aren't supported", event.UID)
+			continue
+		}
+		rdates, err := p.parseDateTimes(property)
+		if err != nil {
+			return nil, err
+		}
+		event.rdates = append(event.rdates, rdates...)
+	}
+
+	event.AllDay = event.start.date
+	event.Start = event.start.instant()
+	event.End = event.end.instant()
+	return event, nil
+}
+
+// parseDateTimes parses a DATE or DATE-TIME property, which may hold several
+// comma separated values.
+func (p *calendarParser) parseDateTimes(property *Property) ([]dateTime, error) {
+	values := []dateTime{}
+	for _, value := range strings.Split(property.Value, ",") {
+		wall, err := parseWallTime(strings.TrimSuffix(value, "Z"))
+		if err != nil {
+			return nil, err
+		}
+
+		d := dateTime{wall: wall, date: len(value) == len(dateLayout)}
+		switch {
+		case d.date:
+		case strings.HasSuffix(value, "Z"):
+			d.zone = utcZone
+		case property.Param("TZID") != "":
+			d.zone = p.zone(property.Param("TZID"))
+		default:
+			d.zone = p.floating
+		}
+		values = append(values, d)
+	}
+	return values, nil
+}
+
+// zone resolves a TZID, preferring Go's zone database over the file's own
+// VTIMEZONE definitions since it knows about historical changes.
+func (p *calendarParser) zone(tzid string) zone {
+	if z, ok := p.zones[tzid]; ok {
+		return z
+	}
+
+	var z zone
+	if loc, ok := loadLocation(tzid); ok {
+		z = locationZone{loc: loc}
+	} else if timezone, ok := p.timezones[tzid]; ok {
+		z = timezone
+	} else {
+		p.warn("unknown time zone %q, treating it as floating time", tzid)
+		z = p.floating
+	}
+	p.zones[tzid] = z
+	return z
+}
+
+func parseWallTime(value string) (time.Time, error) {
+	if len(value) == len(dateLayout) {
+		return time.Parse(dateLayout, value)
+	}
+	return time.Parse(wallTimeLayout, value)
+}
+
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseDuration parses a DURATION value like PT1H30M or P1D.
+func parseDuration(value string) (time.Duration, error) {
+	match := durationPattern.FindStringSubmatch(value)
+	if match == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	duration := time.Duration(0)
+	for i, unit := range units {
+		if match[i+2] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(match[i+2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		duration += time.Duration(n) * unit
+	}
+	if match[1] == "-" {
+		duration = -duration
+	}
+	return duration, nil
+}
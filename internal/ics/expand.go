@@ -0,0 +1,149 @@
+package ics
+
+import (
+	"slices"
+	"time"
+)
+
+// Expand returns every event and instance of a recurring event that overlaps
+// the window from start to end. Recurring events are expanded with their
+// RRULE, RDATE and EXDATE, and instances overridden by an event with a
+// RECURRENCE-ID are replaced by it. All-day events are placed in the window
+// using loc.
+func (c *Calendar) Expand(start time.Time, end time.Time, loc *time.Location) []*Event {
+	overlaps := func(event *Event) bool {
+		eventStart, eventEnd := event.Start, event.End
+		if event.AllDay {
+			eventStart = dateIn(eventStart, loc)
+			eventEnd = dateIn(eventEnd, loc)
+		}
+		if !eventEnd.After(eventStart) {
+			// Zero length events still take up their start time
+			return !eventStart.Before(start) && eventStart.Before(end)
+		}
+		return eventStart.Before(end) && eventEnd.After(start)
+	}
+
+	overrides := map[string][]*Event{}
+	for _, event := range c.Events {
+		if event.recurrenceID != nil {
+			overrides[event.UID] = append(overrides[event.UID], event)
+		}
+	}
+
+	instances := []*Event{}
+	for _, event := range c.Events {
+		if event.recurrenceID != nil {
+			if overlaps(event) {
+				instances = append(instances, event)
+			}
+			continue
+		}
+		if event.rule == nil && len(event.rdates) == 0 {
+			if overlaps(event) {
+				instances = append(instances, event)
+			}
+			continue
+		}
+
+		for _, occurrence := range event.occurrences(end, loc) {
+			if event.isExcluded(occurrence) || isOverridden(overrides[event.UID], occurrence) {
+				continue
+			}
+			instance := event.instance(occurrence)
+			if overlaps(instance) {
+				instances = append(instances, instance)
+			}
+		}
+	}
+
+	slices.SortStableFunc(instances, func(a, b *Event) int {
+		return a.Start.Compare(b.Start)
+	})
+	return instances
+}
+
+// occurrences returns the start of each occurrence of a recurring event that
+// begins before end.
+func (e *Event) occurrences(end time.Time, loc *time.Location) []dateTime {
+	startsBeforeEnd := func(occurrence dateTime) bool {
+		if occurrence.date {
+			return dateIn(occurrence.wall, loc).Before(end)
+		}
+		return occurrence.instant().Before(end)
+	}
+
+	occurrences := []dateTime{}
+	if e.rule != nil {
+		instant := func(wall time.Time) time.Time {
+			return dateTime{wall: wall, zone: e.start.zone, date: e.start.date}.instant()
+		}
+		e.rule.each(e.start.wall, instant, func(wall time.Time) bool {
+			occurrence := dateTime{wall: wall, zone: e.start.zone, date: e.start.date}
+			if !startsBeforeEnd(occurrence) {
+				return false
+			}
+			occurrences = append(occurrences, occurrence)
+			return true
+		})
+	} else {
+		occurrences = append(occurrences, e.start)
+	}
+
+	for _, rdate := range e.rdates {
+		if startsBeforeEnd(rdate) && !slices.ContainsFunc(occurrences, func(occurrence dateTime) bool {
+			return sameOccurrence(occurrence, rdate)
+		}) {
+			occurrences = append(occurrences, rdate)
+		}
+	}
+	return occurrences
+}
+
+// instance returns a copy of a recurring event for one of its occurrences.
+func (e *Event) instance(occurrence dateTime) *Event {
+	instance := *e
+	instance.rule = nil
+	instance.rdates = nil
+	instance.exdates = nil
+	instance.recurrenceID = &occurrence
+	instance.RecurrenceID = occurrence.instant()
+
+	instance.start = occurrence
+	instance.end = occurrence
+	if e.end.zone == e.start.zone {
+		// Keep the same wall clock length, so a 9 to 10 event stays that way across DST changes
+		instance.end.wall = occurrence.wall.Add(e.end.wall.Sub(e.start.wall))
+	} else {
+		instance.end = dateTime{wall: occurrence.instant().Add(e.End.Sub(e.Start)).UTC(), zone: utcZone}
+	}
+	instance.Start = instance.start.instant()
+	instance.End = instance.end.instant()
+	return &instance
+}
+
+func (e *Event) isExcluded(occurrence dateTime) bool {
+	return slices.ContainsFunc(e.exdates, func(exdate dateTime) bool {
+		return sameOccurrence(occurrence, exdate)
+	})
+}
+
+func isOverridden(overrides []*Event, occurrence dateTime) bool {
+	return slices.ContainsFunc(overrides, func(override *Event) bool {
+		return sameOccurrence(occurrence, *override.recurrenceID)
+	})
+}
+
+// sameOccurrence compares an occurrence with an EXDATE or RECURRENCE-ID. A
+// date on its own matches any occurrence on that day.
+func sameOccurrence(occurrence dateTime, other dateTime) bool {
+	if occurrence.date || other.date {
+		return occurrence.wall.Format(dateLayout) == other.wall.Format(dateLayout)
+	}
+	return occurrence.instant().Equal(other.instant())
+}
+
+// dateIn returns midnight in loc on the date carried by a UTC date.
+func dateIn(date time.Time, loc *time.Location) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)
+}
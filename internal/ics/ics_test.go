@@ -0,0 +1,400 @@
+package ics
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("unable to load %s: %v", name, err)
+	}
+	return loc
+}
+
+func parseTestCalendar(t *testing.T, body string) *Calendar {
+	t.Helper()
+	calendar, err := ParseCalendar(strings.NewReader("BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"+body+"END:VCALENDAR\r\n"), time.UTC)
+	if err != nil {
+		t.Fatalf("unable to parse calendar: %v", err)
+	}
+	return calendar
+}
+
+func expandStarts(events []*Event) []string {
+	starts := []string{}
+	for _, event := range events {
+		starts = append(starts, event.Start.UTC().Format(time.RFC3339))
+	}
+	return starts
+}
+
+func TestParse(t *testing.T) {
+	component, err := Parse(strings.NewReader("BEGIN:VCALENDAR\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"DESCRIPTION:A long description that was \r\n folded onto two lines\r\n" +
+		"ATTENDEE;CN=\"Doe, Jane\";ROLE=REQ-PARTICIPANT:mailto:jane@example.com\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"))
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	event := component.Components[0]
+	if event.PropertyValue("DESCRIPTION") != "A long description that was folded onto two lines" {
+		t.Errorf("Folded line wasn't joined: %q", event.PropertyValue("DESCRIPTION"))
+	}
+	attendee := event.Property("ATTENDEE")
+	if attendee.Param("CN") != "Doe, Jane" || attendee.Param("ROLE") != "REQ-PARTICIPANT" || attendee.Value != "mailto:jane@example.com" {
+		t.Errorf("Unexpected attendee %+v", attendee)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"unterminated": "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\n",
+		"mismatched":   "BEGIN:VCALENDAR\r\nEND:VEVENT\r\n",
+		"empty":        "",
+		"no value":     "BEGIN:VCALENDAR\r\nSUMMARY\r\nEND:VCALENDAR\r\n",
+	} {
+		if _, err := Parse(strings.NewReader(content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestUnescapeText(t *testing.T) {
+	if text := UnescapeText(`Dinner\, drinks\; and more\nBring \\snacks`); text != "Dinner, drinks; and more\nBring \\snacks" {
+		t.Errorf("Unexpected text %q", text)
+	}
+}
+
+func TestParseCalendarEvent(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	calendar := parseTestCalendar(t, "BEGIN:VEVENT\r\n"+
+		"UID:game-1@league.example\r\n"+
+		"SUMMARY:Soccer\\, U10\r\n"+
+		"LOCATION:Field 3\r\n"+
+		"DTSTART;TZID=America/New_York:20260314T090000\r\n"+
+		"DURATION:PT1H30M\r\n"+
+		"TRANSP:TRANSPARENT\r\n"+
+		"X-GCAL-BUSY-BLOCKER:true\r\n"+
+		"END:VEVENT\r\n")
+
+	if len(calendar.Events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(calendar.Events))
+	}
+	event := calendar.Events[0]
+	if event.UID != "game-1@league.example" || event.Summary != "Soccer, U10" || event.Location != "Field 3" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+	if !event.Start.Equal(time.Date(2026, 3, 14, 9, 0, 0, 0, newYork)) || event.End.Sub(event.Start) != 90*time.Minute {
+		t.Errorf("Unexpected times %s - %s", event.Start, event.End)
+	}
+	if !event.Transparent {
+		t.Error("TRANSP wasn't read")
+	}
+	if event.XProperties["X-GCAL-BUSY-BLOCKER"] != "true" {
+		t.Error("X- properties weren't kept")
+	}
+}
+
+func TestParseCalendarAllDayEvent(t *testing.T) {
+	calendar := parseTestCalendar(t, "BEGIN:VEVENT\r\n"+
+		"UID:vacation\r\n"+
+		"DTSTART;VALUE=DATE:20260401\r\n"+
+		"END:VEVENT\r\n")
+
+	event := calendar.Events[0]
+	if !event.AllDay || event.Start.Format(dateLayout) != "20260401" || event.End.Format(dateLayout) != "20260402" {
+		t.Errorf("Expected a single all-day event, got %s - %s", event.Start, event.End)
+	}
+}
+
+func TestParseCalendarSkipsBrokenEvents(t *testing.T) {
+	calendar := parseTestCalendar(t, "BEGIN:VEVENT\r\nUID:no-start\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:ok\r\nDTSTART:20260314T090000Z\r\nEND:VEVENT\r\n")
+
+	if len(calendar.Events) != 1 || calendar.Events[0].UID != "ok" {
+		t.Error("Expected the broken event to be skipped")
+	}
+	if len(calendar.Warnings) != 1 {
+		t.Errorf("Expected a warning for the broken event, got %v", calendar.Warnings)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"PT1H30M":  90 * time.Minute,
+		"P1D":      24 * time.Hour,
+		"P1W":      7 * 24 * time.Hour,
+		"P1DT2H":   26 * time.Hour,
+		"-PT15M":   -15 * time.Minute,
+		"PT45S":    45 * time.Second,
+		"+P0DT10M": 10 * time.Minute,
+	}
+	for value, expected := range tests {
+		duration, err := parseDuration(value)
+		if err != nil || duration != expected {
+			t.Errorf("%s: expected %s, got %s (%v)", value, expected, duration, err)
+		}
+	}
+	for _, value := range []string{"P", "PT", "1H", "P1H"} {
+		if _, err := parseDuration(value); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
+
+func TestVTimezone(t *testing.T) {
+	// Outlook writes Windows zone names, which only make sense with the VTIMEZONE
+	calendar := parseTestCalendar(t, "BEGIN:VTIMEZONE\r\n"+
+		"TZID:Eastern Standard Time\r\n"+
+		"BEGIN:STANDARD\r\n"+
+		"DTSTART:16010101T020000\r\n"+
+		"TZOFFSETFROM:-0400\r\n"+
+		"TZOFFSETTO:-0500\r\n"+
+		"RRULE:FREQ=YEARLY;BYDAY=1SU;BYMONTH=11\r\n"+
+		"END:STANDARD\r\n"+
+		"BEGIN:DAYLIGHT\r\n"+
+		"DTSTART:16010101T020000\r\n"+
+		"TZOFFSETFROM:-0500\r\n"+
+		"TZOFFSETTO:-0400\r\n"+
+		"RRULE:FREQ=YEARLY;BYDAY=2SU;BYMONTH=3\r\n"+
+		"END:DAYLIGHT\r\n"+
+		"END:VTIMEZONE\r\n"+
+		"BEGIN:VEVENT\r\nUID:winter\r\nDTSTART;TZID=Eastern Standard Time:20260305T090000\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:summer\r\nDTSTART;TZID=Eastern Standard Time:20260310T090000\r\nEND:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\nUID:autumn\r\nDTSTART;TZID=Eastern Standard Time:20261102T090000\r\nEND:VEVENT\r\n")
+
+	expected := []string{"2026-03-05T14:00:00Z", "2026-03-10T13:00:00Z", "2026-11-02T14:00:00Z"}
+	for i, event := range calendar.Events {
+		if event.Start.UTC().Format(time.RFC3339) != expected[i] {
+			t.Errorf("%s: expected %s, got %s", event.UID, expected[i], event.Start.UTC().Format(time.RFC3339))
+		}
+	}
+}
+
+func TestZonePrefersGoDatabase(t *testing.T) {
+	calendar := parseTestCalendar(t, "BEGIN:VEVENT\r\n"+
+		"UID:prefixed\r\n"+
+		"DTSTART;TZID=/mozilla.org/20050126_1/Europe/Paris:20260701T090000\r\n"+
+		"END:VEVENT\r\n")
+
+	if calendar.Events[0].Start.UTC().Format(time.RFC3339) != "2026-07-01T07:00:00Z" {
+		t.Errorf("Expected the prefixed TZID to resolve to Europe/Paris, got %s", calendar.Events[0].Start.UTC())
+	}
+}
+
+func TestParseRRuleInvalid(t *testing.T) {
+	for _, value := range []string{"FREQ=SECONDLY", "INTERVAL=2", "FREQ=DAILY;INTERVAL=0", "FREQ=WEEKLY;BYDAY=XX", "FREQ=DAILY;COUNT"} {
+		if _, err := ParseRRule(value); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
+
+func TestRRuleExpansion(t *testing.T) {
+	tests := []struct {
+		name     string
+		dtstart  string
+		rule     string
+		expected []string
+	}{
+		{"daily count", "20260301T090000", "FREQ=DAILY;COUNT=3", []string{"20260301T090000", "20260302T090000", "20260303T090000"}},
+		{"every other day", "20260301T090000", "FREQ=DAILY;INTERVAL=2;COUNT=3", []string{"20260301T090000", "20260303T090000", "20260305T090000"}},
+		{"weekdays until", "20260305T090000", "FREQ=WEEKLY;BYDAY=MO,TH,FR;UNTIL=20260312T235959", []string{"20260305T090000", "20260306T090000", "20260309T090000", "20260312T090000"}},
+		{"biweekly", "20260302T090000", "FREQ=WEEKLY;INTERVAL=2;COUNT=3", []string{"20260302T090000", "20260316T090000", "20260330T090000"}},
+		{"second tuesday", "20260310T180000", "FREQ=MONTHLY;BYDAY=2TU;COUNT=3", []string{"20260310T180000", "20260414T180000", "20260512T180000"}},
+		{"last friday", "20260327T120000", "FREQ=MONTHLY;BYDAY=-1FR;COUNT=3", []string{"20260327T120000", "20260424T120000", "20260529T120000"}},
+		{"31st skips short months", "20260131T120000", "FREQ=MONTHLY;COUNT=3", []string{"20260131T120000", "20260331T120000", "20260531T120000"}},
+		{"last day of month", "20260131T120000", "FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=3", []string{"20260131T120000", "20260228T120000", "20260331T120000"}},
+		{"last weekday", "20260130T120000", "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1;COUNT=3", []string{"20260130T120000", "20260227T120000", "20260331T120000"}},
+		{"yearly birthday", "20240229T000000", "FREQ=YEARLY;COUNT=2", []string{"20240229T000000", "20280229T000000"}},
+		{"thanksgiving", "20261126T120000", "FREQ=YEARLY;BYMONTH=11;BYDAY=4TH;COUNT=2", []string{"20261126T120000", "20271125T120000"}},
+		{"until date", "20260301T090000", "FREQ=DAILY;UNTIL=20260302", []string{"20260301T090000", "20260302T090000"}},
+	}
+
+	for _, test := range tests {
+		rule, err := ParseRRule(test.rule)
+		if err != nil {
+			t.Fatalf("%s: invalid rule: %v", test.name, err)
+		}
+		dtstart, _ := parseWallTime(test.dtstart)
+
+		occurrences := []string{}
+		rule.each(dtstart, utcZone.instant, func(occurrence time.Time) bool {
+			occurrences = append(occurrences, occurrence.Format(wallTimeLayout))
+			return len(occurrences) < 10
+		})
+
+		if strings.Join(occurrences, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, occurrences)
+		}
+	}
+}
+
+func TestExpandRecurringEvent(t *testing.T) {
+	calendar := parseTestCalendar(t, "BEGIN:VEVENT\r\n"+
+		"UID:practice\r\n"+
+		"DTSTART;TZID=America/New_York:20260303T180000\r\n"+
+		"DTEND;TZID=America/New_York:20260303T190000\r\n"+
+		"RRULE:FREQ=WEEKLY;BYDAY=TU\r\n"+
+		"EXDATE;TZID=America/New_York:20260317T180000\r\n"+
+		"END:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\n"+
+		"UID:practice\r\n"+
+		"RECURRENCE-ID;TZID=America/New_York:20260324T180000\r\n"+
+		"DTSTART;TZID=America/New_York:20260325T170000\r\n"+
+		"DTEND;TZID=America/New_York:20260325T180000\r\n"+
+		"SUMMARY:Moved practice\r\n"+
+		"END:VEVENT\r\n")
+
+	events := calendar.Expand(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), time.UTC)
+
+	// DST starts on the 8th, so practice moves from 23:00 to 22:00 UTC
+	expected := []string{"2026-03-03T23:00:00Z", "2026-03-10T22:00:00Z", "2026-03-25T21:00:00Z", "2026-03-31T22:00:00Z"}
+	if strings.Join(expandStarts(events), ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected %v, got %v", expected, expandStarts(events))
+	}
+	for _, event := range events {
+		if event.End.Sub(event.Start) != time.Hour {
+			t.Errorf("Expected every instance to last an hour, %s lasts %s", event.Start, event.End.Sub(event.Start))
+		}
+		if event.RecurrenceID.IsZero() {
+			t.Error("Instances should carry their recurrence ID")
+		}
+	}
+	if events[2].Summary != "Moved practice" || events[2].RecurrenceID.UTC().Format(time.RFC3339) != "2026-03-24T22:00:00Z" {
+		t.Errorf("Expected the overridden instance, got %+v", events[2])
+	}
+}
+
+func TestExpandOverrideMovedIntoWindow(t *testing.T) {
+	calendar := parseTestCalendar(t, "BEGIN:VEVENT\r\n"+
+		"UID:standup\r\n"+
+		"DTSTART:20260302T150000Z\r\n"+
+		"RRULE:FREQ=DAILY;COUNT=5\r\n"+
+		"END:VEVENT\r\n"+
+		"BEGIN:VEVENT\r\n"+
+		"UID:standup\r\n"+
+		"RECURRENCE-ID:20260306T150000Z\r\n"+
+		"DTSTART:20260302T180000Z\r\n"+
+		"END:VEVENT\r\n")
+
+	events := calendar.Expand(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC), time.UTC)
+
+	if strings.Join(expandStarts(events), ",") != "2026-03-02T15:00:00Z,2026-03-02T18:00:00Z" {
+		t.Errorf("Expected the instance moved into the window, got %v", expandStarts(events))
+	}
+}
+
+func TestExpandAllDayRecurringEvent(t *testing.T) {
+	tokyo := mustLoadLocation(t, "Asia/Tokyo")
+	calendar := parseTestCalendar(t, "BEGIN:VEVENT\r\n"+
+		"UID:trash\r\n"+
+		"DTSTART;VALUE=DATE:20260302\r\n"+
+		"DTEND;VALUE=DATE:20260303\r\n"+
+		"RRULE:FREQ=WEEKLY\r\n"+
+		"EXDATE;VALUE=DATE:20260309\r\n"+
+		"END:VEVENT\r\n")
+
+	events := calendar.Expand(time.Date(2026, 3, 1, 0, 0, 0, 0, tokyo), time.Date(2026, 3, 20, 0, 0, 0, 0, tokyo), tokyo)
+
+	if len(events) != 2 || events[0].Start.Format(dateLayout) != "20260302" || events[1].Start.Format(dateLayout) != "20260316" {
+		t.Errorf("Unexpected instances %v", expandStarts(events))
+	}
+	for _, event := range events {
+		if !event.AllDay || event.End.Sub(event.Start) != 24*time.Hour {
+			t.Error("Expected all-day instances")
+		}
+	}
+}
+
+func TestExpandRDate(t *testing.T) {
+	calendar := parseTestCalendar(t, "BEGIN:VEVENT\r\n"+
+		"UID:lessons\r\n"+
+		"DTSTART:20260302T150000Z\r\n"+
+		"RDATE:20260305T150000Z,20260309T150000Z\r\n"+
+		"END:VEVENT\r\n")
+
+	events := calendar.Expand(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC), time.UTC)
+
+	if strings.Join(expandStarts(events), ",") != "2026-03-02T15:00:00Z,2026-03-05T15:00:00Z,2026-03-09T15:00:00Z" {
+		t.Errorf("Unexpected instances %v", expandStarts(events))
+	}
+}
+
+func TestExpandSampleFeed(t *testing.T) {
+	f, err := os.Open("testdata/league.ics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	calendar, err := ParseCalendar(f, time.UTC)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	events := calendar.Expand(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC), time.UTC)
+
+	summaries := []string{}
+	for _, event := range events {
+		summaries = append(summaries, event.Summary+"@"+event.Start.UTC().Format(time.RFC3339))
+	}
+	expected := []string{
+		"Practice@2026-04-07T22:00:00Z",
+		"Game vs Hawks@2026-04-11T13:00:00Z",
+		"Practice@2026-04-14T22:00:00Z",
+		"Game vs Owls@2026-04-18T13:00:00Z",
+		"Practice@2026-04-28T22:00:00Z",
+	}
+	if strings.Join(summaries, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, summaries)
+	}
+	// Cancelled events are kept, it's up to the caller to skip them
+	if events[1].Status != "" || events[3].Status != StatusCancelled {
+		t.Errorf("Unexpected statuses %q and %q", events[1].Status, events[3].Status)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	summary := "Lunch; with a rather long title that has to be folded, since no line may be longer than seventy five bytes – ünïcödé"
+	var b strings.Builder
+	err := Encode(&b, &Component{
+		Name:       "VCALENDAR",
+		Properties: []*Property{{Name: "VERSION", Value: "2.0"}},
+		Components: []*Component{{
+			Name: "VEVENT",
+			Properties: []*Property{
+				{Name: "UID", Value: "1"},
+				{Name: "DTSTART", Params: map[string]string{"TZID": "America/New_York"}, Value: "20260310T090000"},
+				{Name: "SUMMARY", Value: EscapeText(summary)},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	for _, line := range strings.Split(b.String(), "\r\n") {
+		if len(line) > maxLineLength {
+			t.Errorf("Line is %d bytes long: %q", len(line), line)
+		}
+	}
+
+	calendar, err := ParseCalendar(strings.NewReader(b.String()), time.UTC)
+	if err != nil {
+		t.Fatalf("Encoded calendar can't be parsed: %v", err)
+	}
+	if calendar.Events[0].Summary != summary {
+		t.Errorf("Summary didn't survive a round trip: %q", calendar.Events[0].Summary)
+	}
+	if calendar.Events[0].Start.UTC().Hour() != 13 {
+		t.Errorf("Unexpected start %s", calendar.Events[0].Start)
+	}
+}
@@ -0,0 +1,209 @@
+package ics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Property is a single content line, e.g. DTSTART;TZID=Europe/Paris:20260310T090000
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// Param returns the value of a property parameter, or an empty string.
+func (p *Property) Param(name string) string {
+	if p == nil {
+		return ""
+	}
+	return p.Params[name]
+}
+
+// Component is a BEGIN/END block such as VCALENDAR, VEVENT or VTIMEZONE.
+type Component struct {
+	Name       string
+	Properties []*Property
+	Components []*Component
+}
+
+// Property returns the first property with the given name, or nil.
+func (c *Component) Property(name string) *Property {
+	for _, property := range c.Properties {
+		if property.Name == name {
+			return property
+		}
+	}
+	return nil
+}
+
+// PropertyValue returns the value of the first property with the given name.
+func (c *Component) PropertyValue(name string) string {
+	if property := c.Property(name); property != nil {
+		return property.Value
+	}
+	return ""
+}
+
+// AllProperties returns every property with the given name.
+func (c *Component) AllProperties(name string) []*Property {
+	properties := []*Property{}
+	for _, property := range c.Properties {
+		if property.Name == name {
+			properties = append(properties, property)
+		}
+	}
+	return properties
+}
+
+// SetProperty replaces every property with the same name as property.
+func (c *Component) SetProperty(property *Property) {
+	c.RemoveProperty(property.Name)
+	c.Properties = append(c.Properties, property)
+}
+
+// RemoveProperty removes every property with the given name.
+func (c *Component) RemoveProperty(name string) {
+	properties := []*Property{}
+	for _, property := range c.Properties {
+		if property.Name != name {
+			properties = append(properties, property)
+		}
+	}
+	c.Properties = properties
+}
+
+// Parse reads an iCalendar stream and returns its top level component.
+func Parse(r io.Reader) (*Component, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var root *Component
+	stack := []*Component{}
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		property, err := parseContentLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+
+		switch property.Name {
+		case "BEGIN":
+			component := &Component{Name: strings.ToUpper(property.Value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, component)
+			} else if root == nil {
+				root = component
+			}
+			stack = append(stack, component)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(property.Value) {
+				return nil, fmt.Errorf("line %d: unexpected END:%s", i+1, property.Value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: property %s outside of a component", i+1, property.Name)
+			}
+			current := stack[len(stack)-1]
+			current.Properties = append(current.Properties, property)
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("no calendar found")
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("missing END:%s", stack[len(stack)-1].Name)
+	}
+	return root, nil
+}
+
+// unfold joins lines that were folded by starting the next one with a space
+// or tab.
+func unfold(r io.Reader) ([]string, error) {
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+func parseContentLine(line string) (*Property, error) {
+	property := &Property{Params: map[string]string{}}
+
+	// The name ends at the first ; or :, parameter values may be quoted
+	nameEnd := strings.IndexAny(line, ";:")
+	if nameEnd <= 0 {
+		return nil, fmt.Errorf("invalid content line %q", line)
+	}
+	property.Name = strings.ToUpper(line[:nameEnd])
+
+	rest := line[nameEnd:]
+	for strings.HasPrefix(rest, ";") {
+		rest = rest[1:]
+		equals := strings.Index(rest, "=")
+		if equals <= 0 {
+			return nil, fmt.Errorf("invalid parameter in %q", line)
+		}
+		paramName := strings.ToUpper(rest[:equals])
+		rest = rest[equals+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			closing := strings.Index(rest[1:], `"`)
+			if closing < 0 {
+				return nil, fmt.Errorf("unterminated quote in %q", line)
+			}
+			value = rest[1 : closing+1]
+			rest = rest[closing+2:]
+		} else {
+			end := strings.IndexAny(rest, ";:")
+			if end < 0 {
+				return nil, fmt.Errorf("missing value in %q", line)
+			}
+			value = rest[:end]
+			rest = rest[end:]
+		}
+		property.Params[paramName] = value
+	}
+
+	if !strings.HasPrefix(rest, ":") {
+		return nil, fmt.Errorf("missing value in %q", line)
+	}
+	property.Value = rest[1:]
+	return property, nil
+}
+
+// UnescapeText decodes a TEXT value.
+func UnescapeText(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,337 @@
+package ics
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expanding stops after this many periods even if the rule never produced an
+// occurrence, e.g. FREQ=MONTHLY;BYMONTHDAY=31;BYMONTH=2
+const maxRulePeriods = 50000
+
+var weekdayNames = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// RRule is a recurrence rule. FREQ values of DAILY and up are supported along
+// with INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY, BYMONTH, BYSETPOS and WKST.
+type RRule struct {
+	Freq       string
+	Interval   int
+	Count      int
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []time.Month
+	BySetPos   []int
+	WeekStart  time.Weekday
+
+	until    time.Time
+	untilSet bool
+	// untilUTC is set when UNTIL is an instant rather than a wall clock time
+	untilUTC bool
+}
+
+// WeekdayNum is a BYDAY entry, e.g. -1FR for the last Friday.
+type WeekdayNum struct {
+	Ordinal int
+	Weekday time.Weekday
+}
+
+// ParseRRule parses the value of an RRULE property.
+func ParseRRule(value string) (*RRule, error) {
+	r := &RRule{Interval: 1, WeekStart: time.Monday}
+	for _, part := range strings.Split(value, ";") {
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rule part %q", part)
+		}
+
+		var err error
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.Freq = strings.ToUpper(val)
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(val)
+			if err == nil && r.Interval < 1 {
+				err = fmt.Errorf("interval must be positive")
+			}
+		case "COUNT":
+			r.Count, err = strconv.Atoi(val)
+		case "UNTIL":
+			r.untilUTC = strings.HasSuffix(val, "Z")
+			r.until, err = parseWallTime(strings.TrimSuffix(val, "Z"))
+			if err == nil && len(val) == 8 {
+				// A date bound includes the whole day
+				r.until = r.until.Add(24*time.Hour - time.Second)
+			}
+			r.untilSet = true
+		case "BYDAY":
+			for _, day := range strings.Split(val, ",") {
+				weekdayNum, dayErr := parseWeekdayNum(day)
+				if dayErr != nil {
+					return nil, dayErr
+				}
+				r.ByDay = append(r.ByDay, weekdayNum)
+			}
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseInts(val)
+		case "BYMONTH":
+			months, monthErr := parseInts(val)
+			for _, month := range months {
+				r.ByMonth = append(r.ByMonth, time.Month(month))
+			}
+			err = monthErr
+		case "BYSETPOS":
+			r.BySetPos, err = parseInts(val)
+		case "WKST":
+			weekday, ok := weekdayNames[strings.ToUpper(val)]
+			if !ok {
+				err = fmt.Errorf("invalid weekday %q", val)
+			}
+			r.WeekStart = weekday
+		default:
+			// Parts like BYHOUR are rarely used by calendar apps and are ignored
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in rule %q: %v", key, value, err)
+		}
+	}
+
+	switch r.Freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	default:
+		return nil, fmt.Errorf("unsupported FREQ %q", r.Freq)
+	}
+	return r, nil
+}
+
+func parseWeekdayNum(value string) (WeekdayNum, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if len(value) < 2 {
+		return WeekdayNum{}, fmt.Errorf("invalid weekday %q", value)
+	}
+	weekday, ok := weekdayNames[value[len(value)-2:]]
+	if !ok {
+		return WeekdayNum{}, fmt.Errorf("invalid weekday %q", value)
+	}
+	ordinal := 0
+	if prefix := value[:len(value)-2]; prefix != "" {
+		var err error
+		ordinal, err = strconv.Atoi(prefix)
+		if err != nil {
+			return WeekdayNum{}, fmt.Errorf("invalid weekday %q", value)
+		}
+	}
+	return WeekdayNum{Ordinal: ordinal, Weekday: weekday}, nil
+}
+
+func parseInts(value string) ([]int, error) {
+	ints := []int{}
+	for _, part := range strings.Split(value, ",") {
+		i, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, i)
+	}
+	return ints, nil
+}
+
+// each calls yield with every occurrence of the rule, as wall clock times in
+// ascending order, until yield returns false or the rule ends. DTSTART is
+// always the first occurrence.
+func (r *RRule) each(dtstart time.Time, instant func(time.Time) time.Time, yield func(time.Time) bool) {
+	count := 0
+	emit := func(occurrence time.Time) bool {
+		if r.untilSet {
+			if r.untilUTC && instant(occurrence).After(r.until) {
+				return false
+			}
+			if !r.untilUTC && occurrence.After(r.until) {
+				return false
+			}
+		}
+		count++
+		if !yield(occurrence) {
+			return false
+		}
+		return r.Count == 0 || count < r.Count
+	}
+
+	if !emit(dtstart) {
+		return
+	}
+	for period := 0; period < maxRulePeriods; period++ {
+		for _, candidate := range r.candidates(dtstart, period) {
+			if !candidate.After(dtstart) {
+				continue
+			}
+			if !emit(candidate) {
+				return
+			}
+		}
+	}
+}
+
+// candidates returns the occurrences in the n-th period of the rule, sorted.
+func (r *RRule) candidates(dtstart time.Time, n int) []time.Time {
+	days := []time.Time{}
+	switch r.Freq {
+	case "DAILY":
+		day := dtstart.AddDate(0, 0, n*r.Interval)
+		if r.matchesMonth(day) && r.matchesMonthDay(day) && r.matchesWeekday(day) {
+			days = append(days, day)
+		}
+	case "WEEKLY":
+		offset := (int(dtstart.Weekday()) - int(r.WeekStart) + 7) % 7
+		weekStart := dtstart.AddDate(0, 0, -offset+n*r.Interval*7)
+		for i := 0; i < 7; i++ {
+			day := weekStart.AddDate(0, 0, i)
+			matchesDay := day.Weekday() == dtstart.Weekday()
+			if len(r.ByDay) > 0 {
+				matchesDay = r.matchesWeekday(day)
+			}
+			if matchesDay && r.matchesMonth(day) {
+				days = append(days, day)
+			}
+		}
+	case "MONTHLY":
+		month := time.Date(dtstart.Year(), dtstart.Month()+time.Month(n*r.Interval), 1, 0, 0, 0, 0, time.UTC)
+		if r.matchesMonth(month) {
+			days = r.monthDays(dtstart, month.Year(), month.Month())
+		}
+	case "YEARLY":
+		year := dtstart.Year() + n*r.Interval
+		if len(r.ByMonth) == 0 && len(r.ByMonthDay) == 0 && len(r.ByDay) > 0 {
+			days = r.yearDays(dtstart, year)
+			break
+		}
+		months := r.ByMonth
+		if len(months) == 0 {
+			months = []time.Month{dtstart.Month()}
+		}
+		for _, month := range slices.Sorted(slices.Values(months)) {
+			days = append(days, r.monthDays(dtstart, year, month)...)
+		}
+	}
+
+	// Every candidate happens at the same time of day as DTSTART
+	for i, day := range days {
+		days[i] = time.Date(day.Year(), day.Month(), day.Day(), dtstart.Hour(), dtstart.Minute(), dtstart.Second(), 0, time.UTC)
+	}
+	slices.SortFunc(days, func(a, b time.Time) int { return a.Compare(b) })
+	days = slices.CompactFunc(days, func(a, b time.Time) bool { return a.Equal(b) })
+	return r.applySetPos(days)
+}
+
+// monthDays returns the days of a month matching the rule.
+func (r *RRule) monthDays(dtstart time.Time, year int, month time.Month) []time.Time {
+	daysInMonth := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	days := []time.Time{}
+
+	switch {
+	case len(r.ByMonthDay) > 0:
+		for _, monthDay := range r.ByMonthDay {
+			if monthDay < 0 {
+				monthDay = daysInMonth + monthDay + 1
+			}
+			if monthDay < 1 || monthDay > daysInMonth {
+				continue
+			}
+			day := time.Date(year, month, monthDay, 0, 0, 0, 0, time.UTC)
+			if r.matchesWeekday(day) {
+				days = append(days, day)
+			}
+		}
+	case len(r.ByDay) > 0:
+		first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+		days = nthWeekdays(first, daysInMonth, r.ByDay)
+	default:
+		if dtstart.Day() <= daysInMonth {
+			days = append(days, time.Date(year, month, dtstart.Day(), 0, 0, 0, 0, time.UTC))
+		}
+	}
+	return days
+}
+
+// yearDays returns the days of a year matching BYDAY, where ordinals count
+// within the whole year.
+func (r *RRule) yearDays(dtstart time.Time, year int) []time.Time {
+	first := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	daysInYear := time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC).YearDay()
+	return nthWeekdays(first, daysInYear, r.ByDay)
+}
+
+// nthWeekdays returns the days in a span of days matching a BYDAY list.
+func nthWeekdays(first time.Time, length int, byDay []WeekdayNum) []time.Time {
+	days := []time.Time{}
+	for _, weekdayNum := range byDay {
+		matches := []time.Time{}
+		for i := 0; i < length; i++ {
+			day := first.AddDate(0, 0, i)
+			if day.Weekday() == weekdayNum.Weekday {
+				matches = append(matches, day)
+			}
+		}
+
+		switch {
+		case weekdayNum.Ordinal == 0:
+			days = append(days, matches...)
+		case weekdayNum.Ordinal > 0 && weekdayNum.Ordinal <= len(matches):
+			days = append(days, matches[weekdayNum.Ordinal-1])
+		case weekdayNum.Ordinal < 0 && -weekdayNum.Ordinal <= len(matches):
+			days = append(days, matches[len(matches)+weekdayNum.Ordinal])
+		}
+	}
+	return days
+}
+
+func (r *RRule) applySetPos(days []time.Time) []time.Time {
+	if len(r.BySetPos) == 0 {
+		return days
+	}
+	selected := []time.Time{}
+	for _, pos := range r.BySetPos {
+		if pos > 0 && pos <= len(days) {
+			selected = append(selected, days[pos-1])
+		} else if pos < 0 && -pos <= len(days) {
+			selected = append(selected, days[len(days)+pos])
+		}
+	}
+	slices.SortFunc(selected, func(a, b time.Time) int { return a.Compare(b) })
+	return selected
+}
+
+func (r *RRule) matchesMonth(day time.Time) bool {
+	return len(r.ByMonth) == 0 || slices.Contains(r.ByMonth, day.Month())
+}
+
+func (r *RRule) matchesMonthDay(day time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	daysInMonth := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	return slices.ContainsFunc(r.ByMonthDay, func(monthDay int) bool {
+		return monthDay == day.Day() || monthDay < 0 && daysInMonth+monthDay+1 == day.Day()
+	})
+}
+
+// matchesWeekday checks BYDAY ignoring ordinals, which only apply to monthly
+// and yearly rules.
+func (r *RRule) matchesWeekday(day time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(r.ByDay, func(weekdayNum WeekdayNum) bool {
+		return weekdayNum.Weekday == day.Weekday()
+	})
+}
@@ -0,0 +1,64 @@
+package ics
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+)
+
+// feedHandler serves an iCalendar file, reading it on every request so it
+// picks up the changes of each sync.
+type feedHandler struct {
+	file string
+	// feedPath is the URL path the feed is served at
+	feedPath string
+}
+
+// NewFeedHandler returns a handler serving file at /<name of file>, or at
+// /<token>/<name of file> when token isn't empty so the URL can be shared as
+// a secret. Clients can make conditional requests with If-None-Match or
+// If-Modified-Since.
+func NewFeedHandler(file string, token string) http.Handler {
+	feedPath := "/" + path.Base(file)
+	if token != "" {
+		feedPath = "/" + token + feedPath
+	}
+	return &feedHandler{file: file, feedPath: feedPath}
+}
+
+func (h *feedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Compare in constant time so the token can't be guessed byte by byte
+	if subtle.ConstantTimeCompare([]byte(r.URL.Path), []byte(h.feedPath)) != 1 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	info, err := os.Stat(h.file)
+	if err != nil {
+		slog.Error("Unable to serve feed", "file", h.file, "error", err)
+		http.Error(w, "feed not available", http.StatusServiceUnavailable)
+		return
+	}
+	content, err := os.ReadFile(h.file)
+	if err != nil {
+		slog.Error("Unable to serve feed", "file", h.file, "error", err)
+		http.Error(w, "feed not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	hash := sha256.Sum256(content)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(hash[:16])+`"`)
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(content))
+}
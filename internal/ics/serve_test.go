@@ -0,0 +1,70 @@
+package ics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFeedHandler(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "busy.ics")
+	if err := os.WriteFile(file, []byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	handler := NewFeedHandler(file, "s3cret")
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		for key, values := range header {
+			request.Header[key] = values
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	for _, path := range []string{"/busy.ics", "/wrong/busy.ics", "/s3cret", "/s3cret/other.ics"} {
+		if response := get(path, nil); response.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, response.Code)
+		}
+	}
+
+	response := get("/s3cret/busy.ics", nil)
+	if response.Code != http.StatusOK || response.Body.String() != "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n" {
+		t.Fatalf("Unexpected response %d %q", response.Code, response.Body.String())
+	}
+	if response.Header().Get("Content-Type") != "text/calendar; charset=utf-8" {
+		t.Errorf("Unexpected content type %q", response.Header().Get("Content-Type"))
+	}
+	etag := response.Header().Get("ETag")
+	lastModified := response.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatal("Expected ETag and Last-Modified headers")
+	}
+
+	if response := get("/s3cret/busy.ics", http.Header{"If-None-Match": {etag}}); response.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a matching ETag, got %d", response.Code)
+	}
+	if response := get("/s3cret/busy.ics", http.Header{"If-Modified-Since": {lastModified}}); response.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for an unchanged file, got %d", response.Code)
+	}
+
+	if err := os.WriteFile(file, []byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nEND:VCALENDAR\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if response := get("/s3cret/busy.ics", http.Header{"If-None-Match": {etag}}); response.Code != http.StatusOK {
+		t.Errorf("Expected the changed feed to be sent, got %d", response.Code)
+	}
+}
+
+func TestFeedHandlerWithoutToken(t *testing.T) {
+	handler := NewFeedHandler(filepath.Join(t.TempDir(), "busy.ics"), "")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/busy.ics", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 before the first sync wrote the feed, got %d", recorder.Code)
+	}
+}
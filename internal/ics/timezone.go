@@ -0,0 +1,178 @@
+package ics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// zone turns a wall clock time into the instant it refers to. Wall clock times
+// are carried around as time.Time values in UTC.
+type zone interface {
+	instant(wall time.Time) time.Time
+}
+
+type locationZone struct {
+	loc *time.Location
+}
+
+func (z locationZone) instant(wall time.Time) time.Time {
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, z.loc)
+}
+
+var utcZone = locationZone{loc: time.UTC}
+
+// vtimezone is a zone defined inline by a VTIMEZONE component, used when the
+// TZID isn't a name Go knows, e.g. Outlook's "Eastern Standard Time".
+type vtimezone struct {
+	observances []*observance
+}
+
+// observance is a STANDARD or DAYLIGHT part of a VTIMEZONE.
+type observance struct {
+	start      time.Time
+	offsetFrom time.Duration
+	offsetTo   time.Duration
+	rule       *RRule
+	rdates     []time.Time
+}
+
+func (z *vtimezone) instant(wall time.Time) time.Time {
+	var current *observance
+	var currentOnset time.Time
+	for _, o := range z.observances {
+		onset, ok := o.lastOnset(wall)
+		if ok && (current == nil || onset.After(currentOnset)) {
+			current, currentOnset = o, onset
+		}
+	}
+
+	offset := time.Duration(0)
+	if current != nil {
+		offset = current.offsetTo
+	} else if len(z.observances) > 0 {
+		// Before the first onset the zone was at the earliest offset it mentions
+		offset = z.observances[0].offsetFrom
+	}
+	return wall.Add(-offset)
+}
+
+// lastOnset returns the latest time at or before wall that the observance
+// came into effect.
+func (o *observance) lastOnset(wall time.Time) (time.Time, bool) {
+	var last time.Time
+	found := false
+	consider := func(onset time.Time) {
+		if !onset.After(wall) && (!found || onset.After(last)) {
+			last, found = onset, true
+		}
+	}
+
+	consider(o.start)
+	for _, rdate := range o.rdates {
+		consider(rdate)
+	}
+	if o.rule != nil {
+		o.rule.each(o.start, utcZone.instant, func(onset time.Time) bool {
+			if onset.After(wall) {
+				return false
+			}
+			consider(onset)
+			return true
+		})
+	}
+	return last, found
+}
+
+func parseVTimezone(component *Component) (*vtimezone, error) {
+	z := &vtimezone{}
+	for _, child := range component.Components {
+		if child.Name != "STANDARD" && child.Name != "DAYLIGHT" {
+			continue
+		}
+
+		start, err := parseWallTime(child.PropertyValue("DTSTART"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid DTSTART in %s: %v", child.Name, err)
+		}
+		offsetFrom, err := parseUTCOffset(child.PropertyValue("TZOFFSETFROM"))
+		if err != nil {
+			return nil, err
+		}
+		offsetTo, err := parseUTCOffset(child.PropertyValue("TZOFFSETTO"))
+		if err != nil {
+			return nil, err
+		}
+		o := &observance{start: start, offsetFrom: offsetFrom, offsetTo: offsetTo}
+
+		if value := child.PropertyValue("RRULE"); value != "" {
+			o.rule, err = ParseRRule(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, rdate := range child.AllProperties("RDATE") {
+			for _, value := range strings.Split(rdate.Value, ",") {
+				t, err := parseWallTime(value)
+				if err != nil {
+					return nil, err
+				}
+				o.rdates = append(o.rdates, t)
+			}
+		}
+		z.observances = append(z.observances, o)
+	}
+
+	if len(z.observances) == 0 {
+		return nil, fmt.Errorf("no STANDARD or DAYLIGHT definitions")
+	}
+	return z, nil
+}
+
+// parseUTCOffset parses offsets like +0100, -0430 or +013000.
+func parseUTCOffset(value string) (time.Duration, error) {
+	if len(value) != 5 && len(value) != 7 || (value[0] != '+' && value[0] != '-') {
+		return 0, fmt.Errorf("invalid UTC offset %q", value)
+	}
+	hours, err := strconv.Atoi(value[1:3])
+	if err != nil {
+		return 0, fmt.Errorf("invalid UTC offset %q", value)
+	}
+	minutes, err := strconv.Atoi(value[3:5])
+	if err != nil {
+		return 0, fmt.Errorf("invalid UTC offset %q", value)
+	}
+	seconds := 0
+	if len(value) == 7 {
+		seconds, err = strconv.Atoi(value[5:7])
+		if err != nil {
+			return 0, fmt.Errorf("invalid UTC offset %q", value)
+		}
+	}
+
+	offset := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
+	if value[0] == '-' {
+		offset = -offset
+	}
+	return offset, nil
+}
+
+// loadLocation finds the Go location for a TZID, which some producers prefix
+// with a path like /mozilla.org/20050126_1/America/New_York.
+func loadLocation(tzid string) (*time.Location, bool) {
+	if loc, err := time.LoadLocation(tzid); err == nil && tzid != "" && tzid != "Local" {
+		return loc, true
+	}
+
+	parts := strings.Split(strings.Trim(tzid, "/"), "/")
+	for n := 3; n >= 2; n-- {
+		if len(parts) <= n {
+			continue
+		}
+		if loc, err := time.LoadLocation(strings.Join(parts[len(parts)-n:], "/")); err == nil {
+			return loc, true
+		}
+	}
+	return nil, false
+}
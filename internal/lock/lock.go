@@ -0,0 +1,128 @@
+// Package lock keeps two syncs from running at the same time, with a lock on
+// a file that the operating system releases if the holder dies.
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// pollInterval is how often a busy lock is tried again while waiting.
+const pollInterval = 250 * time.Millisecond
+
+// Holder describes the process holding a lock.
+type Holder struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Command string    `json:"command,omitempty"`
+	Started time.Time `json:"started"`
+}
+
+func (h *Holder) String() string {
+	return fmt.Sprintf("pid %d on %s since %s", h.PID, h.Host, h.Started.Local().Format(time.DateTime))
+}
+
+// HeldError is returned when another process holds the lock.
+type HeldError struct {
+	Path string
+	// Holder is nil if it couldn't be read
+	Holder *Holder
+}
+
+func (e *HeldError) Error() string {
+	if e.Holder == nil {
+		return fmt.Sprintf("%s is locked by another sync", e.Path)
+	}
+	return fmt.Sprintf("%s is locked by another sync, %s", e.Path, e.Holder)
+}
+
+// Lock is a held lock.
+type Lock struct {
+	file *os.File
+	// Stale is the holder of a lock that was left behind by a process that
+	// died without releasing it, or nil
+	Stale *Holder
+}
+
+// Acquire locks the file at path, creating it if needed. A busy lock is tried
+// again until wait has passed, forever if wait is negative, and returns a
+// *HeldError once it runs out.
+func Acquire(path string, command string, wait time.Duration) (*Lock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open lock file: %v", err)
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		locked, err := tryLock(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("unable to lock %s: %v", path, err)
+		}
+		if locked {
+			break
+		}
+		if wait >= 0 && !time.Now().Before(deadline) {
+			holder, _ := readHolder(file)
+			file.Close()
+			return nil, &HeldError{Path: path, Holder: holder}
+		}
+		time.Sleep(pollInterval)
+	}
+
+	lock := &Lock{file: file}
+	// A released lock leaves the file empty, so a holder still written to it
+	// died while holding the lock
+	lock.Stale, _ = readHolder(file)
+
+	host, _ := os.Hostname()
+	holder := &Holder{PID: os.Getpid(), Host: host, Command: command, Started: time.Now()}
+	if err := writeHolder(file, holder); err != nil {
+		lock.Release()
+		return nil, fmt.Errorf("unable to write lock file: %v", err)
+	}
+	return lock, nil
+}
+
+// Release clears the holder and unlocks the file. The file itself is kept, as
+// removing it could let two processes lock different files of the same name.
+func (l *Lock) Release() error {
+	truncateErr := l.file.Truncate(0)
+	unlockErr := unlock(l.file)
+	closeErr := l.file.Close()
+	return errors.Join(truncateErr, unlockErr, closeErr)
+}
+
+func readHolder(file *os.File) (*Holder, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	holder := &Holder{}
+	if err := json.Unmarshal(data, holder); err != nil {
+		return nil, err
+	}
+	return holder, nil
+}
+
+func writeHolder(file *os.File, holder *Holder) error {
+	data, err := json.Marshal(holder)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(append(data, '\n'), 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
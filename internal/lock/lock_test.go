@@ -0,0 +1,77 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync.lock")
+	lock, err := Acquire(path, "sync", 0)
+	if err != nil {
+		t.Fatalf("Unable to lock: %v", err)
+	}
+	if lock.Stale != nil {
+		t.Errorf("Expected a new lock not to be stale, got %v", lock.Stale)
+	}
+
+	_, err = Acquire(path, "clean", 0)
+	var held *HeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("Expected the lock to be held, got %v", err)
+	}
+	if held.Holder == nil || held.Holder.PID != os.Getpid() || held.Holder.Command != "sync" {
+		t.Errorf("Expected the holder to be reported, got %+v", held.Holder)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Unable to release: %v", err)
+	}
+	if data, _ := os.ReadFile(path); len(data) != 0 {
+		t.Errorf("Expected a released lock to be empty, got %s", data)
+	}
+	lock, err = Acquire(path, "clean", 0)
+	if err != nil {
+		t.Fatalf("Unable to lock again: %v", err)
+	}
+	if lock.Stale != nil {
+		t.Errorf("Expected a released lock not to be stale, got %v", lock.Stale)
+	}
+	lock.Release()
+}
+
+func TestAcquireWait(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync.lock")
+	lock, err := Acquire(path, "sync", 0)
+	if err != nil {
+		t.Fatalf("Unable to lock: %v", err)
+	}
+	go func() {
+		time.Sleep(3 * pollInterval)
+		lock.Release()
+	}()
+
+	waited, err := Acquire(path, "sync", 10*time.Second)
+	if err != nil {
+		t.Fatalf("Expected to get the lock once released, got %v", err)
+	}
+	waited.Release()
+}
+
+func TestAcquireStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync.lock")
+	// A process that died holding the lock leaves its holder behind
+	os.WriteFile(path, []byte(`{"pid": 4242, "host": "laptop", "started": "2026-03-10T09:00:00Z"}`), 0600)
+
+	lock, err := Acquire(path, "sync", 0)
+	if err != nil {
+		t.Fatalf("Expected a stale lock to be taken over, got %v", err)
+	}
+	defer lock.Release()
+	if lock.Stale == nil || lock.Stale.PID != 4242 || lock.Stale.Host != "laptop" {
+		t.Errorf("Expected the stale holder to be reported, got %+v", lock.Stale)
+	}
+}
@@ -0,0 +1,236 @@
+// Package metrics keeps counters and gauges of unattended syncs in a
+// Prometheus registry, to be scraped over HTTP or written for the node
+// exporter's textfile collector.
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"golang.org/x/oauth2"
+)
+
+const lastSuccessName = "gcal_busy_blocker_last_success_timestamp_seconds"
+
+// Results of a sync.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// SyncMetrics measures syncs, each labelled with the account whose calendar
+// was blocked.
+type SyncMetrics struct {
+	Registry *prometheus.Registry
+	// Duration is how long the last sync took
+	Duration *prometheus.GaugeVec
+	// Syncs counts the syncs by result
+	Syncs *prometheus.CounterVec
+	// Events counts the blocks created, updated and deleted and the source
+	// events skipped and excluded by the rules
+	Events *prometheus.CounterVec
+	// LastSuccess is when the last successful sync finished
+	LastSuccess *prometheus.GaugeVec
+	// APIErrors counts the calendar API responses with an error status by
+	// host and code, or with code "network" when no response came back
+	APIErrors *prometheus.CounterVec
+	// TokenExpiry is when the access token last used for an account runs
+	// out. It stops moving forward once the token can't be refreshed.
+	TokenExpiry *prometheus.GaugeVec
+
+	now func() time.Time
+}
+
+// NewSyncMetrics returns the sync metrics in a registry of their own.
+func NewSyncMetrics() *SyncMetrics {
+	m := &SyncMetrics{
+		Registry: prometheus.NewRegistry(),
+		Duration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gcal_busy_blocker_sync_duration_seconds",
+			Help: "How long the last sync took.",
+		}, []string{"account"}),
+		Syncs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gcal_busy_blocker_syncs_total",
+			Help: "Syncs by result.",
+		}, []string{"account", "result"}),
+		Events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gcal_busy_blocker_events_total",
+			Help: "Blocks created, updated and deleted and source events skipped and excluded.",
+		}, []string{"account", "action"}),
+		LastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: lastSuccessName,
+			Help: "When the last successful sync finished, in seconds since the epoch.",
+		}, []string{"account"}),
+		APIErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gcal_busy_blocker_api_errors_total",
+			Help: "Calendar API responses with an error status, by host and code.",
+		}, []string{"host", "code"}),
+		TokenExpiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gcal_busy_blocker_token_expiry_timestamp_seconds",
+			Help: "When the access token last used for an account expires, in seconds since the epoch.",
+		}, []string{"account"}),
+		now: time.Now,
+	}
+	m.Registry.MustRegister(m.Duration, m.Syncs, m.Events, m.LastSuccess, m.APIErrors, m.TokenExpiry)
+	return m
+}
+
+// Handler serves the metrics to Prometheus.
+func (m *SyncMetrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// WriteTextfile writes the metrics to a file for the node exporter's textfile
+// collector. The file is replaced in one go, so the collector never reads half
+// of it.
+func (m *SyncMetrics) WriteTextfile(path string) error {
+	return prometheus.WriteToTextfile(path, m.Registry)
+}
+
+// RestoreLastSuccess sets the times of the last successful syncs to the ones
+// in a textfile written earlier, so they survive the runs that fail. A
+// missing file is not an error.
+func (m *SyncMetrics) RestoreLastSuccess(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	parser := expfmt.NewTextParser(model.LegacyValidation)
+	families, err := parser.TextToMetricFamilies(file)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	family, ok := families[lastSuccessName]
+	if !ok {
+		return nil
+	}
+	for _, metric := range family.GetMetric() {
+		for _, label := range metric.GetLabel() {
+			if label.GetName() == "account" && metric.GetGauge() != nil {
+				m.LastSuccess.WithLabelValues(label.GetValue()).Set(metric.GetGauge().GetValue())
+			}
+		}
+	}
+	return nil
+}
+
+// ObserveSync records a sync that started at the given time and ended with
+// err, and the number of events by action.
+func (m *SyncMetrics) ObserveSync(account string, started time.Time, events map[string]int, err error) {
+	now := m.now()
+	m.Duration.WithLabelValues(account).Set(now.Sub(started).Seconds())
+	for action, count := range events {
+		m.Events.WithLabelValues(account, action).Add(float64(count))
+	}
+	if err != nil {
+		m.Syncs.WithLabelValues(account, ResultFailure).Inc()
+		return
+	}
+	m.Syncs.WithLabelValues(account, ResultSuccess).Inc()
+	m.LastSuccess.WithLabelValues(account).Set(float64(now.Unix()))
+}
+
+// ObserveToken records when the current token of an account expires. Tokens
+// that can't be had or don't expire are left out.
+func (m *SyncMetrics) ObserveToken(account string, source oauth2.TokenSource) {
+	if source == nil {
+		return
+	}
+	token, err := source.Token()
+	if err == nil && !token.Expiry.IsZero() {
+		m.TokenExpiry.WithLabelValues(account).Set(float64(token.Expiry.Unix()))
+	}
+}
+
+// Instrument makes a client count the error responses of its requests. The
+// token source of an OAuth client is returned for ObserveToken, nil for
+// other clients.
+func (m *SyncMetrics) Instrument(client *http.Client) oauth2.TokenSource {
+	if transport, ok := client.Transport.(*oauth2.Transport); ok {
+		transport.Base = m.Transport(transport.Base)
+		return transport.Source
+	}
+	client.Transport = m.Transport(client.Transport)
+	return nil
+}
+
+// Transport counts the error responses of the requests sent through base.
+func (m *SyncMetrics) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &countingTransport{base: base, errors: m.APIErrors}
+}
+
+type countingTransport struct {
+	base   http.RoundTripper
+	errors *prometheus.CounterVec
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.errors.WithLabelValues(req.URL.Host, "network").Inc()
+	} else if resp.StatusCode >= 400 {
+		t.errors.WithLabelValues(req.URL.Host, strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	return resp, err
+}
+
+// HealthHandler answers 200 while some account synced successfully within
+// maxAge, and 503 once none has. A process that started less than maxAge ago
+// gets the benefit of the doubt.
+func (m *SyncMetrics) HealthHandler(maxAge time.Duration, started time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := m.now()
+		last := m.lastSuccess()
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		switch {
+		case !last.IsZero() && now.Sub(last) <= maxAge:
+			fmt.Fprintf(w, "ok, last successful sync at %s\n", last.UTC().Format(time.RFC3339))
+		case last.IsZero() && now.Sub(started) <= maxAge:
+			fmt.Fprintln(w, "ok, waiting for the first sync")
+		case last.IsZero():
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "no successful sync since starting at %s\n", started.UTC().Format(time.RFC3339))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "last successful sync at %s is older than %s\n", last.UTC().Format(time.RFC3339), maxAge)
+		}
+	})
+}
+
+// lastSuccess returns when the latest successful sync of any account
+// finished, or the zero time if none has.
+func (m *SyncMetrics) lastSuccess() time.Time {
+	var last time.Time
+	families, err := m.Registry.Gather()
+	if err != nil {
+		return last
+	}
+	for _, family := range families {
+		if family.GetName() != lastSuccessName {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if t := time.Unix(int64(metric.GetGauge().GetValue()), 0); t.After(last) {
+				last = t
+			}
+		}
+	}
+	return last
+}
@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// scrape returns the metrics as Prometheus reads them.
+func scrape(t *testing.T, m *SyncMetrics) string {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unable to scrape metrics: %d", recorder.Code)
+	}
+	return recorder.Body.String()
+}
+
+func TestObserveSync(t *testing.T) {
+	m := NewSyncMetrics()
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	m.ObserveSync("work", now.Add(-3*time.Second), map[string]int{"created": 2, "skipped": 5}, nil)
+	m.ObserveSync("work", now.Add(-time.Second), map[string]int{"created": 1}, errors.New("quota exceeded"))
+
+	out := scrape(t, m)
+	for _, line := range []string{
+		`gcal_busy_blocker_sync_duration_seconds{account="work"} 1`,
+		`gcal_busy_blocker_syncs_total{account="work",result="failure"} 1`,
+		`gcal_busy_blocker_syncs_total{account="work",result="success"} 1`,
+		`gcal_busy_blocker_events_total{account="work",action="created"} 3`,
+		`gcal_busy_blocker_events_total{account="work",action="skipped"} 5`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected %s in:\n%s", line, out)
+		}
+	}
+	if last := testutil.ToFloat64(m.LastSuccess.WithLabelValues("work")); last != 1773133200 {
+		t.Errorf("Expected the time of the successful sync, got %v", last)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/limited" {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	m := NewSyncMetrics()
+	client := &http.Client{}
+	if source := m.Instrument(client); source != nil {
+		t.Error("Expected no token source for a plain client")
+	}
+	for _, path := range []string{"/ok", "/limited", "/limited"} {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	if count := testutil.ToFloat64(m.APIErrors.WithLabelValues(host, "429")); count != 2 || testutil.CollectAndCount(m.APIErrors) != 1 {
+		t.Errorf("Expected only the error responses to be counted, got:\n%s", scrape(t, m))
+	}
+}
+
+func TestTextfileRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync.prom")
+	m := NewSyncMetrics()
+	m.Syncs.WithLabelValues("work", ResultSuccess).Inc()
+	m.LastSuccess.WithLabelValues("work").Set(1700000000)
+	m.LastSuccess.WithLabelValues(`odd "name", with\comma`).Set(1700000100)
+	if err := m.WriteTextfile(path); err != nil {
+		t.Fatalf("Unable to write textfile: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("Expected the textfile to be readable by the collector, got %v (%v)", info, err)
+	}
+
+	// A later run only has the values it kept
+	restored := NewSyncMetrics()
+	if err := restored.RestoreLastSuccess(path); err != nil {
+		t.Fatalf("Unable to restore: %v", err)
+	}
+	if testutil.CollectAndCount(restored.LastSuccess) != 2 ||
+		testutil.ToFloat64(restored.LastSuccess.WithLabelValues("work")) != 1700000000 ||
+		testutil.ToFloat64(restored.LastSuccess.WithLabelValues(`odd "name", with\comma`)) != 1700000100 {
+		t.Errorf("Expected both values back, got:\n%s", scrape(t, restored))
+	}
+	if testutil.CollectAndCount(restored.Syncs) != 0 {
+		t.Error("Expected only the times of the last successes to be restored")
+	}
+
+	if err := restored.RestoreLastSuccess(filepath.Join(t.TempDir(), "missing.prom")); err != nil {
+		t.Errorf("Expected a missing textfile to be skipped, got %v", err)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	m := NewSyncMetrics()
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	started := now.Add(-10 * time.Minute)
+	handler := m.HealthHandler(30*time.Minute, started)
+
+	status := func() int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+		return recorder.Code
+	}
+
+	if code := status(); code != http.StatusOK {
+		t.Errorf("Expected a new process to be healthy, got %d", code)
+	}
+	now = now.Add(time.Hour)
+	if code := status(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected no successful sync in time to be unhealthy, got %d", code)
+	}
+	m.ObserveSync("alice@acme.com", now, nil, nil)
+	m.ObserveSync("bob@acme.com", now, nil, errors.New("token revoked"))
+	if code := status(); code != http.StatusOK {
+		t.Errorf("Expected a recent successful sync to be healthy, got %d", code)
+	}
+	now = now.Add(31 * time.Minute)
+	if code := status(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a stale sync to be unhealthy, got %d", code)
+	}
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	gosync "sync"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/sync"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/tracing"
+	"golang.org/x/oauth2"
+)
+
+// leaseTTL is how long the lease on a user's destination calendar lasts if
+// the server stops without releasing it, the same as 'sync --lease-ttl'
+const leaseTTL = 15 * time.Minute
+
+var (
+	errNotConnected   = errors.New("both accounts have to be connected before syncing")
+	errAlreadyRunning = errors.New("a sync is already running")
+)
+
+// rateLimitError is returned when a user asks for syncs too often.
+type rateLimitError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("synced too recently, try again in %s", e.retryAfter.Round(time.Second))
+}
+
+// limiter allows each user one sync per interval.
+type limiter struct {
+	mu       gosync.Mutex
+	interval time.Duration
+	last     map[string]time.Time
+	now      func() time.Time
+}
+
+func newLimiter(interval time.Duration) *limiter {
+	return &limiter{interval: interval, last: map[string]time.Time{}, now: time.Now}
+}
+
+// allow records a sync for the user if they haven't had one within the
+// interval, and otherwise returns how long they have to wait.
+func (l *limiter) allow(id string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if last, ok := l.last[id]; ok {
+		if wait := last.Add(l.interval).Sub(now); wait > 0 {
+			return wait, false
+		}
+	}
+	l.last[id] = now
+	return 0, true
+}
+
+// RunScheduler syncs every connected user each interval until ctx is done.
+func (s *Server) RunScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.syncAll()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncAll starts a sync for each connected user. Users who synced recently,
+// or are still syncing, are left for the next round.
+func (s *Server) syncAll() {
+	users, err := s.store.List()
+	if err != nil {
+		slog.Error("Unable to list users", "error", err)
+		return
+	}
+	for _, user := range users {
+		if !user.Connected() {
+			continue
+		}
+		if err := s.startSync(user); err != nil && !errors.Is(err, errAlreadyRunning) {
+			var limited *rateLimitError
+			if !errors.As(err, &limited) {
+				slog.Error("Unable to start sync", "account", user.Email, "error", err)
+			}
+		}
+	}
+}
+
+// startSync runs a user's sync in the background. Each user has at most one
+// sync running, and at most workers run at once across users.
+func (s *Server) startSync(user *User) error {
+	if !user.Connected() {
+		return errNotConnected
+	}
+
+	s.mu.Lock()
+	if s.running[user.ID] {
+		s.mu.Unlock()
+		return errAlreadyRunning
+	}
+	if wait, ok := s.limiter.allow(user.ID); !ok {
+		s.mu.Unlock()
+		return &rateLimitError{retryAfter: wait}
+	}
+	s.running[user.ID] = true
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.workers <- struct{}{}
+		defer func() { <-s.workers }()
+
+		err := s.runSync(user)
+		s.mu.Lock()
+		delete(s.running, user.ID)
+		s.mu.Unlock()
+
+		now := time.Now()
+		if err != nil {
+			slog.Error("Sync failed", "account", user.Email, "error", err)
+		}
+		updateErr := s.store.Update(user.ID, func(stored *User) {
+			stored.Status.LastRun = now
+			stored.Status.LastError = ""
+			if err != nil {
+				stored.Status.LastError = err.Error()
+			} else {
+				stored.Status.LastSuccess = now
+			}
+		})
+		if updateErr != nil {
+			slog.Error("Unable to save status", "account", user.Email, "error", updateErr)
+		}
+	}()
+	return nil
+}
+
+// runSync runs one user's sync, turning a panic into an error so it can't
+// take down the other users' syncs.
+func (s *Server) runSync(user *User) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("sync panicked: %v", r)
+		}
+	}()
+	return s.sync(user)
+}
+
+// isRunning reports whether a user's sync is in progress.
+func (s *Server) isRunning(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running[id]
+}
+
+// syncUser syncs a user's primary calendars with the same engine as the sync
+// command, using clients of their own. The destination calendar is leased
+// like 'sync --lease' does, so syncs of it from the command line have to pass
+// --lease to keep from running at the same time.
+func (s *Server) syncUser(user *User) error {
+	var loc *time.Location
+	if user.TimeZone != "" {
+		var err error
+		loc, err = time.LoadLocation(user.TimeZone)
+		if err != nil {
+			return fmt.Errorf("unknown time zone %q: %v", user.TimeZone, err)
+		}
+	}
+
+	ctx := context.Background()
+	sourceClient := s.sourceConfig.Client(ctx, user.SourceToken)
+	destinationClient := s.destinationConfig.Client(ctx, user.DestinationToken)
+	tracing.Instrument(user.Email+"/source", sourceClient)
+	tracing.Instrument(user.Email+"/destination", destinationClient)
+	var sourceTokens, destinationTokens oauth2.TokenSource
+	if s.metrics != nil {
+		sourceTokens = s.metrics.Instrument(sourceClient)
+		destinationTokens = s.metrics.Instrument(destinationClient)
+	}
+
+	source, err := sync.NewCalendarEventsService(sourceClient)
+	if err != nil {
+		return err
+	}
+	destination, err := sync.NewCalendarEventsService(destinationClient)
+	if err != nil {
+		return err
+	}
+	logger := slog.With("account", user.Email)
+	syncClient := &sync.SyncClient{
+		SourceCalendarService:      source,
+		DestinationCalendarService: destination,
+		Location:                   loc,
+		Audit:                      s.audit,
+		Metrics:                    s.metrics,
+		DestinationAccount:         user.Email,
+		Logger:                     logger,
+	}
+	err = s.syncLeased(syncClient, logger)
+	if s.metrics != nil {
+		s.metrics.ObserveToken(user.Email+"/source", sourceTokens)
+		s.metrics.ObserveToken(user.Email+"/destination", destinationTokens)
+	}
+	return err
+}
+
+// syncLeased runs a sync while holding the lease on its destination calendar.
+// A lease held elsewhere fails the sync, which is tried again next round.
+func (s *Server) syncLeased(syncClient *sync.SyncClient, logger *slog.Logger) error {
+	host, _ := os.Hostname()
+	lease, err := syncClient.AcquireLease(fmt.Sprintf("%s/%d", host, os.Getpid()), leaseTTL, 0)
+	if err != nil {
+		return fmt.Errorf("unable to lease the destination calendar: %v", err)
+	}
+	defer func() {
+		if err := lease.Release(); err != nil {
+			logger.Warn("Unable to release the lease", "expires_in", leaseTTL, "error", err)
+		}
+	}()
+	return syncClient.RunSync(s.daysAhead, false)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	l := newLimiter(time.Minute)
+	l.now = func() time.Time { return now }
+
+	if _, ok := l.allow("alice"); !ok {
+		t.Fatal("Expected the first sync to be allowed")
+	}
+	if _, ok := l.allow("bob"); !ok {
+		t.Error("Expected users to be limited separately")
+	}
+	now = now.Add(20 * time.Second)
+	if wait, ok := l.allow("alice"); ok || wait != 40*time.Second {
+		t.Errorf("Expected to wait 40s, got %v (%v)", wait, ok)
+	}
+	now = now.Add(40 * time.Second)
+	if _, ok := l.allow("alice"); !ok {
+		t.Error("Expected a sync to be allowed after the interval")
+	}
+}
+
+func TestSyncAll(t *testing.T) {
+	s := newTestServer(t)
+	connected := &User{ID: userID("alice@acme.com"), Email: "alice@acme.com", SourceToken: &oauth2.Token{}, DestinationToken: &oauth2.Token{}}
+	failing := &User{ID: userID("bob@acme.com"), Email: "bob@acme.com", SourceToken: &oauth2.Token{}, DestinationToken: &oauth2.Token{}}
+	halfway := &User{ID: userID("carol@acme.com"), Email: "carol@acme.com", DestinationToken: &oauth2.Token{}}
+	for _, user := range []*User{connected, failing, halfway} {
+		s.store.Put(user)
+	}
+
+	synced := map[string]bool{}
+	s.sync = func(user *User) error {
+		synced[user.Email] = true
+		if user.Email == "bob@acme.com" {
+			panic("calendar exploded")
+		}
+		return nil
+	}
+	s.syncAll()
+	s.Wait()
+
+	if !synced["alice@acme.com"] || !synced["bob@acme.com"] || synced["carol@acme.com"] {
+		t.Errorf("Expected only connected users to be synced, got %v", synced)
+	}
+
+	alice, _ := s.store.Get(connected.ID)
+	if alice.Status.LastSuccess.IsZero() || alice.Status.LastError != "" {
+		t.Errorf("Expected a successful sync for alice, got %+v", alice.Status)
+	}
+	bob, _ := s.store.Get(failing.ID)
+	if bob.Status.LastRun.IsZero() || !bob.Status.LastSuccess.IsZero() || bob.Status.LastError != "sync panicked: calendar exploded" {
+		t.Errorf("Expected bob's failure to be recorded, got %+v", bob.Status)
+	}
+
+	// Both were synced just now
+	synced = map[string]bool{}
+	s.syncAll()
+	s.Wait()
+	if len(synced) != 0 {
+		t.Errorf("Expected users to be rate limited, got %v", synced)
+	}
+}
+
+func TestStartSyncAlreadyRunning(t *testing.T) {
+	s := newTestServer(t)
+	user := &User{ID: userID("alice@acme.com"), Email: "alice@acme.com", SourceToken: &oauth2.Token{}, DestinationToken: &oauth2.Token{}}
+	s.store.Put(user)
+
+	release := make(chan struct{})
+	s.sync = func(user *User) error {
+		<-release
+		return nil
+	}
+	if err := s.startSync(user); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := s.startSync(user); !errors.Is(err, errAlreadyRunning) {
+		t.Errorf("Expected the running sync to be reported, got %v", err)
+	}
+	close(release)
+	s.Wait()
+
+	if err := s.startSync(&User{ID: "x"}); !errors.Is(err, errNotConnected) {
+		t.Errorf("Expected an error for a user without accounts, got %v", err)
+	}
+}
@@ -0,0 +1,426 @@
+// Package server hosts gcal-busy-blocker for a team: people connect their
+// source and destination Google accounts in a browser, and the server syncs
+// each of them on a schedule.
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/audit"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/auth"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/metrics"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+const (
+	sessionCookie = "session"
+	stateCookie   = "oauth_state"
+	sessionMaxAge = 30 * 24 * time.Hour
+)
+
+// Options configure a server.
+type Options struct {
+	Store *Store
+	// SourceConfig and DestinationConfig are the OAuth configs used to
+	// connect each account, redirecting back to /oauth/callback
+	SourceConfig      *oauth2.Config
+	DestinationConfig *oauth2.Config
+	// SessionKey signs session cookies
+	SessionKey []byte
+	DaysAhead  int
+	// MinSyncInterval is how often each user may sync, however the sync is
+	// started
+	MinSyncInterval time.Duration
+	// Workers is how many users are synced at once
+	Workers int
+	// Audit logs the changes made to the users' calendars and Metrics
+	// measures their syncs, when set
+	Audit   *audit.Log
+	Metrics *metrics.SyncMetrics
+}
+
+// Server is the web UI and API, and runs the users' syncs.
+type Server struct {
+	store             *Store
+	sourceConfig      *oauth2.Config
+	destinationConfig *oauth2.Config
+	sessionKey        []byte
+	daysAhead         int
+	limiter           *limiter
+	workers           chan struct{}
+	audit             *audit.Log
+	metrics           *metrics.SyncMetrics
+
+	mu      gosync.Mutex
+	running map[string]bool
+	wg      gosync.WaitGroup
+
+	// sync runs one user's sync and lookupAccount finds the email and time
+	// zone of a destination account, both replaced in tests
+	sync          func(user *User) error
+	lookupAccount func(ctx context.Context, client *http.Client) (string, string, error)
+}
+
+// New returns a server for the users in options.Store.
+func New(options Options) *Server {
+	workers := max(options.Workers, 1)
+	s := &Server{
+		store:             options.Store,
+		sourceConfig:      options.SourceConfig,
+		destinationConfig: options.DestinationConfig,
+		sessionKey:        options.SessionKey,
+		daysAhead:         options.DaysAhead,
+		limiter:           newLimiter(options.MinSyncInterval),
+		workers:           make(chan struct{}, workers),
+		audit:             options.Audit,
+		metrics:           options.Metrics,
+		running:           map[string]bool{},
+		lookupAccount:     primaryCalendar,
+	}
+	s.sync = s.syncUser
+	return s
+}
+
+// Wait blocks until the syncs that were started have finished.
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+// Handler serves the web UI and API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.handleIndex)
+	mux.HandleFunc("GET /connect/{account}", s.handleConnect)
+	mux.HandleFunc("GET /oauth/callback", s.handleCallback)
+	mux.HandleFunc("GET /api/status", s.handleStatus)
+	mux.HandleFunc("POST /api/sync", s.handleSync)
+	mux.HandleFunc("POST /api/disconnect", s.handleDisconnect)
+	return mux
+}
+
+// StatusResponse is what /api/status returns for the signed in user.
+type StatusResponse struct {
+	Email                string `json:"email"`
+	SourceConnected      bool   `json:"sourceConnected"`
+	DestinationConnected bool   `json:"destinationConnected"`
+	Running              bool   `json:"running"`
+	Status               Status `json:"status"`
+}
+
+func (s *Server) statusResponse(user *User) *StatusResponse {
+	return &StatusResponse{
+		Email:                user.Email,
+		SourceConnected:      user.SourceToken != nil,
+		DestinationConnected: user.DestinationToken != nil,
+		Running:              s.isRunning(user.ID),
+		Status:               user.Status,
+	}
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	user, err := s.sessionUser(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var status *StatusResponse
+	if user != nil {
+		status = s.statusResponse(user)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, status); err != nil {
+		slog.Error("Unable to render page", "error", err)
+	}
+}
+
+// handleConnect sends the user to Google to connect an account. The
+// destination account identifies the user, so it's connected first.
+func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
+	account := r.PathValue("account")
+	config := s.oauthConfig(account)
+	if config == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if account == auth.SourceAccount {
+		user, err := s.sessionUser(r)
+		if err != nil || user == nil {
+			http.Error(w, "connect your destination account first", http.StatusForbidden)
+			return
+		}
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	state := account + "." + hex.EncodeToString(nonce)
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     "/oauth/callback",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   s.secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	// Asking for consent again makes Google hand out a refresh token even if
+	// the account was connected before
+	http.Redirect(w, r, config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce), http.StatusFound)
+}
+
+func (s *Server) handleCallback(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil || state == "" || !hmac.Equal([]byte(cookie.Value), []byte(state)) {
+		http.Error(w, "invalid OAuth state, start connecting the account again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/oauth/callback", MaxAge: -1})
+	if errorCode := r.URL.Query().Get("error"); errorCode != "" {
+		http.Error(w, "Google didn't connect the account: "+errorCode, http.StatusBadRequest)
+		return
+	}
+
+	account, _, _ := strings.Cut(state, ".")
+	config := s.oauthConfig(account)
+	if config == nil {
+		http.Error(w, "invalid OAuth state", http.StatusBadRequest)
+		return
+	}
+	token, err := config.Exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		http.Error(w, "unable to connect the account: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if account == auth.SourceAccount {
+		user, err := s.sessionUser(r)
+		if err != nil || user == nil {
+			http.Error(w, "connect your destination account first", http.StatusForbidden)
+			return
+		}
+		err = s.store.Update(user.ID, func(user *User) { user.SourceToken = token })
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	email, timeZone, err := s.lookupAccount(r.Context(), config.Client(r.Context(), token))
+	if err != nil {
+		http.Error(w, "unable to read the account's email: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	id := userID(email)
+	user, err := s.store.Get(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		user = &User{ID: id, Email: email}
+	}
+	user.DestinationToken = token
+	user.TimeZone = timeZone
+	if err := s.store.Put(user); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.setSession(w, id)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	user := s.requireUser(w, r)
+	if user == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.statusResponse(user))
+}
+
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	user := s.requireUser(w, r)
+	if user == nil {
+		return
+	}
+	err := s.startSync(user)
+	var limited *rateLimitError
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusAccepted, s.statusResponse(user))
+	case errors.As(err, &limited):
+		w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(limited.retryAfter.Seconds()))))
+		writeError(w, http.StatusTooManyRequests, err)
+	case errors.Is(err, errAlreadyRunning):
+		writeError(w, http.StatusConflict, err)
+	case errors.Is(err, errNotConnected):
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// handleDisconnect forgets the user and their tokens. Blocks already created
+// stay on the destination calendar.
+func (s *Server) handleDisconnect(w http.ResponseWriter, r *http.Request) {
+	user := s.requireUser(w, r)
+	if user == nil {
+		return
+	}
+	if err := s.store.Delete(user.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) oauthConfig(account string) *oauth2.Config {
+	switch account {
+	case auth.SourceAccount:
+		return s.sourceConfig
+	case auth.DestinationAccount:
+		return s.destinationConfig
+	}
+	return nil
+}
+
+func (s *Server) secureCookies() bool {
+	return strings.HasPrefix(s.destinationConfig.RedirectURL, "https://")
+}
+
+// setSession signs the user in. The cookie holds the user's ID and a MAC of
+// it, so it can't be changed to another user's.
+func (s *Server) setSession(w http.ResponseWriter, id string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id + "." + s.sign(id),
+		Path:     "/",
+		MaxAge:   int(sessionMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   s.secureCookies(),
+		// Lax keeps the cookie off cross-site POSTs to the API
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Server) sign(id string) string {
+	mac := hmac.New(sha256.New, s.sessionKey)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sessionUser returns the signed in user, or nil if there isn't one.
+func (s *Server) sessionUser(r *http.Request) (*User, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, nil
+	}
+	id, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.sign(id))) {
+		return nil, nil
+	}
+	return s.store.Get(id)
+}
+
+// requireUser returns the signed in user, answering 401 if there isn't one.
+func (s *Server) requireUser(w http.ResponseWriter, r *http.Request) *User {
+	user, err := s.sessionUser(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return nil
+	}
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, errors.New("not signed in"))
+		return nil
+	}
+	return user
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// primaryCalendar returns the email of the account a client is signed in to,
+// which is the ID of its primary calendar, and the time zone of that calendar.
+func primaryCalendar(ctx context.Context, client *http.Client) (string, string, error) {
+	service, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return "", "", err
+	}
+	entry, err := service.CalendarList.Get("primary").Context(ctx).Do()
+	if err != nil {
+		return "", "", err
+	}
+	return entry.Id, entry.TimeZone, nil
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gcal-busy-blocker</title>
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: 2em auto; }
+dt { font-weight: bold; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>gcal-busy-blocker</h1>
+{{if not .}}
+<p>Busy time on your personal calendar gets blocked on your work calendar. Start by connecting the work calendar the blocks go to.</p>
+<p><a href="/connect/destination">Connect destination account</a></p>
+{{else}}
+<p>Signed in as {{.Email}}.</p>
+<dl>
+<dt>Destination account</dt><dd>{{.Email}} <a href="/connect/destination">Reconnect</a></dd>
+<dt>Source account</dt><dd>{{if .SourceConnected}}Connected <a href="/connect/source">Reconnect</a>{{else}}<a href="/connect/source">Connect source account</a>{{end}}</dd>
+<dt>Last sync</dt><dd>{{if .Running}}Running now{{else if .Status.LastRun.IsZero}}Never{{else}}{{.Status.LastRun.Format "2006-01-02 15:04 MST"}}{{end}}</dd>
+{{if not .Status.LastSuccess.IsZero}}<dt>Last successful sync</dt><dd>{{.Status.LastSuccess.Format "2006-01-02 15:04 MST"}}</dd>{{end}}
+{{if .Status.LastError}}<dt>Last error</dt><dd class="error">{{.Status.LastError}}</dd>{{end}}
+</dl>
+{{if .SourceConnected}}<button onclick="post('/api/sync')">Sync now</button>{{end}}
+<button onclick="if (confirm('Forget your accounts?')) post('/api/disconnect')">Disconnect</button>
+<p id="message" class="error"></p>
+<script>
+async function post(path) {
+  const response = await fetch(path, {method: "POST"});
+  if (response.ok) {
+    location.reload();
+  } else {
+    document.getElementById("message").textContent = (await response.json()).error;
+  }
+}
+</script>
+{{end}}
+</body>
+</html>
+`))
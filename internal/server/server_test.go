@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// newTestServer returns a server whose OAuth configs exchange codes with a
+// stand-in token endpoint, handing out the code as the refresh token.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "access-" + r.PostFormValue("code"),
+			"refresh_token": r.PostFormValue("code"),
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+		})
+	}))
+	t.Cleanup(tokenServer.Close)
+
+	config := func(scope string) *oauth2.Config {
+		return &oauth2.Config{
+			ClientID:    "client",
+			Endpoint:    oauth2.Endpoint{AuthURL: "https://accounts.example.com/auth", TokenURL: tokenServer.URL},
+			RedirectURL: "http://blocker.example.com/oauth/callback",
+			Scopes:      []string{scope},
+		}
+	}
+	s := New(Options{
+		Store:             newTestStore(t),
+		SourceConfig:      config("source"),
+		DestinationConfig: config("destination"),
+		SessionKey:        []byte("session key"),
+		DaysAhead:         30,
+		MinSyncInterval:   time.Minute,
+		Workers:           2,
+	})
+	s.lookupAccount = func(ctx context.Context, client *http.Client) (string, string, error) {
+		return "alice@acme.com", "America/New_York", nil
+	}
+	s.sync = func(user *User) error { return nil }
+	return s
+}
+
+// testBrowser follows the server's redirects with cookies, stopping at the
+// ones that leave for Google.
+func testBrowser(t *testing.T) *http.Client {
+	jar, _ := cookiejar.New(nil)
+	return &http.Client{Jar: jar, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Host == "accounts.example.com" {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}}
+}
+
+// connect goes through connecting an account, with Google handing back code.
+func connect(t *testing.T, browser *http.Client, serverURL string, account string, code string) *http.Response {
+	t.Helper()
+	resp, err := browser.Get(serverURL + "/connect/" + account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return resp
+	}
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	if location.Query().Get("access_type") != "offline" {
+		t.Errorf("Expected offline access to be asked for, got %s", location)
+	}
+
+	resp, err = browser.Get(serverURL + "/oauth/callback?" + url.Values{"state": {location.Query().Get("state")}, "code": {code}}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestConnectAndSync(t *testing.T) {
+	s := newTestServer(t)
+	server := httptest.NewServer(s.Handler())
+	t.Cleanup(server.Close)
+	browser := testBrowser(t)
+
+	// The source can't be connected before the user is known
+	if resp := connect(t, browser, server.URL, "source", "early"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected connecting the source first to be refused, got %s", resp.Status)
+	}
+
+	if resp := connect(t, browser, server.URL, "destination", "work-token"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected to end up on the index page, got %s", resp.Status)
+	}
+	status := getStatus(t, browser, server.URL)
+	if status.Email != "alice@acme.com" || !status.DestinationConnected || status.SourceConnected {
+		t.Errorf("Unexpected status %+v", status)
+	}
+
+	// Syncing needs both accounts
+	if resp := post(t, browser, server.URL+"/api/sync"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected syncing without a source to fail, got %s", resp.Status)
+	}
+
+	connect(t, browser, server.URL, "source", "personal-token")
+	user, _ := s.store.Get(userID("alice@acme.com"))
+	if user.SourceToken.RefreshToken != "personal-token" || user.DestinationToken.RefreshToken != "work-token" {
+		t.Errorf("Expected both tokens to be stored, got %+v", user)
+	}
+	if user.TimeZone != "America/New_York" {
+		t.Errorf("Expected the destination calendar's time zone to be stored, got %q", user.TimeZone)
+	}
+
+	synced := make(chan string, 1)
+	s.sync = func(user *User) error {
+		synced <- user.Email
+		return nil
+	}
+	if resp := post(t, browser, server.URL+"/api/sync"); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected the sync to start, got %s", resp.Status)
+	}
+	if email := <-synced; email != "alice@acme.com" {
+		t.Errorf("Expected alice to be synced, got %s", email)
+	}
+	s.Wait()
+
+	resp := post(t, browser, server.URL+"/api/sync")
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("Expected the next sync to be rate limited, got %s", resp.Status)
+	}
+	if status := getStatus(t, browser, server.URL); status.Status.LastSuccess.IsZero() {
+		t.Errorf("Expected the sync to show in the status, got %+v", status)
+	}
+
+	page, _ := browser.Get(server.URL + "/")
+	page.Body.Close()
+	if page.StatusCode != http.StatusOK {
+		t.Errorf("Expected the index page to render, got %s", page.Status)
+	}
+
+	if resp := post(t, browser, server.URL+"/api/disconnect"); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected to disconnect, got %s", resp.Status)
+	}
+	if user, _ := s.store.Get(userID("alice@acme.com")); user != nil {
+		t.Error("Expected the user to be forgotten")
+	}
+}
+
+func TestCallbackRejectsForgedState(t *testing.T) {
+	s := newTestServer(t)
+	server := httptest.NewServer(s.Handler())
+	t.Cleanup(server.Close)
+
+	resp, err := testBrowser(t).Get(server.URL + "/oauth/callback?state=destination.abc&code=stolen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a callback without the state cookie to be refused, got %s", resp.Status)
+	}
+}
+
+func TestSessionCantBeForged(t *testing.T) {
+	s := newTestServer(t)
+	s.store.Put(&User{ID: userID("bob@acme.com"), Email: "bob@acme.com"})
+	server := httptest.NewServer(s.Handler())
+	t.Cleanup(server.Close)
+
+	for _, value := range []string{"", userID("bob@acme.com"), userID("bob@acme.com") + ".forged"} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/status", nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookie, Value: value})
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%q: expected 401, got %s", value, resp.Status)
+		}
+	}
+}
+
+func getStatus(t *testing.T, browser *http.Client, serverURL string) *StatusResponse {
+	t.Helper()
+	resp, err := browser.Get(serverURL + "/api/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	status := &StatusResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+		t.Fatalf("Unable to decode status: %v", err)
+	}
+	return status
+}
+
+func post(t *testing.T, browser *http.Client, target string) *http.Response {
+	t.Helper()
+	resp, err := browser.Post(target, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const keySize = 32
+
+// User is someone who connected their accounts to the server.
+type User struct {
+	ID               string        `json:"id"`
+	Email            string        `json:"email"`
+	SourceToken      *oauth2.Token `json:"sourceToken,omitempty"`
+	DestinationToken *oauth2.Token `json:"destinationToken,omitempty"`
+	// TimeZone is the IANA zone of the destination's primary calendar, used
+	// for the sync window and block times. Users connected before it was
+	// stored are synced in the server's zone until they connect again.
+	TimeZone string `json:"timeZone,omitempty"`
+	Status   Status `json:"status"`
+}
+
+// Status is the outcome of a user's syncs.
+type Status struct {
+	LastRun     time.Time `json:"lastRun,omitzero"`
+	LastSuccess time.Time `json:"lastSuccess,omitzero"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// Connected reports whether both of the user's accounts are connected, so
+// their calendars can be synced.
+func (u *User) Connected() bool {
+	return u.SourceToken != nil && u.DestinationToken != nil
+}
+
+// userID derives a user's ID from their destination account's email, so
+// connecting the same account again finds the same user.
+func userID(email string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(email)))
+	return hex.EncodeToString(hash[:16])
+}
+
+// Store keeps users in a directory, one file each, encrypted with AES-GCM so
+// their tokens are useless without the key.
+type Store struct {
+	dir  string
+	aead cipher.AEAD
+	mu   gosync.Mutex
+}
+
+// NewStore returns a store in dir encrypting with a 32 byte key.
+func NewStore(dir string, key []byte) (*Store, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("the encryption key must be %d bytes, got %d", keySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, aead: aead}, nil
+}
+
+// LoadOrCreateKey reads a base64 encoded key from path, creating a random one
+// if the file doesn't exist yet. Losing the key loses every stored token.
+func LoadOrCreateKey(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key := make([]byte, keySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(key)
+		if err := os.WriteFile(path, []byte(encoded+"\n"), 0600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode key in %s: %v", path, err)
+	}
+	return key, nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".enc")
+}
+
+// Get returns the user with the ID, or nil if there isn't one.
+func (s *Store) Get(id string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(id)
+}
+
+func (s *Store) get(id string) (*User, error) {
+	sealed, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := s.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("user %s is corrupt", id)
+	}
+	// The ID is authenticated along with the data, so files can't be swapped
+	// between users
+	data, err := s.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt user %s, was the key changed? %v", id, err)
+	}
+	user := &User{}
+	if err := json.Unmarshal(data, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Put saves a user.
+func (s *Store) Put(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put(user)
+}
+
+func (s *Store) put(user *User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := s.aead.Seal(nonce, nonce, data, []byte(user.ID))
+
+	temp, err := os.CreateTemp(s.dir, ".user-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(sealed); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), s.path(user.ID))
+}
+
+// Update changes a user in place, so changes made elsewhere in the meantime
+// aren't overwritten. It does nothing if the user is gone.
+func (s *Store) Update(id string, update func(user *User)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := s.get(id)
+	if err != nil || user == nil {
+		return err
+	}
+	update(user)
+	return s.put(user)
+}
+
+// Delete removes a user.
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List returns every user.
+func (s *Store) List() ([]*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	users := []*User{}
+	for _, file := range files {
+		id, ok := strings.CutSuffix(file.Name(), ".enc")
+		if !ok {
+			continue
+		}
+		user, err := s.get(id)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
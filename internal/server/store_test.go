@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := NewStore(t.TempDir(), bytes.Repeat([]byte{1}, keySize))
+	if err != nil {
+		t.Fatalf("Unable to create store: %v", err)
+	}
+	return store
+}
+
+func TestStore(t *testing.T) {
+	store := newTestStore(t)
+
+	user := &User{ID: userID("alice@acme.com"), Email: "alice@acme.com", DestinationToken: &oauth2.Token{RefreshToken: "secret-refresh-token"}}
+	if err := store.Put(user); err != nil {
+		t.Fatalf("Unable to save user: %v", err)
+	}
+
+	data, err := os.ReadFile(store.path(user.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret-refresh-token")) || bytes.Contains(data, []byte("alice")) {
+		t.Error("Expected the user to be stored encrypted")
+	}
+
+	got, err := store.Get(user.ID)
+	if err != nil || got == nil {
+		t.Fatalf("Unable to read user: %v", err)
+	}
+	if got.Email != "alice@acme.com" || got.DestinationToken.RefreshToken != "secret-refresh-token" {
+		t.Errorf("Unexpected user %+v", got)
+	}
+
+	if err := store.Update(user.ID, func(user *User) { user.SourceToken = &oauth2.Token{RefreshToken: "source"} }); err != nil {
+		t.Fatalf("Unable to update user: %v", err)
+	}
+	users, err := store.List()
+	if err != nil || len(users) != 1 || !users[0].Connected() {
+		t.Fatalf("Expected one connected user, got %v (%v)", users, err)
+	}
+
+	if err := store.Delete(user.ID); err != nil {
+		t.Fatalf("Unable to delete user: %v", err)
+	}
+	if got, err := store.Get(user.ID); got != nil || err != nil {
+		t.Errorf("Expected the user to be gone, got %v (%v)", got, err)
+	}
+	if err := store.Update(user.ID, func(user *User) { t.Error("Update shouldn't be called for a missing user") }); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestStoreWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewStore(dir, bytes.Repeat([]byte{1}, keySize))
+	id := userID("alice@acme.com")
+	store.Put(&User{ID: id, Email: "alice@acme.com"})
+
+	other, _ := NewStore(dir, bytes.Repeat([]byte{2}, keySize))
+	if _, err := other.Get(id); err == nil {
+		t.Error("Expected an error reading with another key")
+	}
+
+	// A user's file can't be passed off as another user's
+	os.Rename(store.path(id), store.path(userID("bob@acme.com")))
+	if _, err := store.Get(userID("bob@acme.com")); err == nil {
+		t.Error("Expected an error reading a file under another ID")
+	}
+
+	if _, err := NewStore(dir, []byte("short")); err == nil {
+		t.Error("Expected an error for a short key")
+	}
+}
+
+func TestLoadOrCreateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.key")
+	key, err := LoadOrCreateKey(path)
+	if err != nil || len(key) != keySize {
+		t.Fatalf("Expected a new %d byte key, got %d bytes (%v)", keySize, len(key), err)
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the key file to only be readable by its owner, got %v", info.Mode().Perm())
+	}
+	again, err := LoadOrCreateKey(path)
+	if err != nil || !bytes.Equal(key, again) {
+		t.Errorf("Expected the same key to be read back (%v)", err)
+	}
+}
+
+func TestUserID(t *testing.T) {
+	if userID("Alice@acme.com") != userID("alice@acme.com") {
+		t.Error("Expected IDs to ignore the case of emails")
+	}
+	if userID("alice@acme.com") == userID("bob@acme.com") {
+		t.Error("Expected different users to get different IDs")
+	}
+}
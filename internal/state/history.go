@@ -0,0 +1,223 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	runsBucket    = []byte("runs")
+	runKey        = []byte("run")
+	changesBucket = []byte("changes")
+)
+
+// historyLimit is how many runs are kept, older ones are dropped.
+const historyLimit = 100
+
+// Run is one run of a command that changed a calendar.
+type Run struct {
+	ID          uint64    `json:"id"`
+	CalendarKey string    `json:"calendarKey"`
+	Command     string    `json:"command"`
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished,omitzero"`
+	Error       string    `json:"error,omitempty"`
+	// UndoneBy is the run that reversed this one
+	UndoneBy uint64 `json:"undoneBy,omitempty"`
+	// Changes are in the order they were made, and are only filled in by
+	// History when asked for
+	Changes []*Change `json:"-"`
+}
+
+// Change is a block that a run created, updated or deleted.
+type Change struct {
+	// Kind is OperationInsert, OperationPatch or OperationDelete
+	Kind    string    `json:"kind"`
+	EventId string    `json:"eventId"`
+	Time    time.Time `json:"time"`
+	// Before and After are the whole event as JSON, before is empty for
+	// inserts and after for deletes
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// BeginRun starts recording a run on a calendar, returning its ID.
+func (s *Store) BeginRun(calendarKey string, command string) (uint64, error) {
+	run := &Run{CalendarKey: calendarKey, Command: command, Started: s.now()}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		runs, err := tx.CreateBucketIfNotExists(runsBucket)
+		if err != nil {
+			return err
+		}
+		run.ID, err = runs.NextSequence()
+		if err != nil {
+			return err
+		}
+		bucket, err := runs.CreateBucket(sequenceKey(run.ID))
+		if err != nil {
+			return err
+		}
+		if _, err := bucket.CreateBucket(changesBucket); err != nil {
+			return err
+		}
+		if err := putRun(bucket, run); err != nil {
+			return err
+		}
+		return pruneRuns(runs)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return run.ID, nil
+}
+
+// AddChange records a change made by a run.
+func (s *Store) AddChange(runId uint64, change *Change) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := runBucket(tx, runId)
+		if err != nil {
+			return err
+		}
+		changes := bucket.Bucket(changesBucket)
+		seq, err := changes.NextSequence()
+		if err != nil {
+			return err
+		}
+		change.Time = s.now()
+		data, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		return changes.Put(sequenceKey(seq), data)
+	})
+}
+
+// FinishRun records the end of a run, and the error it failed with if any.
+func (s *Store) FinishRun(runId uint64, runErr error) error {
+	return s.updateRun(runId, func(run *Run) {
+		run.Finished = s.now()
+		if runErr != nil {
+			run.Error = runErr.Error()
+		}
+	})
+}
+
+// MarkUndone records that a run was reversed by another.
+func (s *Store) MarkUndone(runId uint64, undoneBy uint64) error {
+	return s.updateRun(runId, func(run *Run) {
+		run.UndoneBy = undoneBy
+	})
+}
+
+// History returns the recorded runs, newest first, along with their changes.
+// Runs on every calendar are returned when calendarKey is empty.
+func (s *Store) History(calendarKey string) ([]*Run, error) {
+	history := []*Run{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		runs := tx.Bucket(runsBucket)
+		if runs == nil {
+			return nil
+		}
+		cursor := runs.Cursor()
+		for k, _ := cursor.Last(); k != nil; k, _ = cursor.Prev() {
+			run, err := readRun(runs.Bucket(k))
+			if err != nil {
+				return err
+			}
+			if calendarKey == "" || run.CalendarKey == calendarKey {
+				history = append(history, run)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// Run returns a recorded run along with its changes, or nil if it isn't
+// recorded.
+func (s *Store) Run(runId uint64) (*Run, error) {
+	var run *Run
+	err := s.db.View(func(tx *bolt.Tx) error {
+		runs := tx.Bucket(runsBucket)
+		if runs == nil || runs.Bucket(sequenceKey(runId)) == nil {
+			return nil
+		}
+		var err error
+		run, err = readRun(runs.Bucket(sequenceKey(runId)))
+		return err
+	})
+	return run, err
+}
+
+func (s *Store) updateRun(runId uint64, update func(run *Run)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := runBucket(tx, runId)
+		if err != nil {
+			return err
+		}
+		run := &Run{}
+		if err := json.Unmarshal(bucket.Get(runKey), run); err != nil {
+			return fmt.Errorf("corrupt run %d: %v", runId, err)
+		}
+		update(run)
+		return putRun(bucket, run)
+	})
+}
+
+func runBucket(tx *bolt.Tx, runId uint64) (*bolt.Bucket, error) {
+	runs := tx.Bucket(runsBucket)
+	if runs == nil || runs.Bucket(sequenceKey(runId)) == nil {
+		return nil, fmt.Errorf("run %d isn't recorded", runId)
+	}
+	return runs.Bucket(sequenceKey(runId)), nil
+}
+
+func readRun(bucket *bolt.Bucket) (*Run, error) {
+	run := &Run{}
+	if err := json.Unmarshal(bucket.Get(runKey), run); err != nil {
+		return nil, fmt.Errorf("corrupt run: %v", err)
+	}
+	run.Changes = []*Change{}
+	err := bucket.Bucket(changesBucket).ForEach(func(k, v []byte) error {
+		change := &Change{}
+		if err := json.Unmarshal(v, change); err != nil {
+			return fmt.Errorf("corrupt change in run %d: %v", run.ID, err)
+		}
+		run.Changes = append(run.Changes, change)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func putRun(bucket *bolt.Bucket, run *Run) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(runKey, data)
+}
+
+// pruneRuns drops the oldest runs beyond the history limit.
+func pruneRuns(runs *bolt.Bucket) error {
+	keys := [][]byte{}
+	cursor := runs.Cursor()
+	for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for len(keys) > historyLimit {
+		if err := runs.DeleteBucket(keys[0]); err != nil {
+			return err
+		}
+		keys = keys[1:]
+	}
+	return nil
+}
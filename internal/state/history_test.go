@@ -0,0 +1,70 @@
+package state
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	store, _ := openTestStore(t)
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	first, err := store.BeginRun("google/work/primary", "sync")
+	if err != nil {
+		t.Fatalf("Unable to begin run: %v", err)
+	}
+	store.AddChange(first, &Change{Kind: OperationInsert, EventId: "block1", After: []byte(`{"id":"block1"}`)})
+	store.AddChange(first, &Change{Kind: OperationDelete, EventId: "block2", Before: []byte(`{"id":"block2"}`)})
+	store.FinishRun(first, nil)
+
+	second, _ := store.BeginRun("google/other/primary", "clean")
+	store.FinishRun(second, errors.New("quota exceeded"))
+
+	history, err := store.History("")
+	if err != nil || len(history) != 2 {
+		t.Fatalf("Expected 2 runs, got %d (%v)", len(history), err)
+	}
+	if history[0].ID != second || history[0].Error != "quota exceeded" || history[1].ID != first {
+		t.Errorf("Expected the newest run first, got %+v", history)
+	}
+	if history, _ := store.History("google/work/primary"); len(history) != 1 || history[0].ID != first {
+		t.Errorf("Expected only the calendar's run, got %+v", history)
+	}
+
+	run, err := store.Run(first)
+	if err != nil || run == nil {
+		t.Fatalf("Expected the run to be found, got %v", err)
+	}
+	if run.Command != "sync" || !run.Finished.Equal(now) || len(run.Changes) != 2 {
+		t.Fatalf("Unexpected run %+v", run)
+	}
+	if run.Changes[0].Kind != OperationInsert || string(run.Changes[0].After) != `{"id":"block1"}` || run.Changes[1].EventId != "block2" {
+		t.Errorf("Expected the changes in order, got %+v and %+v", run.Changes[0], run.Changes[1])
+	}
+
+	store.MarkUndone(first, second)
+	if run, _ := store.Run(first); run.UndoneBy != second {
+		t.Errorf("Expected the run to be marked undone, got %+v", run)
+	}
+	if run, err := store.Run(42); run != nil || err != nil {
+		t.Errorf("Expected an unknown run not to be found, got %v (%v)", run, err)
+	}
+	if err := store.AddChange(42, &Change{}); err == nil {
+		t.Error("Expected changes to an unknown run to fail")
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	store, _ := openTestStore(t)
+	for i := 0; i < historyLimit+5; i++ {
+		if _, err := store.BeginRun("google/work/primary", "sync"); err != nil {
+			t.Fatalf("Unable to begin run: %v", err)
+		}
+	}
+	history, _ := store.History("")
+	if len(history) != historyLimit || history[len(history)-1].ID != 6 {
+		t.Errorf("Expected the oldest runs to be dropped, got %d runs", len(history))
+	}
+}
@@ -0,0 +1,98 @@
+package state
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var journalBucket = []byte("journal")
+
+// Kinds of operations on a block.
+const (
+	OperationInsert = "insert"
+	OperationPatch  = "patch"
+	OperationDelete = "delete"
+)
+
+// Operation is a change to a block that was started but isn't known to have
+// finished. A run that was interrupted leaves its last operations behind, and
+// the next run settles them before trusting the recorded blocks.
+type Operation struct {
+	Seq  uint64 `json:"seq"`
+	Kind string `json:"kind"`
+	// EventId is the ID of the block, or the ID it was inserted with
+	EventId  string    `json:"eventId"`
+	SourceId string    `json:"sourceId,omitempty"`
+	Started  time.Time `json:"started"`
+}
+
+// Begin journals an operation before it's made, returning its sequence number
+// to finish it with.
+func (s *Store) Begin(calendarKey string, operation *Operation) (uint64, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := createCalendarBucket(tx, calendarKey)
+		if err != nil {
+			return err
+		}
+		journal := bucket.Bucket(journalBucket)
+		operation.Seq, err = journal.NextSequence()
+		if err != nil {
+			return err
+		}
+		operation.Started = s.now()
+		data, err := json.Marshal(operation)
+		if err != nil {
+			return err
+		}
+		return journal.Put(sequenceKey(operation.Seq), data)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return operation.Seq, nil
+}
+
+// Finish removes an operation from the journal once its outcome is recorded.
+func (s *Store) Finish(calendarKey string, seq uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := calendarBucket(tx, calendarKey)
+		if bucket == nil || bucket.Bucket(journalBucket) == nil {
+			return nil
+		}
+		return bucket.Bucket(journalBucket).Delete(sequenceKey(seq))
+	})
+}
+
+// Pending returns the unfinished operations of a calendar, oldest first.
+func (s *Store) Pending(calendarKey string) ([]*Operation, error) {
+	operations := []*Operation{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := calendarBucket(tx, calendarKey)
+		if bucket == nil || bucket.Bucket(journalBucket) == nil {
+			return nil
+		}
+		return bucket.Bucket(journalBucket).ForEach(func(k, v []byte) error {
+			operation := &Operation{}
+			if err := json.Unmarshal(v, operation); err != nil {
+				return fmt.Errorf("corrupt journal entry %d: %v", binary.BigEndian.Uint64(k), err)
+			}
+			operations = append(operations, operation)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return operations, nil
+}
+
+// sequenceKey encodes a sequence number so keys sort in order.
+func sequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
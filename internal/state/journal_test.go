@@ -0,0 +1,50 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	store, _ := openTestStore(t)
+
+	first, err := store.Begin("google/work/primary", &Operation{Kind: OperationInsert, EventId: "block1", SourceId: "dentist"})
+	if err != nil {
+		t.Fatalf("Unable to begin: %v", err)
+	}
+	second, _ := store.Begin("google/work/primary", &Operation{Kind: OperationDelete, EventId: "block2"})
+	store.Begin("google/other/primary", &Operation{Kind: OperationPatch, EventId: "other1"})
+
+	pending, err := store.Pending("google/work/primary")
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("Expected 2 pending operations, got %d (%v)", len(pending), err)
+	}
+	if pending[0].Seq != first || pending[0].Kind != OperationInsert || pending[0].SourceId != "dentist" || pending[1].Seq != second {
+		t.Errorf("Expected the operations in order, got %+v and %+v", pending[0], pending[1])
+	}
+	if pending[0].Started.IsZero() {
+		t.Error("Expected the start of the operation to be recorded")
+	}
+
+	if err := store.Finish("google/work/primary", first); err != nil {
+		t.Fatalf("Unable to finish: %v", err)
+	}
+	if pending, _ := store.Pending("google/work/primary"); len(pending) != 1 || pending[0].EventId != "block2" {
+		t.Errorf("Expected only the delete to be left, got %+v", pending)
+	}
+
+	// A rebuild knows what became of the operations
+	store.Rebuild("google/work/primary", nil)
+	if pending, _ := store.Pending("google/work/primary"); len(pending) != 0 {
+		t.Errorf("Expected a rebuild to clear the journal, got %d operations", len(pending))
+	}
+	if pending, _ := store.Pending("google/other/primary"); len(pending) != 1 {
+		t.Errorf("Expected other calendars to keep their journal, got %d operations", len(pending))
+	}
+
+	if pending, err := store.Pending("google/unknown/primary"); err != nil || len(pending) != 0 {
+		t.Errorf("Expected an unknown calendar to have no journal, got %d (%v)", len(pending), err)
+	}
+	if err := store.Finish("google/unknown/primary", 1); err != nil {
+		t.Errorf("Expected finishing on an unknown calendar to do nothing, got %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+// Package state keeps a local record of the blocks written to each
+// destination calendar, so a sync doesn't have to list the calendar to know
+// what it already holds.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	calendarsBucket = []byte("calendars")
+	blocksBucket    = []byte("blocks")
+	// builtKey marks a calendar whose blocks were all recorded, as opposed to
+	// one that only has the blocks of an interrupted first run
+	builtKey = []byte("built")
+)
+
+// Block is what's known about one block on a destination calendar.
+type Block struct {
+	SourceId      string `json:"sourceId"`
+	DestinationId string `json:"destinationId"`
+	// ContentHash covers the times and text of the block, telling whether it
+	// has to change without comparing it field by field
+	ContentHash string `json:"contentHash"`
+	ETag        string `json:"etag,omitempty"`
+	// Start and End are RFC 3339 times, or dates for all-day blocks
+	Start  string `json:"start"`
+	End    string `json:"end"`
+	AllDay bool   `json:"allDay,omitempty"`
+	// Schema is the metadata schema version the block was written with
+	Schema  int       `json:"schema,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// Store is a bbolt database of blocks, kept per destination calendar.
+type Store struct {
+	db  *bolt.DB
+	now func() time.Time
+}
+
+// Open opens the database at path, creating it if needed. Only one process
+// can have it open at a time.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("state database %s is in use by another sync", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open state database %s: %v", path, err)
+	}
+	return &Store{db: db, now: time.Now}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Blocks returns the blocks recorded for a calendar, and whether they're
+// known to be all of them. A calendar that was never rebuilt, or whose state
+// was forgotten, has to be listed and rebuilt first.
+func (s *Store) Blocks(calendarKey string) ([]*Block, bool, error) {
+	blocks := []*Block{}
+	built := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := calendarBucket(tx, calendarKey)
+		if bucket == nil {
+			return nil
+		}
+		built = bucket.Get(builtKey) != nil
+		return bucket.Bucket(blocksBucket).ForEach(func(k, v []byte) error {
+			block := &Block{}
+			if err := json.Unmarshal(v, block); err != nil {
+				return fmt.Errorf("corrupt state for block %s: %v", k, err)
+			}
+			blocks = append(blocks, block)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return blocks, built, nil
+}
+
+// BuiltAt returns when the blocks of a calendar were last rebuilt, or the zero
+// time if they never were.
+func (s *Store) BuiltAt(calendarKey string) (time.Time, error) {
+	var built time.Time
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := calendarBucket(tx, calendarKey)
+		if bucket == nil || bucket.Get(builtKey) == nil {
+			return nil
+		}
+		var err error
+		built, err = time.Parse(time.RFC3339, string(bucket.Get(builtKey)))
+		return err
+	})
+	return built, err
+}
+
+// Rebuild replaces the recorded blocks of a calendar, e.g. with the ones
+// listed from the calendar itself, and marks them complete. Its journal is
+// cleared, as the new blocks already reflect any unfinished operations.
+func (s *Store) Rebuild(calendarKey string, blocks []*Block) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		calendars, err := tx.CreateBucketIfNotExists(calendarsBucket)
+		if err != nil {
+			return err
+		}
+		if err := calendars.DeleteBucket([]byte(calendarKey)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+		bucket, err := createCalendarBucket(tx, calendarKey)
+		if err != nil {
+			return err
+		}
+		now := s.now()
+		for _, block := range blocks {
+			if block.Created.IsZero() {
+				block.Created = now
+			}
+			if block.Updated.IsZero() {
+				block.Updated = now
+			}
+			if err := putBlock(bucket, block); err != nil {
+				return err
+			}
+		}
+		return bucket.Put(builtKey, []byte(now.UTC().Format(time.RFC3339)))
+	})
+}
+
+// Put records a block that was created or changed.
+func (s *Store) Put(calendarKey string, block *Block) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := createCalendarBucket(tx, calendarKey)
+		if err != nil {
+			return err
+		}
+		now := s.now()
+		block.Created = now
+		if existing := bucket.Bucket(blocksBucket).Get([]byte(block.DestinationId)); existing != nil {
+			previous := &Block{}
+			if json.Unmarshal(existing, previous) == nil && !previous.Created.IsZero() {
+				block.Created = previous.Created
+			}
+		}
+		block.Updated = now
+		return putBlock(bucket, block)
+	})
+}
+
+// Delete forgets a block that was deleted.
+func (s *Store) Delete(calendarKey string, destinationId string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := calendarBucket(tx, calendarKey)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Bucket(blocksBucket).Delete([]byte(destinationId))
+	})
+}
+
+// Forget drops everything recorded for a calendar, so the next sync rebuilds
+// it from the calendar.
+func (s *Store) Forget(calendarKey string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		calendars := tx.Bucket(calendarsBucket)
+		if calendars == nil {
+			return nil
+		}
+		err := calendars.DeleteBucket([]byte(calendarKey))
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
+}
+
+func calendarBucket(tx *bolt.Tx, calendarKey string) *bolt.Bucket {
+	calendars := tx.Bucket(calendarsBucket)
+	if calendars == nil {
+		return nil
+	}
+	return calendars.Bucket([]byte(calendarKey))
+}
+
+func createCalendarBucket(tx *bolt.Tx, calendarKey string) (*bolt.Bucket, error) {
+	calendars, err := tx.CreateBucketIfNotExists(calendarsBucket)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := calendars.CreateBucketIfNotExists([]byte(calendarKey))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range [][]byte{blocksBucket, journalBucket} {
+		if _, err := bucket.CreateBucketIfNotExists(name); err != nil {
+			return nil, err
+		}
+	}
+	return bucket, nil
+}
+
+func putBlock(bucket *bolt.Bucket, block *Block) error {
+	if block.DestinationId == "" {
+		return errors.New("a block needs a destination ID to be recorded")
+	}
+	data, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	return bucket.Bucket(blocksBucket).Put([]byte(block.DestinationId), data)
+}
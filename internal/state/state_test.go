@@ -0,0 +1,98 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T) (*Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "state.db")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Unable to open store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, path
+}
+
+func TestStore(t *testing.T) {
+	store, _ := openTestStore(t)
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	blocks, built, err := store.Blocks("google/work/primary")
+	if err != nil || built || len(blocks) != 0 {
+		t.Fatalf("Expected nothing to be known about a new calendar, got %d blocks, built %v (%v)", len(blocks), built, err)
+	}
+
+	// Blocks recorded before a rebuild don't make the state complete
+	store.Put("google/work/primary", &Block{SourceId: "dentist", DestinationId: "block1", ContentHash: "a"})
+	if _, built, _ := store.Blocks("google/work/primary"); built {
+		t.Error("Expected the calendar to still need a rebuild")
+	}
+
+	err = store.Rebuild("google/work/primary", []*Block{
+		{SourceId: "dentist", DestinationId: "block1", ContentHash: "a"},
+		{SourceId: "gym", DestinationId: "block2", ContentHash: "b"},
+	})
+	if err != nil {
+		t.Fatalf("Unable to rebuild: %v", err)
+	}
+	store.Put("google/other/primary", &Block{SourceId: "dentist", DestinationId: "other1"})
+
+	blocks, built, err = store.Blocks("google/work/primary")
+	if err != nil || !built || len(blocks) != 2 {
+		t.Fatalf("Expected the 2 rebuilt blocks, got %d, built %v (%v)", len(blocks), built, err)
+	}
+	if builtAt, err := store.BuiltAt("google/work/primary"); err != nil || !builtAt.Equal(now) {
+		t.Errorf("Expected the time of the rebuild, got %v (%v)", builtAt, err)
+	}
+	if builtAt, _ := store.BuiltAt("google/other/primary"); !builtAt.IsZero() {
+		t.Errorf("Expected no rebuild time for a calendar that was never rebuilt, got %v", builtAt)
+	}
+
+	now = now.Add(time.Hour)
+	store.Put("google/work/primary", &Block{SourceId: "dentist", DestinationId: "block1", ContentHash: "c", ETag: `"2"`})
+	store.Delete("google/work/primary", "block2")
+	blocks, _, _ = store.Blocks("google/work/primary")
+	if len(blocks) != 1 {
+		t.Fatalf("Expected 1 block after deleting one, got %d", len(blocks))
+	}
+	if blocks[0].ContentHash != "c" || blocks[0].ETag != `"2"` {
+		t.Errorf("Expected the block to be updated, got %+v", blocks[0])
+	}
+	if !blocks[0].Created.Equal(now.Add(-time.Hour)) || !blocks[0].Updated.Equal(now) {
+		t.Errorf("Expected the creation time to be kept, got created %v updated %v", blocks[0].Created, blocks[0].Updated)
+	}
+
+	if err := store.Forget("google/work/primary"); err != nil {
+		t.Fatalf("Unable to forget calendar: %v", err)
+	}
+	if blocks, built, _ := store.Blocks("google/work/primary"); built || len(blocks) != 0 {
+		t.Error("Expected the calendar to be forgotten")
+	}
+	if blocks, _, _ := store.Blocks("google/other/primary"); len(blocks) != 1 {
+		t.Error("Expected other calendars to be kept")
+	}
+	if err := store.Put("google/work/primary", &Block{SourceId: "x"}); err == nil {
+		t.Error("Expected an error for a block without a destination ID")
+	}
+}
+
+func TestStorePersists(t *testing.T) {
+	store, path := openTestStore(t)
+	store.Rebuild("google/work/primary", []*Block{{SourceId: "dentist", DestinationId: "block1", Start: "2026-03-10T09:00:00Z", End: "2026-03-10T10:00:00Z"}})
+	store.Close()
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Unable to reopen store: %v", err)
+	}
+	defer reopened.Close()
+	blocks, built, err := reopened.Blocks("google/work/primary")
+	if err != nil || !built || len(blocks) != 1 || blocks[0].Start != "2026-03-10T09:00:00Z" {
+		t.Errorf("Expected the block to be read back, got %v, built %v (%v)", blocks, built, err)
+	}
+}
@@ -0,0 +1,26 @@
+package sync
+
+import "github.com/davidpimentel/gcal-busy-blocker/internal/audit"
+
+// audit records a change made to the destination calendar in the audit log,
+// successful or not. sourceId is only logged as a hash.
+func (s *SyncClient) audit(action string, eventId string, sourceId string, changeErr error) {
+	if s.Audit == nil {
+		return
+	}
+	entry := &audit.Entry{
+		Action:       action,
+		Account:      s.DestinationAccount,
+		Calendar:     s.destinationCalendar(),
+		EventId:      eventId,
+		SourceIdHash: audit.HashSourceId(sourceId),
+		Result:       audit.ResultOK,
+	}
+	if changeErr != nil {
+		entry.Result = audit.ResultError
+		entry.Error = changeErr.Error()
+	}
+	if err := s.Audit.Record(entry); err != nil {
+		s.logger().Warn("Unable to write the change to the audit log", "event_id", eventId, "error", err)
+	}
+}
@@ -0,0 +1,88 @@
+package sync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/audit"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestRunSyncAudit(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	personal := &memoryCalendarService{name: "personal", events: []*calendar.Event{
+		createTestEvent("dentist", "Dentist", start, start.Add(time.Hour), nil),
+		createTestEvent("gym", "Gym", start.Add(3*time.Hour), start.Add(4*time.Hour), nil),
+	}}
+	work := &memoryCalendarService{name: "work"}
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	syncClient := &SyncClient{
+		SourceCalendarService:      personal,
+		DestinationCalendarService: work,
+		Location:                   time.UTC,
+		DestinationCalendarId:      "busy@group.calendar.google.com",
+		State:                      openTestState(t),
+		DestinationStateKey:        "google/work/busy@group.calendar.google.com",
+		Audit:                      audit.Open(path),
+		DestinationAccount:         "work",
+	}
+
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	personal.events[0].End.DateTime = start.Add(2 * time.Hour).Format(time.RFC3339)
+	personal.events = personal.events[:1]
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	// Dry runs don't change anything, so there's nothing to log
+	if err := syncClient.RunSync(7, true); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	entries, err := syncClient.Audit.Read(time.Time{})
+	if err != nil {
+		t.Fatalf("Unable to read audit log: %v", err)
+	}
+	actions := []string{}
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+		if entry.Account != "work" || entry.Calendar != "busy@group.calendar.google.com" || entry.Result != audit.ResultOK || entry.EventId == "" {
+			t.Errorf("Unexpected entry %+v", entry)
+		}
+	}
+	if strings.Join(actions, ",") != "insert,insert,update,delete" {
+		t.Errorf("Expected two inserts, an update and a delete, got %v", actions)
+	}
+	if entries[2].SourceIdHash != audit.HashSourceId("dentist") {
+		t.Errorf("Expected the update to carry the hash of the source event, got %q", entries[2].SourceIdHash)
+	}
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "Dentist") || strings.Contains(string(data), `"dentist"`) {
+		t.Error("Expected the audit log to leave out the source event's title and ID")
+	}
+}
+
+func TestAuditFailedChange(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	block := createTestEvent("block1", "Busy", start, start.Add(time.Hour), map[string]string{appName: propertyAppNameValue, sourceEventIdPropertyKey: "dentist"})
+	destination := NewMockCalendarEventsService(nil)
+	destination.patchError = errors.New("rate limited")
+	syncClient := &SyncClient{
+		DestinationCalendarService: destination,
+		Audit:                      audit.Open(filepath.Join(t.TempDir(), "audit.jsonl")),
+	}
+
+	if err := syncClient.patchDestinationEvent(block, &calendar.Event{Summary: "Busy"}, false); err == nil {
+		t.Fatal("Expected the patch to fail")
+	}
+	entries, _ := syncClient.Audit.Read(time.Time{})
+	if len(entries) != 1 || entries[0].Result != audit.ResultError || entries[0].Error != "rate limited" || entries[0].Calendar != defaultCalendar {
+		t.Errorf("Expected the failure to be logged, got %+v", entries)
+	}
+}
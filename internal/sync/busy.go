@@ -0,0 +1,152 @@
+package sync
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+const eventTransparencyTransparent = "transparent"
+
+type interval struct {
+	start time.Time
+	end   time.Time
+}
+
+// fetchDestinationBusy returns the times the destination calendar is already
+// busy with events other than our own blocks, merged into non-overlapping
+// intervals. The events are listed rather than queried through FreeBusy since
+// FreeBusy can't tell our blocks apart from real meetings.
+func (s *SyncClient) fetchDestinationBusy(startTime time.Time, endTime time.Time) ([]interval, error) {
+	end := s.traceCall(s.DestinationCalendarService, "List", s.destinationCalendar(), "")
+	events, err := s.DestinationCalendarService.List(s.destinationCalendar(), startTime, endTime, nil)
+	end(err, eventCount(len(events)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch destination calendar events: %v", err)
+	}
+
+	busy := []interval{}
+	for _, event := range events {
+		if !occupiesTime(event) {
+			continue
+		}
+		start, end, _, ok := eventTimes(event, s.location())
+		if ok && end.After(start) {
+			busy = append(busy, interval{start: start, end: end})
+		}
+	}
+	return mergeIntervals(busy), nil
+}
+
+// occupiesTime reports whether a destination event makes its owner busy.
+func occupiesTime(event *calendar.Event) bool {
+	if isBusyBlock(event) {
+		return false
+	}
+	if event.Status == eventStatusCancelled || event.Transparency == eventTransparencyTransparent {
+		return false
+	}
+	for _, attendee := range event.Attendees {
+		if attendee.Self && attendee.ResponseStatus == "declined" {
+			return false
+		}
+	}
+	return true
+}
+
+func mergeIntervals(intervals []interval) []interval {
+	sorted := slices.Clone(intervals)
+	slices.SortFunc(sorted, func(a, b interval) int {
+		return a.start.Compare(b.start)
+	})
+
+	merged := []interval{}
+	for _, i := range sorted {
+		last := len(merged) - 1
+		if last >= 0 && !i.start.After(merged[last].end) {
+			if i.end.After(merged[last].end) {
+				merged[last].end = i.end
+			}
+			continue
+		}
+		merged = append(merged, i)
+	}
+	return merged
+}
+
+// subtractIntervals returns the parts of i not covered by busy, which must be
+// sorted and non-overlapping.
+func subtractIntervals(i interval, busy []interval) []interval {
+	free := []interval{}
+	start := i.start
+	for _, b := range busy {
+		if !b.end.After(start) {
+			continue
+		}
+		if !b.start.Before(i.end) {
+			break
+		}
+		if b.start.After(start) {
+			free = append(free, interval{start: start, end: b.start})
+		}
+		start = b.end
+		if !start.Before(i.end) {
+			return free
+		}
+	}
+	return append(free, interval{start: start, end: i.end})
+}
+
+// newSegmentEvents builds the blocks for the parts of a source event that the
+// destination calendar isn't already busy for. All-day events are always
+// blocked whole.
+func (s *SyncClient) newSegmentEvents(sourceEvent *calendar.Event, busy []interval) []*calendar.Event {
+	block := s.newDestinationEvent(sourceEvent)
+	start, end, allDay, ok := eventTimes(block, s.location())
+	if !ok || allDay {
+		return []*calendar.Event{block}
+	}
+
+	segments := []*calendar.Event{}
+	for _, free := range subtractIntervals(interval{start: start, end: end}, busy) {
+		segment := *block
+		segment.Start = eventDateTime(free.start, s.location())
+		segment.End = eventDateTime(free.end, s.location())
+		segments = append(segments, &segment)
+	}
+	return segments
+}
+
+// reconcileSegments makes the blocks of a source event match the parts of it
+// that aren't already busy, keeping blocks that are still correct. Returns the
+// number of blocks inserted and deleted.
+func (s *SyncClient) reconcileSegments(sourceEvent *calendar.Event, destinationEvents []*calendar.Event, busy []interval, dryRun bool) (int, int, error) {
+	existing := findDestinationEvents(destinationEvents, sourceEvent.Id)
+	desired := s.newSegmentEvents(sourceEvent, busy)
+
+	inserted := 0
+	for _, segment := range desired {
+		index := slices.IndexFunc(existing, func(event *calendar.Event) bool {
+			return sameEventTime(event.Start, segment.Start) && sameEventTime(event.End, segment.End)
+		})
+		if index >= 0 {
+			existing = slices.Delete(existing, index, index+1)
+			continue
+		}
+
+		inserted++
+		if _, err := s.insertDestinationEvent(segment, dryRun); err != nil {
+			return inserted, 0, err
+		}
+	}
+
+	// Whatever is left over no longer matches a free part of the event
+	for _, event := range existing {
+		if err := s.deleteDestinationEvent(event, dryRun); err != nil {
+			return inserted, 0, err
+		}
+	}
+	return inserted, len(existing), nil
+}
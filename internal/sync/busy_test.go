@@ -0,0 +1,214 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestSubtractIntervals(t *testing.T) {
+	base := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time {
+		return base.Add(time.Duration(minutes) * time.Minute)
+	}
+	event := interval{start: at(0), end: at(120)}
+
+	tests := []struct {
+		name     string
+		busy     []interval
+		expected []interval
+	}{
+		{"nothing busy", nil, []interval{event}},
+		{"busy before", []interval{{at(-60), at(0)}}, []interval{event}},
+		{"busy after", []interval{{at(120), at(180)}}, []interval{event}},
+		{"busy in the middle", []interval{{at(30), at(60)}}, []interval{{at(0), at(30)}, {at(60), at(120)}}},
+		{"busy at the start", []interval{{at(-30), at(30)}}, []interval{{at(30), at(120)}}},
+		{"busy at the end", []interval{{at(90), at(150)}}, []interval{{at(0), at(90)}}},
+		{"fully covered", []interval{{at(-30), at(150)}}, []interval{}},
+		{"several meetings", []interval{{at(10), at(20)}, {at(40), at(50)}}, []interval{{at(0), at(10)}, {at(20), at(40)}, {at(50), at(120)}}},
+	}
+
+	for _, test := range tests {
+		free := subtractIntervals(event, test.busy)
+		if len(free) != len(test.expected) {
+			t.Errorf("%s: expected %d free intervals, got %d", test.name, len(test.expected), len(free))
+			continue
+		}
+		for i := range free {
+			if !free[i].start.Equal(test.expected[i].start) || !free[i].end.Equal(test.expected[i].end) {
+				t.Errorf("%s: unexpected interval %s - %s", test.name, free[i].start, free[i].end)
+			}
+		}
+	}
+}
+
+func TestMergeIntervals(t *testing.T) {
+	base := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	merged := mergeIntervals([]interval{
+		{base.Add(2 * time.Hour), base.Add(3 * time.Hour)},
+		{base, base.Add(time.Hour)},
+		{base.Add(30 * time.Minute), base.Add(90 * time.Minute)},
+		{base.Add(3 * time.Hour), base.Add(4 * time.Hour)},
+	})
+
+	if len(merged) != 2 {
+		t.Fatalf("Expected 2 merged intervals, got %d", len(merged))
+	}
+	if !merged[0].end.Equal(base.Add(90*time.Minute)) || !merged[1].start.Equal(base.Add(2*time.Hour)) || !merged[1].end.Equal(base.Add(4*time.Hour)) {
+		t.Errorf("Unexpected merged intervals %v", merged)
+	}
+}
+
+func TestOccupiesTime(t *testing.T) {
+	start := time.Now()
+	meeting := createTestEvent("1", "Standup", start, start.Add(time.Hour), nil)
+	block := createTestEvent("2", "Busy", start, start.Add(time.Hour), blockProperties("abc"))
+	free := createTestEvent("3", "Reminder", start, start.Add(time.Hour), nil)
+	free.Transparency = eventTransparencyTransparent
+	declined := createTestEvent("4", "Optional sync", start, start.Add(time.Hour), nil)
+	declined.Attendees = []*calendar.EventAttendee{{Self: true, ResponseStatus: "declined"}}
+	cancelled := createTestEvent("5", "Moved", start, start.Add(time.Hour), nil)
+	cancelled.Status = eventStatusCancelled
+
+	if !occupiesTime(meeting) {
+		t.Error("A meeting should make the user busy")
+	}
+	for _, event := range []*calendar.Event{block, free, declined, cancelled} {
+		if occupiesTime(event) {
+			t.Errorf("%s shouldn't make the user busy", event.Summary)
+		}
+	}
+}
+
+func newSkipBusyClient(sourceEvents []*calendar.Event, destinationEvents []*calendar.Event) (*SyncClient, *MockCalendarEventsService) {
+	mockDestinationService := &MockCalendarEventsService{events: destinationEvents, filterPrivateProperties: true}
+	return &SyncClient{
+		SourceCalendarService:      &MockCalendarEventsService{events: sourceEvents},
+		DestinationCalendarService: mockDestinationService,
+		Location:                   time.UTC,
+		SkipBusy:                   true,
+	}, mockDestinationService
+}
+
+func TestRunSyncSkipBusySplitsAroundMeetings(t *testing.T) {
+	start := time.Now().Add(time.Hour).Truncate(time.Minute).UTC()
+	syncClient, mockDestinationService := newSkipBusyClient(
+		[]*calendar.Event{createTestEvent("123", "Offsite", start, start.Add(3*time.Hour), nil)},
+		[]*calendar.Event{createTestEvent("meeting", "Planning", start.Add(time.Hour), start.Add(2*time.Hour), nil)},
+	)
+
+	err := syncClient.RunSync(30, false)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	inserted := mockDestinationService.insertedEvents
+	if len(inserted) != 2 {
+		t.Fatalf("Expected a block before and after the meeting, got %d", len(inserted))
+	}
+	if !sameEventTime(inserted[0].Start, eventDateTime(start, time.UTC)) || !sameEventTime(inserted[0].End, eventDateTime(start.Add(time.Hour), time.UTC)) {
+		t.Errorf("Unexpected first block %s - %s", inserted[0].Start.DateTime, inserted[0].End.DateTime)
+	}
+	if !sameEventTime(inserted[1].Start, eventDateTime(start.Add(2*time.Hour), time.UTC)) || !sameEventTime(inserted[1].End, eventDateTime(start.Add(3*time.Hour), time.UTC)) {
+		t.Errorf("Unexpected second block %s - %s", inserted[1].Start.DateTime, inserted[1].End.DateTime)
+	}
+	for _, event := range inserted {
+		if event.ExtendedProperties.Private[sourceEventIdPropertyKey] != "123" {
+			t.Error("Every part should be keyed by the source event")
+		}
+	}
+}
+
+func TestRunSyncSkipBusyFullyCovered(t *testing.T) {
+	start := time.Now().Add(time.Hour).Truncate(time.Minute).UTC()
+	syncClient, mockDestinationService := newSkipBusyClient(
+		[]*calendar.Event{createTestEvent("123", "Call", start, start.Add(time.Hour), nil)},
+		[]*calendar.Event{
+			createTestEvent("meeting", "All hands", start.Add(-time.Hour), start.Add(2*time.Hour), nil),
+			createTestEvent("abc", "Busy", start, start.Add(time.Hour), blockProperties("123")),
+		},
+	)
+
+	err := syncClient.RunSync(30, false)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if len(mockDestinationService.insertedEvents) != 0 {
+		t.Error("A fully covered event shouldn't be blocked")
+	}
+	if len(mockDestinationService.deletedEvents) != 1 || mockDestinationService.deletedEvents[0] != "abc" {
+		t.Errorf("Expected the now redundant block to be deleted, deleted %v", mockDestinationService.deletedEvents)
+	}
+}
+
+func TestRunSyncSkipBusyReexpands(t *testing.T) {
+	start := time.Now().Add(time.Hour).Truncate(time.Minute).UTC()
+	// Blocks from a previous run, split around a meeting that has since been removed
+	syncClient, mockDestinationService := newSkipBusyClient(
+		[]*calendar.Event{createTestEvent("123", "Offsite", start, start.Add(3*time.Hour), nil)},
+		[]*calendar.Event{
+			createTestEvent("before", "Busy", start, start.Add(time.Hour), blockProperties("123")),
+			createTestEvent("after", "Busy", start.Add(2*time.Hour), start.Add(3*time.Hour), blockProperties("123")),
+		},
+	)
+
+	err := syncClient.RunSync(30, false)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if len(mockDestinationService.insertedEvents) != 1 {
+		t.Fatalf("Expected a single block for the whole event, got %d", len(mockDestinationService.insertedEvents))
+	}
+	if !sameEventTime(mockDestinationService.insertedEvents[0].End, eventDateTime(start.Add(3*time.Hour), time.UTC)) {
+		t.Error("Expected the block to cover the whole event again")
+	}
+	if len(mockDestinationService.deletedEvents) != 2 {
+		t.Errorf("Expected both partial blocks to be replaced, deleted %v", mockDestinationService.deletedEvents)
+	}
+}
+
+func TestRunSyncSkipBusyKeepsCorrectBlocks(t *testing.T) {
+	start := time.Now().Add(time.Hour).Truncate(time.Minute).UTC()
+	syncClient, mockDestinationService := newSkipBusyClient(
+		[]*calendar.Event{createTestEvent("123", "Offsite", start, start.Add(3*time.Hour), nil)},
+		[]*calendar.Event{
+			createTestEvent("meeting", "Planning", start.Add(time.Hour), start.Add(2*time.Hour), nil),
+			createTestEvent("before", "Busy", start, start.Add(time.Hour), blockProperties("123")),
+			createTestEvent("after", "Busy", start.Add(2*time.Hour), start.Add(3*time.Hour), blockProperties("123")),
+		},
+	)
+
+	syncClient.RunSync(30, false)
+
+	if len(mockDestinationService.insertedEvents) != 0 || len(mockDestinationService.deletedEvents) != 0 {
+		t.Error("Blocks that are already correct shouldn't change")
+	}
+}
+
+func TestRunSyncSkipBusyIgnoresFreeTime(t *testing.T) {
+	start := time.Now().Add(time.Hour).Truncate(time.Minute).UTC()
+	free := createTestEvent("reminder", "Reminder", start, start.Add(time.Hour), nil)
+	free.Transparency = eventTransparencyTransparent
+	syncClient, mockDestinationService := newSkipBusyClient(
+		[]*calendar.Event{createTestEvent("123", "Call", start, start.Add(time.Hour), nil)},
+		[]*calendar.Event{free},
+	)
+
+	syncClient.RunSync(30, false)
+
+	if len(mockDestinationService.insertedEvents) != 1 {
+		t.Error("Events marked as free shouldn't prevent blocking")
+	}
+}
+
+func TestRunSyncSkipBusyWithMirrorRecurring(t *testing.T) {
+	syncClient, _ := newSkipBusyClient(nil, nil)
+	syncClient.MirrorRecurring = true
+
+	if err := syncClient.RunSync(30, false); err == nil {
+		t.Error("Expected an error when combined with mirroring recurring events")
+	}
+}
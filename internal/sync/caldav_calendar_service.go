@@ -0,0 +1,347 @@
+package sync
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/caldav"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/ics"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Private properties are stored on CalDAV events as X- properties named after
+// them, e.g. gcal-busy-blocker-source-event-id becomes
+// X-GCAL-BUSY-BLOCKER-SOURCE-EVENT-ID
+const caldavPropertyPrefix = "X-"
+
+// caldavCalendarService reads and writes a CalDAV calendar collection, such as
+// a Fastmail or Nextcloud calendar. Recurring events are expanded on the
+// client, with instances keyed like the iCalendar source.
+type caldavCalendarService struct {
+	client *caldav.Client
+	// floating is the zone for times the server doesn't give one
+	floating *time.Location
+	// objects remembers where each listed or created event is stored and its
+	// ETag, so it's only changed if nobody else changed it in between
+	objects map[string]*caldavObject
+	logger  *slog.Logger
+}
+
+type caldavObject struct {
+	href string
+	etag string
+	root *ics.Component
+}
+
+// NewCalDAVCalendarService returns a service for the calendar collection the
+// client points at. Times without a zone are read in loc, or the system zone
+// if it's nil. Events that can't be read are logged to logger, or
+// slog.Default() if it's nil.
+func NewCalDAVCalendarService(client *caldav.Client, loc *time.Location, logger *slog.Logger) CalendarEventsService {
+	if loc == nil {
+		loc = time.Local
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &caldavCalendarService{client: client, floating: loc, objects: map[string]*caldavObject{}, logger: logger}
+}
+
+func (c *caldavCalendarService) List(calendarId string, startTime time.Time, endTime time.Time, privateProperties map[string]string) ([]*calendar.Event, error) {
+	objects, err := c.client.Query(startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*calendar.Event{}
+	for _, object := range objects {
+		root, err := ics.Parse(strings.NewReader(object.Data))
+		if err != nil {
+			c.logger.Warn("Skipping calendar object", "href", object.Href, "error", err)
+			continue
+		}
+		feed, err := ics.NewCalendar(root, c.floating)
+		if err != nil {
+			c.logger.Warn("Skipping calendar object", "href", object.Href, "error", err)
+			continue
+		}
+		for _, warning := range feed.Warnings {
+			c.logger.Warn("Problem reading calendar object", "href", object.Href, "detail", warning)
+		}
+
+		instances := feed.Events
+		// Expanding needs both ends of the window. Listing without them is only
+		// done to find our own blocks, which never recur.
+		if !startTime.IsZero() && !endTime.IsZero() {
+			instances = feed.Expand(startTime, endTime, startTime.Location())
+		}
+		for _, instance := range instances {
+			if instance.Status == ics.StatusCancelled || instance.Transparent {
+				continue
+			}
+			event := icsEvent(instance)
+			event.ExtendedProperties = caldavPrivateProperties(instance)
+			if !hasPrivateProperties(event, privateProperties) {
+				continue
+			}
+			if instance.RecurrenceID.IsZero() {
+				c.objects[event.Id] = &caldavObject{href: object.Href, etag: object.ETag, root: root}
+			}
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// Insert creates an event, using its ID as the UID when it has one. An object
+// with that UID is already there when an earlier attempt's response was lost,
+// and is replaced unless it isn't a block or is from a newer schema.
+func (c *caldavCalendarService) Insert(calendarId string, event *calendar.Event) (*calendar.Event, error) {
+	uid := event.Id
+	if uid == "" {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		uid = hex.EncodeToString(random)
+	}
+
+	vevent := &ics.Component{
+		Name: "VEVENT",
+		Properties: []*ics.Property{
+			{Name: "UID", Value: uid},
+			{Name: "TRANSP", Value: "OPAQUE"},
+		},
+	}
+	root := &ics.Component{
+		Name: "VCALENDAR",
+		Properties: []*ics.Property{
+			{Name: "VERSION", Value: "2.0"},
+			{Name: "PRODID", Value: "-//gcal-busy-blocker//busy blocks//EN"},
+		},
+		Components: []*ics.Component{vevent},
+	}
+	if err := applyCalDAVPatch(vevent, event); err != nil {
+		return nil, err
+	}
+
+	object := &caldavObject{href: c.client.Href(uid + ".ics"), root: root}
+	err := c.put(object)
+	var statusErr *caldav.StatusError
+	if event.Id != "" && errors.As(err, &statusErr) && statusErr.PreconditionFailed() {
+		existing, getErr := c.client.Get(object.href)
+		if getErr != nil {
+			return nil, err
+		}
+		if err := c.checkReplaceable(existing, uid); err != nil {
+			return nil, err
+		}
+		object.etag = existing.ETag
+		err = c.put(object)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.objects[uid] = object
+
+	inserted := *event
+	inserted.Id = uid
+	return &inserted, nil
+}
+
+func (c *caldavCalendarService) Patch(calendarId string, eventId string, patch *calendar.Event) (*calendar.Event, error) {
+	object, err := c.object(eventId)
+	if err != nil {
+		return nil, err
+	}
+
+	vevent := object.master(eventId)
+	if vevent == nil {
+		return nil, fmt.Errorf("event %s not found in %s", eventId, object.href)
+	}
+	if err := applyCalDAVPatch(vevent, patch); err != nil {
+		return nil, err
+	}
+	if err := c.put(object); err != nil {
+		return nil, err
+	}
+
+	feed, err := ics.NewCalendar(object.root, c.floating)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range feed.Events {
+		if event.UID == eventId && event.RecurrenceID.IsZero() {
+			patched := icsEvent(event)
+			patched.ExtendedProperties = caldavPrivateProperties(event)
+			return patched, nil
+		}
+	}
+	return nil, fmt.Errorf("event %s not found in %s", eventId, object.href)
+}
+
+// Blocks are written without the colour rules give them.
+func (c *caldavCalendarService) dropsColors() bool {
+	return true
+}
+
+func (c *caldavCalendarService) Delete(calendarId string, eventId string) error {
+	object, err := c.object(eventId)
+	if err != nil {
+		return err
+	}
+	if err := c.client.Delete(object.href, object.etag); err != nil {
+		return caldavError(err)
+	}
+	delete(c.objects, eventId)
+	return nil
+}
+
+// checkReplaceable refuses to replace a stored event that isn't a block, or
+// is a block written by a newer version of the app.
+func (c *caldavCalendarService) checkReplaceable(existing *caldav.Object, uid string) error {
+	root, err := ics.Parse(strings.NewReader(existing.Data))
+	if err != nil {
+		return fmt.Errorf("unable to parse %s: %v", existing.Href, err)
+	}
+	feed, err := ics.NewCalendar(root, c.floating)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %v", existing.Href, err)
+	}
+	for _, instance := range feed.Events {
+		if instance.UID != uid || !instance.RecurrenceID.IsZero() {
+			continue
+		}
+		event := icsEvent(instance)
+		event.ExtendedProperties = caldavPrivateProperties(instance)
+		if !isBusyBlock(event) {
+			return fmt.Errorf("event %s already exists and isn't a block", uid)
+		}
+		_, err := checkSchema([]*calendar.Event{event})
+		return err
+	}
+	return fmt.Errorf("event %s already exists in %s and isn't a block", uid, existing.Href)
+}
+
+// object returns where an event is stored. Events that weren't listed, such
+// as blocks the local state knows of, are fetched from the file their UID
+// names, which is where Insert puts them.
+func (c *caldavCalendarService) object(eventId string) (*caldavObject, error) {
+	if object, ok := c.objects[eventId]; ok {
+		return object, nil
+	}
+	fetched, err := c.client.Get(c.client.Href(eventId + ".ics"))
+	if err != nil {
+		return nil, err
+	}
+	root, err := ics.Parse(strings.NewReader(fetched.Data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", fetched.Href, err)
+	}
+	object := &caldavObject{href: fetched.Href, etag: fetched.ETag, root: root}
+	if object.master(eventId) == nil {
+		return nil, fmt.Errorf("event %s not found in %s", eventId, fetched.Href)
+	}
+	c.objects[eventId] = object
+	return object, nil
+}
+
+// put stores an object, only replacing it if it still has the ETag it was
+// read with.
+func (c *caldavCalendarService) put(object *caldavObject) error {
+	var data bytes.Buffer
+	if err := ics.Encode(&data, object.root); err != nil {
+		return err
+	}
+	etag, err := c.client.Put(object.href, data.String(), object.etag)
+	if err != nil {
+		return caldavError(err)
+	}
+	object.etag = etag
+	return nil
+}
+
+// master returns the VEVENT for an event, leaving out overridden instances.
+func (o *caldavObject) master(uid string) *ics.Component {
+	for _, component := range o.root.Components {
+		if component.Name == "VEVENT" && component.PropertyValue("UID") == uid && component.Property("RECURRENCE-ID") == nil {
+			return component
+		}
+	}
+	return nil
+}
+
+func caldavError(err error) error {
+	var statusErr *caldav.StatusError
+	if errors.As(err, &statusErr) && statusErr.PreconditionFailed() {
+		return fmt.Errorf("%s was changed on the server since it was read, run the sync again: %w", statusErr.Href, err)
+	}
+	return err
+}
+
+// applyCalDAVPatch sets the fields of a calendar event on a VEVENT, leaving
+// the properties it doesn't mention as they are.
+func applyCalDAVPatch(vevent *ics.Component, event *calendar.Event) error {
+	vevent.SetProperty(&ics.Property{Name: "DTSTAMP", Value: ics.FormatDateTime(time.Now())})
+	if event.Summary != "" {
+		vevent.SetProperty(&ics.Property{Name: "SUMMARY", Value: ics.EscapeText(event.Summary)})
+	}
+	if event.Description != "" {
+		vevent.SetProperty(&ics.Property{Name: "DESCRIPTION", Value: ics.EscapeText(event.Description)})
+	}
+	if event.Start != nil {
+		start, err := caldavDateTime("DTSTART", event.Start)
+		if err != nil {
+			return err
+		}
+		vevent.SetProperty(start)
+	}
+	if event.End != nil {
+		end, err := caldavDateTime("DTEND", event.End)
+		if err != nil {
+			return err
+		}
+		vevent.SetProperty(end)
+		vevent.RemoveProperty("DURATION")
+	}
+	if event.ExtendedProperties != nil {
+		for key, value := range event.ExtendedProperties.Private {
+			vevent.SetProperty(&ics.Property{Name: caldavPropertyPrefix + strings.ToUpper(key), Value: ics.EscapeText(value)})
+		}
+	}
+	return nil
+}
+
+func caldavDateTime(name string, eventTime *calendar.EventDateTime) (*ics.Property, error) {
+	if eventTime.DateTime == "" {
+		date, err := time.Parse(time.DateOnly, eventTime.Date)
+		if err != nil {
+			return nil, err
+		}
+		return &ics.Property{Name: name, Params: map[string]string{"VALUE": "DATE"}, Value: ics.FormatDate(date)}, nil
+	}
+	t, err := time.Parse(time.RFC3339, eventTime.DateTime)
+	if err != nil {
+		return nil, err
+	}
+	return &ics.Property{Name: name, Value: ics.FormatDateTime(t)}, nil
+}
+
+// caldavPrivateProperties reads back the private properties stored as X-
+// properties by applyCalDAVPatch.
+func caldavPrivateProperties(event *ics.Event) *calendar.EventExtendedProperties {
+	private := map[string]string{}
+	for name, value := range event.XProperties {
+		key := strings.ToLower(strings.TrimPrefix(name, caldavPropertyPrefix))
+		if strings.HasPrefix(key, appName) {
+			private[key] = value
+		}
+	}
+	return &calendar.EventExtendedProperties{Private: private}
+}
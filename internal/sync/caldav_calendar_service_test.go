@@ -0,0 +1,240 @@
+package sync
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/caldav"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/caldav/caldavtest"
+	"google.golang.org/api/calendar/v3"
+)
+
+func newTestCalDAVService(t *testing.T) (*caldavtest.Server, *caldavCalendarService) {
+	t.Helper()
+	server := caldavtest.NewServer()
+	t.Cleanup(server.Close)
+	client, err := caldav.NewClient(http.DefaultClient, server.CalendarURL())
+	if err != nil {
+		t.Fatalf("unable to create CalDAV client: %v", err)
+	}
+	return server, NewCalDAVCalendarService(client, time.UTC, nil).(*caldavCalendarService)
+}
+
+func TestCalDAVCalendarServiceList(t *testing.T) {
+	server, service := newTestCalDAVService(t)
+	server.AddObject("standup.ics", "BEGIN:VCALENDAR\r\n"+
+		"BEGIN:VEVENT\r\n"+
+		"UID:standup\r\n"+
+		"DTSTART;TZID=Europe/Berlin:20260302T093000\r\n"+
+		"DTEND;TZID=Europe/Berlin:20260302T094500\r\n"+
+		"RRULE:FREQ=DAILY;COUNT=3\r\n"+
+		"SUMMARY:Standup\r\n"+
+		"END:VEVENT\r\n"+
+		"END:VCALENDAR\r\n")
+	server.AddObject("free.ics", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:free\r\nDTSTART:20260302T120000Z\r\nTRANSP:TRANSPARENT\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+
+	events, err := service.List(defaultCalendar, time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC), nil)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	ids := []string{}
+	for _, event := range events {
+		ids = append(ids, event.Id)
+	}
+	if strings.Join(ids, ",") != "standup_20260303T083000Z,standup_20260304T083000Z" {
+		t.Errorf("Expected the instances in the window, got %v", ids)
+	}
+	if events[0].Summary != "Standup" || events[0].Start.DateTime != "2026-03-03T09:30:00+01:00" {
+		t.Errorf("Unexpected event %+v", events[0])
+	}
+}
+
+func TestCalDAVCalendarServiceInsertPatchDelete(t *testing.T) {
+	server, service := newTestCalDAVService(t)
+	sourceEvent := createTestEvent("source-1", "Doctor", time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC), time.Date(2026, 3, 10, 10, 0, 0, 0, time.UTC), nil)
+
+	inserted, err := service.Insert(defaultCalendar, createDestinationEvent(sourceEvent, time.UTC))
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	data, ok := server.Object(inserted.Id + ".ics")
+	if !ok {
+		t.Fatalf("Expected the block to be stored as %s.ics, got %v", inserted.Id, server.Objects())
+	}
+	for _, line := range []string{"X-GCAL-BUSY-BLOCKER:true", "X-GCAL-BUSY-BLOCKER-SOURCE-EVENT-ID:source-1", "DTSTART:20260310T090000Z", "SUMMARY:Busy"} {
+		if !strings.Contains(data, line+"\r\n") {
+			t.Errorf("Expected %q in the stored event:\n%s", line, data)
+		}
+	}
+
+	// A fresh service finds the block by its properties, like the next sync run
+	service2 := NewCalDAVCalendarService(service.client, time.UTC, nil)
+	blocks, err := service2.List(defaultCalendar, time.Time{}, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), map[string]string{appName: propertyAppNameValue})
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(blocks) != 1 || blocks[0].Id != inserted.Id || blocks[0].ExtendedProperties.Private[sourceEventIdPropertyKey] != "source-1" {
+		t.Fatalf("Expected to list the block back, got %+v", blocks)
+	}
+
+	patched, err := service2.Patch(defaultCalendar, inserted.Id, &calendar.Event{
+		Start: &calendar.EventDateTime{DateTime: "2026-03-10T11:00:00Z"},
+		End:   &calendar.EventDateTime{DateTime: "2026-03-10T12:00:00Z"},
+	})
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if patched.Start.DateTime != "2026-03-10T11:00:00Z" || patched.Summary != "Busy" || patched.ExtendedProperties.Private[appName] != propertyAppNameValue {
+		t.Errorf("Unexpected patched event %+v", patched)
+	}
+
+	if err := service2.Delete(defaultCalendar, inserted.Id); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(server.Objects()) != 0 {
+		t.Errorf("Expected the block to be deleted, got %v", server.Objects())
+	}
+}
+
+func TestCalDAVCalendarServiceInsertRetry(t *testing.T) {
+	server, service := newTestCalDAVService(t)
+	sourceEvent := createTestEvent("source-1", "Doctor", time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC), time.Date(2026, 3, 10, 10, 0, 0, 0, time.UTC), nil)
+	block := createDestinationEvent(sourceEvent, time.UTC)
+	block.Id = "0123456789abcdef"
+
+	if _, err := service.Insert(defaultCalendar, block); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	// Inserting the same block again, as after a lost response, replaces it
+	block.Start = &calendar.EventDateTime{DateTime: "2026-03-10T11:00:00Z"}
+	block.End = &calendar.EventDateTime{DateTime: "2026-03-10T12:00:00Z"}
+	inserted, err := NewCalDAVCalendarService(service.client, time.UTC, nil).Insert(defaultCalendar, block)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if inserted.Id != "0123456789abcdef" || len(server.Objects()) != 1 {
+		t.Fatalf("Expected a single block with the given ID, got %s and %v", inserted.Id, server.Objects())
+	}
+	if data, _ := server.Object("0123456789abcdef.ics"); !strings.Contains(data, "DTSTART:20260310T110000Z\r\n") {
+		t.Errorf("Expected the block to be replaced, got:\n%s", data)
+	}
+}
+
+func TestCalDAVCalendarServiceInsertRetryRefusesOthers(t *testing.T) {
+	server, service := newTestCalDAVService(t)
+	server.AddObject("meeting.ics", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:meeting\r\nDTSTART:20260310T090000Z\r\nSUMMARY:Planning\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+	server.AddObject("future.ics", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:future\r\nDTSTART:20260310T090000Z\r\nSUMMARY:Busy\r\n"+
+		"X-GCAL-BUSY-BLOCKER:true\r\nX-GCAL-BUSY-BLOCKER-SCHEMA:"+strconv.Itoa(currentSchema()+1)+"\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+	sourceEvent := createTestEvent("source-1", "Doctor", time.Date(2026, 3, 10, 11, 0, 0, 0, time.UTC), time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC), nil)
+
+	for _, id := range []string{"meeting", "future"} {
+		block := createDestinationEvent(sourceEvent, time.UTC)
+		block.Id = id
+		if _, err := service.Insert(defaultCalendar, block); err == nil {
+			t.Errorf("Expected %s not to be replaced", id)
+		}
+		if data, _ := server.Object(id + ".ics"); !strings.Contains(data, "DTSTART:20260310T090000Z\r\n") {
+			t.Errorf("Expected %s to be left alone, got:\n%s", id, data)
+		}
+	}
+}
+
+func TestCalDAVCalendarServiceConcurrentChange(t *testing.T) {
+	server, service := newTestCalDAVService(t)
+	server.AddObject("dentist.ics", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:dentist\r\nDTSTART:20260310T090000Z\r\nDTEND:20260310T100000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+	if _, err := service.List(defaultCalendar, time.Time{}, time.Time{}, nil); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	// Someone else edits the event after it was listed
+	server.AddObject("dentist.ics", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:dentist\r\nDTSTART:20260310T140000Z\r\nDTEND:20260310T150000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+
+	if _, err := service.Patch(defaultCalendar, "dentist", &calendar.Event{Summary: "Moved"}); err == nil || !strings.Contains(err.Error(), "changed on the server") {
+		t.Errorf("Expected the stale ETag to be rejected, got %v", err)
+	}
+	if err := service.Delete(defaultCalendar, "dentist"); err == nil {
+		t.Error("Expected the stale ETag to be rejected")
+	}
+	if data, _ := server.Object("dentist.ics"); !strings.Contains(data, "T140000Z") {
+		t.Error("The other client's change was overwritten")
+	}
+}
+
+func TestCalDAVCalendarServicePatchUnknownEvent(t *testing.T) {
+	_, service := newTestCalDAVService(t)
+
+	if _, err := service.Patch(defaultCalendar, "missing", &calendar.Event{Summary: "Busy"}); err == nil {
+		t.Error("Expected an error for an event that wasn't listed")
+	}
+}
+
+func TestCalDAVDestinationSync(t *testing.T) {
+	server, destination := newTestCalDAVService(t)
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	source := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestEvent("1", "Therapy", start, start.Add(time.Hour), nil),
+		createTestEvent("2", "School run", start.Add(3*time.Hour), start.Add(4*time.Hour), nil),
+	}}
+	syncClient := &SyncClient{SourceCalendarService: source, DestinationCalendarService: destination, Location: time.UTC}
+
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(server.Objects()) != 2 {
+		t.Fatalf("Expected 2 blocks, got %d", len(server.Objects()))
+	}
+
+	source.events = source.events[:1]
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if objects := server.Objects(); len(objects) != 1 || !strings.Contains(objects[0], "SOURCE-EVENT-ID:1\r\n") {
+		t.Errorf("Expected only the block for event 1 to be left, got %v", objects)
+	}
+}
+
+func TestCalDAVDestinationSyncWithState(t *testing.T) {
+	server, destination := newTestCalDAVService(t)
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	source := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestEvent("1", "Therapy", start, start.Add(time.Hour), nil),
+		createTestEvent("2", "School run", start.Add(3*time.Hour), start.Add(4*time.Hour), nil),
+	}}
+	store := openTestState(t)
+	newClient := func(destination CalendarEventsService) *SyncClient {
+		return &SyncClient{
+			SourceCalendarService:      source,
+			DestinationCalendarService: destination,
+			Location:                   time.UTC,
+			State:                      store,
+			DestinationStateKey:        "caldav/" + server.CalendarURL(),
+		}
+	}
+
+	if err := newClient(destination).RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	// The next run is a new process, which never lists the blocks as the
+	// local state knows them
+	client, err := caldav.NewClient(http.DefaultClient, server.CalendarURL())
+	if err != nil {
+		t.Fatalf("unable to create CalDAV client: %v", err)
+	}
+	source.events[0].End.DateTime = start.Add(2 * time.Hour).Format(time.RFC3339)
+	source.events = source.events[:1]
+	if err := newClient(NewCalDAVCalendarService(client, time.UTC, nil)).RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	objects := server.Objects()
+	if len(objects) != 1 || !strings.Contains(objects[0], "SOURCE-EVENT-ID:1\r\n") {
+		t.Fatalf("Expected only the block for event 1 to be left, got %v", objects)
+	}
+	if !strings.Contains(objects[0], "DTEND:"+start.Add(2*time.Hour).Format("20060102T150405Z")) {
+		t.Errorf("Expected the block to be moved, got %s", objects[0])
+	}
+}
@@ -2,27 +2,79 @@ package sync
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"time"
 
 	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
 )
 
 type CalendarEventsService interface {
 	List(calendarId string, startTime time.Time, endTime time.Time, privateProperties map[string]string) ([]*calendar.Event, error)
 	Insert(calendarId string, event *calendar.Event) (*calendar.Event, error)
+	Patch(calendarId string, eventId string, event *calendar.Event) (*calendar.Event, error)
 	Delete(calendarId string, eventId string) error
 }
 
+// RecurringEventsService is implemented by calendars that can return recurring
+// events as a single series instead of one event per instance.
+type RecurringEventsService interface {
+	// ListSeries is like List, but returns each recurring event as its master
+	// event followed by any instances that were moved or cancelled.
+	ListSeries(calendarId string, startTime time.Time, endTime time.Time, privateProperties map[string]string) ([]*calendar.Event, error)
+	// Instance returns the instance of a recurring event originally scheduled at
+	// originalStartTime, or nil if it doesn't exist (e.g. it was cancelled).
+	Instance(calendarId string, eventId string, originalStartTime *calendar.EventDateTime) (*calendar.Event, error)
+}
+
+// EventLookupService is implemented by calendars that keep the IDs blocks are
+// inserted with, so a block can be looked up without listing the calendar.
+type EventLookupService interface {
+	// Get returns an event by ID. Deleted events may come back with the
+	// cancelled status.
+	Get(calendarId string, eventId string) (*calendar.Event, error)
+}
+
+// colorlessCalendar is implemented by calendars that can't store the colour
+// of an event, so the colour a rule gives a block is never read back.
+type colorlessCalendar interface {
+	dropsColors() bool
+}
+
 // implementation
 type calendarEventsService struct {
 	service *calendar.Service
 }
 
+// NewCalendarEventsService returns a Google Calendar backed service using an
+// authorized client.
+func NewCalendarEventsService(client *http.Client) (CalendarEventsService, error) {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	return &calendarEventsService{service: service}, nil
+}
+
 func (c *calendarEventsService) List(calendarId string, startTime time.Time, endTime time.Time, privateProperties map[string]string) ([]*calendar.Event, error) {
+	return c.list(calendarId, startTime, endTime, privateProperties, true)
+}
+
+func (c *calendarEventsService) ListSeries(calendarId string, startTime time.Time, endTime time.Time, privateProperties map[string]string) ([]*calendar.Event, error) {
+	return c.list(calendarId, startTime, endTime, privateProperties, false)
+}
+
+func (c *calendarEventsService) list(calendarId string, startTime time.Time, endTime time.Time, privateProperties map[string]string, singleEvents bool) ([]*calendar.Event, error) {
 	eventListCall := c.service.Events.List(calendarId).
-		SingleEvents(true).
-		OrderBy("startTime")
+		SingleEvents(singleEvents)
+
+	// Ordering by start time is only supported for expanded instances
+	if singleEvents {
+		eventListCall = eventListCall.OrderBy("startTime")
+	}
 
 	if !startTime.IsZero() {
 		eventListCall = eventListCall.TimeMin(startTime.Format(time.RFC3339))
@@ -47,10 +99,68 @@ func (c *calendarEventsService) List(calendarId string, startTime time.Time, end
 	}
 	return allEvents, nil
 }
+
+func (c *calendarEventsService) Instance(calendarId string, eventId string, originalStartTime *calendar.EventDateTime) (*calendar.Event, error) {
+	originalStart := originalStartTime.DateTime
+	if originalStart == "" {
+		originalStart = originalStartTime.Date
+	}
+
+	instances, err := c.service.Events.Instances(calendarId, eventId).OriginalStart(originalStart).Do()
+	if err != nil {
+		return nil, err
+	}
+	if len(instances.Items) == 0 {
+		return nil, nil
+	}
+	return instances.Items[0], nil
+}
+
+func (c *calendarEventsService) Get(calendarId string, eventId string) (*calendar.Event, error) {
+	return c.service.Events.Get(calendarId, eventId).Do()
+}
+
+// Insert creates an event. An event given an ID that's already taken is
+// overwritten if it's a block, as it was created by an earlier attempt whose
+// response was lost, or it's a block that was deleted since, which Google
+// keeps the ID of. Blocks from a newer schema are left alone.
 func (c *calendarEventsService) Insert(calendarId string, event *calendar.Event) (*calendar.Event, error) {
-	return c.service.Events.Insert(calendarId, event).Do()
+	inserted, err := c.service.Events.Insert(calendarId, event).Do()
+	var apiErr *googleapi.Error
+	if event.Id == "" || !errors.As(err, &apiErr) || apiErr.Code != http.StatusConflict {
+		return inserted, err
+	}
+
+	existing, getErr := c.Get(calendarId, event.Id)
+	if getErr != nil {
+		return nil, err
+	}
+	if existing.Status != eventStatusCancelled && !isBusyBlock(existing) {
+		return nil, fmt.Errorf("event %s already exists and isn't a block: %v", event.Id, err)
+	}
+	if _, err := checkSchema([]*calendar.Event{existing}); err != nil {
+		return nil, err
+	}
+	revived := *event
+	revived.Status = "confirmed"
+	return c.service.Events.Update(calendarId, event.Id, &revived).Do()
+}
+
+func (c *calendarEventsService) Patch(calendarId string, eventId string, event *calendar.Event) (*calendar.Event, error) {
+	return c.service.Events.Patch(calendarId, eventId, event).Do()
 }
 
 func (c *calendarEventsService) Delete(calendarId string, eventId string) error {
 	return c.service.Events.Delete(calendarId, eventId).Do()
 }
+
+// hasPrivateProperties checks an event has all of the given private
+// properties, for calendars that filter them on the client side.
+func hasPrivateProperties(event *calendar.Event, privateProperties map[string]string) bool {
+	for key, value := range privateProperties {
+		if event.ExtendedProperties == nil || event.ExtendedProperties.Private[key] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// CalendarListService is implemented by accounts that hold several calendars
+// and can list them.
+type CalendarListService interface {
+	ListCalendars() ([]*calendar.CalendarListEntry, error)
+}
+
+// Access roles that allow creating events on a calendar
+var writableAccessRoles = []string{"owner", "writer"}
+
+func (c *calendarEventsService) ListCalendars() ([]*calendar.CalendarListEntry, error) {
+	entries := []*calendar.CalendarListEntry{}
+	err := c.service.CalendarList.List().Pages(context.Background(), func(list *calendar.CalendarList) error {
+		entries = append(entries, list.Items...)
+		return nil
+	})
+	if err != nil {
+		return nil, calendarListError(err)
+	}
+	return entries, nil
+}
+
+// CalendarName returns the name the account's owner sees for a calendar.
+func CalendarName(entry *calendar.CalendarListEntry) string {
+	if entry.SummaryOverride != "" {
+		return entry.SummaryOverride
+	}
+	return entry.Summary
+}
+
+// ResolveCalendarId turns a calendar ID or display name into the calendar's
+// ID. Names are matched ignoring case and must be unique. Accounts that can't
+// list their calendars take the value as an ID.
+func ResolveCalendarId(service CalendarEventsService, idOrName string) (string, error) {
+	if idOrName == "" || idOrName == defaultCalendar {
+		return defaultCalendar, nil
+	}
+	lister, ok := service.(CalendarListService)
+	if !ok {
+		return idOrName, nil
+	}
+	entries, err := lister.ListCalendars()
+	if err != nil {
+		return "", err
+	}
+
+	matches := []string{}
+	for _, entry := range entries {
+		if entry.Id == idOrName {
+			return entry.Id, nil
+		}
+		if strings.EqualFold(CalendarName(entry), idOrName) {
+			matches = append(matches, entry.Id)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no calendar with the ID or name %q, see 'calendars list'", idOrName)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("more than one calendar is named %q, use one of their IDs instead: %s", idOrName, strings.Join(matches, ", "))
+	}
+}
+
+// checkDestinationAccess makes sure blocks can be written to the destination
+// calendar before anything is synced. Accounts that can't list their
+// calendars are trusted to be writable.
+func (s *SyncClient) checkDestinationAccess() error {
+	lister, ok := s.DestinationCalendarService.(CalendarListService)
+	if !ok {
+		return nil
+	}
+	end := s.traceCall(lister, "ListCalendars", "", "")
+	entries, err := lister.ListCalendars()
+	end(err)
+	if errors.Is(err, errCalendarListScope) {
+		s.logger().Warn("Unable to check access to the destination calendar", "error", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to list destination calendars: %v", err)
+	}
+
+	calendarId := s.destinationCalendar()
+	i := slices.IndexFunc(entries, func(entry *calendar.CalendarListEntry) bool {
+		return entry.Id == calendarId || calendarId == defaultCalendar && entry.Primary
+	})
+	if i < 0 {
+		return fmt.Errorf("destination calendar %s isn't in the destination account's calendar list", calendarId)
+	}
+	if !slices.Contains(writableAccessRoles, entries[i].AccessRole) {
+		return fmt.Errorf("destination calendar %q only grants %s access, blocks need writer access", CalendarName(entries[i]), entries[i].AccessRole)
+	}
+	return nil
+}
+
+// errCalendarListScope is returned for tokens authorized before listing
+// calendars was asked for.
+var errCalendarListScope = errors.New("the account wasn't authorized to list calendars, run the login command again")
+
+func calendarListError(err error) error {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden {
+		if strings.Contains(apiErr.Message, "insufficient authentication scopes") {
+			return fmt.Errorf("%w: %v", errCalendarListScope, err)
+		}
+		for _, item := range apiErr.Errors {
+			if item.Reason == "insufficientPermissions" {
+				return fmt.Errorf("%w: %v", errCalendarListScope, err)
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,163 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+var testCalendarList = []*calendar.CalendarListEntry{
+	{Id: "me@example.com", Summary: "me@example.com", AccessRole: "owner", Primary: true},
+	{Id: "family123@group.calendar.google.com", Summary: "Family", AccessRole: "writer"},
+	{Id: "holidays@group.v.calendar.google.com", Summary: "Holidays in United States", SummaryOverride: "Holidays", AccessRole: "reader"},
+	{Id: "club1@group.calendar.google.com", Summary: "Club", AccessRole: "reader"},
+	{Id: "club2@group.calendar.google.com", Summary: "club", AccessRole: "reader"},
+}
+
+// newTestCalendarListService serves the calendar list in pages of two.
+func newTestCalendarListService(t *testing.T, entries []*calendar.CalendarListEntry) *calendarEventsService {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/calendar/v3/users/me/calendarList" {
+			http.NotFound(w, r)
+			return
+		}
+		start := 0
+		if token := r.URL.Query().Get("pageToken"); token != "" {
+			json.Unmarshal([]byte(token), &start)
+		}
+		page := &calendar.CalendarList{Items: entries[start:min(start+2, len(entries))]}
+		if start+2 < len(entries) {
+			next, _ := json.Marshal(start + 2)
+			page.NextPageToken = string(next)
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	t.Cleanup(server.Close)
+
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(server.Client()), option.WithEndpoint(server.URL+"/calendar/v3/"))
+	if err != nil {
+		t.Fatalf("unable to create calendar service: %v", err)
+	}
+	return &calendarEventsService{service: service}
+}
+
+func TestListCalendars(t *testing.T) {
+	entries, err := newTestCalendarListService(t, testCalendarList).ListCalendars()
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(entries) != len(testCalendarList) {
+		t.Errorf("Expected every page of the calendar list, got %d calendars", len(entries))
+	}
+}
+
+func TestResolveCalendarId(t *testing.T) {
+	service := newTestCalendarListService(t, testCalendarList)
+
+	tests := []struct {
+		idOrName string
+		want     string
+	}{
+		{"", defaultCalendar},
+		{defaultCalendar, defaultCalendar},
+		{"family123@group.calendar.google.com", "family123@group.calendar.google.com"},
+		{"family", "family123@group.calendar.google.com"},
+		{"Holidays", "holidays@group.v.calendar.google.com"},
+	}
+	for _, test := range tests {
+		got, err := ResolveCalendarId(service, test.idOrName)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.idOrName, err)
+		} else if got != test.want {
+			t.Errorf("%q: expected %s, got %s", test.idOrName, test.want, got)
+		}
+	}
+
+	if _, err := ResolveCalendarId(service, "Club"); err == nil || !strings.Contains(err.Error(), "club2@group.calendar.google.com") {
+		t.Errorf("Expected an error listing the calendars sharing a name, got %v", err)
+	}
+	if _, err := ResolveCalendarId(service, "Work"); err == nil {
+		t.Error("Expected an error for a calendar that doesn't exist")
+	}
+
+	// Calendars that can't be listed are given by ID
+	got, err := ResolveCalendarId(&MockCalendarEventsService{}, "Family")
+	if err != nil || got != "Family" {
+		t.Errorf("Expected the value to be used as an ID, got %q (%v)", got, err)
+	}
+}
+
+func TestCheckDestinationAccess(t *testing.T) {
+	service := newTestCalendarListService(t, testCalendarList)
+
+	tests := []struct {
+		calendarId string
+		ok         bool
+	}{
+		{"", true},
+		{"family123@group.calendar.google.com", true},
+		{"holidays@group.v.calendar.google.com", false},
+		{"someone-else@example.com", false},
+	}
+	for _, test := range tests {
+		syncClient := &SyncClient{DestinationCalendarService: service, DestinationCalendarId: test.calendarId}
+		if err := syncClient.checkDestinationAccess(); (err == nil) != test.ok {
+			t.Errorf("%q: expected ok = %v, got %v", test.calendarId, test.ok, err)
+		}
+	}
+}
+
+func TestCheckDestinationAccessWithoutScope(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error": {"code": 403, "message": "Request had insufficient authentication scopes.", "errors": [{"reason": "insufficientPermissions"}]}}`))
+	}))
+	t.Cleanup(server.Close)
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(server.Client()), option.WithEndpoint(server.URL+"/calendar/v3/"))
+	if err != nil {
+		t.Fatalf("unable to create calendar service: %v", err)
+	}
+
+	// Tokens from before calendars could be chosen still sync
+	syncClient := &SyncClient{DestinationCalendarService: &calendarEventsService{service: service}}
+	if err := syncClient.checkDestinationAccess(); err != nil {
+		t.Errorf("Expected a missing scope to be skipped, got %v", err)
+	}
+}
+
+func TestRunSyncConfiguredCalendars(t *testing.T) {
+	source := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestEvent("123", "Dentist", time.Now(), time.Now().Add(time.Hour), nil),
+	}}
+	destination := &MockCalendarEventsService{}
+	syncClient := &SyncClient{
+		SourceCalendarService:      source,
+		DestinationCalendarService: destination,
+		SourceCalendarId:           "family123@group.calendar.google.com",
+		DestinationCalendarId:      "busy@group.calendar.google.com",
+	}
+
+	if err := syncClient.RunSync(30, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if source.listCalls[0].calendarId != "family123@group.calendar.google.com" {
+		t.Errorf("Expected the source calendar to be listed, got %s", source.listCalls[0].calendarId)
+	}
+	if destination.listCalls[0].calendarId != "busy@group.calendar.google.com" {
+		t.Errorf("Expected the destination calendar to be listed, got %s", destination.listCalls[0].calendarId)
+	}
+
+	reversed := syncClient.reversed()
+	if reversed.sourceCalendar() != "busy@group.calendar.google.com" || reversed.destinationCalendar() != "family123@group.calendar.google.com" {
+		t.Error("Expected two-way sync to swap the calendars")
+	}
+}
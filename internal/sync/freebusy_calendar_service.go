@@ -0,0 +1,157 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// FreeBusy rejects queries over long time ranges, so longer windows are split
+const freeBusyMaxRange = 28 * 24 * time.Hour
+
+// freeBusyLookback is how far before the day of the sync busy times are
+// queried from, so intervals under way are seen whole unless they're longer
+const freeBusyLookback = 24 * time.Hour
+
+var errFreeBusyReadOnly = errors.New("free/busy calendars are read-only")
+
+// freeBusyCalendarService reads calendars that are only shared as free/busy.
+// Each busy interval, merged across all of the calendars, is listed as an
+// event whose ID is derived from its start and end. When an interval shifts
+// it gets a new ID, so its old block is removed and a new one created.
+type freeBusyCalendarService struct {
+	service     *calendar.Service
+	calendarIds []string
+}
+
+// NewFreeBusyCalendarService returns a read-only source listing the busy
+// times of the given calendars.
+func NewFreeBusyCalendarService(client *http.Client, calendarIds []string) (CalendarEventsService, error) {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	return &freeBusyCalendarService{service: service, calendarIds: calendarIds}, nil
+}
+
+func (c *freeBusyCalendarService) List(calendarId string, startTime time.Time, endTime time.Time, privateProperties map[string]string) ([]*calendar.Event, error) {
+	// Busy intervals have no properties, so they never match a property filter
+	if len(privateProperties) > 0 {
+		return []*calendar.Event{}, nil
+	}
+	if startTime.IsZero() || endTime.IsZero() {
+		return nil, errors.New("free/busy calendars can only be listed for a bounded time range")
+	}
+
+	calendarIds := c.calendarIds
+	if len(calendarIds) == 0 {
+		calendarIds = []string{calendarId}
+	}
+	items := []*calendar.FreeBusyRequestItem{}
+	for _, id := range calendarIds {
+		items = append(items, &calendar.FreeBusyRequestItem{Id: id})
+	}
+
+	// FreeBusy cuts busy periods off at the start of the query, which would
+	// move an interval that's under way on every run. Querying from a fixed
+	// time before the day of the sync keeps it where it is.
+	year, month, day := startTime.Date()
+	rangeStart := time.Date(year, month, day, 0, 0, 0, 0, startTime.Location()).Add(-freeBusyLookback)
+
+	periods := []*calendar.TimePeriod{}
+	for queryStart := rangeStart; queryStart.Before(endTime); queryStart = queryStart.Add(freeBusyMaxRange) {
+		queryEnd := queryStart.Add(freeBusyMaxRange)
+		if queryEnd.After(endTime) {
+			queryEnd = endTime
+		}
+
+		response, err := c.service.Freebusy.Query(&calendar.FreeBusyRequest{
+			TimeMin: queryStart.Format(time.RFC3339),
+			TimeMax: queryEnd.Format(time.RFC3339),
+			Items:   items,
+		}).Do()
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden {
+			return nil, fmt.Errorf("the source account can't read free/busy information, run 'login source' again to allow it: %v", err)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range calendarIds {
+			busy, ok := response.Calendars[id]
+			if !ok {
+				return nil, fmt.Errorf("no free/busy information returned for calendar %s", id)
+			}
+			if len(busy.Errors) > 0 {
+				return nil, fmt.Errorf("unable to read free/busy information for calendar %s: %s", id, busy.Errors[0].Reason)
+			}
+			periods = append(periods, busy.Busy...)
+		}
+	}
+	return busyPeriodEvents(periods, rangeStart, startTime)
+}
+
+func (c *freeBusyCalendarService) Insert(calendarId string, event *calendar.Event) (*calendar.Event, error) {
+	return nil, errFreeBusyReadOnly
+}
+
+func (c *freeBusyCalendarService) Patch(calendarId string, eventId string, event *calendar.Event) (*calendar.Event, error) {
+	return nil, errFreeBusyReadOnly
+}
+
+func (c *freeBusyCalendarService) Delete(calendarId string, eventId string) error {
+	return errFreeBusyReadOnly
+}
+
+// busyPeriodEvents merges overlapping busy periods and turns each of them into
+// an event keyed by its interval. Intervals that are over by since are left
+// out. The ones that were cut off at rangeStart don't show where they really
+// start, so they're keyed by their end alone.
+func busyPeriodEvents(periods []*calendar.TimePeriod, rangeStart time.Time, since time.Time) ([]*calendar.Event, error) {
+	intervals := []interval{}
+	for _, period := range periods {
+		start, err := time.Parse(time.RFC3339, period.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := time.Parse(time.RFC3339, period.End)
+		if err != nil {
+			return nil, err
+		}
+		intervals = append(intervals, interval{start: start, end: end})
+	}
+
+	events := []*calendar.Event{}
+	for _, busy := range mergeIntervals(intervals) {
+		if !busy.end.After(since) {
+			continue
+		}
+		id := intervalId(busy)
+		if !busy.start.After(rangeStart) {
+			id = intervalId(interval{end: busy.end})
+		}
+		events = append(events, &calendar.Event{
+			Id:      id,
+			Summary: "Busy",
+			Start:   &calendar.EventDateTime{DateTime: busy.start.UTC().Format(time.RFC3339)},
+			End:     &calendar.EventDateTime{DateTime: busy.end.UTC().Format(time.RFC3339)},
+		})
+	}
+	return events, nil
+}
+
+// intervalId returns an ID that stays the same for as long as the interval
+// doesn't move.
+func intervalId(i interval) string {
+	hash := sha256.Sum256([]byte(i.start.UTC().Format(time.RFC3339) + "/" + i.end.UTC().Format(time.RFC3339)))
+	return "freebusy-" + hex.EncodeToString(hash[:10])
+}
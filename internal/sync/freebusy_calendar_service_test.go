@@ -0,0 +1,253 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+func newTestFreeBusyService(t *testing.T, calendarIds []string, handler func(request *calendar.FreeBusyRequest) *calendar.FreeBusyResponse) *freeBusyCalendarService {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/calendar/v3/freeBusy" {
+			http.NotFound(w, r)
+			return
+		}
+		request := &calendar.FreeBusyRequest{}
+		if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(handler(request))
+	}))
+	t.Cleanup(server.Close)
+
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(server.Client()), option.WithEndpoint(server.URL+"/calendar/v3/"))
+	if err != nil {
+		t.Fatalf("unable to create calendar service: %v", err)
+	}
+	return &freeBusyCalendarService{service: service, calendarIds: calendarIds}
+}
+
+func TestBusyPeriodEvents(t *testing.T) {
+	events, err := busyPeriodEvents([]*calendar.TimePeriod{
+		{Start: "2026-03-10T09:00:00Z", End: "2026-03-10T10:00:00Z"},
+		{Start: "2026-03-10T09:30:00Z", End: "2026-03-10T11:00:00Z"},
+		{Start: "2026-03-10T14:00:00-04:00", End: "2026-03-10T15:00:00-04:00"},
+	}, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("Expected overlapping periods to be merged into 2 events, got %d", len(events))
+	}
+	if events[0].Start.DateTime != "2026-03-10T09:00:00Z" || events[0].End.DateTime != "2026-03-10T11:00:00Z" {
+		t.Errorf("Unexpected merged event %s - %s", events[0].Start.DateTime, events[0].End.DateTime)
+	}
+	if events[1].Start.DateTime != "2026-03-10T18:00:00Z" {
+		t.Errorf("Expected times to be normalized to UTC, got %s", events[1].Start.DateTime)
+	}
+
+	again, _ := busyPeriodEvents([]*calendar.TimePeriod{{Start: "2026-03-10T14:00:00-04:00", End: "2026-03-10T15:00:00-04:00"}}, time.Time{}, time.Time{})
+	if again[0].Id != events[1].Id {
+		t.Error("The same interval should always get the same ID")
+	}
+	shifted, _ := busyPeriodEvents([]*calendar.TimePeriod{{Start: "2026-03-10T14:30:00-04:00", End: "2026-03-10T15:00:00-04:00"}}, time.Time{}, time.Time{})
+	if shifted[0].Id == events[1].Id {
+		t.Error("A shifted interval should get a new ID")
+	}
+}
+
+func TestFreeBusyList(t *testing.T) {
+	requests := []*calendar.FreeBusyRequest{}
+	service := newTestFreeBusyService(t, []string{"family@example.com", "kids@example.com"}, func(request *calendar.FreeBusyRequest) *calendar.FreeBusyResponse {
+		requests = append(requests, request)
+		return &calendar.FreeBusyResponse{Calendars: map[string]calendar.FreeBusyCalendar{
+			"family@example.com": {Busy: []*calendar.TimePeriod{{Start: "2026-03-10T09:00:00Z", End: "2026-03-10T10:00:00Z"}}},
+			"kids@example.com":   {Busy: []*calendar.TimePeriod{{Start: "2026-03-10T09:30:00Z", End: "2026-03-10T10:30:00Z"}}},
+		}}
+	})
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	events, err := service.List(defaultCalendar, start, start.AddDate(0, 0, 7), nil)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if len(requests) != 1 || len(requests[0].Items) != 2 {
+		t.Fatal("Expected a single query for both calendars")
+	}
+	if requests[0].TimeMin != "2026-02-28T00:00:00Z" || requests[0].TimeMax != "2026-03-08T00:00:00Z" {
+		t.Errorf("Unexpected query range %s - %s", requests[0].TimeMin, requests[0].TimeMax)
+	}
+	if len(events) != 1 || events[0].End.DateTime != "2026-03-10T10:30:00Z" {
+		t.Errorf("Expected busy times across calendars to be merged, got %d events", len(events))
+	}
+
+	blocks, _ := service.List(defaultCalendar, start, start.AddDate(0, 0, 7), map[string]string{appName: propertyAppNameValue})
+	if len(blocks) != 0 {
+		t.Error("Busy intervals never have our properties")
+	}
+}
+
+func TestFreeBusyListSplitsLongRanges(t *testing.T) {
+	requests := []*calendar.FreeBusyRequest{}
+	service := newTestFreeBusyService(t, nil, func(request *calendar.FreeBusyRequest) *calendar.FreeBusyResponse {
+		requests = append(requests, request)
+		// The same busy time reported at the end of one range and the start of the next
+		return &calendar.FreeBusyResponse{Calendars: map[string]calendar.FreeBusyCalendar{
+			defaultCalendar: {Busy: []*calendar.TimePeriod{{Start: request.TimeMin, End: request.TimeMax}}},
+		}}
+	})
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	events, err := service.List(defaultCalendar, start, start.AddDate(0, 0, 40), nil)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if len(requests) != 2 {
+		t.Errorf("Expected the range to be split into 2 queries, got %d", len(requests))
+	}
+	if len(events) != 1 {
+		t.Errorf("Expected busy time spanning both queries to be merged, got %d events", len(events))
+	}
+}
+
+func TestFreeBusyListCalendarError(t *testing.T) {
+	service := newTestFreeBusyService(t, []string{"family@example.com"}, func(request *calendar.FreeBusyRequest) *calendar.FreeBusyResponse {
+		return &calendar.FreeBusyResponse{Calendars: map[string]calendar.FreeBusyCalendar{
+			"family@example.com": {Errors: []*calendar.Error{{Domain: "calendar", Reason: "notFound"}}},
+		}}
+	})
+	start := time.Now()
+
+	if _, err := service.List(defaultCalendar, start, start.AddDate(0, 0, 1), nil); err == nil {
+		t.Error("Expected an error for a calendar that couldn't be read")
+	}
+}
+
+func TestRunSyncFreeBusySourceReconcilesShiftedIntervals(t *testing.T) {
+	start := time.Now().Add(time.Hour).Truncate(time.Minute).UTC()
+	previous := interval{start: start, end: start.Add(time.Hour)}
+	current := interval{start: start.Add(30 * time.Minute), end: start.Add(time.Hour)}
+
+	service := newTestFreeBusyService(t, nil, func(request *calendar.FreeBusyRequest) *calendar.FreeBusyResponse {
+		return &calendar.FreeBusyResponse{Calendars: map[string]calendar.FreeBusyCalendar{
+			defaultCalendar: {Busy: []*calendar.TimePeriod{{Start: current.start.Format(time.RFC3339), End: current.end.Format(time.RFC3339)}}},
+		}}
+	})
+	mockDestinationService := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestEvent("old", "Busy", previous.start, previous.end, blockProperties(intervalId(previous))),
+	}}
+	syncClient := &SyncClient{
+		SourceCalendarService:      service,
+		DestinationCalendarService: mockDestinationService,
+	}
+
+	err := syncClient.RunSync(1, false)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if len(mockDestinationService.deletedEvents) != 1 || mockDestinationService.deletedEvents[0] != "old" {
+		t.Errorf("Expected the block for the old interval to be deleted, deleted %v", mockDestinationService.deletedEvents)
+	}
+	if len(mockDestinationService.insertedEvents) != 1 || mockDestinationService.insertedEvents[0].ExtendedProperties.Private[sourceEventIdPropertyKey] != intervalId(current) {
+		t.Error("Expected a block for the shifted interval")
+	}
+}
+
+func TestFreeBusyListLooksBack(t *testing.T) {
+	requests := []*calendar.FreeBusyRequest{}
+	service := newTestFreeBusyService(t, nil, func(request *calendar.FreeBusyRequest) *calendar.FreeBusyResponse {
+		requests = append(requests, request)
+		return &calendar.FreeBusyResponse{Calendars: map[string]calendar.FreeBusyCalendar{
+			defaultCalendar: {Busy: []*calendar.TimePeriod{
+				{Start: "2026-03-10T08:00:00Z", End: "2026-03-10T09:00:00Z"},
+				{Start: "2026-03-10T13:00:00Z", End: "2026-03-10T15:00:00Z"},
+			}},
+		}}
+	})
+	start := time.Date(2026, 3, 10, 14, 5, 30, 0, time.UTC)
+
+	events, err := service.List(defaultCalendar, start, start.AddDate(0, 0, 1), nil)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if requests[0].TimeMin != "2026-03-09T00:00:00Z" {
+		t.Errorf("Expected the query to start at midnight the day before, got %s", requests[0].TimeMin)
+	}
+	if len(events) != 1 || events[0].Start.DateTime != "2026-03-10T13:00:00Z" {
+		t.Errorf("Expected only the interval under way, kept whole, got %+v", events)
+	}
+}
+
+func TestFreeBusyListIntervalCutOff(t *testing.T) {
+	// Like FreeBusy, cut a week away off at the start of the query
+	service := newTestFreeBusyService(t, nil, func(request *calendar.FreeBusyRequest) *calendar.FreeBusyResponse {
+		return &calendar.FreeBusyResponse{Calendars: map[string]calendar.FreeBusyCalendar{
+			defaultCalendar: {Busy: []*calendar.TimePeriod{{Start: request.TimeMin, End: "2026-03-14T00:00:00Z"}}},
+		}}
+	})
+
+	ids := []string{}
+	for _, start := range []time.Time{time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC), time.Date(2026, 3, 11, 9, 0, 0, 0, time.UTC)} {
+		events, err := service.List(defaultCalendar, start, start.AddDate(0, 0, 1), nil)
+		if err != nil {
+			t.Fatalf("Function returned error: %v", err)
+		}
+		if len(events) != 1 {
+			t.Fatalf("Expected the week away, got %d events", len(events))
+		}
+		ids = append(ids, events[0].Id)
+	}
+
+	if ids[0] != ids[1] {
+		t.Errorf("Expected an interval cut off by the query to keep its ID from day to day, got %v", ids)
+	}
+}
+
+func TestRunSyncFreeBusySourceKeepsIntervalUnderWay(t *testing.T) {
+	year, month, day := time.Now().UTC().Date()
+	now := time.Date(year, month, day, 12, 0, 0, 0, time.UTC)
+	busy := interval{start: now.Add(-time.Hour), end: now.Add(time.Hour)}
+
+	// Like FreeBusy, cut the busy time off at the start of the query
+	service := newTestFreeBusyService(t, nil, func(request *calendar.FreeBusyRequest) *calendar.FreeBusyResponse {
+		start := busy.start.Format(time.RFC3339)
+		if timeMin, _ := time.Parse(time.RFC3339, request.TimeMin); timeMin.After(busy.start) {
+			start = request.TimeMin
+		}
+		return &calendar.FreeBusyResponse{Calendars: map[string]calendar.FreeBusyCalendar{
+			defaultCalendar: {Busy: []*calendar.TimePeriod{{Start: start, End: busy.end.Format(time.RFC3339)}}},
+		}}
+	})
+	destination := &memoryCalendarService{name: "work"}
+	syncClient := &SyncClient{SourceCalendarService: service, DestinationCalendarService: destination, Location: time.UTC}
+
+	t.Cleanup(func() { clock = time.Now })
+	blockIds := []string{}
+	for _, at := range []time.Time{now, now.Add(5 * time.Minute)} {
+		clock = func() time.Time { return at }
+		if err := syncClient.RunSync(1, false); err != nil {
+			t.Fatalf("Function returned error: %v", err)
+		}
+		if len(destination.events) != 1 {
+			t.Fatalf("Expected a single block, got %d", len(destination.events))
+		}
+		blockIds = append(blockIds, destination.events[0].Id)
+	}
+
+	if blockIds[0] != blockIds[1] {
+		t.Errorf("Expected the block to be kept between runs, got %v", blockIds)
+	}
+}
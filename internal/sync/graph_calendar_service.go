@@ -0,0 +1,364 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	graphBaseURL = "https://graph.microsoft.com/v1.0"
+	// Extended properties live in the PS_PUBLIC_STRINGS property set, named
+	// after the private property they stand for
+	graphPropertySet       = "{00020329-0000-0000-C000-000000000046}"
+	graphDateTimeLayout    = "2006-01-02T15:04:05.9999999"
+	graphListPageSize      = 100
+	graphTimeZonePreferUTC = `outlook.timezone="UTC"`
+)
+
+// Private properties read back from Graph events. Graph only returns the
+// extended properties that are asked for by ID.
+var graphPropertyKeys = []string{appName, sourceEventIdPropertyKey, markerPropertyKey, leasePropertyKey, leaseHolderPropertyKey, leaseExpiresPropertyKey, schemaVersionPropertyKey}
+
+// graphCalendarService writes to an Outlook / Microsoft 365 calendar through
+// Microsoft Graph. Private properties are stored as single value extended
+// properties.
+type graphCalendarService struct {
+	client  *http.Client
+	baseURL string
+}
+
+// NewGraphCalendarService returns a service for the calendars of the account
+// the client is signed in to.
+func NewGraphCalendarService(client *http.Client) CalendarEventsService {
+	return &graphCalendarService{client: client, baseURL: graphBaseURL}
+}
+
+type graphEvent struct {
+	Id                            string                   `json:"id,omitempty"`
+	Subject                       string                   `json:"subject,omitempty"`
+	Body                          *graphItemBody           `json:"body,omitempty"`
+	Start                         *graphDateTime           `json:"start,omitempty"`
+	End                           *graphDateTime           `json:"end,omitempty"`
+	IsAllDay                      *bool                    `json:"isAllDay,omitempty"`
+	IsCancelled                   bool                     `json:"isCancelled,omitempty"`
+	ShowAs                        string                   `json:"showAs,omitempty"`
+	TransactionId                 string                   `json:"transactionId,omitempty"`
+	SingleValueExtendedProperties []*graphExtendedProperty `json:"singleValueExtendedProperties,omitempty"`
+}
+
+type graphItemBody struct {
+	ContentType string `json:"contentType"`
+	Content     string `json:"content"`
+}
+
+type graphDateTime struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+type graphExtendedProperty struct {
+	Id    string `json:"id"`
+	Value string `json:"value"`
+}
+
+type graphEventList struct {
+	Value    []*graphEvent `json:"value"`
+	NextLink string        `json:"@odata.nextLink"`
+}
+
+type graphErrorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// graphStatusError is returned for a request Graph answered with an error
+// status, so callers can tell a missing event from other failures.
+type graphStatusError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	Code       string
+	Message    string
+}
+
+func (e *graphStatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("microsoft graph: %s %s: %s (%s)", e.Method, e.Path, e.Message, e.Code)
+	}
+	return fmt.Sprintf("microsoft graph: %s %s: %s", e.Method, e.Path, e.Status)
+}
+
+func graphPropertyId(key string) string {
+	return "String " + graphPropertySet + " Name " + key
+}
+
+func (c *graphCalendarService) List(calendarId string, startTime time.Time, endTime time.Time, privateProperties map[string]string) ([]*calendar.Event, error) {
+	ids := []string{}
+	for _, key := range graphPropertyKeys {
+		ids = append(ids, fmt.Sprintf("id eq '%s'", graphPropertyId(key)))
+	}
+	query := url.Values{
+		"$expand": {"singleValueExtendedProperties($filter=" + strings.Join(ids, " or ") + ")"},
+		"$top":    {fmt.Sprint(graphListPageSize)},
+	}
+
+	// The calendar view expands recurring events but needs both ends of the
+	// window, listing events is only done to find our own blocks
+	path := c.calendarPath(calendarId)
+	if !startTime.IsZero() && !endTime.IsZero() {
+		path += "/calendarView"
+		query.Set("startDateTime", startTime.UTC().Format(time.RFC3339))
+		query.Set("endDateTime", endTime.UTC().Format(time.RFC3339))
+	} else {
+		path += "/events"
+		filters := []string{}
+		for key, value := range privateProperties {
+			filters = append(filters, fmt.Sprintf("singleValueExtendedProperties/Any(ep: ep/id eq '%s' and ep/value eq '%s')", graphPropertyId(key), strings.ReplaceAll(value, "'", "''")))
+		}
+		if len(filters) > 0 {
+			query.Set("$filter", strings.Join(filters, " and "))
+		}
+	}
+
+	events := []*calendar.Event{}
+	next := c.baseURL + path + "?" + query.Encode()
+	for next != "" {
+		page := &graphEventList{}
+		if err := c.do(http.MethodGet, next, nil, page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Value {
+			event, err := item.calendarEvent()
+			if err != nil {
+				return nil, err
+			}
+			if !hasPrivateProperties(event, privateProperties) || !eventOverlaps(event, startTime, endTime) {
+				continue
+			}
+			events = append(events, event)
+		}
+		next = page.NextLink
+	}
+	return events, nil
+}
+
+func (c *graphCalendarService) Insert(calendarId string, event *calendar.Event) (*calendar.Event, error) {
+	body, err := newGraphEvent(event)
+	if err != nil {
+		return nil, err
+	}
+	body.ShowAs = "busy"
+	// Graph picks its own IDs, but returns the event already created with the
+	// same transaction ID when an insert is retried
+	body.TransactionId = event.Id
+
+	created := &graphEvent{}
+	if err := c.do(http.MethodPost, c.baseURL+c.calendarPath(calendarId)+"/events", body, created); err != nil {
+		return nil, err
+	}
+	// The response leaves out the extended properties that were just set
+	created.SingleValueExtendedProperties = body.SingleValueExtendedProperties
+	return created.calendarEvent()
+}
+
+func (c *graphCalendarService) Patch(calendarId string, eventId string, event *calendar.Event) (*calendar.Event, error) {
+	body, err := newGraphEvent(event)
+	if err != nil {
+		return nil, err
+	}
+
+	patched := &graphEvent{}
+	if err := c.do(http.MethodPatch, c.baseURL+"/me/events/"+url.PathEscape(eventId), body, patched); err != nil {
+		return nil, err
+	}
+	return patched.calendarEvent()
+}
+
+// Blocks are written without the colour rules give them.
+func (c *graphCalendarService) dropsColors() bool {
+	return true
+}
+
+func (c *graphCalendarService) Delete(calendarId string, eventId string) error {
+	return c.do(http.MethodDelete, c.baseURL+"/me/events/"+url.PathEscape(eventId), nil, nil)
+}
+
+func (c *graphCalendarService) calendarPath(calendarId string) string {
+	if calendarId == defaultCalendar {
+		return "/me/calendar"
+	}
+	return "/me/calendars/" + url.PathEscape(calendarId)
+}
+
+// do sends a request to Graph, decoding the response into result if it isn't
+// nil. Times are always asked for in UTC.
+func (c *graphCalendarService) do(method string, target string, body any, result any) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Prefer", graphTimeZonePreferUTC)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		statusErr := &graphStatusError{Method: method, Path: req.URL.Path, StatusCode: resp.StatusCode, Status: resp.Status}
+		graphErr := &graphErrorResponse{}
+		if json.NewDecoder(resp.Body).Decode(graphErr) == nil {
+			statusErr.Code = graphErr.Error.Code
+			statusErr.Message = graphErr.Error.Message
+		}
+		return statusErr
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// newGraphEvent converts the fields set on a calendar event, so it works for
+// both creating and patching.
+func newGraphEvent(event *calendar.Event) (*graphEvent, error) {
+	result := &graphEvent{Subject: event.Summary}
+	if event.Description != "" {
+		result.Body = &graphItemBody{ContentType: "html", Content: event.Description}
+	}
+
+	var err error
+	if event.Start != nil {
+		result.Start, err = newGraphDateTime(event.Start)
+		if err != nil {
+			return nil, err
+		}
+		allDay := event.Start.DateTime == ""
+		result.IsAllDay = &allDay
+	}
+	if event.End != nil {
+		result.End, err = newGraphDateTime(event.End)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if event.ExtendedProperties != nil {
+		for key, value := range event.ExtendedProperties.Private {
+			result.SingleValueExtendedProperties = append(result.SingleValueExtendedProperties, &graphExtendedProperty{Id: graphPropertyId(key), Value: value})
+		}
+	}
+	return result, nil
+}
+
+func newGraphDateTime(eventTime *calendar.EventDateTime) (*graphDateTime, error) {
+	// All-day events are given as midnight in a zone, UTC unless the event has one
+	if eventTime.DateTime == "" {
+		date, err := time.Parse(time.DateOnly, eventTime.Date)
+		if err != nil {
+			return nil, err
+		}
+		zone := eventTime.TimeZone
+		if zone == "" {
+			zone = "UTC"
+		}
+		return &graphDateTime{DateTime: date.Format(graphDateTimeLayout), TimeZone: zone}, nil
+	}
+	t, err := time.Parse(time.RFC3339, eventTime.DateTime)
+	if err != nil {
+		return nil, err
+	}
+	return &graphDateTime{DateTime: t.UTC().Format(graphDateTimeLayout), TimeZone: "UTC"}, nil
+}
+
+func (e *graphEvent) calendarEvent() (*calendar.Event, error) {
+	event := &calendar.Event{
+		Id:                 e.Id,
+		Summary:            e.Subject,
+		ExtendedProperties: &calendar.EventExtendedProperties{Private: map[string]string{}},
+	}
+	if e.Body != nil {
+		event.Description = e.Body.Content
+	}
+	if e.IsCancelled {
+		event.Status = eventStatusCancelled
+	}
+	if e.ShowAs == "free" {
+		event.Transparency = eventTransparencyTransparent
+	}
+
+	allDay := e.IsAllDay != nil && *e.IsAllDay
+	var err error
+	event.Start, err = e.Start.eventDateTime(allDay)
+	if err != nil {
+		return nil, err
+	}
+	event.End, err = e.End.eventDateTime(allDay)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, property := range e.SingleValueExtendedProperties {
+		_, key, ok := strings.Cut(property.Id, " Name ")
+		if ok {
+			event.ExtendedProperties.Private[key] = property.Value
+		}
+	}
+	return event, nil
+}
+
+func (d *graphDateTime) eventDateTime(allDay bool) (*calendar.EventDateTime, error) {
+	if d == nil {
+		return nil, nil
+	}
+	if allDay {
+		return &calendar.EventDateTime{Date: d.DateTime[:len(time.DateOnly)]}, nil
+	}
+	// Every request asks for UTC, but other zones may still come back
+	loc := time.UTC
+	if d.TimeZone != "" && d.TimeZone != "UTC" {
+		var err error
+		loc, err = time.LoadLocation(d.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected time zone %q from microsoft graph", d.TimeZone)
+		}
+	}
+	t, err := time.ParseInLocation(graphDateTimeLayout, d.DateTime, loc)
+	if err != nil {
+		return nil, err
+	}
+	return &calendar.EventDateTime{DateTime: t.Format(time.RFC3339)}, nil
+}
+
+// eventOverlaps checks an event against a window, either end of which may be
+// left zero.
+func eventOverlaps(event *calendar.Event, startTime time.Time, endTime time.Time) bool {
+	start, end, _, ok := eventTimes(event, time.UTC)
+	if !ok {
+		return true
+	}
+	return (startTime.IsZero() || end.After(startTime)) && (endTime.IsZero() || start.Before(endTime))
+}
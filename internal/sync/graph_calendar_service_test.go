@@ -0,0 +1,341 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// graphStandIn serves the parts of Microsoft Graph the calendar service uses,
+// keeping events in memory.
+type graphStandIn struct {
+	t        *testing.T
+	events   map[string]*graphEvent
+	order    []string
+	nextId   int
+	pageSize int
+	requests []*http.Request
+}
+
+func newGraphStandIn(t *testing.T) (*graphStandIn, *graphCalendarService) {
+	t.Helper()
+	standIn := &graphStandIn{t: t, events: map[string]*graphEvent{}, pageSize: 2}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+	return standIn, &graphCalendarService{client: server.Client(), baseURL: server.URL + "/v1.0"}
+}
+
+func (g *graphStandIn) add(event *graphEvent) *graphEvent {
+	g.nextId++
+	event.Id = fmt.Sprintf("AAMkAD%d==", g.nextId)
+	g.events[event.Id] = event
+	g.order = append(g.order, event.Id)
+	return event
+}
+
+func (g *graphStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.requests = append(g.requests, r)
+	if r.Header.Get("Prefer") != `outlook.timezone="UTC"` {
+		g.t.Errorf("%s %s: times weren't asked for in UTC", r.Method, r.URL.Path)
+	}
+
+	switch {
+	case r.Method == http.MethodGet && (r.URL.Path == "/v1.0/me/calendar/calendarView" || r.URL.Path == "/v1.0/me/calendar/events"):
+		if r.URL.Path == "/v1.0/me/calendar/calendarView" && (r.URL.Query().Get("startDateTime") == "" || r.URL.Query().Get("endDateTime") == "") {
+			writeGraphError(w, http.StatusBadRequest, "ErrorInvalidParameter", "calendarView needs a start and end")
+			return
+		}
+		if !strings.Contains(r.URL.Query().Get("$expand"), graphPropertyId(sourceEventIdPropertyKey)) {
+			g.t.Errorf("Extended properties weren't expanded: %s", r.URL.RawQuery)
+		}
+		// Page through the events with a skip token, like Graph does
+		skip := 0
+		fmt.Sscan(r.URL.Query().Get("$skiptoken"), &skip)
+		page := &graphEventList{Value: []*graphEvent{}}
+		for i := skip; i < len(g.order) && i < skip+g.pageSize; i++ {
+			page.Value = append(page.Value, g.events[g.order[i]])
+		}
+		if skip+g.pageSize < len(g.order) {
+			next := *r.URL
+			query := next.Query()
+			query.Set("$skiptoken", fmt.Sprint(skip+g.pageSize))
+			next.RawQuery = query.Encode()
+			page.NextLink = "http://" + r.Host + next.String()
+		}
+		json.NewEncoder(w).Encode(page)
+	case r.Method == http.MethodPost && r.URL.Path == "/v1.0/me/calendar/events":
+		event := &graphEvent{}
+		if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+			writeGraphError(w, http.StatusBadRequest, "BadRequest", err.Error())
+			return
+		}
+		if event.Start == nil || event.End == nil || event.Start.TimeZone == "" {
+			writeGraphError(w, http.StatusBadRequest, "BadRequest", "start and end are required")
+			return
+		}
+		for _, id := range g.order {
+			if existing := g.events[id]; event.TransactionId != "" && existing.TransactionId == event.TransactionId {
+				w.WriteHeader(http.StatusCreated)
+				json.NewEncoder(w).Encode(existing)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+		created := *g.add(event)
+		created.SingleValueExtendedProperties = nil
+		json.NewEncoder(w).Encode(created)
+	case strings.HasPrefix(r.URL.Path, "/v1.0/me/events/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1.0/me/events/")
+		event, ok := g.events[id]
+		if !ok {
+			writeGraphError(w, http.StatusNotFound, "ErrorItemNotFound", "The specified object was not found in the store.")
+			return
+		}
+		switch r.Method {
+		case http.MethodPatch:
+			patch := &graphEvent{}
+			if err := json.NewDecoder(r.Body).Decode(patch); err != nil {
+				writeGraphError(w, http.StatusBadRequest, "BadRequest", err.Error())
+				return
+			}
+			if patch.Subject != "" {
+				event.Subject = patch.Subject
+			}
+			if patch.Start != nil {
+				event.Start = patch.Start
+			}
+			if patch.End != nil {
+				event.End = patch.End
+			}
+			json.NewEncoder(w).Encode(event)
+		case http.MethodDelete:
+			delete(g.events, id)
+			for i, existing := range g.order {
+				if existing == id {
+					g.order = append(g.order[:i], g.order[i+1:]...)
+					break
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeGraphError(w, http.StatusMethodNotAllowed, "BadRequest", "method not allowed")
+		}
+	default:
+		writeGraphError(w, http.StatusNotFound, "BadRequest", "unsupported request")
+	}
+}
+
+func writeGraphError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	response := &graphErrorResponse{}
+	response.Error.Code = code
+	response.Error.Message = message
+	json.NewEncoder(w).Encode(response)
+}
+
+func TestGraphCalendarServiceInsert(t *testing.T) {
+	standIn, service := newGraphStandIn(t)
+	sourceEvent := createTestEvent("source-1", "Doctor", time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC), time.Date(2026, 3, 10, 10, 0, 0, 0, time.UTC), nil)
+
+	inserted, err := service.Insert(defaultCalendar, createDestinationEvent(sourceEvent, time.UTC))
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	stored := standIn.events[inserted.Id]
+	if stored == nil {
+		t.Fatalf("Expected the event to be created")
+	}
+	if stored.Subject != "Busy" || stored.ShowAs != "busy" || stored.Start.DateTime != "2026-03-10T09:00:00" || stored.Start.TimeZone != "UTC" || *stored.IsAllDay {
+		t.Errorf("Unexpected event %+v", stored)
+	}
+	properties := map[string]string{}
+	for _, property := range stored.SingleValueExtendedProperties {
+		properties[property.Id] = property.Value
+	}
+	if properties["String {00020329-0000-0000-C000-000000000046} Name gcal-busy-blocker"] != "true" || properties[graphPropertyId(sourceEventIdPropertyKey)] != "source-1" {
+		t.Errorf("Unexpected extended properties %v", properties)
+	}
+	if inserted.ExtendedProperties.Private[sourceEventIdPropertyKey] != "source-1" || inserted.Start.DateTime != "2026-03-10T09:00:00Z" {
+		t.Errorf("Unexpected inserted event %+v", inserted)
+	}
+}
+
+func TestGraphCalendarServiceInsertRetry(t *testing.T) {
+	standIn, service := newGraphStandIn(t)
+	sourceEvent := createTestEvent("source-1", "Doctor", time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC), time.Date(2026, 3, 10, 10, 0, 0, 0, time.UTC), nil)
+	block := createDestinationEvent(sourceEvent, time.UTC)
+	block.Id = "0123456789abcdef"
+
+	first, err := service.Insert(defaultCalendar, block)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	second, err := service.Insert(defaultCalendar, block)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if first.Id != second.Id || len(standIn.events) != 1 {
+		t.Errorf("Expected the retried insert to find the first block, got %s and %s", first.Id, second.Id)
+	}
+}
+
+func TestGraphCalendarServiceList(t *testing.T) {
+	standIn, service := newGraphStandIn(t)
+	block := func(sourceId string, start string, end string) *graphEvent {
+		return &graphEvent{
+			Subject: "Busy",
+			Start:   &graphDateTime{DateTime: start, TimeZone: "UTC"},
+			End:     &graphDateTime{DateTime: end, TimeZone: "UTC"},
+			SingleValueExtendedProperties: []*graphExtendedProperty{
+				{Id: graphPropertyId(appName), Value: propertyAppNameValue},
+				{Id: graphPropertyId(sourceEventIdPropertyKey), Value: sourceId},
+			},
+		}
+	}
+	standIn.add(block("1", "2026-03-10T09:00:00.0000000", "2026-03-10T10:00:00.0000000"))
+	standIn.add(&graphEvent{Subject: "Standup", Start: &graphDateTime{DateTime: "2026-03-10T11:00:00.0000000", TimeZone: "UTC"}, End: &graphDateTime{DateTime: "2026-03-10T11:15:00.0000000", TimeZone: "UTC"}})
+	standIn.add(block("2", "2026-03-11T09:00:00.0000000", "2026-03-11T10:00:00.0000000"))
+	standIn.add(block("3", "2026-05-01T09:00:00.0000000", "2026-05-01T10:00:00.0000000"))
+	allDay := true
+	standIn.add(&graphEvent{Subject: "Holiday", IsAllDay: &allDay, ShowAs: "free", Start: &graphDateTime{DateTime: "2026-03-12T00:00:00.0000000", TimeZone: "UTC"}, End: &graphDateTime{DateTime: "2026-03-13T00:00:00.0000000", TimeZone: "UTC"}})
+
+	blocks, err := service.List(defaultCalendar, time.Time{}, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), map[string]string{appName: propertyAppNameValue})
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(blocks) != 2 || blocks[0].ExtendedProperties.Private[sourceEventIdPropertyKey] != "1" || blocks[1].ExtendedProperties.Private[sourceEventIdPropertyKey] != "2" {
+		t.Fatalf("Expected the blocks before April across all pages, got %+v", blocks)
+	}
+	if !strings.Contains(standIn.requests[0].URL.Query().Get("$filter"), graphPropertyId(appName)) {
+		t.Errorf("Expected the listing to be filtered by the app marker, got %s", standIn.requests[0].URL.RawQuery)
+	}
+
+	events, err := service.List(defaultCalendar, time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 13, 0, 0, 0, 0, time.UTC), nil)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 events in the window, got %d", len(events))
+	}
+	holiday := events[3]
+	if holiday.Start.Date != "2026-03-12" || holiday.End.Date != "2026-03-13" || holiday.Transparency != "transparent" {
+		t.Errorf("Expected a free all-day event, got %+v", holiday)
+	}
+}
+
+func TestGraphCalendarServicePatchAndDelete(t *testing.T) {
+	standIn, service := newGraphStandIn(t)
+	event := standIn.add(&graphEvent{Subject: "Busy", Start: &graphDateTime{DateTime: "2026-03-10T09:00:00.0000000", TimeZone: "UTC"}, End: &graphDateTime{DateTime: "2026-03-10T10:00:00.0000000", TimeZone: "UTC"}})
+
+	patched, err := service.Patch(defaultCalendar, event.Id, &calendar.Event{
+		Start: &calendar.EventDateTime{DateTime: "2026-03-10T07:00:00-04:00"},
+		End:   &calendar.EventDateTime{DateTime: "2026-03-10T08:00:00-04:00"},
+	})
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if patched.Start.DateTime != "2026-03-10T11:00:00Z" || standIn.events[event.Id].Subject != "Busy" {
+		t.Errorf("Unexpected patched event %+v", patched)
+	}
+
+	if err := service.Delete(defaultCalendar, event.Id); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	err = service.Delete(defaultCalendar, event.Id)
+	if err == nil || !strings.Contains(err.Error(), "ErrorItemNotFound") {
+		t.Errorf("Expected Graph's error to be passed on, got %v", err)
+	}
+}
+
+func TestGraphDestinationSync(t *testing.T) {
+	standIn, destination := newGraphStandIn(t)
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	source := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestEvent("1", "Therapy", start, start.Add(time.Hour), nil),
+		createTestEvent("2", "School run", start.Add(3*time.Hour), start.Add(4*time.Hour), nil),
+		createTestEvent("3", "Dinner", start.Add(6*time.Hour), start.Add(7*time.Hour), nil),
+	}}
+	syncClient := &SyncClient{SourceCalendarService: source, DestinationCalendarService: destination, Location: time.UTC}
+
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(standIn.events) != 3 {
+		t.Fatalf("Expected 3 blocks, got %d", len(standIn.events))
+	}
+
+	// A second run finds the blocks again instead of adding more
+	source.events = source.events[1:]
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(standIn.events) != 2 {
+		t.Errorf("Expected the block for the removed event to be deleted, got %d blocks", len(standIn.events))
+	}
+}
+
+func TestGraphDestinationSyncWithState(t *testing.T) {
+	standIn, destination := newGraphStandIn(t)
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	source := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestEvent("1", "Therapy", start, start.Add(time.Hour), nil),
+		createTestEvent("2", "School run", start.Add(3*time.Hour), start.Add(4*time.Hour), nil),
+	}}
+	syncClient := &SyncClient{
+		SourceCalendarService:      source,
+		DestinationCalendarService: destination,
+		Location:                   time.UTC,
+		State:                      openTestState(t),
+		DestinationStateKey:        "microsoft/me",
+	}
+
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	// Someone deletes a block by hand before its event is removed
+	delete(standIn.events, standIn.order[0])
+	standIn.order = standIn.order[1:]
+	source.events = nil
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Expected the deleted block to be skipped, got %v", err)
+	}
+	if len(standIn.events) != 0 {
+		t.Errorf("Expected both blocks to be gone, got %d", len(standIn.events))
+	}
+}
+
+func TestGraphDestinationSyncIgnoresColors(t *testing.T) {
+	standIn, destination := newGraphStandIn(t)
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	source := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestEvent("1", "Gym", start, start.Add(time.Hour), nil),
+	}}
+	syncClient := &SyncClient{
+		SourceCalendarService:      source,
+		DestinationCalendarService: destination,
+		Location:                   time.UTC,
+		Rules:                      &Rules{Rules: []*Rule{{Name: "gym", Action: ActionRecolor, ColorId: "2"}}},
+	}
+
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	standIn.requests = nil
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	for _, request := range standIn.requests {
+		if request.Method != http.MethodGet {
+			t.Errorf("Expected the block to be left alone as Graph doesn't store colours, got %s %s", request.Method, request.URL.Path)
+		}
+	}
+}
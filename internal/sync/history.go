@@ -0,0 +1,203 @@
+package sync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/state"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/api/calendar/v3"
+)
+
+// beginRun starts recording the changes of a command in the history, which
+// lives in the state store. Dry runs aren't recorded.
+func (s *SyncClient) beginRun(command string, dryRun bool) {
+	s.runId = 0
+	if dryRun || s.State == nil || s.DestinationStateKey == "" {
+		return
+	}
+	runId, err := s.State.BeginRun(s.DestinationStateKey, command)
+	if err != nil {
+		s.logger().Warn("Unable to record the run in the history", "error", err)
+		return
+	}
+	s.runId = runId
+}
+
+func (s *SyncClient) finishRun(runErr error) {
+	if s.runId == 0 {
+		return
+	}
+	if err := s.State.FinishRun(s.runId, runErr); err != nil {
+		s.logger().Warn("Unable to record the end of the run in the history", "error", err)
+	}
+	s.runId = 0
+}
+
+// recordChange adds a change to the history of the current run.
+func (s *SyncClient) recordChange(kind string, eventId string, before *calendar.Event, after *calendar.Event) {
+	if s.runId == 0 {
+		return
+	}
+	change := &state.Change{Kind: kind, EventId: eventId}
+	var err error
+	if before != nil {
+		change.Before, err = json.Marshal(before)
+	}
+	if after != nil && err == nil {
+		change.After, err = json.Marshal(after)
+	}
+	if err == nil {
+		err = s.State.AddChange(s.runId, change)
+	}
+	if err != nil {
+		s.logger().Warn("Unable to record the change in the history", "event_id", eventId, "error", err)
+	}
+}
+
+// priorEvent returns the whole of a block that's about to change, for the
+// history to be able to put it back. Blocks from the local state only have
+// what syncs look at, so they're read from the calendar if it can look them
+// up.
+func (s *SyncClient) priorEvent(event *calendar.Event) *calendar.Event {
+	if s.runId == 0 || !s.useState() {
+		return event
+	}
+	lookup, ok := s.DestinationCalendarService.(EventLookupService)
+	if !ok {
+		return event
+	}
+	end := s.traceCall(lookup, "Get", s.destinationCalendar(), event.Id)
+	current, err := lookup.Get(s.destinationCalendar(), event.Id)
+	end(err)
+	if err != nil || current == nil || current.Status == eventStatusCancelled {
+		return event
+	}
+	return current
+}
+
+// Undo reverses the changes of a run on the destination calendar, newest
+// first: created blocks are deleted, updated blocks are changed back, and
+// deleted blocks are created again. The latest run that changed anything is
+// undone when runId is 0. The undo is a run of its own, so it can be undone
+// in turn.
+func (s *SyncClient) Undo(runId uint64, dryRun bool) (run *state.Run, err error) {
+	if s.State == nil || s.DestinationStateKey == "" {
+		return nil, errors.New("undoing needs the run history in the local state")
+	}
+	run, err = s.undoableRun(runId)
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		s.logger().Info("Dry run, nothing will be changed")
+	}
+	s.logger().Info("Undoing run", "run", run.ID, "command", run.Command, "started", run.Started.Local().Format(time.DateTime))
+	s.beginRun(fmt.Sprintf("undo %d", run.ID), dryRun)
+	undoId := s.runId
+	defer func() { s.finishRun(err) }()
+	finishSpan := s.startSpan("Undo", attribute.Int64("sync.run_id", int64(run.ID)), attribute.Bool("sync.dry_run", dryRun))
+	defer func() { finishSpan(err) }()
+
+	for i := len(run.Changes) - 1; i >= 0; i-- {
+		if err := s.undoChange(run.Changes[i], dryRun); err != nil {
+			return run, err
+		}
+	}
+	if undoId != 0 {
+		if err := s.State.MarkUndone(run.ID, undoId); err != nil {
+			return run, err
+		}
+	}
+	return run, nil
+}
+
+// undoableRun finds the run to undo, making sure it was on this calendar and
+// wasn't undone already.
+func (s *SyncClient) undoableRun(runId uint64) (*state.Run, error) {
+	if runId == 0 {
+		history, err := s.State.History(s.DestinationStateKey)
+		if err != nil {
+			return nil, err
+		}
+		for _, run := range history {
+			if len(run.Changes) > 0 && run.UndoneBy == 0 {
+				return run, nil
+			}
+		}
+		return nil, errors.New("no run changed the destination calendar yet")
+	}
+
+	run, err := s.State.Run(runId)
+	if err != nil {
+		return nil, err
+	}
+	if run == nil {
+		return nil, fmt.Errorf("run %d isn't in the history", runId)
+	}
+	if run.CalendarKey != s.DestinationStateKey {
+		return nil, fmt.Errorf("run %d changed %s, pass the destination flags it was run with", runId, run.CalendarKey)
+	}
+	if run.UndoneBy != 0 {
+		return nil, fmt.Errorf("run %d was already undone by run %d", runId, run.UndoneBy)
+	}
+	return run, nil
+}
+
+func (s *SyncClient) undoChange(change *state.Change, dryRun bool) error {
+	var before, after *calendar.Event
+	if len(change.Before) > 0 {
+		before = &calendar.Event{}
+		if err := json.Unmarshal(change.Before, before); err != nil {
+			return fmt.Errorf("corrupt history for event %s: %v", change.EventId, err)
+		}
+	}
+	if len(change.After) > 0 {
+		after = &calendar.Event{}
+		if err := json.Unmarshal(change.After, after); err != nil {
+			return fmt.Errorf("corrupt history for event %s: %v", change.EventId, err)
+		}
+	}
+
+	var err error
+	switch {
+	case change.Kind == state.OperationInsert && after != nil:
+		err = s.deleteDestinationEvent(after, dryRun)
+	case change.Kind == state.OperationPatch && before != nil && after != nil:
+		patch := &calendar.Event{
+			Summary:     before.Summary,
+			Description: before.Description,
+			ColorId:     before.ColorId,
+			Start:       before.Start,
+			End:         before.End,
+			Recurrence:  before.Recurrence,
+		}
+		// Migrations change the private properties
+		if before.ExtendedProperties != nil && after.ExtendedProperties != nil && !maps.Equal(before.ExtendedProperties.Private, after.ExtendedProperties.Private) {
+			patch.ExtendedProperties = &calendar.EventExtendedProperties{
+				Private: propertiesPatch(after.ExtendedProperties.Private, before.ExtendedProperties.Private),
+			}
+		}
+		err = s.patchDestinationEvent(after, patch, dryRun)
+	case change.Kind == state.OperationDelete && before != nil:
+		// Blocks keep their ID where the calendar allows it, which lets the
+		// next sync find them where the deleted ones were
+		restored := *before
+		restored.Etag, restored.Status = "", ""
+		_, err = s.insertDestinationEvent(&restored, dryRun)
+	default:
+		return fmt.Errorf("unable to undo %s of event %s, the history is incomplete", change.Kind, change.EventId)
+	}
+
+	// Blocks changed since may be gone already, which is where undoing would
+	// have left them anyway
+	if err != nil && isGone(err) {
+		s.logger().Info("Block is gone, skipping it", "event_id", change.EventId)
+		return nil
+	}
+	return err
+}
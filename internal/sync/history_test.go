@@ -0,0 +1,107 @@
+package sync
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/state"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestUndo(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	personal := &memoryCalendarService{name: "personal", events: []*calendar.Event{
+		createTestEvent("dentist", "Dentist", start, start.Add(time.Hour), nil),
+		createTestEvent("gym", "Gym", start.Add(3*time.Hour), start.Add(4*time.Hour), nil),
+	}}
+	work := &lookupCalendarService{memoryCalendarService: &memoryCalendarService{name: "work"}}
+	syncClient := &SyncClient{
+		SourceCalendarService:      personal,
+		DestinationCalendarService: work,
+		Location:                   time.UTC,
+		State:                      openTestState(t),
+		DestinationStateKey:        "google/work/primary",
+	}
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	gymBlock := findDestinationEvent(work.events, "gym").Id
+
+	// A bad change moves one block and deletes the other
+	personal.events[0].Start.DateTime = start.Add(time.Hour).Format(time.RFC3339)
+	personal.events[0].End.DateTime = start.Add(2 * time.Hour).Format(time.RFC3339)
+	personal.events = personal.events[:1]
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	history, _ := syncClient.State.History("google/work/primary")
+	if len(history) != 2 || len(history[0].Changes) != 2 || len(history[1].Changes) != 2 {
+		t.Fatalf("Expected 2 runs of 2 changes each, got %+v", history)
+	}
+	patch := history[0].Changes[0]
+	if patch.Kind != state.OperationPatch || !strings.Contains(string(patch.Before), `"summary":"Busy"`) {
+		t.Errorf("Expected the whole block to be kept from before the update, got %s", patch.Before)
+	}
+
+	if _, err := syncClient.Undo(0, true); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(work.blocks()) != 1 {
+		t.Fatalf("Expected a dry run not to change anything, got %d blocks", len(work.blocks()))
+	}
+
+	run, err := syncClient.Undo(0, false)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if run.ID != history[0].ID {
+		t.Errorf("Expected the latest run to be undone, got run %d", run.ID)
+	}
+	dentist, gym := findDestinationEvent(work.events, "dentist"), findDestinationEvent(work.events, "gym")
+	if dentist == nil || !sameEventTime(dentist.Start, eventDateTime(start, time.UTC)) {
+		t.Errorf("Expected the dentist block to move back, got %+v", dentist)
+	}
+	if gym == nil || gym.Id != gymBlock {
+		t.Errorf("Expected the gym block to come back with its ID, got %+v", gym)
+	}
+	if blocks, _, _ := syncClient.State.Blocks("google/work/primary"); len(blocks) != 2 {
+		t.Errorf("Expected the state to follow the undo, got %d blocks", len(blocks))
+	}
+
+	if _, err := syncClient.Undo(run.ID, false); err == nil || !strings.Contains(err.Error(), "already undone") {
+		t.Errorf("Expected a run not to be undone twice, got %v", err)
+	}
+
+	// The undo is a run of its own
+	history, _ = syncClient.State.History("google/work/primary")
+	if history[0].Command != "undo 2" || history[1].UndoneBy != history[0].ID {
+		t.Fatalf("Expected the undo to be recorded, got %+v and %+v", history[0], history[1])
+	}
+	if _, err := syncClient.Undo(history[0].ID, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(work.blocks()) != 1 || findDestinationEvent(work.events, "gym") != nil {
+		t.Errorf("Expected undoing the undo to delete the gym block again, got %d blocks", len(work.blocks()))
+	}
+}
+
+func TestUndoOtherCalendar(t *testing.T) {
+	syncClient := &SyncClient{
+		SourceCalendarService:      &memoryCalendarService{name: "personal"},
+		DestinationCalendarService: &memoryCalendarService{name: "work"},
+		State:                      openTestState(t),
+		DestinationStateKey:        "google/work/primary",
+	}
+	runId, _ := syncClient.State.BeginRun("google/other/primary", "sync")
+	if _, err := syncClient.Undo(runId, false); err == nil {
+		t.Error("Expected a run on another calendar not to be undone")
+	}
+	if _, err := syncClient.Undo(0, false); err == nil {
+		t.Error("Expected an error without a run to undo")
+	}
+	if _, err := (&SyncClient{}).Undo(0, false); err == nil {
+		t.Error("Expected an error without a history")
+	}
+}
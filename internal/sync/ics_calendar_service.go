@@ -0,0 +1,193 @@
+package sync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/ics"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Feeds that take longer than this to download fail the sync rather than
+// hanging it
+const icsFetchTimeout = time.Minute
+
+var errICSReadOnly = errors.New("iCalendar sources are read-only")
+
+// icsCalendarService reads events from an iCalendar feed, either a local file
+// or a URL. Feeds fetched over HTTP are cached on disk and only downloaded
+// again when the server says they changed.
+type icsCalendarService struct {
+	source   string
+	client   *http.Client
+	cacheDir string
+	// floating is the zone for times the feed doesn't give one
+	floating *time.Location
+	logger   *slog.Logger
+}
+
+// icsCacheMeta holds the validators of a cached feed.
+type icsCacheMeta struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// NewICSCalendarService returns a read-only source listing the events of an
+// iCalendar file or URL. Downloads are cached in cacheDir. Times without a
+// zone are read in loc, or the system zone if it's nil. Problems with the feed
+// are logged to logger, or slog.Default() if it's nil.
+func NewICSCalendarService(source string, cacheDir string, loc *time.Location, logger *slog.Logger) CalendarEventsService {
+	if strings.HasPrefix(source, "webcal://") {
+		source = "https://" + strings.TrimPrefix(source, "webcal://")
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &icsCalendarService{source: source, client: &http.Client{Timeout: icsFetchTimeout}, cacheDir: cacheDir, floating: loc, logger: logger}
+}
+
+func (c *icsCalendarService) List(calendarId string, startTime time.Time, endTime time.Time, privateProperties map[string]string) ([]*calendar.Event, error) {
+	// Feed events have no private properties, so they never match a filter
+	if len(privateProperties) > 0 {
+		return []*calendar.Event{}, nil
+	}
+	if startTime.IsZero() || endTime.IsZero() {
+		return nil, errors.New("iCalendar sources can only be listed for a bounded time range")
+	}
+
+	body, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	feed, err := ics.ParseCalendar(bytes.NewReader(body), c.floating)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", c.source, err)
+	}
+	for _, warning := range feed.Warnings {
+		c.logger.Warn("Problem reading iCalendar feed", "source", c.source, "detail", warning)
+	}
+
+	events := []*calendar.Event{}
+	for _, event := range feed.Expand(startTime, endTime, startTime.Location()) {
+		if event.Status == ics.StatusCancelled || event.Transparent {
+			continue
+		}
+		events = append(events, icsEvent(event))
+	}
+	return events, nil
+}
+
+func (c *icsCalendarService) Insert(calendarId string, event *calendar.Event) (*calendar.Event, error) {
+	return nil, errICSReadOnly
+}
+
+func (c *icsCalendarService) Patch(calendarId string, eventId string, event *calendar.Event) (*calendar.Event, error) {
+	return nil, errICSReadOnly
+}
+
+func (c *icsCalendarService) Delete(calendarId string, eventId string) error {
+	return errICSReadOnly
+}
+
+// load returns the contents of the feed.
+func (c *icsCalendarService) load() ([]byte, error) {
+	if !strings.HasPrefix(c.source, "http://") && !strings.HasPrefix(c.source, "https://") {
+		return os.ReadFile(strings.TrimPrefix(c.source, "file://"))
+	}
+
+	hash := sha256.Sum256([]byte(c.source))
+	cachePath := filepath.Join(c.cacheDir, hex.EncodeToString(hash[:10]))
+	meta := icsCacheMeta{}
+	cached, err := os.ReadFile(cachePath + ".ics")
+	if err == nil {
+		if metaJson, err := os.ReadFile(cachePath + ".json"); err == nil {
+			_ = json.Unmarshal(metaJson, &meta)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, c.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		return cached, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("unable to fetch %s: %s", c.source, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// A feed that can't be cached still works, it's just downloaded every time
+	meta = icsCacheMeta{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	if err := os.MkdirAll(c.cacheDir, 0700); err != nil {
+		c.logger.Warn("Unable to cache iCalendar feed", "source", c.source, "error", err)
+		return body, nil
+	}
+	metaJson, _ := json.Marshal(meta)
+	if err := os.WriteFile(cachePath+".ics", body, 0600); err != nil {
+		c.logger.Warn("Unable to cache iCalendar feed", "source", c.source, "error", err)
+	} else if err := os.WriteFile(cachePath+".json", metaJson, 0600); err != nil {
+		c.logger.Warn("Unable to cache iCalendar feed", "source", c.source, "error", err)
+	}
+	return body, nil
+}
+
+// icsEvent converts a feed event into a calendar event. Instances of recurring
+// events are keyed by their UID and original start, so each gets its own block.
+func icsEvent(event *ics.Event) *calendar.Event {
+	id := event.UID
+	if !event.RecurrenceID.IsZero() {
+		if event.AllDay {
+			id += "_" + event.RecurrenceID.Format("20060102")
+		} else {
+			id += "_" + event.RecurrenceID.UTC().Format("20060102T150405Z")
+		}
+	}
+
+	result := &calendar.Event{
+		Id:          id,
+		Summary:     event.Summary,
+		Description: event.Description,
+		Location:    event.Location,
+	}
+	if event.AllDay {
+		result.Start = &calendar.EventDateTime{Date: event.Start.Format(time.DateOnly)}
+		result.End = &calendar.EventDateTime{Date: event.End.Format(time.DateOnly)}
+	} else {
+		result.Start = &calendar.EventDateTime{DateTime: event.Start.Format(time.RFC3339)}
+		result.End = &calendar.EventDateTime{DateTime: event.End.Format(time.RFC3339)}
+	}
+	return result
+}
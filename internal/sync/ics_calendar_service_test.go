@@ -0,0 +1,212 @@
+package sync
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFeed = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:practice@league.example\r\n" +
+	"DTSTART;TZID=America/New_York:20260303T180000\r\n" +
+	"DTEND;TZID=America/New_York:20260303T190000\r\n" +
+	"RRULE:FREQ=WEEKLY;COUNT=3\r\n" +
+	"SUMMARY:Practice\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:tournament@league.example\r\n" +
+	"DTSTART;VALUE=DATE:20260307\r\n" +
+	"DTEND;VALUE=DATE:20260309\r\n" +
+	"SUMMARY:Tournament\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:cancelled@league.example\r\n" +
+	"DTSTART:20260304T150000Z\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:free@league.example\r\n" +
+	"DTSTART:20260305T150000Z\r\n" +
+	"TRANSP:TRANSPARENT\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func testFeedWindow() (time.Time, time.Time) {
+	return time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)
+}
+
+func TestICSCalendarServiceFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "league.ics")
+	if err := os.WriteFile(path, []byte(testFeed), 0600); err != nil {
+		t.Fatal(err)
+	}
+	service := NewICSCalendarService(path, t.TempDir(), time.UTC, nil)
+
+	start, end := testFeedWindow()
+	events, err := service.List(defaultCalendar, start, end, nil)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	ids := []string{}
+	for _, event := range events {
+		ids = append(ids, event.Id)
+	}
+	expected := []string{
+		"practice@league.example_20260303T230000Z",
+		"tournament@league.example",
+		"practice@league.example_20260310T220000Z",
+	}
+	if strings.Join(ids, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected %v, got %v", expected, ids)
+	}
+
+	if events[0].Summary != "Practice" || events[0].Start.DateTime != "2026-03-03T18:00:00-05:00" || events[0].End.DateTime != "2026-03-03T19:00:00-05:00" {
+		t.Errorf("Unexpected event %s %s - %s", events[0].Summary, events[0].Start.DateTime, events[0].End.DateTime)
+	}
+	if events[1].Start.Date != "2026-03-07" || events[1].End.Date != "2026-03-09" || events[1].Start.DateTime != "" {
+		t.Errorf("Expected an all-day event, got %+v - %+v", events[1].Start, events[1].End)
+	}
+}
+
+func TestICSCalendarServiceFiltersPrivateProperties(t *testing.T) {
+	service := NewICSCalendarService("does-not-exist.ics", t.TempDir(), time.UTC, nil)
+
+	start, end := testFeedWindow()
+	events, err := service.List(defaultCalendar, start, end, map[string]string{appName: propertyAppNameValue})
+	if err != nil || len(events) != 0 {
+		t.Errorf("Expected no events without reading the feed, got %v (%v)", events, err)
+	}
+}
+
+func TestICSCalendarServiceIsReadOnly(t *testing.T) {
+	service := NewICSCalendarService("league.ics", t.TempDir(), time.UTC, nil)
+
+	if _, err := service.Insert(defaultCalendar, createTestEvent("1", "Test", time.Now(), time.Now(), nil)); err != errICSReadOnly {
+		t.Errorf("Expected Insert to fail, got %v", err)
+	}
+	if err := service.Delete(defaultCalendar, "1"); err != errICSReadOnly {
+		t.Errorf("Expected Delete to fail, got %v", err)
+	}
+}
+
+func TestICSCalendarServiceHTTPCaching(t *testing.T) {
+	requests := 0
+	fullResponses := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 2 && r.Header.Get("If-None-Match") != `"v1"` {
+			t.Errorf("Expected the cached ETag to be sent, got %q", r.Header.Get("If-None-Match"))
+		}
+		if requests == 2 && r.Header.Get("If-Modified-Since") != "Sun, 01 Mar 2026 00:00:00 GMT" {
+			t.Errorf("Expected the cached Last-Modified to be sent, got %q", r.Header.Get("If-Modified-Since"))
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fullResponses++
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Sun, 01 Mar 2026 00:00:00 GMT")
+		w.Write([]byte(testFeed))
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	start, end := testFeedWindow()
+	for i := 0; i < 2; i++ {
+		// A new service each time, like separate runs of the sync command
+		service := NewICSCalendarService(server.URL+"/league.ics", cacheDir, time.UTC, nil)
+		events, err := service.List(defaultCalendar, start, end, nil)
+		if err != nil {
+			t.Fatalf("Function returned error: %v", err)
+		}
+		if len(events) != 3 {
+			t.Errorf("Run %d: expected 3 events, got %d", i+1, len(events))
+		}
+	}
+
+	if requests != 2 || fullResponses != 1 {
+		t.Errorf("Expected the second run to reuse the cached feed, got %d requests and %d downloads", requests, fullResponses)
+	}
+}
+
+func TestICSCalendarServiceHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusNotFound)
+	}))
+	defer server.Close()
+	service := NewICSCalendarService(server.URL, t.TempDir(), time.UTC, nil)
+
+	start, end := testFeedWindow()
+	if _, err := service.List(defaultCalendar, start, end, nil); err == nil {
+		t.Error("Expected an error for a missing feed")
+	}
+}
+
+func TestICSCalendarServiceHTTPTimeout(t *testing.T) {
+	hang := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer server.Close()
+	defer close(hang)
+	service := NewICSCalendarService(server.URL, t.TempDir(), time.UTC, nil).(*icsCalendarService)
+	if service.client.Timeout != icsFetchTimeout {
+		t.Errorf("Expected downloads to time out after %s, got %s", icsFetchTimeout, service.client.Timeout)
+	}
+
+	service.client.Timeout = 50 * time.Millisecond
+	start, end := testFeedWindow()
+	if _, err := service.List(defaultCalendar, start, end, nil); err == nil {
+		t.Error("Expected an error for a feed that never arrives")
+	}
+}
+
+func TestNewICSCalendarServiceWebcal(t *testing.T) {
+	service := NewICSCalendarService("webcal://example.com/league.ics", t.TempDir(), nil, nil).(*icsCalendarService)
+
+	if service.source != "https://example.com/league.ics" || service.floating != time.Local {
+		t.Errorf("Unexpected service %+v", service)
+	}
+}
+
+func TestICSSourceSync(t *testing.T) {
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1)
+	feed := "BEGIN:VCALENDAR\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:dentist\r\n" +
+		"DTSTART:" + tomorrow.Format("20060102") + "T150000Z\r\n" +
+		"DURATION:PT1H\r\n" +
+		"SUMMARY:Dentist\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	path := filepath.Join(t.TempDir(), "personal.ics")
+	if err := os.WriteFile(path, []byte(feed), 0600); err != nil {
+		t.Fatal(err)
+	}
+	destination := &MockCalendarEventsService{filterPrivateProperties: true}
+	syncClient := &SyncClient{
+		SourceCalendarService:      NewICSCalendarService(path, t.TempDir(), time.UTC, nil),
+		DestinationCalendarService: destination,
+		Location:                   time.UTC,
+	}
+
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if len(destination.insertedEvents) != 1 {
+		t.Fatalf("Expected 1 block, got %d", len(destination.insertedEvents))
+	}
+	block := destination.insertedEvents[0]
+	if block.ExtendedProperties.Private[sourceEventIdPropertyKey] != "dentist" || block.Summary == "Dentist" {
+		t.Errorf("Unexpected block %+v", block)
+	}
+}
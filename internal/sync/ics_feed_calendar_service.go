@@ -0,0 +1,256 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/ics"
+	"google.golang.org/api/calendar/v3"
+)
+
+// icsFeedCalendarService is a destination that publishes blocks as an
+// iCalendar file, for calendars that can only subscribe to a URL. The blocks
+// and their properties are kept in a state file next to the feed, so the feed
+// itself only carries anonymous busy times.
+type icsFeedCalendarService struct {
+	file      string
+	stateFile string
+	events    []*calendar.Event
+	// now is when the feed was last written, stubbed in tests
+	now func() time.Time
+}
+
+// NewICSFeedCalendarService returns a destination writing its blocks to file,
+// picking up the blocks of previous runs from its state file.
+func NewICSFeedCalendarService(file string) (CalendarEventsService, error) {
+	c := &icsFeedCalendarService{file: file, stateFile: file + ".json", events: []*calendar.Event{}, now: time.Now}
+
+	state, err := os.ReadFile(c.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(state, &c.events); err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", c.stateFile, err)
+	}
+	return c, nil
+}
+
+func (c *icsFeedCalendarService) List(calendarId string, startTime time.Time, endTime time.Time, privateProperties map[string]string) ([]*calendar.Event, error) {
+	events := []*calendar.Event{}
+	for _, event := range c.events {
+		if !hasPrivateProperties(event, privateProperties) {
+			continue
+		}
+		start, end, _, ok := eventTimes(event, startTime.Location())
+		if !ok {
+			continue
+		}
+		if !startTime.IsZero() && !end.After(startTime) || !endTime.IsZero() && !start.Before(endTime) {
+			continue
+		}
+		events = append(events, copyEvent(event))
+	}
+	return events, nil
+}
+
+func (c *icsFeedCalendarService) Insert(calendarId string, event *calendar.Event) (*calendar.Event, error) {
+	inserted := copyEvent(event)
+	inserted.Id = c.newEventId(event)
+	inserted.Status = "confirmed"
+	inserted.Updated = c.now().UTC().Format(time.RFC3339)
+	c.events = append(c.events, inserted)
+	return copyEvent(inserted), c.save()
+}
+
+func (c *icsFeedCalendarService) Patch(calendarId string, eventId string, patch *calendar.Event) (*calendar.Event, error) {
+	i := slices.IndexFunc(c.events, func(event *calendar.Event) bool { return event.Id == eventId })
+	if i < 0 {
+		return nil, fmt.Errorf("event %s not found", eventId)
+	}
+
+	event := c.events[i]
+	if patch.Summary != "" {
+		event.Summary = patch.Summary
+	}
+	if patch.Description != "" {
+		event.Description = patch.Description
+	}
+	if patch.ColorId != "" {
+		event.ColorId = patch.ColorId
+	}
+	if patch.Start != nil {
+		event.Start = patch.Start
+	}
+	if patch.End != nil {
+		event.End = patch.End
+	}
+	if patch.Recurrence != nil {
+		event.Recurrence = patch.Recurrence
+	}
+	if patch.ExtendedProperties != nil {
+		if event.ExtendedProperties == nil {
+			event.ExtendedProperties = &calendar.EventExtendedProperties{}
+		}
+		if event.ExtendedProperties.Private == nil {
+			event.ExtendedProperties.Private = map[string]string{}
+		}
+		for key, value := range patch.ExtendedProperties.Private {
+			event.ExtendedProperties.Private[key] = value
+		}
+	}
+	event.Updated = c.now().UTC().Format(time.RFC3339)
+	return copyEvent(event), c.save()
+}
+
+func (c *icsFeedCalendarService) Delete(calendarId string, eventId string) error {
+	i := slices.IndexFunc(c.events, func(event *calendar.Event) bool { return event.Id == eventId })
+	if i < 0 {
+		return fmt.Errorf("event %s not found", eventId)
+	}
+	c.events = slices.Delete(c.events, i, i+1)
+	return c.save()
+}
+
+// newEventId derives the ID, and so the feed UID, of a block from its source
+// event, so rebuilding the feed doesn't make subscribers see new events.
+func (c *icsFeedCalendarService) newEventId(event *calendar.Event) string {
+	sourceId := ""
+	if event.ExtendedProperties != nil {
+		sourceId = event.ExtendedProperties.Private[sourceEventIdPropertyKey]
+	}
+
+	// Blocks split around busy time share a source event, so they also need
+	// their times to be told apart
+	keys := []string{sourceId, sourceId + "/" + eventTimeKey(event.Start) + "/" + eventTimeKey(event.End)}
+	for _, key := range keys {
+		hash := sha256.Sum256([]byte(key))
+		id := hex.EncodeToString(hash[:16])
+		if !slices.ContainsFunc(c.events, func(existing *calendar.Event) bool { return existing.Id == id }) {
+			return id
+		}
+	}
+	return fmt.Sprintf("%s-%d", keys[1], c.now().UnixNano())
+}
+
+func eventTimeKey(eventTime *calendar.EventDateTime) string {
+	if eventTime == nil {
+		return ""
+	}
+	return eventTime.DateTime + eventTime.Date
+}
+
+// save writes the state file and the feed. Each file is replaced in one go, so
+// a subscriber never reads half a feed.
+func (c *icsFeedCalendarService) save() error {
+	state, err := json.MarshalIndent(c.events, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.stateFile, state, 0600); err != nil {
+		return err
+	}
+
+	feed, err := os.CreateTemp(filepath.Dir(c.file), ".busy-*.ics")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(feed.Name())
+	if err := ics.Encode(feed, c.feed()); err != nil {
+		feed.Close()
+		return err
+	}
+	if err := feed.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(feed.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(feed.Name(), c.file)
+}
+
+// feed renders the blocks as a VCALENDAR. Only their times and titles are
+// published.
+func (c *icsFeedCalendarService) feed() *ics.Component {
+	root := &ics.Component{
+		Name: "VCALENDAR",
+		Properties: []*ics.Property{
+			{Name: "VERSION", Value: "2.0"},
+			{Name: "PRODID", Value: "-//gcal-busy-blocker//busy blocks//EN"},
+			{Name: "CALSCALE", Value: "GREGORIAN"},
+			{Name: "X-WR-CALNAME", Value: "Busy"},
+		},
+	}
+
+	stamp := ics.FormatDateTime(c.now())
+	for _, event := range c.events {
+		start, end, allDay, ok := eventTimes(event, time.UTC)
+		if !ok {
+			continue
+		}
+
+		vevent := &ics.Component{
+			Name: "VEVENT",
+			Properties: []*ics.Property{
+				{Name: "UID", Value: event.Id + "@gcal-busy-blocker"},
+				{Name: "DTSTAMP", Value: stamp},
+			},
+		}
+		if allDay {
+			vevent.Properties = append(vevent.Properties,
+				&ics.Property{Name: "DTSTART", Params: map[string]string{"VALUE": "DATE"}, Value: ics.FormatDate(start)},
+				&ics.Property{Name: "DTEND", Params: map[string]string{"VALUE": "DATE"}, Value: ics.FormatDate(end)},
+			)
+		} else {
+			vevent.Properties = append(vevent.Properties,
+				&ics.Property{Name: "DTSTART", Value: ics.FormatDateTime(start)},
+				&ics.Property{Name: "DTEND", Value: ics.FormatDateTime(end)},
+			)
+		}
+		if updated, err := time.Parse(time.RFC3339, event.Updated); err == nil {
+			vevent.Properties = append(vevent.Properties, &ics.Property{Name: "LAST-MODIFIED", Value: ics.FormatDateTime(updated)})
+		}
+		vevent.Properties = append(vevent.Properties,
+			&ics.Property{Name: "SUMMARY", Value: ics.EscapeText(event.Summary)},
+			&ics.Property{Name: "TRANSP", Value: "OPAQUE"},
+			&ics.Property{Name: "CLASS", Value: "PRIVATE"},
+		)
+		root.Components = append(root.Components, vevent)
+	}
+	return root
+}
+
+func copyEvent(event *calendar.Event) *calendar.Event {
+	data, _ := json.Marshal(event)
+	copied := &calendar.Event{}
+	_ = json.Unmarshal(data, copied)
+	return copied
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
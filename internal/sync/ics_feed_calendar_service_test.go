@@ -0,0 +1,169 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/ics"
+	"google.golang.org/api/calendar/v3"
+)
+
+func newTestFeedService(t *testing.T, file string) *icsFeedCalendarService {
+	t.Helper()
+	service, err := NewICSFeedCalendarService(file)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	feed := service.(*icsFeedCalendarService)
+	feed.now = func() time.Time { return time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC) }
+	return feed
+}
+
+func readTestFeed(t *testing.T, file string) *ics.Calendar {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("Feed wasn't written: %v", err)
+	}
+	defer f.Close()
+	feed, err := ics.ParseCalendar(f, time.UTC)
+	if err != nil {
+		t.Fatalf("Feed isn't valid: %v", err)
+	}
+	return feed
+}
+
+func TestICSFeedCalendarService(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "busy.ics")
+	service := newTestFeedService(t, file)
+
+	sourceEvent := createTestEvent("secret-meeting", "Interview with Acme, Inc.", time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC), time.Date(2026, 3, 10, 10, 0, 0, 0, time.UTC), nil)
+	inserted, err := service.Insert(defaultCalendar, createDestinationEvent(sourceEvent, time.UTC))
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	allDay := &calendar.Event{
+		Summary:            "Busy",
+		Start:              &calendar.EventDateTime{Date: "2026-03-12"},
+		End:                &calendar.EventDateTime{Date: "2026-03-13"},
+		ExtendedProperties: &calendar.EventExtendedProperties{Private: map[string]string{appName: propertyAppNameValue, sourceEventIdPropertyKey: "vacation"}},
+	}
+	if _, err := service.Insert(defaultCalendar, allDay); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	feed := readTestFeed(t, file)
+	if len(feed.Events) != 2 {
+		t.Fatalf("Expected 2 events in the feed, got %d", len(feed.Events))
+	}
+	event := feed.Events[0]
+	if event.UID != inserted.Id+"@gcal-busy-blocker" || event.Summary != "Busy" || !event.Start.Equal(time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected feed event %+v", event)
+	}
+	if !feed.Events[1].AllDay || feed.Events[1].Start.Format(time.DateOnly) != "2026-03-12" {
+		t.Errorf("Expected an all-day feed event, got %+v", feed.Events[1])
+	}
+
+	content, _ := os.ReadFile(file)
+	for _, secret := range []string{"secret-meeting", "Acme", "vacation"} {
+		if strings.Contains(string(content), secret) {
+			t.Errorf("The feed leaks %q", secret)
+		}
+	}
+}
+
+func TestICSFeedCalendarServiceStableIds(t *testing.T) {
+	dir := t.TempDir()
+	sourceEvent := createTestEvent("standup", "Standup", time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC), time.Date(2026, 3, 10, 9, 15, 0, 0, time.UTC), nil)
+
+	first, _ := newTestFeedService(t, filepath.Join(dir, "first.ics")).Insert(defaultCalendar, createDestinationEvent(sourceEvent, time.UTC))
+	second, _ := newTestFeedService(t, filepath.Join(dir, "second.ics")).Insert(defaultCalendar, createDestinationEvent(sourceEvent, time.UTC))
+	if first.Id != second.Id {
+		t.Error("A block for the same source event should always get the same UID")
+	}
+
+	// Split blocks share a source event but still need their own IDs
+	service := newTestFeedService(t, filepath.Join(dir, "split.ics"))
+	sourceEvent.End.DateTime = "2026-03-10T09:05:00Z"
+	a, _ := service.Insert(defaultCalendar, createDestinationEvent(sourceEvent, time.UTC))
+	sourceEvent.Start.DateTime = "2026-03-10T09:10:00Z"
+	sourceEvent.End.DateTime = "2026-03-10T09:15:00Z"
+	b, _ := service.Insert(defaultCalendar, createDestinationEvent(sourceEvent, time.UTC))
+	if a.Id == b.Id {
+		t.Error("Expected split blocks to get different IDs")
+	}
+}
+
+func TestICSFeedCalendarServiceState(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "busy.ics")
+	service := newTestFeedService(t, file)
+	sourceEvent := createTestEvent("dentist", "Dentist", time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC), time.Date(2026, 3, 10, 10, 0, 0, 0, time.UTC), nil)
+	inserted, _ := service.Insert(defaultCalendar, createDestinationEvent(sourceEvent, time.UTC))
+	other, _ := service.Insert(defaultCalendar, createDestinationEvent(createTestEvent("gym", "Gym", time.Date(2026, 4, 10, 9, 0, 0, 0, time.UTC), time.Date(2026, 4, 10, 10, 0, 0, 0, time.UTC), nil), time.UTC))
+
+	// A later run picks up the blocks from the state file
+	reloaded := newTestFeedService(t, file)
+	events, err := reloaded.List(defaultCalendar, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), map[string]string{sourceEventIdPropertyKey: "dentist"})
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(events) != 1 || events[0].Id != inserted.Id {
+		t.Fatalf("Expected the dentist block, got %v", events)
+	}
+
+	patched, err := reloaded.Patch(defaultCalendar, inserted.Id, &calendar.Event{Summary: "Out", Start: &calendar.EventDateTime{DateTime: "2026-03-10T11:00:00Z"}, End: &calendar.EventDateTime{DateTime: "2026-03-10T12:00:00Z"}})
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if patched.Summary != "Out" || patched.Start.DateTime != "2026-03-10T11:00:00Z" || patched.ExtendedProperties.Private[sourceEventIdPropertyKey] != "dentist" {
+		t.Errorf("Unexpected patched event %+v", patched)
+	}
+
+	if err := reloaded.Delete(defaultCalendar, other.Id); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if err := reloaded.Delete(defaultCalendar, other.Id); err == nil {
+		t.Error("Expected deleting a missing event to fail")
+	}
+
+	feed := readTestFeed(t, file)
+	if len(feed.Events) != 1 || feed.Events[0].Summary != "Out" || feed.Events[0].Start.Hour() != 11 {
+		t.Errorf("Expected the feed to hold the patched block only, got %+v", feed.Events)
+	}
+}
+
+func TestICSFeedDestinationSync(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "busy.ics")
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	source := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestEvent("1", "Therapy", start, start.Add(time.Hour), nil),
+	}}
+
+	for run := 0; run < 2; run++ {
+		destination, err := NewICSFeedCalendarService(file)
+		if err != nil {
+			t.Fatalf("Function returned error: %v", err)
+		}
+		syncClient := &SyncClient{SourceCalendarService: source, DestinationCalendarService: destination, Location: time.UTC}
+		if err := syncClient.RunSync(7, false); err != nil {
+			t.Fatalf("Function returned error: %v", err)
+		}
+	}
+
+	if feed := readTestFeed(t, file); len(feed.Events) != 1 {
+		t.Errorf("Expected a second run to keep the single block, got %d", len(feed.Events))
+	}
+
+	source.events = []*calendar.Event{}
+	destination, _ := NewICSFeedCalendarService(file)
+	syncClient := &SyncClient{SourceCalendarService: source, DestinationCalendarService: destination, Location: time.UTC}
+	if err := syncClient.Clean(false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if feed := readTestFeed(t, file); len(feed.Events) != 0 {
+		t.Errorf("Expected clean to empty the feed, got %d events", len(feed.Events))
+	}
+}
@@ -0,0 +1,84 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"strings"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/state"
+	"google.golang.org/api/calendar/v3"
+)
+
+// blockIdEncoding is the base32hex alphabet Google requires of event IDs.
+var blockIdEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// blockId derives the ID a new block is inserted with from its source event,
+// so inserting it again after a lost response finds the block that was
+// already created instead of duplicating it. Blocks split around busy time
+// share a source event, so their times are part of the ID too.
+func (s *SyncClient) blockId(event *calendar.Event) string {
+	key := appName + "/" + s.sourceCalendar() + "/" + blockSourceId(event)
+	if s.SkipBusy {
+		key += "/" + eventTimeKey(event.Start) + "/" + eventTimeKey(event.End)
+	}
+	hash := sha256.Sum256([]byte(key))
+	return strings.ToLower(blockIdEncoding.EncodeToString(hash[:20]))
+}
+
+// journal records an operation on a block before it's made, and returns the
+// function to call once its outcome is recorded. Operations that are never
+// finished are settled by the next run.
+func (s *SyncClient) journal(kind string, eventId string, sourceId string) func() {
+	if !s.useState() {
+		return func() {}
+	}
+	seq, err := s.State.Begin(s.DestinationStateKey, &state.Operation{Kind: kind, EventId: eventId, SourceId: sourceId})
+	if err != nil {
+		s.dropState(err)
+		return func() {}
+	}
+	return func() {
+		if err := s.State.Finish(s.DestinationStateKey, seq); err != nil {
+			s.dropState(err)
+		}
+	}
+}
+
+// resumeJournal settles the operations an interrupted run left unfinished by
+// looking up what became of their blocks. Returns false when they can't be
+// looked up, and the state has to be rebuilt from the calendar instead.
+func (s *SyncClient) resumeJournal() (bool, error) {
+	pending, err := s.State.Pending(s.DestinationStateKey)
+	if err != nil {
+		return false, err
+	}
+	if len(pending) == 0 {
+		return true, nil
+	}
+
+	s.logger().Info("Resuming the unfinished changes of an interrupted sync", "changes", len(pending))
+	lookup, ok := s.DestinationCalendarService.(EventLookupService)
+	if !ok {
+		return false, nil
+	}
+	for _, operation := range pending {
+		end := s.traceCall(lookup, "Get", s.destinationCalendar(), operation.EventId)
+		event, err := lookup.Get(s.destinationCalendar(), operation.EventId)
+		end(err)
+		if err != nil && !isGone(err) {
+			return false, err
+		}
+		if err == nil && event.Status != eventStatusCancelled && isBusyBlock(event) {
+			err = s.State.Put(s.DestinationStateKey, stateBlock(event))
+		} else {
+			err = s.State.Delete(s.DestinationStateKey, operation.EventId)
+		}
+		if err != nil {
+			return false, err
+		}
+		if err := s.State.Finish(s.DestinationStateKey, operation.Seq); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
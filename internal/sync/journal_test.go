@@ -0,0 +1,206 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/state"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// lookupCalendarService keeps the IDs blocks are inserted with, like Google
+// does, and can lose the response of an insert after making it.
+type lookupCalendarService struct {
+	*memoryCalendarService
+	loseResponses bool
+}
+
+func (l *lookupCalendarService) Insert(calendarId string, event *calendar.Event) (*calendar.Event, error) {
+	inserted := *event
+	l.events = append(l.events, &inserted)
+	if l.loseResponses {
+		return nil, errors.New("connection reset by peer")
+	}
+	return &inserted, nil
+}
+
+func (l *lookupCalendarService) Get(calendarId string, eventId string) (*calendar.Event, error) {
+	for _, event := range l.events {
+		if event.Id == eventId {
+			found := *event
+			return &found, nil
+		}
+	}
+	return nil, &googleapi.Error{Code: http.StatusNotFound}
+}
+
+func TestBlockId(t *testing.T) {
+	start := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	block := createDestinationEvent(createTestEvent("dentist", "Dentist", start, start.Add(time.Hour), nil), time.UTC)
+	moved := createDestinationEvent(createTestEvent("dentist", "Dentist", start.Add(time.Hour), start.Add(2*time.Hour), nil), time.UTC)
+
+	syncClient := &SyncClient{}
+	id := syncClient.blockId(block)
+	if !regexp.MustCompile(`^[0-9a-v]{5,}$`).MatchString(id) {
+		t.Errorf("Expected a valid Google event ID, got %s", id)
+	}
+	if syncClient.blockId(moved) != id {
+		t.Error("Expected the ID to only depend on the source event")
+	}
+	if (&SyncClient{SourceCalendarId: "family"}).blockId(block) == id {
+		t.Error("Expected events of another source calendar to get other IDs")
+	}
+	if (&SyncClient{SkipBusy: true}).blockId(moved) == (&SyncClient{SkipBusy: true}).blockId(block) {
+		t.Error("Expected the parts of a source event to get their own IDs")
+	}
+}
+
+func TestRunSyncResumesInterruptedInsert(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	personal := &memoryCalendarService{name: "personal"}
+	work := &lookupCalendarService{memoryCalendarService: &memoryCalendarService{name: "work"}}
+	syncClient := &SyncClient{
+		SourceCalendarService:      personal,
+		DestinationCalendarService: work,
+		State:                      openTestState(t),
+		DestinationStateKey:        "google/work/primary",
+	}
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	// The block is created, but the response never arrives
+	personal.events = append(personal.events, createTestEvent("dentist", "Dentist", start, start.Add(time.Hour), nil))
+	work.loseResponses = true
+	if err := syncClient.RunSync(7, false); err == nil {
+		t.Fatal("Expected the lost response to fail the sync")
+	}
+	if pending, _ := syncClient.State.Pending("google/work/primary"); len(pending) != 1 {
+		t.Fatalf("Expected the insert to be left in the journal, got %d operations", len(pending))
+	}
+
+	work.loseResponses = false
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(work.blocks()) != 1 {
+		t.Errorf("Expected the resumed sync not to duplicate the block, got %d blocks", len(work.blocks()))
+	}
+	if pending, _ := syncClient.State.Pending("google/work/primary"); len(pending) != 0 {
+		t.Errorf("Expected the journal to be settled, got %d operations", len(pending))
+	}
+	if blocks, _, _ := syncClient.State.Blocks("google/work/primary"); len(blocks) != 1 || blocks[0].SourceId != "dentist" {
+		t.Errorf("Expected the block to be recorded, got %+v", blocks)
+	}
+}
+
+func TestRunSyncRebuildsAfterInterruptionWithoutLookup(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	personal := &memoryCalendarService{name: "personal", events: []*calendar.Event{
+		createTestEvent("dentist", "Dentist", start, start.Add(time.Hour), nil),
+	}}
+	work := &memoryCalendarService{name: "work"}
+	destination := &countingCalendarService{CalendarEventsService: work}
+	syncClient := &SyncClient{
+		SourceCalendarService:      personal,
+		DestinationCalendarService: destination,
+		State:                      openTestState(t),
+		DestinationStateKey:        "google/work/primary",
+	}
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	// An operation that never finished can't be looked up on this calendar
+	syncClient.journal(state.OperationDelete, work.blocks()[0].Id, "dentist")
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if destination.lists != 2 || len(work.blocks()) != 1 {
+		t.Errorf("Expected the calendar to be listed again without changing blocks, got %d listings and %d blocks", destination.lists, len(work.blocks()))
+	}
+	if pending, _ := syncClient.State.Pending("google/work/primary"); len(pending) != 0 {
+		t.Errorf("Expected the rebuild to clear the journal, got %d operations", len(pending))
+	}
+}
+
+func TestCalendarEventsServiceInsertConflict(t *testing.T) {
+	stored := map[string]*calendar.Event{
+		"deleted": {Id: "deleted", Status: "cancelled"},
+		"block":   {Id: "block", ExtendedProperties: &calendar.EventExtendedProperties{Private: map[string]string{appName: propertyAppNameValue}}},
+		"meeting": {Id: "meeting", Summary: "Planning"},
+		"future": {Id: "future", Summary: "Busy", ExtendedProperties: &calendar.EventExtendedProperties{Private: map[string]string{
+			appName: propertyAppNameValue, schemaVersionPropertyKey: strconv.Itoa(currentSchema() + 1),
+		}}},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/calendar/v3/calendars/primary/events")
+		id = strings.TrimPrefix(id, "/")
+		event := &calendar.Event{}
+		if r.Body != nil {
+			json.NewDecoder(r.Body).Decode(event)
+		}
+		switch {
+		case r.Method == http.MethodPost && stored[event.Id] != nil:
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error": {"code": 409, "message": "The requested identifier already exists."}}`))
+		case r.Method == http.MethodPost:
+			stored[event.Id] = event
+			json.NewEncoder(w).Encode(event)
+		case r.Method == http.MethodGet && stored[id] != nil:
+			json.NewEncoder(w).Encode(stored[id])
+		case r.Method == http.MethodPut:
+			event.Id = id
+			stored[id] = event
+			json.NewEncoder(w).Encode(event)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(server.Client()), option.WithEndpoint(server.URL+"/calendar/v3/"))
+	if err != nil {
+		t.Fatalf("unable to create calendar service: %v", err)
+	}
+	events := &calendarEventsService{service: service}
+
+	start := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	for _, id := range []string{"new", "deleted", "block"} {
+		block := createDestinationEvent(createTestEvent("dentist", "Dentist", start, start.Add(time.Hour), nil), time.UTC)
+		block.Id = id
+		inserted, err := events.Insert(defaultCalendar, block)
+		if err != nil {
+			t.Fatalf("Unable to insert %s: %v", id, err)
+		}
+		if inserted.Id != id || stored[id].Status == "cancelled" || !isBusyBlock(stored[id]) {
+			t.Errorf("Expected block %s to be written, got %+v", id, stored[id])
+		}
+	}
+
+	block := createDestinationEvent(createTestEvent("dentist", "Dentist", start, start.Add(time.Hour), nil), time.UTC)
+	block.Id = "meeting"
+	if _, err := events.Insert(defaultCalendar, block); err == nil {
+		t.Error("Expected an event that isn't a block not to be overwritten")
+	}
+	if stored["meeting"].Summary != "Planning" {
+		t.Errorf("Expected the meeting to be left alone, got %+v", stored["meeting"])
+	}
+
+	block.Id = "future"
+	if _, err := events.Insert(defaultCalendar, block); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Expected a block from a newer schema not to be overwritten, got %v", err)
+	}
+	if stored["future"].Summary != "Busy" || stored["future"].Start != nil {
+		t.Errorf("Expected the newer block to be left alone, got %+v", stored["future"])
+	}
+}
@@ -0,0 +1,234 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	gosync "sync"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/audit"
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	leasePropertyKey        = "gcal-busy-blocker-lease"
+	leaseHolderPropertyKey  = "gcal-busy-blocker-lease-holder"
+	leaseExpiresPropertyKey = "gcal-busy-blocker-lease-expires"
+)
+
+var (
+	// leaseDate is the day the lease event is on, far enough in the past to
+	// stay out of every sync window
+	leaseDate = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	// leaseSettleTime is how long a claim is left before checking that no one
+	// else claimed the lease at the same time
+	leaseSettleTime = 2 * time.Second
+	// leasePollInterval is how often a held lease is tried again while waiting
+	leasePollInterval = 5 * time.Second
+)
+
+// LeaseHeldError is returned when a sync elsewhere holds the lease.
+type LeaseHeldError struct {
+	Holder  string
+	Expires time.Time
+}
+
+func (e *LeaseHeldError) Error() string {
+	return fmt.Sprintf("the destination calendar is leased by %s until %s", e.Holder, e.Expires.Local().Format(time.DateTime))
+}
+
+// Lease is a claim on the destination calendar, stored as an event on it so
+// syncs on different machines don't write to it at the same time. It's
+// renewed in the background until it's released.
+type Lease struct {
+	client  *SyncClient
+	holder  string
+	eventId string
+	ttl     time.Duration
+	// stop ends the renewals, and done is closed once they have
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce gosync.Once
+}
+
+// AcquireLease claims the destination calendar for holder until ttl has
+// passed. A lease held elsewhere is tried again until wait has passed, forever
+// if wait is negative, and returns a *LeaseHeldError once it runs out. Leases
+// that expired were left behind by syncs that didn't finish, and are taken
+// over.
+func (s *SyncClient) AcquireLease(holder string, ttl time.Duration, wait time.Duration) (*Lease, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		lease, err := s.tryLease(holder, ttl)
+		var held *LeaseHeldError
+		if !errors.As(err, &held) || (wait >= 0 && !time.Now().Before(deadline)) {
+			return lease, err
+		}
+		time.Sleep(leasePollInterval)
+	}
+}
+
+func (s *SyncClient) tryLease(holder string, ttl time.Duration) (*Lease, error) {
+	leases, err := s.leaseEvents()
+	if err != nil {
+		return nil, err
+	}
+	if err := leaseHeldByOther(leases, holder, time.Now()); err != nil {
+		return nil, err
+	}
+
+	claim := newLeaseEvent(holder, time.Now().Add(ttl))
+	var eventId string
+	if len(leases) > 0 {
+		for _, lease := range leases {
+			if lease.ExtendedProperties.Private[leaseHolderPropertyKey] != holder {
+				s.logger().Warn("Taking over an expired lease", "holder", lease.ExtendedProperties.Private[leaseHolderPropertyKey])
+			}
+		}
+		eventId = leases[0].Id
+		end := s.traceCall(s.DestinationCalendarService, "Patch", s.destinationCalendar(), eventId)
+		_, err := s.DestinationCalendarService.Patch(s.destinationCalendar(), eventId, &calendar.Event{ExtendedProperties: claim.ExtendedProperties})
+		end(err)
+		s.audit(audit.ActionUpdate, eventId, "", err)
+		if err != nil {
+			return nil, fmt.Errorf("unable to claim the lease: %v", err)
+		}
+	} else {
+		end := s.traceCall(s.DestinationCalendarService, "Insert", s.destinationCalendar(), "")
+		inserted, err := s.DestinationCalendarService.Insert(s.destinationCalendar(), claim)
+		end(err)
+		if err != nil {
+			s.audit(audit.ActionInsert, "", "", err)
+			return nil, fmt.Errorf("unable to claim the lease: %v", err)
+		}
+		eventId = inserted.Id
+		s.audit(audit.ActionInsert, eventId, "", nil)
+	}
+
+	// Calendars can't compare and swap, so a claim made elsewhere at the same
+	// time is only seen by reading the leases back
+	time.Sleep(leaseSettleTime)
+	leases, err = s.leaseEvents()
+	if err != nil {
+		return nil, err
+	}
+	if err := leaseHeldByOther(leases, holder, time.Now()); err != nil {
+		if len(leases) > 1 {
+			s.deleteLeaseEvent(eventId)
+		}
+		return nil, err
+	}
+	// Leases that lost out to this one aren't needed anymore
+	for _, lease := range leases {
+		if lease.Id != eventId {
+			s.deleteLeaseEvent(lease.Id)
+		}
+	}
+	lease := &Lease{client: s, holder: holder, eventId: eventId, ttl: ttl, stop: make(chan struct{}), done: make(chan struct{})}
+	go lease.renew()
+	return lease, nil
+}
+
+// renew pushes the expiry of the lease back every half of its ttl, so a sync
+// that runs longer than the ttl isn't taken over.
+func (l *Lease) renew() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.ttl / 2)
+	defer ticker.Stop()
+	s := l.client
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			expires := time.Now().Add(l.ttl)
+			_, err := s.DestinationCalendarService.Patch(s.destinationCalendar(), l.eventId, &calendar.Event{
+				ExtendedProperties: &calendar.EventExtendedProperties{
+					Private: map[string]string{leaseExpiresPropertyKey: expires.UTC().Format(time.RFC3339)},
+				},
+			})
+			s.audit(audit.ActionUpdate, l.eventId, "", err)
+			if err != nil {
+				s.logger().Warn("Unable to renew the lease", "error", err)
+			}
+		}
+	}
+}
+
+// stopRenewing ends the renewals and waits for one under way to finish.
+func (l *Lease) stopRenewing() {
+	l.stopOnce.Do(func() { close(l.stop) })
+	<-l.done
+}
+
+// Release stops renewing the lease and gives it up, unless it was taken over
+// in the meantime.
+func (l *Lease) Release() error {
+	l.stopRenewing()
+	leases, err := l.client.leaseEvents()
+	if err != nil {
+		return err
+	}
+	for _, lease := range leases {
+		if lease.Id == l.eventId && lease.ExtendedProperties.Private[leaseHolderPropertyKey] == l.holder {
+			return l.client.deleteLeaseEvent(l.eventId)
+		}
+	}
+	return nil
+}
+
+func (s *SyncClient) deleteLeaseEvent(eventId string) error {
+	end := s.traceCall(s.DestinationCalendarService, "Delete", s.destinationCalendar(), eventId)
+	err := s.DestinationCalendarService.Delete(s.destinationCalendar(), eventId)
+	end(err)
+	s.audit(audit.ActionDelete, eventId, "", err)
+	return err
+}
+
+// leaseEvents lists the lease events on the destination calendar, ordered by
+// ID so every machine picks the same one when several were created at once.
+func (s *SyncClient) leaseEvents() ([]*calendar.Event, error) {
+	end := s.traceCall(s.DestinationCalendarService, "List", s.destinationCalendar(), "")
+	events, err := s.DestinationCalendarService.List(s.destinationCalendar(), leaseDate, leaseDate.AddDate(0, 0, 1), map[string]string{leasePropertyKey: propertyAppNameValue})
+	end(err, eventCount(len(events)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the lease: %v", err)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Id < events[j].Id })
+	return events, nil
+}
+
+// leaseHeldByOther returns a *LeaseHeldError if the first lease that hasn't
+// expired belongs to someone else.
+func leaseHeldByOther(leases []*calendar.Event, holder string, now time.Time) error {
+	for _, lease := range leases {
+		expires, err := time.Parse(time.RFC3339, lease.ExtendedProperties.Private[leaseExpiresPropertyKey])
+		if err != nil || !expires.After(now) {
+			continue
+		}
+		if leaseHolder := lease.ExtendedProperties.Private[leaseHolderPropertyKey]; leaseHolder != holder {
+			return &LeaseHeldError{Holder: leaseHolder, Expires: expires}
+		}
+		return nil
+	}
+	return nil
+}
+
+func newLeaseEvent(holder string, expires time.Time) *calendar.Event {
+	return &calendar.Event{
+		Summary:      "gcal-busy-blocker lease",
+		Description:  "Keeps syncs on different machines from writing to this calendar at the same time.",
+		Start:        &calendar.EventDateTime{Date: leaseDate.Format(time.DateOnly)},
+		End:          &calendar.EventDateTime{Date: leaseDate.AddDate(0, 0, 1).Format(time.DateOnly)},
+		Transparency: eventTransparencyTransparent,
+		Visibility:   "private",
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{
+				leasePropertyKey:        propertyAppNameValue,
+				leaseHolderPropertyKey:  holder,
+				leaseExpiresPropertyKey: expires.UTC().Format(time.RFC3339),
+			},
+		},
+	}
+}
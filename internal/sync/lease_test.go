@@ -0,0 +1,144 @@
+package sync
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func newLeaseTestClient(t *testing.T) (*SyncClient, *memoryCalendarService) {
+	t.Helper()
+	settle, poll := leaseSettleTime, leasePollInterval
+	leaseSettleTime, leasePollInterval = 0, time.Millisecond
+	t.Cleanup(func() { leaseSettleTime, leasePollInterval = settle, poll })
+
+	work := &memoryCalendarService{name: "work"}
+	return &SyncClient{SourceCalendarService: &memoryCalendarService{name: "personal"}, DestinationCalendarService: work}, work
+}
+
+func TestLease(t *testing.T) {
+	syncClient, work := newLeaseTestClient(t)
+
+	lease, err := syncClient.AcquireLease("laptop/1", time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Unable to acquire the lease: %v", err)
+	}
+	if len(work.events) != 1 || work.events[0].ExtendedProperties.Private[leaseHolderPropertyKey] != "laptop/1" {
+		t.Fatalf("Expected a lease event held by the laptop, got %+v", work.events)
+	}
+
+	_, err = syncClient.AcquireLease("desktop/2", time.Hour, 0)
+	var held *LeaseHeldError
+	if !errors.As(err, &held) || held.Holder != "laptop/1" {
+		t.Fatalf("Expected the lease to be held by the laptop, got %v", err)
+	}
+
+	// Syncs leave the lease alone
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(work.events) != 1 {
+		t.Fatalf("Expected the lease to survive a sync, got %d events", len(work.events))
+	}
+
+	if err := lease.Release(); err != nil {
+		t.Fatalf("Unable to release the lease: %v", err)
+	}
+	if len(work.events) != 0 {
+		t.Errorf("Expected the lease event to be deleted, got %d events", len(work.events))
+	}
+	lease, err = syncClient.AcquireLease("desktop/2", time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Expected the released lease to be free, got %v", err)
+	}
+	lease.Release()
+}
+
+func TestLeaseExpired(t *testing.T) {
+	syncClient, work := newLeaseTestClient(t)
+	stale := newLeaseEvent("crashed/3", time.Now().Add(-time.Minute))
+	stale.Id = "lease"
+	work.events = append(work.events, stale)
+
+	lease, err := syncClient.AcquireLease("laptop/1", time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Expected an expired lease to be taken over, got %v", err)
+	}
+	if len(work.events) != 1 || work.events[0].ExtendedProperties.Private[leaseHolderPropertyKey] != "laptop/1" {
+		t.Errorf("Expected the lease event to be claimed, got %+v", work.events)
+	}
+
+	// A lease taken over by someone else isn't released from under them
+	work.events[0].ExtendedProperties = newLeaseEvent("desktop/2", time.Now().Add(time.Hour)).ExtendedProperties
+	lease.Release()
+	if len(work.events) != 1 {
+		t.Error("Expected a lease held by someone else not to be released")
+	}
+}
+
+func TestLeaseWait(t *testing.T) {
+	syncClient, work := newLeaseTestClient(t)
+	held := newLeaseEvent("desktop/2", time.Now().Add(50*time.Millisecond))
+	held.Id = "lease"
+	work.events = append(work.events, held)
+
+	lease, err := syncClient.AcquireLease("laptop/1", time.Hour, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Expected to get the lease once it expired, got %v", err)
+	}
+	lease.Release()
+}
+
+func TestLeaseRenewed(t *testing.T) {
+	syncClient, work := newLeaseTestClient(t)
+
+	acquired := time.Now()
+	lease, err := syncClient.AcquireLease("laptop/1", 2*time.Second, 0)
+	if err != nil {
+		t.Fatalf("Unable to acquire the lease: %v", err)
+	}
+
+	// The lease is renewed after half its ttl. Expiries are kept to the
+	// second, so a renewed one is at least a second past the claimed one.
+	time.Sleep(1500 * time.Millisecond)
+	lease.stopRenewing()
+	renewed, err := time.Parse(time.RFC3339, work.events[0].ExtendedProperties.Private[leaseExpiresPropertyKey])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if minimum := acquired.Add(3 * time.Second).Truncate(time.Second); renewed.Before(minimum) {
+		t.Errorf("Expected the lease to be renewed until %s or later, got %s", minimum, renewed)
+	}
+
+	if err := lease.Release(); err != nil {
+		t.Fatalf("Unable to release the lease: %v", err)
+	}
+	if len(work.events) != 0 {
+		t.Errorf("Expected the lease event to be deleted, got %d events", len(work.events))
+	}
+}
+
+func TestLeaseHeldByOther(t *testing.T) {
+	now := time.Now()
+	lease := func(id string, holder string, expires time.Time) *calendar.Event {
+		event := newLeaseEvent(holder, expires)
+		event.Id = id
+		return event
+	}
+
+	// Claims made at the same time go to the lease with the lowest ID
+	leases := []*calendar.Event{lease("a", "laptop/1", now.Add(time.Hour)), lease("b", "desktop/2", now.Add(time.Hour))}
+	if err := leaseHeldByOther(leases, "laptop/1", now); err != nil {
+		t.Errorf("Expected the laptop to win, got %v", err)
+	}
+	if err := leaseHeldByOther(leases, "desktop/2", now); err == nil {
+		t.Error("Expected the desktop to lose")
+	}
+
+	leases[0] = lease("a", "laptop/1", now.Add(-time.Hour))
+	if err := leaseHeldByOther(leases, "desktop/2", now); err != nil {
+		t.Errorf("Expected an expired lease not to count, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/audit"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Markers set on a single source event override the rules for that event
+const (
+	MarkerBlock   = "block"
+	MarkerNoBlock = "noblock"
+
+	markerPropertyKey = "gcal-busy-blocker-marker"
+)
+
+var descriptionMarker = regexp.MustCompile(`(?i)(?:^|[^\w#])#(noblock|block)\b`)
+
+// eventMarker returns the marker set on a source event, either as a private
+// property or as a #block or #noblock tag in its description.
+func eventMarker(event *calendar.Event) string {
+	if event.ExtendedProperties != nil {
+		switch event.ExtendedProperties.Private[markerPropertyKey] {
+		case MarkerBlock:
+			return MarkerBlock
+		case MarkerNoBlock:
+			return MarkerNoBlock
+		}
+	}
+
+	marker := ""
+	for _, match := range descriptionMarker.FindAllStringSubmatch(event.Description, -1) {
+		// When both tags are present, err on the side of not blocking
+		if marker != MarkerNoBlock {
+			marker = strings.ToLower(match[1])
+		}
+	}
+	return marker
+}
+
+// excludes reports whether a source event should not be blocked, taking the
+// event's own marker over the rules.
+func (s *SyncClient) excludes(event *calendar.Event) bool {
+	switch eventMarker(event) {
+	case MarkerBlock:
+		return false
+	case MarkerNoBlock:
+		return true
+	}
+	return s.Rules.Excludes(event, s.sourceCalendar(), s.location())
+}
+
+// MarkSourceEvent sets the marker on a source event, or clears it when marker
+// is empty. This needs an account authorized with write access.
+func (s *SyncClient) MarkSourceEvent(eventId string, marker string) error {
+	if marker != "" && marker != MarkerBlock && marker != MarkerNoBlock {
+		return fmt.Errorf("unknown marker %q", marker)
+	}
+
+	// An empty value is treated the same as no marker at all
+	patch := &calendar.Event{
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{markerPropertyKey: marker},
+		},
+	}
+	end := s.traceCall(s.SourceCalendarService, "Patch", s.sourceCalendar(), eventId)
+	_, err := s.SourceCalendarService.Patch(s.sourceCalendar(), eventId, patch)
+	end(err)
+	// The event is a source event, so only the hash of its ID is logged
+	s.reversed().audit(audit.ActionUpdate, "", eventId, err)
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden {
+		return fmt.Errorf("the source account can't modify events, run 'login source --write' to allow it: %v", err)
+	}
+	if err != nil {
+		return fmt.Errorf("error marking event %s: %v", eventId, err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package sync
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/audit"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+func TestEventMarker(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  *calendar.Event
+		marker string
+	}{
+		{"no marker", &calendar.Event{Description: "Lunch with Sam"}, ""},
+		{"noblock tag", &calendar.Event{Description: "Lunch, happy to skip #noblock"}, MarkerNoBlock},
+		{"block tag", &calendar.Event{Description: "#block\nDoctor"}, MarkerBlock},
+		{"tag in html", &calendar.Event{Description: "Lunch<br>#NoBlock"}, MarkerNoBlock},
+		{"longer word", &calendar.Event{Description: "#blocked by traffic"}, ""},
+		{"hashtag inside word", &calendar.Event{Description: "see thread##block"}, ""},
+		{"both tags", &calendar.Event{Description: "#block #noblock"}, MarkerNoBlock},
+		{"property", &calendar.Event{ExtendedProperties: &calendar.EventExtendedProperties{Private: map[string]string{markerPropertyKey: MarkerBlock}}}, MarkerBlock},
+		{
+			"property wins over description",
+			&calendar.Event{
+				Description:        "#noblock",
+				ExtendedProperties: &calendar.EventExtendedProperties{Private: map[string]string{markerPropertyKey: MarkerBlock}},
+			},
+			MarkerBlock,
+		},
+		{
+			"cleared property",
+			&calendar.Event{
+				Description:        "#noblock",
+				ExtendedProperties: &calendar.EventExtendedProperties{Private: map[string]string{markerPropertyKey: ""}},
+			},
+			MarkerNoBlock,
+		},
+	}
+
+	for _, test := range tests {
+		if marker := eventMarker(test.event); marker != test.marker {
+			t.Errorf("%s: expected marker %q, got %q", test.name, test.marker, marker)
+		}
+	}
+}
+
+func TestRunSyncHonorsMarkers(t *testing.T) {
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	skipped := createTestEvent("lunch", "Lunch", start, start.Add(time.Hour), nil)
+	skipped.Description = "#noblock"
+	forced := createTestEvent("soccer", "Soccer", start, start.Add(time.Hour), map[string]string{markerPropertyKey: MarkerBlock})
+
+	mockSourceService := &MockCalendarEventsService{events: []*calendar.Event{skipped, forced}}
+	mockDestinationService := &MockCalendarEventsService{}
+	syncClient := &SyncClient{
+		SourceCalendarService:      mockSourceService,
+		DestinationCalendarService: mockDestinationService,
+		Rules: &Rules{Rules: []*Rule{
+			{Name: "no sports", Match: RuleMatch{Title: "Soccer"}, Action: ActionExclude},
+		}},
+	}
+
+	err := syncClient.RunSync(30, false)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if len(mockDestinationService.insertedEvents) != 1 {
+		t.Fatalf("Expected 1 inserted event, got %d", len(mockDestinationService.insertedEvents))
+	}
+	if mockDestinationService.insertedEvents[0].ExtendedProperties.Private[sourceEventIdPropertyKey] != "soccer" {
+		t.Error("Expected the marked event to be blocked despite the rule excluding it")
+	}
+}
+
+func TestMarkSourceEvent(t *testing.T) {
+	mockSourceService := &MockCalendarEventsService{}
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	syncClient := &SyncClient{
+		SourceCalendarService:      mockSourceService,
+		DestinationCalendarService: &MockCalendarEventsService{},
+		Audit:                      audit.Open(path),
+	}
+
+	err := syncClient.MarkSourceEvent("123", MarkerNoBlock)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	patch := mockSourceService.patchedEvents["123"]
+	if patch == nil || patch.ExtendedProperties.Private[markerPropertyKey] != MarkerNoBlock {
+		t.Error("Expected the marker property to be set on the source event")
+	}
+
+	err = syncClient.MarkSourceEvent("123", "")
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	patch = mockSourceService.patchedEvents["123"]
+	if value, ok := patch.ExtendedProperties.Private[markerPropertyKey]; !ok || value != "" {
+		t.Error("Expected clearing to blank the marker property")
+	}
+
+	if err := syncClient.MarkSourceEvent("123", "maybe"); err == nil {
+		t.Error("Expected an error for an unknown marker")
+	}
+
+	entries, err := syncClient.Audit.Read(time.Time{})
+	if err != nil {
+		t.Fatalf("Unable to read audit log: %v", err)
+	}
+	if len(entries) != 2 || entries[0].SourceIdHash != audit.HashSourceId("123") {
+		t.Errorf("Expected both marks to be logged with the hash of the event, got %+v", entries)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), `"123"`) {
+		t.Errorf("Expected the audit log to leave out the source event's ID, got %s", data)
+	}
+}
+
+func TestMarkSourceEventReadOnlyAccount(t *testing.T) {
+	mockSourceService := &MockCalendarEventsService{patchError: &googleapi.Error{Code: http.StatusForbidden}}
+	syncClient := &SyncClient{
+		SourceCalendarService:      mockSourceService,
+		DestinationCalendarService: &MockCalendarEventsService{},
+	}
+
+	err := syncClient.MarkSourceEvent("123", MarkerBlock)
+
+	if err == nil || !strings.Contains(err.Error(), "login source --write") {
+		t.Errorf("Expected a hint to authorize write access, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+const eventStatusCancelled = "cancelled"
+
+func (s *SyncClient) recurringServices() (RecurringEventsService, RecurringEventsService, error) {
+	source, ok := s.SourceCalendarService.(RecurringEventsService)
+	if !ok {
+		return nil, nil, errors.New("the source calendar can't list recurring events as a series")
+	}
+	destination, ok := s.DestinationCalendarService.(RecurringEventsService)
+	if !ok {
+		return nil, nil, errors.New("the destination calendar can't list recurring events as a series")
+	}
+	return source, destination, nil
+}
+
+// splitSeriesExceptions separates moved or cancelled instances of a recurring
+// event from the events that should each get a block.
+func splitSeriesExceptions(events []*calendar.Event) ([]*calendar.Event, []*calendar.Event) {
+	blocks := []*calendar.Event{}
+	exceptions := []*calendar.Event{}
+	for _, event := range events {
+		if event.RecurringEventId != "" {
+			exceptions = append(exceptions, event)
+		} else {
+			blocks = append(blocks, event)
+		}
+	}
+	return blocks, exceptions
+}
+
+// collapseInstances replaces instances of a recurring block with the series
+// itself, so that deleting it removes every instance in a single call.
+func collapseInstances(events []*calendar.Event) []*calendar.Event {
+	collapsed := []*calendar.Event{}
+	seen := map[string]bool{}
+	for _, event := range events {
+		if event.RecurringEventId != "" {
+			series := *event
+			series.Id = event.RecurringEventId
+			series.RecurringEventId = ""
+			event = &series
+		}
+		if seen[event.Id] {
+			continue
+		}
+		seen[event.Id] = true
+		collapsed = append(collapsed, event)
+	}
+	return collapsed
+}
+
+// updateSeries brings an existing recurring block in line with its source
+// series when the rules or times of the series changed. Returns whether an
+// update was needed.
+func (s *SyncClient) updateSeries(existingEvent *calendar.Event, newEvent *calendar.Event, dryRun bool) (bool, error) {
+	if slices.Equal(existingEvent.Recurrence, newEvent.Recurrence) &&
+		sameEventTime(existingEvent.Start, newEvent.Start) &&
+		sameEventTime(existingEvent.End, newEvent.End) {
+		return false, nil
+	}
+
+	patch := &calendar.Event{
+		Start:      newEvent.Start,
+		End:        newEvent.End,
+		Recurrence: newEvent.Recurrence,
+	}
+	return true, s.patchDestinationEvent(existingEvent, patch, dryRun)
+}
+
+// applyInstanceOverrides mirrors moved and cancelled instances of source series
+// onto the matching instances of the recurring blocks. Returns the number of
+// instances updated and deleted.
+func (s *SyncClient) applyInstanceOverrides(sourceEvents []*calendar.Event, sourceExceptions []*calendar.Event, destinationSeries map[string]*calendar.Event, dryRun bool) (int, int, error) {
+	_, destination, err := s.recurringServices()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sourceSeries := map[string]*calendar.Event{}
+	for _, event := range sourceEvents {
+		sourceSeries[event.Id] = event
+	}
+
+	updated := 0
+	deleted := 0
+	for _, exception := range sourceExceptions {
+		series := destinationSeries[exception.RecurringEventId]
+		// The series is only known after it has been created, which a dry run never does
+		if series == nil || series.Id == "" || exception.OriginalStartTime == nil {
+			continue
+		}
+
+		// A padded series starts earlier than its source, and so does each instance
+		originalStartTime := exception.OriginalStartTime
+		if rule := s.Rules.Match(sourceSeries[exception.RecurringEventId], s.sourceCalendar(), s.location()); rule != nil && rule.Action == ActionPad {
+			originalStartTime = shiftEventDateTime(originalStartTime, -time.Duration(rule.PadBefore))
+		}
+
+		end := s.traceCall(destination, "Instance", s.destinationCalendar(), series.Id)
+		instance, err := destination.Instance(s.destinationCalendar(), series.Id, originalStartTime)
+		end(err)
+		if err != nil {
+			return updated, deleted, fmt.Errorf("error fetching instance of event %s: %v", series.Id, err)
+		}
+		if instance == nil || instance.Status == eventStatusCancelled {
+			continue
+		}
+
+		if exception.Status == eventStatusCancelled || s.excludes(exception) {
+			deleted++
+			if err := s.deleteDestinationEvent(instance, dryRun); err != nil {
+				return updated, deleted, err
+			}
+			continue
+		}
+
+		newEvent := s.newDestinationEvent(exception)
+		if sameEventTime(instance.Start, newEvent.Start) && sameEventTime(instance.End, newEvent.End) {
+			continue
+		}
+		updated++
+		if err := s.patchDestinationEvent(instance, &calendar.Event{Start: newEvent.Start, End: newEvent.End}, dryRun); err != nil {
+			return updated, deleted, err
+		}
+	}
+	return updated, deleted, nil
+}
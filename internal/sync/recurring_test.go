@@ -0,0 +1,277 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func createTestSeries(id string, startTime time.Time, recurrence []string, privateProps map[string]string) *calendar.Event {
+	event := createTestEvent(id, "weekly class", startTime, startTime.Add(time.Hour), privateProps)
+	event.Start.TimeZone = "Europe/London"
+	event.End.TimeZone = "Europe/London"
+	event.Recurrence = recurrence
+	return event
+}
+
+func createTestInstance(id string, recurringEventId string, originalStartTime time.Time, startTime time.Time, privateProps map[string]string) *calendar.Event {
+	event := createTestEvent(id, "weekly class", startTime, startTime.Add(time.Hour), privateProps)
+	event.RecurringEventId = recurringEventId
+	event.OriginalStartTime = &calendar.EventDateTime{DateTime: originalStartTime.Format(time.RFC3339)}
+	return event
+}
+
+// createTestBlockSeries returns a series looking like the block a sync
+// creates for a source series.
+func createTestBlockSeries(id string, startTime time.Time, recurrence []string, sourceEventId string) *calendar.Event {
+	event := createTestSeries(id, startTime, recurrence, blockProperties(sourceEventId))
+	event.Summary = "Busy"
+	event.ColorId = "4"
+	return event
+}
+
+func blockProperties(sourceEventId string) map[string]string {
+	return map[string]string{appName: propertyAppNameValue, sourceEventIdPropertyKey: sourceEventId}
+}
+
+func TestRunSyncMirrorRecurringInsertsSeries(t *testing.T) {
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	mockSourceService := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestSeries("weekly", start, []string{"RRULE:FREQ=WEEKLY;COUNT=10"}, nil),
+		createTestEvent("single", "one off", start, start.Add(time.Hour), nil),
+	}}
+	mockDestinationService := &MockCalendarEventsService{}
+	syncClient := &SyncClient{
+		SourceCalendarService:      mockSourceService,
+		DestinationCalendarService: mockDestinationService,
+		MirrorRecurring:            true,
+	}
+
+	err := syncClient.RunSync(30, false)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if len(mockSourceService.seriesCalls) != 1 || len(mockSourceService.listCalls) != 0 {
+		t.Error("Source events should be listed as series")
+	}
+	if len(mockDestinationService.seriesCalls) != 1 || len(mockDestinationService.listCalls) != 0 {
+		t.Error("Destination events should be listed as series")
+	}
+	if len(mockDestinationService.insertedEvents) != 2 {
+		t.Fatalf("Expected one block for the series and one for the single event, got %d", len(mockDestinationService.insertedEvents))
+	}
+
+	series := mockDestinationService.insertedEvents[0]
+	if len(series.Recurrence) != 1 || series.Recurrence[0] != "RRULE:FREQ=WEEKLY;COUNT=10" {
+		t.Errorf("Recurrence wasn't copied to the block: %v", series.Recurrence)
+	}
+	if series.Start.TimeZone != "Europe/London" {
+		t.Errorf("Series should keep the zone its rule is expanded in, got %q", series.Start.TimeZone)
+	}
+	if series.ExtendedProperties.Private[sourceEventIdPropertyKey] != "weekly" {
+		t.Error("Series block should be keyed by the source series ID")
+	}
+	if len(mockDestinationService.insertedEvents[1].Recurrence) != 0 {
+		t.Error("Single event shouldn't get a recurrence")
+	}
+}
+
+func TestRunSyncMirrorRecurringCancelledInstance(t *testing.T) {
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	cancelledStart := start.AddDate(0, 0, 7)
+	cancelled := createTestInstance("weekly_2", "weekly", cancelledStart, cancelledStart, nil)
+	cancelled.Status = eventStatusCancelled
+
+	mockSourceService := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestSeries("weekly", start, []string{"RRULE:FREQ=WEEKLY"}, nil),
+		cancelled,
+	}}
+	mockDestinationService := &MockCalendarEventsService{
+		events: []*calendar.Event{createTestBlockSeries("dest-weekly", start, []string{"RRULE:FREQ=WEEKLY"}, "weekly")},
+		instances: []*calendar.Event{
+			createTestInstance("dest-weekly_1", "dest-weekly", start, start, blockProperties("weekly")),
+			createTestInstance("dest-weekly_2", "dest-weekly", cancelledStart, cancelledStart, blockProperties("weekly")),
+		},
+	}
+	syncClient := &SyncClient{
+		SourceCalendarService:      mockSourceService,
+		DestinationCalendarService: mockDestinationService,
+		MirrorRecurring:            true,
+	}
+
+	err := syncClient.RunSync(30, false)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if len(mockDestinationService.insertedEvents) != 0 {
+		t.Error("Cancelled instance shouldn't create a block")
+	}
+	if len(mockDestinationService.deletedEvents) != 1 || mockDestinationService.deletedEvents[0] != "dest-weekly_2" {
+		t.Errorf("Expected only the cancelled instance to be deleted, deleted %v", mockDestinationService.deletedEvents)
+	}
+}
+
+func TestRunSyncMirrorRecurringMovedInstance(t *testing.T) {
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	originalStart := start.AddDate(0, 0, 7)
+	movedStart := originalStart.Add(2 * time.Hour)
+
+	mockSourceService := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestSeries("weekly", start, []string{"RRULE:FREQ=WEEKLY"}, nil),
+		createTestInstance("weekly_2", "weekly", originalStart, movedStart, nil),
+	}}
+	mockDestinationService := &MockCalendarEventsService{
+		events: []*calendar.Event{createTestBlockSeries("dest-weekly", start, []string{"RRULE:FREQ=WEEKLY"}, "weekly")},
+		instances: []*calendar.Event{
+			createTestInstance("dest-weekly_2", "dest-weekly", originalStart, originalStart, blockProperties("weekly")),
+		},
+	}
+	syncClient := &SyncClient{
+		SourceCalendarService:      mockSourceService,
+		DestinationCalendarService: mockDestinationService,
+		MirrorRecurring:            true,
+	}
+
+	err := syncClient.RunSync(30, false)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	patch, ok := mockDestinationService.patchedEvents["dest-weekly_2"]
+	if !ok {
+		t.Fatal("Moved instance wasn't updated")
+	}
+	patchedStart, _ := time.Parse(time.RFC3339, patch.Start.DateTime)
+	if !patchedStart.Equal(movedStart) {
+		t.Errorf("Expected instance to move to %s, got %s", movedStart, patchedStart)
+	}
+	if len(mockDestinationService.patchedEvents) != 1 || len(mockDestinationService.deletedEvents) != 0 {
+		t.Error("Only the moved instance should change")
+	}
+
+	// Once applied, the override shouldn't be written again
+	mockDestinationService.instances[0] = createTestInstance("dest-weekly_2", "dest-weekly", originalStart, movedStart, blockProperties("weekly"))
+	mockDestinationService.patchedEvents = nil
+	syncClient.RunSync(30, false)
+	if len(mockDestinationService.patchedEvents) != 0 {
+		t.Error("Instance was updated again when it was already in place")
+	}
+}
+
+func TestRunSyncMirrorRecurringUpdatesChangedSeries(t *testing.T) {
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	mockSourceService := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestSeries("weekly", start, []string{"RRULE:FREQ=WEEKLY;UNTIL=20301231T000000Z"}, nil),
+	}}
+	mockDestinationService := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestSeries("dest-weekly", start, []string{"RRULE:FREQ=WEEKLY"}, blockProperties("weekly")),
+	}}
+	syncClient := &SyncClient{
+		SourceCalendarService:      mockSourceService,
+		DestinationCalendarService: mockDestinationService,
+		MirrorRecurring:            true,
+	}
+
+	err := syncClient.RunSync(30, false)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	patch, ok := mockDestinationService.patchedEvents["dest-weekly"]
+	if !ok {
+		t.Fatal("Series wasn't updated")
+	}
+	if len(patch.Recurrence) != 1 || patch.Recurrence[0] != "RRULE:FREQ=WEEKLY;UNTIL=20301231T000000Z" {
+		t.Errorf("Unexpected recurrence %v", patch.Recurrence)
+	}
+	if len(mockDestinationService.insertedEvents) != 0 || len(mockDestinationService.deletedEvents) != 0 {
+		t.Error("Changed series should be updated in place")
+	}
+}
+
+func TestRunSyncMirrorRecurringUnchangedSeries(t *testing.T) {
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	mockSourceService := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestSeries("weekly", start, []string{"RRULE:FREQ=WEEKLY"}, nil),
+	}}
+	mockDestinationService := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestBlockSeries("dest-weekly", start, []string{"RRULE:FREQ=WEEKLY"}, "weekly"),
+	}}
+	syncClient := &SyncClient{
+		SourceCalendarService:      mockSourceService,
+		DestinationCalendarService: mockDestinationService,
+		MirrorRecurring:            true,
+	}
+
+	syncClient.RunSync(30, false)
+
+	if len(mockDestinationService.insertedEvents) != 0 || len(mockDestinationService.patchedEvents) != 0 || len(mockDestinationService.deletedEvents) != 0 {
+		t.Error("Nothing should change for a series that is already mirrored")
+	}
+}
+
+func TestRunSyncMirrorRecurringUnsupportedCalendar(t *testing.T) {
+	// Embedding only the interface hides the mock's series methods
+	mockSourceService := struct{ CalendarEventsService }{&MockCalendarEventsService{}}
+	syncClient := &SyncClient{
+		SourceCalendarService:      mockSourceService,
+		DestinationCalendarService: &MockCalendarEventsService{},
+		MirrorRecurring:            true,
+	}
+
+	err := syncClient.RunSync(30, false)
+
+	if err == nil {
+		t.Error("Expected an error for a calendar that can't list series")
+	}
+}
+
+func TestRunSyncDeletesMirroredSeriesOnce(t *testing.T) {
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	mockSourceService := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestEvent("weekly_1", "weekly class", start, start.Add(time.Hour), nil),
+	}}
+	// Expanded instances of a block mirrored while --mirror-recurring was on
+	mockDestinationService := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestInstance("dest-weekly_1", "dest-weekly", start, start, blockProperties("weekly")),
+		createTestInstance("dest-weekly_2", "dest-weekly", start.AddDate(0, 0, 7), start.AddDate(0, 0, 7), blockProperties("weekly")),
+	}}
+	syncClient := &SyncClient{
+		SourceCalendarService:      mockSourceService,
+		DestinationCalendarService: mockDestinationService,
+	}
+
+	syncClient.RunSync(30, false)
+
+	if len(mockDestinationService.deletedEvents) != 1 || mockDestinationService.deletedEvents[0] != "dest-weekly" {
+		t.Errorf("Expected the whole series to be deleted once, deleted %v", mockDestinationService.deletedEvents)
+	}
+	if len(mockDestinationService.insertedEvents) != 1 {
+		t.Error("Expected the instance to be blocked on its own")
+	}
+}
+
+func TestCleanDeletesSeriesOnce(t *testing.T) {
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	mockDestinationService := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestInstance("dest-weekly_1", "dest-weekly", start, start, blockProperties("weekly")),
+		createTestInstance("dest-weekly_2", "dest-weekly", start.AddDate(0, 0, 7), start.AddDate(0, 0, 7), blockProperties("weekly")),
+		createTestEvent("single", "Busy", start, start, blockProperties("other")),
+	}}
+	syncClient := &SyncClient{
+		SourceCalendarService:      &MockCalendarEventsService{},
+		DestinationCalendarService: mockDestinationService,
+	}
+
+	err := syncClient.Clean(false)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if len(mockDestinationService.deletedEvents) != 2 || mockDestinationService.deletedEvents[0] != "dest-weekly" || mockDestinationService.deletedEvents[1] != "single" {
+		t.Errorf("Expected the series and the single block to be deleted, deleted %v", mockDestinationService.deletedEvents)
+	}
+}
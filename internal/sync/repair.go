@@ -0,0 +1,187 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/audit"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/state"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Problems repair finds with blocks.
+const (
+	// ProblemDuplicate is a second block for the same source event, e.g. from
+	// two machines syncing at the same time
+	ProblemDuplicate = "duplicate"
+	// ProblemOrphaned is a block whose properties are missing or garbled, so
+	// syncs can't tell which source event it belongs to
+	ProblemOrphaned = "orphaned"
+	// ProblemDiverged is a block whose times no longer match its source event
+	ProblemDiverged = "diverged"
+)
+
+// BlockProblem is something wrong with a block on the destination calendar.
+type BlockProblem struct {
+	Problem string
+	Block   *calendar.Event
+	// Detail explains the problem and what's done about it
+	Detail string
+}
+
+// Repair looks for broken blocks on the destination calendar within the next
+// daysAhead days, and fixes them unless dryRun is set. Duplicates and orphaned
+// blocks are deleted and diverged blocks are moved back to their source
+// event's times. Mirrored series aren't checked.
+func (s *SyncClient) Repair(daysAhead int, dryRun bool) (problems []*BlockProblem, err error) {
+	if dryRun {
+		s.logger().Info("Dry run, nothing will be changed")
+	}
+	s.beginRun("repair", dryRun)
+	defer func() { s.finishRun(err) }()
+	finishSpan := s.startSpan("Repair", attribute.Int("sync.days_ahead", daysAhead), attribute.Bool("sync.dry_run", dryRun))
+	defer func() { finishSpan(err, attribute.Int("sync.problems", len(problems))) }()
+	if s.MirrorRecurring {
+		return nil, errors.New("mirrored recurring events can't be repaired, run 'clean' and sync again instead")
+	}
+
+	now, endTime := syncWindow(clock(), daysAhead, s.location())
+	sourceEvents, err := s.fetchSourceEvents(now, endTime)
+	if err != nil {
+		return nil, err
+	}
+	newBlocks := map[string]*calendar.Event{}
+	for _, event := range s.filterExcludedEvents(sourceEvents) {
+		newBlocks[event.Id] = s.newDestinationEvent(event)
+	}
+
+	// Everything is listed rather than just the blocks, as blocks that lost
+	// their properties can't be listed by them
+	end := s.traceCall(s.DestinationCalendarService, "List", s.destinationCalendar(), "")
+	events, err := s.DestinationCalendarService.List(s.destinationCalendar(), now, endTime, nil)
+	end(err, eventCount(len(events)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch destination calendar events: %v", err)
+	}
+	if _, err := checkSchema(events); err != nil {
+		return nil, err
+	}
+
+	problems = s.findBlockProblems(events, newBlocks)
+	for _, problem := range problems {
+		s.logger().Info("Found a problem with a block", "problem", problem.Problem, "event_id", problem.Block.Id, "detail", problem.Detail)
+		if err := s.repairBlock(problem, newBlocks, dryRun); err != nil {
+			return problems, err
+		}
+	}
+
+	// The blocks were changed behind the local state's back
+	if len(problems) > 0 && s.useState() && !dryRun {
+		if err := s.State.Forget(s.DestinationStateKey); err != nil {
+			return problems, err
+		}
+	}
+	return problems, nil
+}
+
+// findBlockProblems checks the events of the destination calendar against the
+// blocks their source events need.
+func (s *SyncClient) findBlockProblems(events []*calendar.Event, newBlocks map[string]*calendar.Event) []*BlockProblem {
+	problems := []*BlockProblem{}
+	groups := map[string][]*calendar.Event{}
+	keys := []string{}
+	for _, event := range events {
+		// Instances are listed along with the series they belong to
+		if event.RecurringEventId != "" {
+			continue
+		}
+		if !isBusyBlock(event) {
+			if looksLikeBlock(event) {
+				problems = append(problems, &BlockProblem{Problem: ProblemOrphaned, Block: event, Detail: "created by gcal-busy-blocker but not marked as a block, deleting it"})
+			}
+			continue
+		}
+		sourceId := event.ExtendedProperties.Private[sourceEventIdPropertyKey]
+		if sourceId == "" {
+			problems = append(problems, &BlockProblem{Problem: ProblemOrphaned, Block: event, Detail: "has no source event, deleting it"})
+			continue
+		}
+
+		// Blocks split around busy time share a source event, and are only
+		// duplicates if their times match too
+		key := sourceId
+		if s.SkipBusy {
+			key += "/" + eventTimeKey(event.Start) + "/" + eventTimeKey(event.End)
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], event)
+	}
+
+	for _, key := range keys {
+		blocks := groups[key]
+		sourceId := blocks[0].ExtendedProperties.Private[sourceEventIdPropertyKey]
+		newBlock := newBlocks[sourceId]
+
+		// Keep a block that's already right if there is one
+		keep := 0
+		for i, block := range blocks {
+			if newBlock != nil && sameEventTime(block.Start, newBlock.Start) && sameEventTime(block.End, newBlock.End) {
+				keep = i
+				break
+			}
+		}
+		for i, block := range blocks {
+			if i != keep {
+				problems = append(problems, &BlockProblem{Problem: ProblemDuplicate, Block: block, Detail: fmt.Sprintf("source event %s is already blocked by %s, deleting it", sourceId, blocks[keep].Id)})
+			}
+		}
+
+		block := blocks[keep]
+		if newBlock != nil && !s.SkipBusy && len(newBlock.Recurrence) == 0 && (!sameEventTime(block.Start, newBlock.Start) || !sameEventTime(block.End, newBlock.End)) {
+			problems = append(problems, &BlockProblem{Problem: ProblemDiverged, Block: block, Detail: fmt.Sprintf("source event %s is at %s - %s, moving it", sourceId, eventTimeKey(newBlock.Start), eventTimeKey(newBlock.End))})
+		}
+	}
+	return problems
+}
+
+// looksLikeBlock reports whether an event was created by this app, going by
+// what's left of it when its private properties were lost.
+func looksLikeBlock(event *calendar.Event) bool {
+	if event.Source != nil && event.Source.Url == appURL {
+		return true
+	}
+	return event.ExtendedProperties != nil && (event.ExtendedProperties.Private[appName] != "" || event.ExtendedProperties.Private[sourceEventIdPropertyKey] != "")
+}
+
+func (s *SyncClient) repairBlock(problem *BlockProblem, newBlocks map[string]*calendar.Event, dryRun bool) error {
+	block := problem.Block
+	switch problem.Problem {
+	case ProblemDiverged:
+		newBlock := newBlocks[block.ExtendedProperties.Private[sourceEventIdPropertyKey]]
+		return s.patchDestinationEvent(block, &calendar.Event{Start: newBlock.Start, End: newBlock.End}, dryRun)
+	case ProblemDuplicate:
+		return s.deleteDestinationEvent(block, dryRun)
+	case ProblemOrphaned:
+		// Orphans aren't marked, so deleteDestinationEvent would refuse them
+		if !looksLikeBlock(block) {
+			return fmt.Errorf("aborting, almost deleted an event we weren't supposed to! Event ID = %s", block.Id)
+		}
+		if dryRun {
+			s.logger().Info("Would delete block", blockAttrs(block)...)
+			return nil
+		}
+		s.logger().Info("Deleting block", blockAttrs(block)...)
+		end := s.traceCall(s.DestinationCalendarService, "Delete", s.destinationCalendar(), block.Id)
+		err := s.DestinationCalendarService.Delete(s.destinationCalendar(), block.Id)
+		end(err)
+		s.audit(audit.ActionDelete, block.Id, "", err)
+		if err != nil {
+			return fmt.Errorf("error deleting event %s: %w", block.Id, err)
+		}
+		s.recordChange(state.OperationDelete, block.Id, block, nil)
+	}
+	return nil
+}
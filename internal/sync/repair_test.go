@@ -0,0 +1,145 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestRepair(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	personal := &memoryCalendarService{name: "personal", events: []*calendar.Event{
+		createTestEvent("dentist", "Dentist", start, start.Add(time.Hour), nil),
+		createTestEvent("gym", "Gym", start.Add(3*time.Hour), start.Add(4*time.Hour), nil),
+	}}
+
+	block := func(id string, sourceId string, from time.Time, to time.Time) *calendar.Event {
+		event := createDestinationEvent(createTestEvent(sourceId, "", from, to, nil), time.UTC)
+		event.Id = id
+		return event
+	}
+	unmarked := block("unmarked", "gym", start.Add(6*time.Hour), start.Add(7*time.Hour))
+	unmarked.ExtendedProperties = nil
+	garbled := block("garbled", "", start.Add(8*time.Hour), start.Add(9*time.Hour))
+	work := &memoryCalendarService{name: "work", events: []*calendar.Event{
+		// The second dentist block is the right one and is kept
+		block("dentist-1", "dentist", start.Add(-time.Hour), start),
+		block("dentist-2", "dentist", start, start.Add(time.Hour)),
+		block("gym-1", "gym", start.Add(5*time.Hour), start.Add(6*time.Hour)),
+		unmarked,
+		garbled,
+		createTestEvent("meeting", "Planning", start, start.Add(time.Hour), nil),
+	}}
+	syncClient := &SyncClient{
+		SourceCalendarService:      personal,
+		DestinationCalendarService: work,
+		Location:                   time.UTC,
+	}
+
+	problems, err := syncClient.Repair(7, true)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	found := map[string]string{}
+	for _, problem := range problems {
+		found[problem.Block.Id] = problem.Problem
+	}
+	expected := map[string]string{
+		"dentist-1": ProblemDuplicate,
+		"gym-1":     ProblemDiverged,
+		"unmarked":  ProblemOrphaned,
+		"garbled":   ProblemOrphaned,
+	}
+	if len(found) != len(expected) {
+		t.Errorf("Expected %v, got %v", expected, found)
+	}
+	for id, problem := range expected {
+		if found[id] != problem {
+			t.Errorf("Expected %s to be %s, got %q", id, problem, found[id])
+		}
+	}
+	if len(work.events) != 6 {
+		t.Fatalf("Expected a dry run not to change anything, got %d events", len(work.events))
+	}
+
+	if _, err := syncClient.Repair(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	ids := []string{}
+	for _, event := range work.events {
+		ids = append(ids, event.Id)
+	}
+	if len(work.events) != 3 || work.events[0].Id != "dentist-2" || work.events[1].Id != "gym-1" || work.events[2].Id != "meeting" {
+		t.Fatalf("Expected the duplicate and orphans to be deleted, got %v", ids)
+	}
+	if !sameEventTime(work.events[1].Start, eventDateTime(start.Add(3*time.Hour), time.UTC)) {
+		t.Errorf("Expected the gym block to be moved back, got %s", work.events[1].Start.DateTime)
+	}
+
+	problems, err = syncClient.Repair(7, false)
+	if err != nil || len(problems) != 0 {
+		t.Errorf("Expected nothing left to repair, got %d problems (%v)", len(problems), err)
+	}
+}
+
+func TestRepairSkipBusy(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	personal := &memoryCalendarService{name: "personal", events: []*calendar.Event{
+		createTestEvent("offsite", "Offsite", start, start.Add(4*time.Hour), nil),
+	}}
+	segment := func(id string, from time.Time, to time.Time) *calendar.Event {
+		event := createDestinationEvent(createTestEvent("offsite", "", from, to, nil), time.UTC)
+		event.Id = id
+		return event
+	}
+	work := &memoryCalendarService{name: "work", events: []*calendar.Event{
+		segment("morning", start, start.Add(time.Hour)),
+		segment("afternoon", start.Add(2*time.Hour), start.Add(4*time.Hour)),
+		segment("afternoon-again", start.Add(2*time.Hour), start.Add(4*time.Hour)),
+	}}
+	syncClient := &SyncClient{
+		SourceCalendarService:      personal,
+		DestinationCalendarService: work,
+		Location:                   time.UTC,
+		SkipBusy:                   true,
+	}
+
+	problems, err := syncClient.Repair(7, true)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(problems) != 1 || problems[0].Block.Id != "afternoon-again" || problems[0].Problem != ProblemDuplicate {
+		t.Errorf("Expected only the repeated segment to be a duplicate, got %+v", problems)
+	}
+}
+
+func TestRepairForgetsState(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	personal := &memoryCalendarService{name: "personal", events: []*calendar.Event{
+		createTestEvent("dentist", "Dentist", start, start.Add(time.Hour), nil),
+	}}
+	work := &memoryCalendarService{name: "work"}
+	syncClient := &SyncClient{
+		SourceCalendarService:      personal,
+		DestinationCalendarService: work,
+		State:                      openTestState(t),
+		DestinationStateKey:        "google/work/primary",
+	}
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	duplicate := *work.events[0]
+	duplicate.Id = "copy"
+	work.events = append(work.events, &duplicate)
+
+	if _, err := syncClient.Repair(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if _, built, _ := syncClient.State.Blocks("google/work/primary"); built {
+		t.Error("Expected the state to be rebuilt after a repair")
+	}
+	if len(work.blocks()) != 1 {
+		t.Errorf("Expected the duplicate to be deleted, got %d blocks", len(work.blocks()))
+	}
+}
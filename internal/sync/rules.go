@@ -0,0 +1,300 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Rule actions
+const (
+	ActionInclude = "include"
+	ActionExclude = "exclude"
+	ActionRename  = "rename"
+	ActionRecolor = "recolor"
+	ActionPad     = "pad"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Rules decide which source events get a block and how that block looks. Rules
+// are checked in order and the first one that matches an event wins. Events no
+// rule matches are blocked as usual.
+type Rules struct {
+	Rules []*Rule `json:"rules"`
+}
+
+// Rule matches source events and decides what happens to their blocks.
+type Rule struct {
+	Name   string    `json:"name"`
+	Match  RuleMatch `json:"match"`
+	Action string    `json:"action"`
+
+	// Title replaces the block's summary for the rename action
+	Title string `json:"title,omitempty"`
+	// ColorId replaces the block's color for the recolor action
+	ColorId string `json:"colorId,omitempty"`
+	// PadBefore and PadAfter extend the block for the pad action
+	PadBefore Duration `json:"padBefore,omitempty"`
+	PadAfter  Duration `json:"padAfter,omitempty"`
+}
+
+// RuleMatch describes the events a rule applies to. Every condition that is
+// set must hold for the rule to match.
+type RuleMatch struct {
+	// Title is a regular expression matched against the event's summary
+	Title        string   `json:"title,omitempty"`
+	ColorId      string   `json:"colorId,omitempty"`
+	Calendar     string   `json:"calendar,omitempty"`
+	MinAttendees *int     `json:"minAttendees,omitempty"`
+	MaxAttendees *int     `json:"maxAttendees,omitempty"`
+	MinDuration  Duration `json:"minDuration,omitempty"`
+	MaxDuration  Duration `json:"maxDuration,omitempty"`
+	EventType    string   `json:"eventType,omitempty"`
+	// Weekdays are three letter day names, e.g. ["sat", "sun"]
+	Weekdays []string `json:"weekdays,omitempty"`
+	// After and Before bound the event's start time of day as "HH:MM"
+	After       string `json:"after,omitempty"`
+	Before      string `json:"before,omitempty"`
+	HasLocation *bool  `json:"hasLocation,omitempty"`
+	// Organizer is the organizer's email address
+	Organizer string `json:"organizer,omitempty"`
+
+	title *regexp.Regexp
+}
+
+// Duration is a time.Duration written as a string like "15m" in rule files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadRules reads and validates a rules file.
+func LoadRules(path string) (*Rules, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := &Rules{}
+	if err := json.Unmarshal(b, rules); err != nil {
+		return nil, fmt.Errorf("unable to parse rules file %s: %v", path, err)
+	}
+	for i, rule := range rules.Rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("invalid rule %d (%s): %v", i+1, rule.Name, err)
+		}
+	}
+	return rules, nil
+}
+
+func (r *Rule) validate() error {
+	switch r.Action {
+	case ActionInclude, ActionExclude:
+	case ActionRename:
+		if r.Title == "" {
+			return fmt.Errorf("rename needs a title")
+		}
+	case ActionRecolor:
+		if r.ColorId == "" {
+			return fmt.Errorf("recolor needs a colorId")
+		}
+	case ActionPad:
+		if r.PadBefore < 0 || r.PadAfter < 0 {
+			return fmt.Errorf("padding can't be negative")
+		}
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+
+	if r.Match.Title != "" {
+		title, err := regexp.Compile(r.Match.Title)
+		if err != nil {
+			return err
+		}
+		r.Match.title = title
+	}
+	for _, day := range r.Match.Weekdays {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("unknown weekday %q", day)
+		}
+	}
+	for _, timeOfDay := range []string{r.Match.After, r.Match.Before} {
+		if timeOfDay == "" {
+			continue
+		}
+		if _, err := time.Parse("15:04", timeOfDay); err != nil {
+			return fmt.Errorf("invalid time of day %q, expected HH:MM", timeOfDay)
+		}
+	}
+	return nil
+}
+
+// Match returns the first rule matching the event, or nil if none do.
+func (r *Rules) Match(event *calendar.Event, calendarId string, loc *time.Location) *Rule {
+	if r == nil {
+		return nil
+	}
+	for _, rule := range r.Rules {
+		if rule.Match.matches(event, calendarId, loc) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Excludes reports whether the event should not be blocked.
+func (r *Rules) Excludes(event *calendar.Event, calendarId string, loc *time.Location) bool {
+	rule := r.Match(event, calendarId, loc)
+	return rule != nil && rule.Action == ActionExclude
+}
+
+// Apply changes a block according to the rule's action.
+func (r *Rule) Apply(event *calendar.Event) {
+	switch r.Action {
+	case ActionRename:
+		event.Summary = r.Title
+	case ActionRecolor:
+		event.ColorId = r.ColorId
+	case ActionPad:
+		event.Start = shiftEventDateTime(event.Start, -time.Duration(r.PadBefore))
+		event.End = shiftEventDateTime(event.End, time.Duration(r.PadAfter))
+	}
+}
+
+func (m *RuleMatch) matches(event *calendar.Event, calendarId string, loc *time.Location) bool {
+	if m.Title != "" {
+		if m.title == nil {
+			m.title = regexp.MustCompile(m.Title)
+		}
+		if !m.title.MatchString(event.Summary) {
+			return false
+		}
+	}
+	if m.ColorId != "" && event.ColorId != m.ColorId {
+		return false
+	}
+	if m.Calendar != "" && calendarId != m.Calendar {
+		return false
+	}
+	if m.MinAttendees != nil && len(event.Attendees) < *m.MinAttendees {
+		return false
+	}
+	if m.MaxAttendees != nil && len(event.Attendees) > *m.MaxAttendees {
+		return false
+	}
+	if m.EventType != "" && eventType(event) != m.EventType {
+		return false
+	}
+	if m.HasLocation != nil && (event.Location != "") != *m.HasLocation {
+		return false
+	}
+	if m.Organizer != "" && (event.Organizer == nil || !strings.EqualFold(event.Organizer.Email, m.Organizer)) {
+		return false
+	}
+
+	start, end, allDay, ok := eventTimes(event, loc)
+	if !ok {
+		return m.MinDuration == 0 && m.MaxDuration == 0 && len(m.Weekdays) == 0 && m.After == "" && m.Before == ""
+	}
+	if m.MinDuration != 0 && end.Sub(start) < time.Duration(m.MinDuration) {
+		return false
+	}
+	if m.MaxDuration != 0 && end.Sub(start) > time.Duration(m.MaxDuration) {
+		return false
+	}
+	if len(m.Weekdays) > 0 && !slices.ContainsFunc(m.Weekdays, func(day string) bool {
+		return weekdays[strings.ToLower(day)] == start.Weekday()
+	}) {
+		return false
+	}
+	// All-day events don't have a time of day to compare
+	timeOfDay := start.Format("15:04")
+	if m.After != "" && (allDay || timeOfDay < m.After) {
+		return false
+	}
+	if m.Before != "" && (allDay || timeOfDay >= m.Before) {
+		return false
+	}
+	return true
+}
+
+func eventType(event *calendar.Event) string {
+	if event.EventType == "" {
+		return "default"
+	}
+	return event.EventType
+}
+
+// eventTimes returns when an event starts and ends in loc, and whether it is
+// an all-day event.
+func eventTimes(event *calendar.Event, loc *time.Location) (time.Time, time.Time, bool, bool) {
+	if event.Start == nil || event.End == nil {
+		return time.Time{}, time.Time{}, false, false
+	}
+	if event.Start.DateTime == "" {
+		start, startErr := time.ParseInLocation(time.DateOnly, event.Start.Date, loc)
+		end, endErr := time.ParseInLocation(time.DateOnly, event.End.Date, loc)
+		return start, end, true, startErr == nil && endErr == nil
+	}
+	start, startErr := time.Parse(time.RFC3339, event.Start.DateTime)
+	end, endErr := time.Parse(time.RFC3339, event.End.DateTime)
+	return start.In(loc), end.In(loc), false, startErr == nil && endErr == nil
+}
+
+// RuleResult is the outcome of the rules for a single source event.
+type RuleResult struct {
+	Event *calendar.Event
+	// Rule is the rule that matched the event, or nil if none did
+	Rule *Rule
+	// Marker is the event's own block or noblock marker, which wins over Rule
+	Marker string
+}
+
+// TestRules lists the upcoming source events along with the rule and marker
+// that apply to each of them, without changing anything.
+func (s *SyncClient) TestRules(daysAhead int) ([]*RuleResult, error) {
+	now, endTime := syncWindow(clock(), daysAhead, s.location())
+	events, err := s.fetchSourceEvents(now, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*RuleResult{}
+	for _, event := range events {
+		results = append(results, &RuleResult{
+			Event:  event,
+			Rule:   s.Rules.Match(event, s.sourceCalendar(), s.location()),
+			Marker: eventMarker(event),
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,321 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func intPointer(i int) *int {
+	return &i
+}
+
+func boolPointer(b bool) *bool {
+	return &b
+}
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	os.WriteFile(path, []byte(`{
+		"rules": [
+			{"name": "no gym", "match": {"title": "(?i)gym"}, "action": "exclude"},
+			{"name": "pad travel", "match": {"hasLocation": true}, "action": "pad", "padBefore": "30m", "padAfter": "15m"}
+		]
+	}`), 0600)
+
+	rules, err := LoadRules(path)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if len(rules.Rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(rules.Rules))
+	}
+	if time.Duration(rules.Rules[1].PadBefore) != 30*time.Minute || time.Duration(rules.Rules[1].PadAfter) != 15*time.Minute {
+		t.Error("Padding wasn't parsed")
+	}
+	if rules.Match(&calendar.Event{Summary: "Gym class"}, defaultCalendar, time.UTC) != rules.Rules[0] {
+		t.Error("Title regex wasn't compiled")
+	}
+}
+
+func TestLoadRulesInvalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown action":   `{"rules": [{"action": "delete"}]}`,
+		"bad regex":        `{"rules": [{"match": {"title": "("}, "action": "exclude"}]}`,
+		"bad weekday":      `{"rules": [{"match": {"weekdays": ["someday"]}, "action": "exclude"}]}`,
+		"bad time of day":  `{"rules": [{"match": {"after": "9am"}, "action": "exclude"}]}`,
+		"rename no title":  `{"rules": [{"action": "rename"}]}`,
+		"recolor no color": `{"rules": [{"action": "recolor"}]}`,
+		"bad duration":     `{"rules": [{"match": {"minDuration": "long"}, "action": "exclude"}]}`,
+	} {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		os.WriteFile(path, []byte(content), 0600)
+
+		if _, err := LoadRules(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestRuleMatch(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	// A Saturday
+	start := time.Date(2026, 3, 14, 9, 30, 0, 0, newYork)
+	event := createTestEvent("123", "Soccer practice", start, start.Add(90*time.Minute), nil)
+	event.ColorId = "5"
+	event.EventType = "default"
+	event.Location = "The park"
+	event.Organizer = &calendar.EventOrganizer{Email: "Coach@example.com"}
+	event.Attendees = []*calendar.EventAttendee{{Email: "a@example.com"}, {Email: "b@example.com"}}
+
+	tests := []struct {
+		name    string
+		match   RuleMatch
+		matches bool
+	}{
+		{"empty match", RuleMatch{}, true},
+		{"title", RuleMatch{Title: "^Soccer"}, true},
+		{"title mismatch", RuleMatch{Title: "^Piano"}, false},
+		{"color", RuleMatch{ColorId: "5"}, true},
+		{"color mismatch", RuleMatch{ColorId: "4"}, false},
+		{"calendar", RuleMatch{Calendar: defaultCalendar}, true},
+		{"calendar mismatch", RuleMatch{Calendar: "family"}, false},
+		{"min attendees", RuleMatch{MinAttendees: intPointer(2)}, true},
+		{"min attendees mismatch", RuleMatch{MinAttendees: intPointer(3)}, false},
+		{"max attendees", RuleMatch{MaxAttendees: intPointer(2)}, true},
+		{"max attendees mismatch", RuleMatch{MaxAttendees: intPointer(1)}, false},
+		{"min duration", RuleMatch{MinDuration: Duration(time.Hour)}, true},
+		{"min duration mismatch", RuleMatch{MinDuration: Duration(2 * time.Hour)}, false},
+		{"max duration", RuleMatch{MaxDuration: Duration(90 * time.Minute)}, true},
+		{"max duration mismatch", RuleMatch{MaxDuration: Duration(time.Hour)}, false},
+		{"event type", RuleMatch{EventType: "default"}, true},
+		{"event type mismatch", RuleMatch{EventType: "focusTime"}, false},
+		{"weekday", RuleMatch{Weekdays: []string{"sat", "Sun"}}, true},
+		{"weekday mismatch", RuleMatch{Weekdays: []string{"mon"}}, false},
+		{"time of day", RuleMatch{After: "09:00", Before: "10:00"}, true},
+		{"before mismatch", RuleMatch{Before: "09:30"}, false},
+		{"after mismatch", RuleMatch{After: "09:31"}, false},
+		{"has location", RuleMatch{HasLocation: boolPointer(true)}, true},
+		{"has location mismatch", RuleMatch{HasLocation: boolPointer(false)}, false},
+		{"organizer", RuleMatch{Organizer: "coach@example.com"}, true},
+		{"organizer mismatch", RuleMatch{Organizer: "boss@example.com"}, false},
+		{"every condition", RuleMatch{Title: "Soccer", Weekdays: []string{"sat"}, After: "09:00", HasLocation: boolPointer(true)}, true},
+	}
+
+	for _, test := range tests {
+		rule := &Rule{Name: test.name, Match: test.match, Action: ActionExclude}
+		if err := rule.validate(); err != nil {
+			t.Fatalf("%s: invalid rule: %v", test.name, err)
+		}
+		if rule.Match.matches(event, defaultCalendar, newYork) != test.matches {
+			t.Errorf("%s: expected match to be %v", test.name, test.matches)
+		}
+	}
+}
+
+func TestRuleMatchUsesLocationForTimeOfDay(t *testing.T) {
+	// 23:00 on a Friday in UTC is already Saturday morning in Tokyo
+	start := time.Date(2026, 3, 13, 23, 0, 0, 0, time.UTC)
+	event := createTestEvent("123", "Call", start, start.Add(time.Hour), nil)
+	match := RuleMatch{Weekdays: []string{"sat"}, Before: "12:00"}
+
+	if !match.matches(event, defaultCalendar, mustLoadLocation(t, "Asia/Tokyo")) {
+		t.Error("Expected the rule to match in Tokyo")
+	}
+	if match.matches(event, defaultCalendar, time.UTC) {
+		t.Error("Expected the rule not to match in UTC")
+	}
+}
+
+func TestRuleMatchAllDayEvent(t *testing.T) {
+	event := &calendar.Event{
+		Summary: "Vacation",
+		Start:   &calendar.EventDateTime{Date: "2026-03-14"},
+		End:     &calendar.EventDateTime{Date: "2026-03-16"},
+	}
+
+	if !(&RuleMatch{MinDuration: Duration(24 * time.Hour), Weekdays: []string{"sat"}}).matches(event, defaultCalendar, time.UTC) {
+		t.Error("Expected duration and weekday to apply to all-day events")
+	}
+	if (&RuleMatch{After: "00:00"}).matches(event, defaultCalendar, time.UTC) {
+		t.Error("All-day events have no time of day and shouldn't match one")
+	}
+}
+
+func TestRulesFirstMatchWins(t *testing.T) {
+	rules := &Rules{Rules: []*Rule{
+		{Name: "keep lessons", Match: RuleMatch{Title: "lesson"}, Action: ActionInclude},
+		{Name: "drop weekends", Match: RuleMatch{Weekdays: []string{"sat", "sun"}}, Action: ActionExclude},
+	}}
+	saturday := time.Date(2026, 3, 14, 9, 0, 0, 0, time.UTC)
+
+	lesson := createTestEvent("1", "piano lesson", saturday, saturday.Add(time.Hour), nil)
+	brunch := createTestEvent("2", "brunch", saturday, saturday.Add(time.Hour), nil)
+
+	if rules.Excludes(lesson, defaultCalendar, time.UTC) {
+		t.Error("The first matching rule should win")
+	}
+	if !rules.Excludes(brunch, defaultCalendar, time.UTC) {
+		t.Error("Expected the weekend rule to exclude brunch")
+	}
+	if (*Rules)(nil).Excludes(brunch, defaultCalendar, time.UTC) {
+		t.Error("No rules shouldn't exclude anything")
+	}
+}
+
+func TestRuleApply(t *testing.T) {
+	start := time.Date(2026, 3, 14, 9, 0, 0, 0, time.UTC)
+	newBlock := func() *calendar.Event {
+		return createDestinationEvent(createTestEvent("1", "class", start, start.Add(time.Hour), nil), time.UTC)
+	}
+
+	renamed := newBlock()
+	(&Rule{Action: ActionRename, Title: "Out of office"}).Apply(renamed)
+	if renamed.Summary != "Out of office" {
+		t.Errorf("Expected block to be renamed, got %s", renamed.Summary)
+	}
+
+	recolored := newBlock()
+	(&Rule{Action: ActionRecolor, ColorId: "11"}).Apply(recolored)
+	if recolored.ColorId != "11" {
+		t.Errorf("Expected block to be recolored, got %s", recolored.ColorId)
+	}
+
+	padded := newBlock()
+	(&Rule{Action: ActionPad, PadBefore: Duration(30 * time.Minute), PadAfter: Duration(15 * time.Minute)}).Apply(padded)
+	if padded.Start.DateTime != "2026-03-14T08:30:00Z" || padded.End.DateTime != "2026-03-14T10:15:00Z" {
+		t.Errorf("Unexpected padded block %s - %s", padded.Start.DateTime, padded.End.DateTime)
+	}
+}
+
+func TestRunSyncAppliesRules(t *testing.T) {
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	mockSourceService := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestEvent("gym", "Gym", start, start.Add(time.Hour), nil),
+		createTestEvent("dentist", "Dentist", start, start.Add(time.Hour), nil),
+		createTestEvent("dinner", "Dinner", start, start.Add(time.Hour), nil),
+	}}
+	mockDestinationService := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestEvent("abc", "Busy", start, start.Add(time.Hour), blockProperties("gym")),
+	}}
+	syncClient := &SyncClient{
+		SourceCalendarService:      mockSourceService,
+		DestinationCalendarService: mockDestinationService,
+		Rules: &Rules{Rules: []*Rule{
+			{Name: "no gym", Match: RuleMatch{Title: "Gym"}, Action: ActionExclude},
+			{Name: "appointments", Match: RuleMatch{Title: "Dentist"}, Action: ActionRename, Title: "Appointment"},
+		}},
+	}
+
+	err := syncClient.RunSync(30, false)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if len(mockDestinationService.insertedEvents) != 2 {
+		t.Fatalf("Expected excluded event not to be blocked, inserted %d", len(mockDestinationService.insertedEvents))
+	}
+	if mockDestinationService.insertedEvents[0].Summary != "Appointment" {
+		t.Errorf("Expected rename rule to apply, got %s", mockDestinationService.insertedEvents[0].Summary)
+	}
+	if mockDestinationService.insertedEvents[1].Summary != "Busy" {
+		t.Errorf("Events no rule matches should be blocked as usual, got %s", mockDestinationService.insertedEvents[1].Summary)
+	}
+	if len(mockDestinationService.deletedEvents) != 1 || mockDestinationService.deletedEvents[0] != "abc" {
+		t.Error("Expected the block of a newly excluded event to be deleted")
+	}
+}
+
+func TestRunSyncAppliesChangedRulesWithoutState(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	sourceEvents := []*calendar.Event{
+		createTestEvent("dentist", "Dentist", start, start.Add(time.Hour), nil),
+		createTestEvent("gym", "Gym", start.Add(2*time.Hour), start.Add(3*time.Hour), nil),
+		createTestEvent("school", "School run", start.Add(4*time.Hour), start.Add(5*time.Hour), nil),
+		createTestEvent("dinner", "Dinner", start.Add(6*time.Hour), start.Add(7*time.Hour), nil),
+	}
+	// Blocks created before the rules were added
+	blocks := []*calendar.Event{}
+	for _, event := range sourceEvents {
+		block := createDestinationEvent(event, time.UTC)
+		block.Id = "block-" + event.Id
+		blocks = append(blocks, block)
+	}
+	mockDestinationService := &MockCalendarEventsService{events: blocks}
+	syncClient := &SyncClient{
+		SourceCalendarService:      &MockCalendarEventsService{events: sourceEvents},
+		DestinationCalendarService: mockDestinationService,
+		Location:                   time.UTC,
+		Rules: &Rules{Rules: []*Rule{
+			{Name: "appointments", Match: RuleMatch{Title: "Dentist"}, Action: ActionRename, Title: "Appointment"},
+			{Name: "gym", Match: RuleMatch{Title: "Gym"}, Action: ActionRecolor, ColorId: "2"},
+			{Name: "travel", Match: RuleMatch{Title: "School"}, Action: ActionPad, PadBefore: Duration(15 * time.Minute)},
+		}},
+	}
+
+	if err := syncClient.RunSync(30, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if patch := mockDestinationService.patchedEvents["block-dentist"]; patch == nil || patch.Summary != "Appointment" {
+		t.Errorf("Expected the renamed block to be patched, got %+v", patch)
+	}
+	if patch := mockDestinationService.patchedEvents["block-gym"]; patch == nil || patch.ColorId != "2" {
+		t.Errorf("Expected the recolored block to be patched, got %+v", patch)
+	}
+	if patch := mockDestinationService.patchedEvents["block-school"]; patch == nil || !sameEventTime(patch.Start, eventDateTime(start.Add(225*time.Minute), time.UTC)) {
+		t.Errorf("Expected the padded block to be patched, got %+v", patch)
+	}
+	if _, ok := mockDestinationService.patchedEvents["block-dinner"]; ok || len(mockDestinationService.patchedEvents) != 3 {
+		t.Errorf("Expected only the blocks the rules changed to be patched, got %d", len(mockDestinationService.patchedEvents))
+	}
+}
+
+func TestRunSyncMirrorRecurringPaddedSeries(t *testing.T) {
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	originalStart := start.AddDate(0, 0, 7)
+	movedStart := originalStart.Add(2 * time.Hour)
+	pad := 15 * time.Minute
+
+	mockSourceService := &MockCalendarEventsService{events: []*calendar.Event{
+		createTestSeries("weekly", start, []string{"RRULE:FREQ=WEEKLY"}, nil),
+		createTestInstance("weekly_2", "weekly", originalStart, movedStart, nil),
+	}}
+	series := createTestBlockSeries("dest-weekly", start.Add(-pad), []string{"RRULE:FREQ=WEEKLY"}, "weekly")
+	series.End = shiftEventDateTime(series.End, 2*pad)
+	mockDestinationService := &MockCalendarEventsService{
+		events: []*calendar.Event{series},
+		instances: []*calendar.Event{
+			createTestInstance("dest-weekly_2", "dest-weekly", originalStart.Add(-pad), originalStart.Add(-pad), blockProperties("weekly")),
+		},
+	}
+	syncClient := &SyncClient{
+		SourceCalendarService:      mockSourceService,
+		DestinationCalendarService: mockDestinationService,
+		MirrorRecurring:            true,
+		Rules: &Rules{Rules: []*Rule{
+			{Name: "pad", Action: ActionPad, PadBefore: Duration(pad), PadAfter: Duration(pad)},
+		}},
+	}
+
+	err := syncClient.RunSync(30, false)
+	if err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if _, ok := mockDestinationService.patchedEvents["dest-weekly"]; ok {
+		t.Error("Padded series was already in place and shouldn't be updated")
+	}
+	patch, ok := mockDestinationService.patchedEvents["dest-weekly_2"]
+	if !ok {
+		t.Fatal("Moved instance of the padded series wasn't found")
+	}
+	patchedStart, _ := time.Parse(time.RFC3339, patch.Start.DateTime)
+	if !patchedStart.Equal(movedStart.Add(-pad)) {
+		t.Errorf("Expected moved instance to be padded, got %s", patchedStart)
+	}
+}
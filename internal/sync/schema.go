@@ -0,0 +1,141 @@
+package sync
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/api/calendar/v3"
+)
+
+// schemaVersionPropertyKey holds the version of the private properties a
+// block was written with. Blocks from before it was added have none, which
+// is version 0.
+const schemaVersionPropertyKey = "gcal-busy-blocker-schema"
+
+// migration rewrites the private properties of a block from one schema
+// version to the next. It's given a copy it can change in place; keys it
+// deletes are cleared on the block, since calendars merge patched properties.
+type migration struct {
+	description string
+	apply       func(private map[string]string)
+}
+
+// migrations[i] takes blocks from schema version i to i+1, so the current
+// version is the number of migrations. Migrations are never changed once
+// released, new ones are added to the end.
+var migrations = []migration{
+	{
+		description: "record the schema version",
+		apply:       func(private map[string]string) {},
+	},
+}
+
+// currentSchema is the schema version new blocks are written with.
+func currentSchema() int {
+	return len(migrations)
+}
+
+// blockSchema returns the schema version of a block. Versions that can't be
+// read are reported as not ok.
+func blockSchema(event *calendar.Event) (int, bool) {
+	if event.ExtendedProperties == nil || event.ExtendedProperties.Private[schemaVersionPropertyKey] == "" {
+		return 0, true
+	}
+	version, err := strconv.Atoi(event.ExtendedProperties.Private[schemaVersionPropertyKey])
+	return version, err == nil && version >= 0
+}
+
+// checkSchema refuses blocks written by a newer version of the app, which
+// this one could mistake for something else and orphan. Events other than
+// blocks are skipped. Returns the number of blocks that need migrating.
+func checkSchema(blocks []*calendar.Event) (int, error) {
+	outdated := 0
+	for _, block := range blocks {
+		if !isBusyBlock(block) {
+			continue
+		}
+		version, ok := blockSchema(block)
+		if !ok || version > currentSchema() {
+			return 0, fmt.Errorf("block %s was written with metadata schema %q, newer than this version of %s supports (%d), upgrade it first",
+				block.Id, block.ExtendedProperties.Private[schemaVersionPropertyKey], appName, currentSchema())
+		}
+		if version < currentSchema() {
+			outdated++
+		}
+	}
+	return outdated, nil
+}
+
+// migratedProperties applies the migrations a block needs to its private
+// properties, returning the properties to patch it with.
+func migratedProperties(private map[string]string, version int) map[string]string {
+	migrated := maps.Clone(private)
+	if migrated == nil {
+		migrated = map[string]string{}
+	}
+	for _, migration := range migrations[version:] {
+		migration.apply(migrated)
+	}
+	migrated[schemaVersionPropertyKey] = strconv.Itoa(currentSchema())
+	return propertiesPatch(private, migrated)
+}
+
+// propertiesPatch returns the private properties that turn from into to.
+// Calendars merge patched properties, so removed ones are set empty.
+func propertiesPatch(from map[string]string, to map[string]string) map[string]string {
+	patch := maps.Clone(to)
+	if patch == nil {
+		patch = map[string]string{}
+	}
+	for key := range from {
+		if _, ok := to[key]; !ok {
+			patch[key] = ""
+		}
+	}
+	return patch
+}
+
+// Migrate rewrites the blocks on the destination calendar that were written
+// with an older metadata schema to the current one, returning how many were
+// or, on a dry run, would be migrated.
+func (s *SyncClient) Migrate(dryRun bool) (migrated int, err error) {
+	if dryRun {
+		s.logger().Info("Dry run, nothing will be changed")
+	}
+	s.beginRun("migrate", dryRun)
+	defer func() { s.finishRun(err) }()
+	finishSpan := s.startSpan("Migrate", attribute.Bool("sync.dry_run", dryRun))
+	defer func() { finishSpan(err, attribute.Int("sync.migrated", migrated)) }()
+
+	events, err := s.fetchBusyBlockEvents(time.Time{})
+	if err != nil {
+		return 0, err
+	}
+	blocks := collapseInstances(events)
+	if _, err := checkSchema(blocks); err != nil {
+		return 0, err
+	}
+
+	for _, block := range blocks {
+		version, _ := blockSchema(block)
+		if version == currentSchema() {
+			continue
+		}
+		for _, migration := range migrations[version:] {
+			s.logger().Info("Migrating block", "event_id", block.Id, "migration", migration.description)
+		}
+		patch := &calendar.Event{
+			ExtendedProperties: &calendar.EventExtendedProperties{
+				Private: migratedProperties(block.ExtendedProperties.Private, version),
+			},
+		}
+		if err := s.patchDestinationEvent(block, patch, dryRun); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
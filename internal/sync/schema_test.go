@@ -0,0 +1,152 @@
+package sync
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func newSchemaTestBlock(id string, sourceId string, start time.Time, version string) *calendar.Event {
+	properties := map[string]string{appName: propertyAppNameValue, sourceEventIdPropertyKey: sourceId}
+	if version != "" {
+		properties[schemaVersionPropertyKey] = version
+	}
+	return createTestEvent(id, "Busy", start, start.Add(time.Hour), properties)
+}
+
+func TestMigrate(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	work := &memoryCalendarService{name: "work", events: []*calendar.Event{
+		newSchemaTestBlock("old", "dentist", start, ""),
+		newSchemaTestBlock("current", "gym", start.Add(2*time.Hour), strconv.Itoa(currentSchema())),
+		createTestEvent("meeting", "Standup", start, start.Add(time.Hour), nil),
+	}}
+	syncClient := &SyncClient{
+		DestinationCalendarService: work,
+		State:                      openTestState(t),
+		DestinationStateKey:        "google/work/primary",
+	}
+
+	migrated, err := syncClient.Migrate(true)
+	if err != nil || migrated != 1 {
+		t.Fatalf("Expected 1 block to need migrating, got %d (%v)", migrated, err)
+	}
+	if version, _ := blockSchema(work.events[0]); version != 0 {
+		t.Fatal("Expected a dry run not to change the block")
+	}
+
+	migrated, err = syncClient.Migrate(false)
+	if err != nil || migrated != 1 {
+		t.Fatalf("Expected 1 block to be migrated, got %d (%v)", migrated, err)
+	}
+	if version, _ := blockSchema(work.events[0]); version != currentSchema() {
+		t.Errorf("Expected the block to be at schema %d, got %d", currentSchema(), version)
+	}
+	if blockSourceId(work.events[0]) != "dentist" || !isBusyBlock(work.events[0]) {
+		t.Errorf("Expected the other properties to be kept, got %v", work.events[0].ExtendedProperties.Private)
+	}
+	if migrated, _ := syncClient.Migrate(false); migrated != 0 {
+		t.Errorf("Expected nothing left to migrate, got %d", migrated)
+	}
+
+	// The migration is in the history, so it can be undone
+	if _, err := syncClient.Undo(0, false); err != nil {
+		t.Fatalf("Unable to undo the migration: %v", err)
+	}
+	if version, ok := blockSchema(work.events[0]); !ok || version != 0 {
+		t.Errorf("Expected the block to be back at schema 0, got %d", version)
+	}
+}
+
+func TestMigrateSteps(t *testing.T) {
+	original := migrations
+	t.Cleanup(func() { migrations = original })
+	migrations = append(append([]migration{}, original...), migration{
+		description: "rename the source event property",
+		apply: func(private map[string]string) {
+			private["gcal-busy-blocker-source"] = private[sourceEventIdPropertyKey]
+			delete(private, sourceEventIdPropertyKey)
+		},
+	})
+
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	work := &memoryCalendarService{name: "work", events: []*calendar.Event{
+		newSchemaTestBlock("v0", "dentist", start, ""),
+		newSchemaTestBlock("v1", "gym", start, "1"),
+	}}
+	syncClient := &SyncClient{DestinationCalendarService: work}
+	if migrated, err := syncClient.Migrate(false); err != nil || migrated != 2 {
+		t.Fatalf("Expected both blocks to be migrated, got %d (%v)", migrated, err)
+	}
+	for _, event := range work.events {
+		private := event.ExtendedProperties.Private
+		if private[schemaVersionPropertyKey] != "2" || private["gcal-busy-blocker-source"] == "" || private[sourceEventIdPropertyKey] != "" {
+			t.Errorf("Expected %s to be migrated through every step, got %v", event.Id, private)
+		}
+	}
+}
+
+func TestRunSyncRefusesNewerSchema(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	personal := &memoryCalendarService{name: "personal", events: []*calendar.Event{
+		createTestEvent("gym", "Gym", start, start.Add(time.Hour), nil),
+	}}
+	for _, version := range []string{strconv.Itoa(currentSchema() + 1), "v2"} {
+		work := &memoryCalendarService{name: "work", events: []*calendar.Event{
+			newSchemaTestBlock("future", "dentist", start, version),
+		}}
+		syncClient := &SyncClient{SourceCalendarService: personal, DestinationCalendarService: work}
+
+		err := syncClient.RunSync(7, false)
+		if err == nil || !strings.Contains(err.Error(), "newer") {
+			t.Errorf("%s: expected the sync to refuse the block, got %v", version, err)
+		}
+		if len(work.events) != 1 || work.events[0].Id != "future" {
+			t.Errorf("%s: expected the calendar to be left alone, got %d events", version, len(work.events))
+		}
+		if _, err := syncClient.Migrate(false); err == nil {
+			t.Errorf("%s: expected migrate to refuse the block too", version)
+		}
+	}
+}
+
+func TestRunSyncWithStateRefusesNewerSchema(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	personal := &memoryCalendarService{name: "personal", events: []*calendar.Event{
+		createTestEvent("gym", "Gym", start, start.Add(time.Hour), nil),
+	}}
+	// The rebuild records blocks past the window too, so a newer one there is
+	// refused before the store is written
+	work := &memoryCalendarService{name: "work", events: []*calendar.Event{
+		newSchemaTestBlock("future", "dentist", start.Add(30*24*time.Hour), strconv.Itoa(currentSchema()+1)),
+	}}
+	syncClient := &SyncClient{
+		SourceCalendarService:      personal,
+		DestinationCalendarService: work,
+		State:                      openTestState(t),
+		DestinationStateKey:        "google/work/primary",
+	}
+
+	if err := syncClient.RunSync(7, false); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Expected the sync to refuse the block, got %v", err)
+	}
+	if len(work.events) != 1 {
+		t.Errorf("Expected the calendar to be left alone, got %d events", len(work.events))
+	}
+	if _, ok, _ := syncClient.State.Blocks("google/work/primary"); ok {
+		t.Error("Expected nothing to be recorded")
+	}
+}
+
+func TestNewBlocksUseCurrentSchema(t *testing.T) {
+	block := createDestinationEvent(createTestEvent("gym", "Gym", time.Now(), time.Now().Add(time.Hour), nil), time.UTC)
+	if version, _ := blockSchema(block); version != currentSchema() {
+		t.Errorf("Expected new blocks to be at schema %d, got %d", currentSchema(), version)
+	}
+	if outdated, err := checkSchema([]*calendar.Event{block, newSchemaTestBlock("old", "x", time.Now(), "")}); err != nil || outdated != 1 {
+		t.Errorf("Expected 1 outdated block, got %d (%v)", outdated, err)
+	}
+}
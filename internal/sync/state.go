@@ -0,0 +1,284 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/caldav"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/state"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// useState reports whether blocks are looked up in the state store rather
+// than listed from the destination. Mirrored series need their instances
+// from the calendar, so they're always listed.
+func (s *SyncClient) useState() bool {
+	return s.State != nil && s.DestinationStateKey != "" && !s.MirrorRecurring
+}
+
+// existingBlocks returns the blocks on the destination calendar that start
+// before endTime, along with the content hash of each by event ID. They come
+// from the state store when it knows the calendar, and otherwise from the
+// calendar itself, rebuilding the store from them.
+func (s *SyncClient) existingBlocks(endTime time.Time) ([]*calendar.Event, map[string]string, error) {
+	if !s.useState() {
+		events, err := s.fetchBusyBlockEvents(endTime)
+		return events, nil, err
+	}
+
+	blocks, built, err := s.State.Blocks(s.DestinationStateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !built {
+		s.logger().Info("Rebuilding the local state from the destination calendar")
+		return s.rebuildState(endTime)
+	}
+	// Nothing tells the state about blocks deleted by hand, so it's only
+	// trusted for so long
+	if s.StateMaxAge > 0 {
+		builtAt, err := s.State.BuiltAt(s.DestinationStateKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		if age := clock().Sub(builtAt); age > s.StateMaxAge {
+			s.logger().Info("Rebuilding the local state as it's out of date", "age", age.Round(time.Second))
+			return s.rebuildState(endTime)
+		}
+	}
+	resumed, err := s.resumeJournal()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !resumed {
+		s.logger().Info("Rebuilding the local state after an interrupted sync")
+		return s.rebuildState(endTime)
+	}
+	// Settling the journal may have changed the blocks
+	blocks, _, err = s.State.Blocks(s.DestinationStateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	events := []*calendar.Event{}
+	hashes := map[string]string{}
+	for _, block := range blocks {
+		event := blockEvent(block)
+		if start, _, _, ok := eventTimes(event, s.location()); ok && !start.Before(endTime) {
+			continue
+		}
+		events = append(events, event)
+		hashes[event.Id] = block.ContentHash
+	}
+	return events, hashes, nil
+}
+
+// rebuildState records every block on the destination calendar, which carry
+// their source event in their private properties.
+func (s *SyncClient) rebuildState(endTime time.Time) ([]*calendar.Event, map[string]string, error) {
+	all, err := s.fetchBusyBlockEvents(time.Time{})
+	if err != nil {
+		return nil, nil, err
+	}
+	// Blocks past the window are recorded too, so they're checked here rather
+	// than left to the check on the window's blocks
+	if _, err := checkSchema(all); err != nil {
+		return nil, nil, err
+	}
+
+	blocks := []*state.Block{}
+	events := []*calendar.Event{}
+	hashes := map[string]string{}
+	for _, event := range all {
+		block := stateBlock(event)
+		blocks = append(blocks, block)
+		start, _, _, ok := eventTimes(event, s.location())
+		if ok && !start.Before(endTime) {
+			continue
+		}
+		events = append(events, event)
+		hashes[event.Id] = block.ContentHash
+	}
+	if err := s.State.Rebuild(s.DestinationStateKey, blocks); err != nil {
+		return nil, nil, err
+	}
+	return events, hashes, nil
+}
+
+// recordBlock saves a block that was written to the destination. If the store
+// can't be written it's dropped instead, so the next run rebuilds it rather
+// than trusting a record that's missing blocks.
+func (s *SyncClient) recordBlock(event *calendar.Event) {
+	if !s.useState() || !isBusyBlock(event) {
+		return
+	}
+	if err := s.State.Put(s.DestinationStateKey, stateBlock(event)); err != nil {
+		s.dropState(err)
+	}
+}
+
+// forgetBlock removes a block that was deleted from the destination.
+func (s *SyncClient) forgetBlock(eventId string) {
+	if !s.useState() {
+		return
+	}
+	if err := s.State.Delete(s.DestinationStateKey, eventId); err != nil {
+		s.dropState(err)
+	}
+}
+
+func (s *SyncClient) dropState(err error) {
+	s.logger().Warn("Unable to update the local state, it will be rebuilt on the next run", "error", err)
+	if err := s.State.Forget(s.DestinationStateKey); err != nil {
+		s.logger().Warn("Unable to reset the local state", "error", err)
+	}
+}
+
+// blockChanged reports whether a block no longer matches the one that would
+// be created for its source event now. Blocks the state store knows the
+// content of are compared by hash, others by what the listed block shows.
+func (s *SyncClient) blockChanged(hashes map[string]string, existing *calendar.Event, newEvent *calendar.Event) bool {
+	if hash, ok := hashes[existing.Id]; ok {
+		return hash != blockHash(newEvent)
+	}
+	if existing.Summary != newEvent.Summary || !sameEventTime(existing.Start, newEvent.Start) || !sameEventTime(existing.End, newEvent.End) {
+		return true
+	}
+	// Calendars that don't store colours list every block without one
+	if colorless, ok := s.DestinationCalendarService.(colorlessCalendar); ok && colorless.dropsColors() {
+		return false
+	}
+	return existing.ColorId != newEvent.ColorId
+}
+
+// blockHash hashes what a block shows. Times are compared as instants, so the
+// same block read back in another zone hashes the same.
+func blockHash(event *calendar.Event) string {
+	content := struct {
+		Summary     string   `json:"summary"`
+		Description string   `json:"description"`
+		ColorId     string   `json:"colorId"`
+		Start       string   `json:"start"`
+		End         string   `json:"end"`
+		AllDay      bool     `json:"allDay"`
+		Recurrence  []string `json:"recurrence"`
+	}{
+		Summary:     event.Summary,
+		Description: event.Description,
+		ColorId:     event.ColorId,
+		Recurrence:  event.Recurrence,
+	}
+	if start, end, allDay, ok := eventTimes(event, time.UTC); ok {
+		content.Start = start.Format(time.RFC3339)
+		content.End = end.Format(time.RFC3339)
+		content.AllDay = allDay
+	}
+	data, _ := json.Marshal(content)
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// stateBlock converts a block into its record.
+func stateBlock(event *calendar.Event) *state.Block {
+	block := &state.Block{
+		DestinationId: event.Id,
+		ContentHash:   blockHash(event),
+		ETag:          event.Etag,
+	}
+	if event.ExtendedProperties != nil {
+		block.SourceId = event.ExtendedProperties.Private[sourceEventIdPropertyKey]
+	}
+	block.Schema, _ = blockSchema(event)
+	if event.Start != nil && event.End != nil {
+		if event.Start.DateTime == "" {
+			block.Start, block.End, block.AllDay = event.Start.Date, event.End.Date, true
+		} else {
+			block.Start, block.End = event.Start.DateTime, event.End.DateTime
+		}
+	}
+	return block
+}
+
+// blockEvent turns a record back into the parts of a block a sync looks at.
+func blockEvent(block *state.Block) *calendar.Event {
+	event := &calendar.Event{
+		Id:    block.DestinationId,
+		Etag:  block.ETag,
+		Start: &calendar.EventDateTime{},
+		End:   &calendar.EventDateTime{},
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{
+				appName:                  propertyAppNameValue,
+				sourceEventIdPropertyKey: block.SourceId,
+			},
+		},
+	}
+	if block.Schema > 0 {
+		event.ExtendedProperties.Private[schemaVersionPropertyKey] = strconv.Itoa(block.Schema)
+	}
+	if block.AllDay {
+		event.Start.Date, event.End.Date = block.Start, block.End
+	} else {
+		event.Start.DateTime, event.End.DateTime = block.Start, block.End
+	}
+	return event
+}
+
+// patchedEvent returns the block as it is after applying a patch to it.
+func patchedEvent(event *calendar.Event, patch *calendar.Event, response *calendar.Event) *calendar.Event {
+	patched := *event
+	if patch.Summary != "" {
+		patched.Summary = patch.Summary
+	}
+	if patch.Description != "" {
+		patched.Description = patch.Description
+	}
+	if patch.ColorId != "" {
+		patched.ColorId = patch.ColorId
+	}
+	if patch.Start != nil {
+		patched.Start = patch.Start
+	}
+	if patch.End != nil {
+		patched.End = patch.End
+	}
+	if patch.Recurrence != nil {
+		patched.Recurrence = patch.Recurrence
+	}
+	// Calendars merge patched private properties into the existing ones
+	if patch.ExtendedProperties != nil {
+		private := map[string]string{}
+		if event.ExtendedProperties != nil {
+			maps.Copy(private, event.ExtendedProperties.Private)
+		}
+		maps.Copy(private, patch.ExtendedProperties.Private)
+		patched.ExtendedProperties = &calendar.EventExtendedProperties{Private: private}
+	}
+	if response != nil {
+		patched.Etag = response.Etag
+	}
+	return &patched
+}
+
+// isGone reports whether an event was already deleted from the calendar.
+func isGone(err error) bool {
+	var apiErr *googleapi.Error
+	var graphErr *graphStatusError
+	var caldavErr *caldav.StatusError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusGone
+	case errors.As(err, &graphErr):
+		return graphErr.StatusCode == http.StatusNotFound || graphErr.StatusCode == http.StatusGone
+	case errors.As(err, &caldavErr):
+		return caldavErr.StatusCode == http.StatusNotFound || caldavErr.StatusCode == http.StatusGone
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/caldav"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/state"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// countingCalendarService counts the listings of a calendar.
+type countingCalendarService struct {
+	CalendarEventsService
+	lists int
+}
+
+func (c *countingCalendarService) List(calendarId string, startTime time.Time, endTime time.Time, privateProperties map[string]string) ([]*calendar.Event, error) {
+	c.lists++
+	return c.CalendarEventsService.List(calendarId, startTime, endTime, privateProperties)
+}
+
+func openTestState(t *testing.T) *state.Store {
+	t.Helper()
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("Unable to open state: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestRunSyncWithState(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	personal := &memoryCalendarService{name: "personal", events: []*calendar.Event{
+		createTestEvent("dentist", "Dentist", start, start.Add(time.Hour), nil),
+		createTestEvent("gym", "Gym", start.Add(3*time.Hour), start.Add(4*time.Hour), nil),
+	}}
+	work := &memoryCalendarService{name: "work"}
+	destination := &countingCalendarService{CalendarEventsService: work}
+	store := openTestState(t)
+	syncClient := &SyncClient{
+		SourceCalendarService:      personal,
+		DestinationCalendarService: destination,
+		Location:                   time.UTC,
+		State:                      store,
+		DestinationStateKey:        "google/work/primary",
+	}
+
+	// The first run doesn't know the calendar yet, so it's listed
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if destination.lists != 1 || len(work.blocks()) != 2 {
+		t.Fatalf("Expected one listing and 2 blocks, got %d listings and %d blocks", destination.lists, len(work.blocks()))
+	}
+	blocks, built, _ := store.Blocks("google/work/primary")
+	if !built || len(blocks) != 2 {
+		t.Fatalf("Expected both blocks to be recorded, got %d (built %v)", len(blocks), built)
+	}
+
+	// Later runs go by the state, and still notice changes
+	personal.events[0].Start.DateTime = start.Add(time.Hour).Format(time.RFC3339)
+	personal.events[0].End.DateTime = start.Add(2 * time.Hour).Format(time.RFC3339)
+	personal.events = personal.events[:1]
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if destination.lists != 1 {
+		t.Errorf("Expected the destination not to be listed again, got %d listings", destination.lists)
+	}
+	if len(work.blocks()) != 1 {
+		t.Fatalf("Expected the gym block to be deleted, got %d blocks", len(work.blocks()))
+	}
+	if block := work.blocks()[0]; block.Start.DateTime != start.Add(time.Hour).Format(time.RFC3339) {
+		t.Errorf("Expected the dentist block to move, got %s", block.Start.DateTime)
+	}
+
+	// Nothing changed, so nothing is written
+	before := len(work.events)
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(work.events) != before || work.nextId != 2 {
+		t.Errorf("Expected no writes, got %d events and %d inserts", len(work.events), work.nextId)
+	}
+
+	// A lost state is rebuilt from the blocks' private properties
+	syncClient.State = openTestState(t)
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if destination.lists != 2 || len(work.blocks()) != 1 || work.nextId != 2 {
+		t.Errorf("Expected the state to be rebuilt without new blocks, got %d listings, %d blocks, %d inserts", destination.lists, len(work.blocks()), work.nextId)
+	}
+
+	if err := syncClient.Clean(false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	blocks, built, _ = syncClient.State.Blocks("google/work/primary")
+	if !built || len(blocks) != 0 {
+		t.Errorf("Expected the state to be empty after cleaning, got %d blocks (built %v)", len(blocks), built)
+	}
+}
+
+func TestRunSyncWithStateMaxAge(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	personal := &memoryCalendarService{name: "personal", events: []*calendar.Event{
+		createTestEvent("dentist", "Dentist", start, start.Add(time.Hour), nil),
+	}}
+	work := &memoryCalendarService{name: "work"}
+	syncClient := &SyncClient{
+		SourceCalendarService:      personal,
+		DestinationCalendarService: work,
+		Location:                   time.UTC,
+		State:                      openTestState(t),
+		DestinationStateKey:        "google/work/primary",
+		StateMaxAge:                time.Hour,
+	}
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	// Someone deletes the block by hand, which the state doesn't know
+	work.events = nil
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(work.blocks()) != 0 {
+		t.Fatalf("Expected the state to be trusted while it's new, got %d blocks", len(work.blocks()))
+	}
+
+	// Once it's out of date it's rebuilt, and the block created again
+	t.Cleanup(func() { clock = time.Now })
+	clock = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if len(work.blocks()) != 1 {
+		t.Errorf("Expected the deleted block to be created again, got %d blocks", len(work.blocks()))
+	}
+}
+
+func TestRunSyncWithStateDryRun(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	personal := &memoryCalendarService{name: "personal", events: []*calendar.Event{
+		createTestEvent("dentist", "Dentist", start, start.Add(time.Hour), nil),
+	}}
+	store := openTestState(t)
+	syncClient := &SyncClient{
+		SourceCalendarService:      personal,
+		DestinationCalendarService: &memoryCalendarService{name: "work"},
+		State:                      store,
+		DestinationStateKey:        "google/work/primary",
+	}
+	if err := syncClient.RunSync(7, true); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if blocks, _, _ := store.Blocks("google/work/primary"); len(blocks) != 0 {
+		t.Errorf("Expected a dry run not to record blocks, got %d", len(blocks))
+	}
+}
+
+func TestBlockHash(t *testing.T) {
+	utc := createDestinationEvent(createTestEvent("dentist", "Dentist", time.Date(2026, 3, 10, 13, 0, 0, 0, time.UTC), time.Date(2026, 3, 10, 14, 0, 0, 0, time.UTC), nil), time.UTC)
+	newYork := createDestinationEvent(utc, mustLoadLocation(t, "America/New_York"))
+	if utc.Start.DateTime == newYork.Start.DateTime || blockHash(utc) != blockHash(newYork) {
+		t.Error("Expected the same times in another zone to hash the same")
+	}
+
+	renamed := *utc
+	renamed.Summary = "Out of office"
+	if blockHash(&renamed) == blockHash(utc) {
+		t.Error("Expected a changed summary to change the hash")
+	}
+
+	record := stateBlock(&calendar.Event{Id: "block1", Etag: `"1"`, Start: utc.Start, End: utc.End, ExtendedProperties: utc.ExtendedProperties})
+	event := blockEvent(record)
+	if event.Id != "block1" || event.Etag != `"1"` || !isBusyBlock(event) || event.ExtendedProperties.Private[sourceEventIdPropertyKey] != "dentist" {
+		t.Errorf("Expected the block to survive a round trip through the state, got %+v", event)
+	}
+}
+
+func TestIsGone(t *testing.T) {
+	if !isGone(fmt.Errorf("wrapped: %w", &googleapi.Error{Code: 410})) || !isGone(&googleapi.Error{Code: 404}) {
+		t.Error("Expected deleted events to be recognized")
+	}
+	if !isGone(&graphStatusError{StatusCode: 404, Code: "ErrorItemNotFound"}) || !isGone(fmt.Errorf("wrapped: %w", &caldav.StatusError{StatusCode: 404})) {
+		t.Error("Expected deleted Microsoft and CalDAV events to be recognized")
+	}
+	if isGone(&googleapi.Error{Code: 403}) || isGone(&graphStatusError{StatusCode: 403}) || isGone(&caldav.StatusError{StatusCode: 412}) || isGone(errors.New("not found")) {
+		t.Error("Expected other errors not to be taken as deleted events")
+	}
+}
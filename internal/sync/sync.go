@@ -3,110 +3,247 @@ package sync
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"slices"
+	"strconv"
 	"time"
 
-	"github.com/davidpimentel/gcal-busy-blocker/internal/auth"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/audit"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/metrics"
+	"github.com/davidpimentel/gcal-busy-blocker/internal/state"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/api/calendar/v3"
-	"google.golang.org/api/option"
 )
 
 type SyncClient struct {
 	SourceCalendarService      CalendarEventsService
 	DestinationCalendarService CalendarEventsService
+
+	// Location is the zone used for the sync window and for the times written
+	// to generated blocks. Defaults to the system's local zone when nil.
+	Location *time.Location
+
+	// MirrorRecurring copies recurring source events as a single recurring
+	// block instead of one block per instance. Both calendars must implement
+	// RecurringEventsService.
+	MirrorRecurring bool
+
+	// Rules decide which source events are blocked and how. Every event is
+	// blocked when nil.
+	Rules *Rules
+
+	// SkipBusy only blocks the parts of a source event that aren't already
+	// covered by another event on the destination calendar.
+	SkipBusy bool
+
+	// SourceCalendarId and DestinationCalendarId pick the calendars to sync
+	// within each account, the primary calendars when empty.
+	SourceCalendarId      string
+	DestinationCalendarId string
+
+	// State records the blocks written to the destination calendar, so runs
+	// don't have to list it. DestinationStateKey names the calendar in it and
+	// SourceStateKey names the source for syncing the other way round. The
+	// state is rebuilt from the calendar once it's older than StateMaxAge, so
+	// blocks deleted by hand are created again; zero trusts it until it's
+	// forgotten.
+	State               *state.Store
+	SourceStateKey      string
+	DestinationStateKey string
+	StateMaxAge         time.Duration
+
+	// Audit logs every change made to the calendars and Metrics measures the
+	// syncs, when set. SourceAccount and DestinationAccount name the accounts
+	// in both.
+	Audit              *audit.Log
+	Metrics            *metrics.SyncMetrics
+	SourceAccount      string
+	DestinationAccount string
+
+	// Logger receives the progress of runs, slog.Default() when nil. Titles
+	// of source events are only ever logged at the debug level.
+	Logger *slog.Logger
+
+	// runId is the run whose changes are being recorded in the history
+	runId uint64
+	// ctx holds the span of the command being run, under which the calls to
+	// the calendars are traced
+	ctx context.Context
 }
 
 const (
 	appName                  = "gcal-busy-blocker"
+	appURL                   = "https://github.com/davidpimentel/gcal-busy-blocker"
 	defaultCalendar          = "primary"
 	propertyAppNameValue     = "true"
 	sourceEventIdPropertyKey = "gcal-busy-blocker-source-event-id"
 )
 
-func NewSyncClient() *SyncClient {
-
-	// Get source client
-	sourceClient, err := auth.SourceClient()
-	if err != nil {
-		log.Fatalf("Unable to get source client: %v", err)
-	}
-
-	// Get destination client
-	destClient, err := auth.DestinationClient()
-	if err != nil {
-		log.Fatalf("Unable to get destination client: %v", err)
-	}
-
-	// Create calendar service for source and destination
-	sourceSrv, err := calendar.NewService(context.Background(), option.WithHTTPClient(sourceClient))
-	if err != nil {
-		log.Fatalf("Unable to retrieve source Calendar client: %v", err)
+func (s *SyncClient) RunSync(daysAhead int, dryRun bool) (err error) {
+	if dryRun {
+		s.logger().Info("Dry run, nothing will be changed")
 	}
-
-	destSrv, err := calendar.NewService(context.Background(), option.WithHTTPClient(destClient))
-	if err != nil {
-		log.Fatalf("Unable to retrieve destination Calendar client: %v", err)
+	s.beginRun("sync", dryRun)
+	defer func() { s.finishRun(err) }()
+
+	var excludedEvents, skippedEvents, eventsCreated, updatedEvents, deletedEvents int
+	finishSpan := s.startSpan("RunSync", attribute.Int("sync.days_ahead", daysAhead), attribute.Bool("sync.dry_run", dryRun))
+	defer func() {
+		finishSpan(err,
+			attribute.Int("sync.created", eventsCreated),
+			attribute.Int("sync.updated", updatedEvents),
+			attribute.Int("sync.deleted", deletedEvents),
+			attribute.Int("sync.skipped", skippedEvents),
+			attribute.Int("sync.excluded", excludedEvents),
+		)
+	}()
+	if s.Metrics != nil && !dryRun {
+		started := time.Now()
+		defer func() {
+			s.Metrics.ObserveSync(s.DestinationAccount, started, map[string]int{
+				"created":  eventsCreated,
+				"updated":  updatedEvents,
+				"deleted":  deletedEvents,
+				"skipped":  skippedEvents,
+				"excluded": excludedEvents,
+			}, err)
+		}()
 	}
 
-	return &SyncClient{
-		SourceCalendarService:      &calendarEventsService{service: sourceSrv},
-		DestinationCalendarService: &calendarEventsService{service: destSrv},
+	if err := s.checkDestinationAccess(); err != nil {
+		return err
 	}
-}
 
-func (s *SyncClient) RunSync(daysAhead int, dryRun bool) error {
-	if dryRun {
-		log.Println("DRY RUN!")
+	if s.MirrorRecurring {
+		if _, _, err := s.recurringServices(); err != nil {
+			return err
+		}
+		if s.SkipBusy {
+			return errors.New("skipping busy time can't be combined with mirroring recurring events")
+		}
 	}
 
-	now := time.Now()
-	endTime := now.AddDate(0, 0, daysAhead)
+	now, endTime := syncWindow(clock(), daysAhead, s.location())
 
-	log.Printf("Starting calendar sync for time range: %s to %s\n", now, endTime)
+	s.logger().Info("Starting calendar sync", "from", now, "to", endTime)
 
 	// List events from source calendar
-	sourceEvents := s.fetchSourceEvents(now, endTime)
+	sourceEvents, err := s.fetchSourceEvents(now, endTime)
+	if err != nil {
+		return err
+	}
 
+	// Carry on without events, blocks left over from earlier runs still have to
+	// go. In two-way mode a calendar holding nothing but blocks looks empty.
 	if len(sourceEvents) == 0 {
-		log.Println("No upcoming events found in source calendar, terminating...")
-		return nil
+		s.logger().Info("No upcoming events found in source calendar")
+	}
+
+	// Moved and cancelled instances of a series are applied to the mirrored
+	// series after it exists, rather than becoming blocks of their own
+	sourceExceptions := []*calendar.Event{}
+	if s.MirrorRecurring {
+		sourceEvents, sourceExceptions = splitSeriesExceptions(sourceEvents)
 	}
 
 	sourceEventCount := len(sourceEvents)
-	skippedEvents := 0
-	eventsCreated := 0
-	deletedEvents := 0
+	sourceEvents = s.filterExcludedEvents(sourceEvents)
+	excludedEvents = sourceEventCount - len(sourceEvents)
+
+	existingDestinationEvents, blockHashes, err := s.existingBlocks(endTime)
+	if err != nil {
+		return err
+	}
+	outdated, err := checkSchema(existingDestinationEvents)
+	if err != nil {
+		return err
+	}
+	if outdated > 0 {
+		s.logger().Warn("Blocks were written with an older metadata schema, run 'migrate' to update them", "blocks", outdated)
+	}
+	destinationSeries := map[string]*calendar.Event{}
 
-	existingDestinationEvents := s.fetchBusyBlockEvents(endTime)
+	var busy []interval
+	if s.SkipBusy {
+		busy, err = s.fetchDestinationBusy(now, endTime)
+		if err != nil {
+			return err
+		}
+	}
 
 	for _, event := range sourceEvents {
-		if eventAlreadyExists(existingDestinationEvents, event.Id) {
-			skippedEvents++
-		} else {
-			eventsCreated++
-			newEvent := createDestinationEvent(event)
+		if s.SkipBusy {
+			inserted, deleted, err := s.reconcileSegments(event, existingDestinationEvents, busy, dryRun)
+			if err != nil {
+				return err
+			}
+			if inserted == 0 && deleted == 0 {
+				skippedEvents++
+			}
+			eventsCreated += inserted
+			deletedEvents += deleted
+			continue
+		}
 
-			if dryRun {
-				b, err := json.MarshalIndent(newEvent, "", "  ")
+		existingEvents := findDestinationEvents(existingDestinationEvents, event.Id)
+		if len(existingEvents) > 1 {
+			s.logger().Warn("Source event has several blocks, run 'repair' to remove the duplicates", "source_event_id", event.Id, "blocks", len(existingEvents))
+		}
+		existingEvent := findDestinationEvent(existingDestinationEvents, event.Id)
+		if existingEvent != nil {
+			if len(event.Recurrence) > 0 {
+				destinationSeries[event.Id] = existingEvent
+				updated, err := s.updateSeries(existingEvent, s.newDestinationEvent(event), dryRun)
 				if err != nil {
 					return err
 				}
-				log.Println("Dry Run:")
-				log.Println(string(b))
-			} else {
-				_, err := s.DestinationCalendarService.Insert("primary", newEvent)
-				if err != nil {
-					log.Printf("Error creating event: %v", err)
+				if updated {
+					updatedEvents++
+					continue
+				}
+			}
+			// Patch blocks whose source event moved or whose rules changed
+			// what they show
+			if newEvent := s.newDestinationEvent(event); s.blockChanged(blockHashes, existingEvent, newEvent) {
+				patch := &calendar.Event{
+					Summary:     newEvent.Summary,
+					Description: newEvent.Description,
+					ColorId:     newEvent.ColorId,
+					Start:       newEvent.Start,
+					End:         newEvent.End,
+				}
+				if err := s.patchDestinationEvent(existingEvent, patch, dryRun); err != nil {
 					return err
 				}
+				updatedEvents++
+				continue
+			}
+			skippedEvents++
+		} else {
+			eventsCreated++
+			insertedEvent, err := s.insertDestinationEvent(s.newDestinationEvent(event), dryRun)
+			if err != nil {
+				return err
+			}
+			if len(event.Recurrence) > 0 {
+				destinationSeries[event.Id] = insertedEvent
 			}
 		}
 	}
 
+	if s.MirrorRecurring {
+		updated, deleted, err := s.applyInstanceOverrides(sourceEvents, sourceExceptions, destinationSeries, dryRun)
+		if err != nil {
+			return err
+		}
+		updatedEvents += updated
+		deletedEvents += deleted
+	}
+
 	// Remove blocks that don't exist in source calendar anymore, or are in the past
-	oldEvents := findOldEvents(sourceEvents, existingDestinationEvents, now)
+	oldEvents := collapseInstances(findOldEvents(sourceEvents, existingDestinationEvents, now))
 	for _, event := range oldEvents {
 		deletedEvents++
 		err := s.deleteDestinationEvent(event, dryRun)
@@ -115,31 +252,129 @@ func (s *SyncClient) RunSync(daysAhead int, dryRun bool) error {
 		}
 	}
 
-	log.Println("Sync completed successfully")
-	log.Printf(
-		"Source events scanned: %d\nEvents skipped: %d\nEvents added: %d\nEvents deleted: %d",
-		sourceEventCount,
-		skippedEvents,
-		eventsCreated,
-		deletedEvents,
+	s.logger().Info("Sync completed successfully",
+		"scanned", sourceEventCount,
+		"excluded", excludedEvents,
+		"skipped", skippedEvents,
+		"created", eventsCreated,
+		"updated", updatedEvents,
+		"deleted", deletedEvents,
 	)
 	return nil
 }
 
-func (s *SyncClient) fetchBusyBlockEvents(endTime time.Time) []*calendar.Event {
-	events, err := s.DestinationCalendarService.List(defaultCalendar, time.Time{}, endTime, map[string]string{appName: propertyAppNameValue})
+func (s *SyncClient) sourceCalendar() string {
+	if s.SourceCalendarId == "" {
+		return defaultCalendar
+	}
+	return s.SourceCalendarId
+}
+
+func (s *SyncClient) destinationCalendar() string {
+	if s.DestinationCalendarId == "" {
+		return defaultCalendar
+	}
+	return s.DestinationCalendarId
+}
+
+func (s *SyncClient) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
+
+func (s *SyncClient) location() *time.Location {
+	if s.Location == nil {
+		return time.Local
+	}
+	return s.Location
+}
+
+func (s *SyncClient) fetchBusyBlockEvents(endTime time.Time) ([]*calendar.Event, error) {
+	privateProperties := map[string]string{appName: propertyAppNameValue}
+
+	var events []*calendar.Event
+	var err error
+	if s.MirrorRecurring {
+		_, destination, _ := s.recurringServices()
+		end := s.traceCall(destination, "ListSeries", s.destinationCalendar(), "")
+		events, err = destination.ListSeries(s.destinationCalendar(), time.Time{}, endTime, privateProperties)
+		end(err, eventCount(len(events)))
+	} else {
+		end := s.traceCall(s.DestinationCalendarService, "List", s.destinationCalendar(), "")
+		events, err = s.DestinationCalendarService.List(s.destinationCalendar(), time.Time{}, endTime, privateProperties)
+		end(err, eventCount(len(events)))
+	}
 	if err != nil {
-		log.Fatalf("Unable to fetch destination calendar events: %v", err)
+		return nil, fmt.Errorf("unable to fetch destination calendar events: %v", err)
 	}
-	return events
+	return events, nil
 }
 
-func (s *SyncClient) fetchSourceEvents(startTime time.Time, endTime time.Time) []*calendar.Event {
-	events, err := s.SourceCalendarService.List(defaultCalendar, startTime, endTime, nil)
+func (s *SyncClient) fetchSourceEvents(startTime time.Time, endTime time.Time) ([]*calendar.Event, error) {
+	var events []*calendar.Event
+	var err error
+	if s.MirrorRecurring {
+		source, _, _ := s.recurringServices()
+		end := s.traceCall(source, "ListSeries", s.sourceCalendar(), "")
+		events, err = source.ListSeries(s.sourceCalendar(), startTime, endTime, nil)
+		end(err, eventCount(len(events)))
+	} else {
+		end := s.traceCall(s.SourceCalendarService, "List", s.sourceCalendar(), "")
+		events, err = s.SourceCalendarService.List(s.sourceCalendar(), startTime, endTime, nil)
+		end(err, eventCount(len(events)))
+	}
 	if err != nil {
-		log.Fatalf("Unable to fetch source calendar events: %v", err)
+		return nil, fmt.Errorf("unable to fetch source calendar events: %v", err)
 	}
-	return events
+
+	// Blocks are never blocked in turn, or two calendars syncing into each
+	// other would bounce them back and forth forever
+	sourceEvents := []*calendar.Event{}
+	for _, event := range events {
+		if !isBusyBlock(event) {
+			sourceEvents = append(sourceEvents, event)
+		}
+	}
+	return sourceEvents, nil
+}
+
+// blockSourceId returns the ID of the source event a block was created for.
+func blockSourceId(event *calendar.Event) string {
+	if event.ExtendedProperties == nil {
+		return ""
+	}
+	return event.ExtendedProperties.Private[sourceEventIdPropertyKey]
+}
+
+// isBusyBlock reports whether an event is a block created by this app.
+func isBusyBlock(event *calendar.Event) bool {
+	return event.ExtendedProperties != nil && event.ExtendedProperties.Private[appName] == propertyAppNameValue
+}
+
+// filterExcludedEvents drops the source events a rule or marker excludes from
+// blocking.
+func (s *SyncClient) filterExcludedEvents(sourceEvents []*calendar.Event) []*calendar.Event {
+	included := []*calendar.Event{}
+	for _, event := range sourceEvents {
+		if !s.excludes(event) {
+			included = append(included, event)
+			continue
+		}
+		s.logger().Debug("Excluded by the rules", "source_event_id", event.Id, "title", event.Summary)
+	}
+	return included
+}
+
+// newDestinationEvent builds the block for a source event with the matching
+// rule, if any, applied to it.
+func (s *SyncClient) newDestinationEvent(sourceEvent *calendar.Event) *calendar.Event {
+	event := createDestinationEvent(sourceEvent, s.location())
+	if rule := s.Rules.Match(sourceEvent, s.sourceCalendar(), s.location()); rule != nil {
+		rule.Apply(event)
+	}
+	return event
 }
 
 func findOldEvents(sourceEvents []*calendar.Event, destinationEvents []*calendar.Event, startTime time.Time) []*calendar.Event {
@@ -158,46 +393,87 @@ func findOldEvents(sourceEvents []*calendar.Event, destinationEvents []*calendar
 	return oldEvents
 }
 
-func createDestinationEvent(sourceEvent *calendar.Event) *calendar.Event {
+func createDestinationEvent(sourceEvent *calendar.Event, loc *time.Location) *calendar.Event {
+	start := normalizeEventDateTime(sourceEvent.Start, loc)
+	end := normalizeEventDateTime(sourceEvent.End, loc)
+	// A recurrence rule is expanded in the zone of its start time, so a series
+	// has to keep the source zone for its instances to land on the right times
+	if len(sourceEvent.Recurrence) > 0 {
+		start = sourceEvent.Start
+		end = sourceEvent.End
+	}
+
 	return &calendar.Event{
 		ColorId:     "4",
 		Summary:     "Busy",
 		Description: "Created with <a href=\"https://github.com/davidpimentel/gcal-busy-blocker\">gcal-busy-blocker</a>. User has a personal commitment and is busy at this time. Please find another time to avoid scheduling conflicts.",
-		Start:       sourceEvent.Start,
-		End:         sourceEvent.End,
+		Start:       start,
+		End:         end,
+		Recurrence:  sourceEvent.Recurrence,
 		// Add extended properties to track the source event
 		ExtendedProperties: &calendar.EventExtendedProperties{
 			Private: map[string]string{
 				appName:                  propertyAppNameValue,
 				sourceEventIdPropertyKey: sourceEvent.Id,
+				schemaVersionPropertyKey: strconv.Itoa(currentSchema()),
 			},
 		},
 		Source: &calendar.EventSource{
-			Title: "gcal-busy-blocker",
-			Url:   "https://github.com/davidpimentel/gcal-busy-blocker",
+			Title: appName,
+			Url:   appURL,
 		},
 	}
 }
 
-func eventAlreadyExists(destinationEvents []*calendar.Event, sourceEventID string) bool {
+// findDestinationEvent returns the block generated for a source event.
+func findDestinationEvent(destinationEvents []*calendar.Event, sourceEventID string) *calendar.Event {
+	events := findDestinationEvents(destinationEvents, sourceEventID)
+	if len(events) == 0 {
+		return nil
+	}
+	return events[0]
+}
+
+// findDestinationEvents returns every block generated for a source event.
+// Instances of a mirrored series share the series' properties and are never
+// a match.
+func findDestinationEvents(destinationEvents []*calendar.Event, sourceEventID string) []*calendar.Event {
+	events := []*calendar.Event{}
 	for _, event := range destinationEvents {
+		if event.RecurringEventId != "" {
+			continue
+		}
 		if event.ExtendedProperties != nil && event.ExtendedProperties.Private != nil {
 			if event.ExtendedProperties.Private[sourceEventIdPropertyKey] == sourceEventID {
-				return true
+				events = append(events, event)
 			}
 		}
 	}
-	return false
+	return events
 }
 
-func (s *SyncClient) Clean(dryRun bool) error {
-	events := s.fetchBusyBlockEvents(time.Time{})
+func (s *SyncClient) Clean(dryRun bool) (err error) {
+	s.beginRun("clean", dryRun)
+	defer func() { s.finishRun(err) }()
+	var deleted int
+	finishSpan := s.startSpan("Clean", attribute.Bool("sync.dry_run", dryRun))
+	defer func() { finishSpan(err, attribute.Int("sync.deleted", deleted)) }()
 
-	for _, event := range events {
+	events, err := s.fetchBusyBlockEvents(time.Time{})
+	if err != nil {
+		return err
+	}
+
+	for _, event := range collapseInstances(events) {
 		err := s.deleteDestinationEvent(event, dryRun)
 		if err != nil {
 			return err
 		}
+		deleted++
+	}
+	// The calendar is known to hold no blocks now
+	if s.useState() && !dryRun {
+		return s.State.Rebuild(s.DestinationStateKey, nil)
 	}
 	return nil
 }
@@ -209,14 +485,106 @@ func (s *SyncClient) deleteDestinationEvent(event *calendar.Event, dryRun bool)
 	}
 
 	if dryRun {
-		fmt.Printf("DRY RUN - Deleting event at %s - %s\n", event.Start.DateTime, event.End.DateTime)
+		s.logger().Info("Would delete block", blockAttrs(event)...)
 	} else {
-		fmt.Printf("Deleting event at %s - %s\n", event.Start.DateTime, event.End.DateTime)
+		s.logger().Info("Deleting block", blockAttrs(event)...)
+
+		before := s.priorEvent(event)
+		finish := s.journal(state.OperationDelete, event.Id, blockSourceId(event))
+		end := s.traceCall(s.DestinationCalendarService, "Delete", s.destinationCalendar(), event.Id)
+		err := s.DestinationCalendarService.Delete(s.destinationCalendar(), event.Id)
+		end(err)
+		s.audit(audit.ActionDelete, event.Id, blockSourceId(event), err)
+		// A block the local state remembers may have been deleted by hand, or
+		// by an interrupted run
+		if err != nil && s.useState() && isGone(err) {
+			s.logger().Info("Block was already deleted", "event_id", event.Id)
+			err = nil
+			before = nil
+		}
+		if err != nil {
+			return fmt.Errorf("error deleting event %s: %w", event.Id, err)
+		}
+		s.forgetBlock(event.Id)
+		finish()
+		if before != nil {
+			s.recordChange(state.OperationDelete, event.Id, before, nil)
+		}
+	}
+	return nil
+}
 
-		err := s.DestinationCalendarService.Delete(defaultCalendar, event.Id)
+// insertDestinationEvent creates a block, returning the created event or nil
+// on a dry run.
+func (s *SyncClient) insertDestinationEvent(newEvent *calendar.Event, dryRun bool) (*calendar.Event, error) {
+	if dryRun {
+		s.logger().Info("Would create block", blockAttrs(newEvent)...)
+		b, err := json.MarshalIndent(newEvent, "", "  ")
 		if err != nil {
-			return fmt.Errorf("error deleting event %s: %v", event.Id, err)
+			return nil, err
 		}
+		s.logger().Debug("Block to create", "event", string(b))
+		return nil, nil
+	}
+	s.logger().Info("Creating block", blockAttrs(newEvent)...)
+
+	block := *newEvent
+	if block.Id == "" {
+		block.Id = s.blockId(newEvent)
+	}
+	finish := s.journal(state.OperationInsert, block.Id, blockSourceId(newEvent))
+	end := s.traceCall(s.DestinationCalendarService, "Insert", s.destinationCalendar(), block.Id)
+	insertedEvent, err := s.DestinationCalendarService.Insert(s.destinationCalendar(), &block)
+	end(err)
+	if err != nil {
+		s.audit(audit.ActionInsert, block.Id, blockSourceId(newEvent), err)
+		s.logger().Error("Unable to create block", "error", err)
+		return nil, err
+	}
+	// Recorded as written, in case the response leaves out any of it
+	recorded := block
+	recorded.Id, recorded.Etag = insertedEvent.Id, insertedEvent.Etag
+	s.audit(audit.ActionInsert, recorded.Id, blockSourceId(newEvent), nil)
+	s.recordBlock(&recorded)
+	finish()
+	s.recordChange(state.OperationInsert, recorded.Id, nil, &recorded)
+	return insertedEvent, nil
+}
+
+func (s *SyncClient) patchDestinationEvent(event *calendar.Event, patch *calendar.Event, dryRun bool) error {
+	// Sanity check, ensure each event is definitely ours
+	if event.ExtendedProperties == nil || event.ExtendedProperties.Private[appName] != propertyAppNameValue {
+		return fmt.Errorf("aborting, almost updated an event we weren't supposed to! Event ID = %s", event.Id)
+	}
+
+	if dryRun {
+		s.logger().Info("Would update block", blockAttrs(event)...)
+	} else {
+		s.logger().Info("Updating block", blockAttrs(event)...)
+
+		before := s.priorEvent(event)
+		finish := s.journal(state.OperationPatch, event.Id, blockSourceId(event))
+		end := s.traceCall(s.DestinationCalendarService, "Patch", s.destinationCalendar(), event.Id)
+		response, err := s.DestinationCalendarService.Patch(s.destinationCalendar(), event.Id, patch)
+		end(err)
+		s.audit(audit.ActionUpdate, event.Id, blockSourceId(event), err)
+		if err != nil {
+			return fmt.Errorf("error updating event %s: %w", event.Id, err)
+		}
+		after := patchedEvent(before, patch, response)
+		s.recordBlock(after)
+		finish()
+		s.recordChange(state.OperationPatch, event.Id, before, after)
 	}
 	return nil
 }
+
+// blockAttrs are the attributes a block is logged with. What the block shows
+// is only logged at the debug level.
+func blockAttrs(event *calendar.Event) []any {
+	attrs := []any{}
+	if event.Id != "" {
+		attrs = append(attrs, "event_id", event.Id)
+	}
+	return append(attrs, "start", eventTimeKey(event.Start), "end", eventTimeKey(event.End))
+}
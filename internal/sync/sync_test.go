@@ -1,18 +1,29 @@
 package sync
 
 import (
+	"log/slog"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/davidpimentel/gcal-busy-blocker/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"google.golang.org/api/calendar/v3"
 )
 
 type MockCalendarEventsService struct {
 	events         []*calendar.Event
+	instances      []*calendar.Event
 	insertedEvents []*calendar.Event
+	patchedEvents  map[string]*calendar.Event
+	patchError     error
 	deletedEvents  []string
 	listCalls      []*listCallParams
+	seriesCalls    []*listCallParams
+
+	// filterPrivateProperties makes List only return events with matching
+	// private properties, like the real calendar does
+	filterPrivateProperties bool
 }
 
 type listCallParams struct {
@@ -39,14 +50,54 @@ func (m *MockCalendarEventsService) List(calendarId string, startTime time.Time,
 		privateProperties: privateProperties,
 	})
 
+	if m.filterPrivateProperties {
+		events := []*calendar.Event{}
+		for _, event := range m.events {
+			if hasPrivateProperties(event, privateProperties) {
+				events = append(events, event)
+			}
+		}
+		return events, nil
+	}
 	return m.events, nil
 }
 
+func (m *MockCalendarEventsService) ListSeries(calendarId string, startTime time.Time, endTime time.Time, privateProperties map[string]string) ([]*calendar.Event, error) {
+	m.seriesCalls = append(m.seriesCalls, &listCallParams{
+		calendarId:        calendarId,
+		startTime:         startTime,
+		endTime:           endTime,
+		privateProperties: privateProperties,
+	})
+
+	return m.events, nil
+}
+
+func (m *MockCalendarEventsService) Instance(calendarId string, eventId string, originalStartTime *calendar.EventDateTime) (*calendar.Event, error) {
+	for _, instance := range m.instances {
+		if instance.RecurringEventId == eventId && sameEventTime(instance.OriginalStartTime, originalStartTime) {
+			return instance, nil
+		}
+	}
+	return nil, nil
+}
+
 func (m *MockCalendarEventsService) Insert(calendarId string, event *calendar.Event) (*calendar.Event, error) {
 	m.insertedEvents = append(m.insertedEvents, event)
 	return event, nil
 }
 
+func (m *MockCalendarEventsService) Patch(calendarId string, eventId string, event *calendar.Event) (*calendar.Event, error) {
+	if m.patchError != nil {
+		return nil, m.patchError
+	}
+	if m.patchedEvents == nil {
+		m.patchedEvents = map[string]*calendar.Event{}
+	}
+	m.patchedEvents[eventId] = event
+	return event, nil
+}
+
 func (m *MockCalendarEventsService) Delete(calendarId string, eventId string) error {
 	m.deletedEvents = append(m.deletedEvents, eventId)
 	return nil
@@ -342,3 +393,88 @@ func TestDeleteDestinationEventDryRun(t *testing.T) {
 		t.Error("deleted event when it shouldn't")
 	}
 }
+
+func TestRunSyncMetrics(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	personal := &memoryCalendarService{name: "personal", events: []*calendar.Event{
+		createTestEvent("dentist", "Dentist", start, start.Add(time.Hour), nil),
+		createTestEvent("gym", "Gym", start.Add(3*time.Hour), start.Add(4*time.Hour), nil),
+	}}
+	syncMetrics := metrics.NewSyncMetrics()
+	syncClient := &SyncClient{
+		SourceCalendarService:      personal,
+		DestinationCalendarService: &memoryCalendarService{name: "work"},
+		Metrics:                    syncMetrics,
+		DestinationAccount:         "work",
+	}
+
+	for range 2 {
+		if err := syncClient.RunSync(7, false); err != nil {
+			t.Fatalf("Function returned error: %v", err)
+		}
+	}
+	// Dry runs aren't measured
+	if err := syncClient.RunSync(7, true); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if syncs := testutil.ToFloat64(syncMetrics.Syncs.WithLabelValues("work", metrics.ResultSuccess)); syncs != 2 {
+		t.Errorf("Expected 2 successful syncs, got %v", syncs)
+	}
+	for _, action := range []string{"created", "skipped"} {
+		if events := testutil.ToFloat64(syncMetrics.Events.WithLabelValues("work", action)); events != 2 {
+			t.Errorf("Expected 2 %s events, got %v", action, events)
+		}
+	}
+	if testutil.CollectAndCount(syncMetrics.LastSuccess) != 1 {
+		t.Error("Expected the time of the last success to be recorded")
+	}
+}
+
+func TestRunSyncLogging(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	newClient := func(out *strings.Builder, level slog.Level) *SyncClient {
+		return &SyncClient{
+			SourceCalendarService: &memoryCalendarService{name: "personal", events: []*calendar.Event{
+				createTestEvent("dentist", "Root canal", start, start.Add(time.Hour), nil),
+				createTestEvent("gym", "Gym with Sam", start.Add(3*time.Hour), start.Add(4*time.Hour), nil),
+			}},
+			DestinationCalendarService: &memoryCalendarService{name: "work"},
+			Rules: &Rules{Rules: []*Rule{
+				{Name: "no gym", Match: RuleMatch{Title: "Gym"}, Action: ActionExclude},
+			}},
+			Logger: slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})),
+		}
+	}
+
+	var info strings.Builder
+	if err := newClient(&info, slog.LevelInfo).RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	for _, want := range []string{`"msg":"Creating block"`, `"msg":"Sync completed successfully"`, `"created":1`, `"excluded":1`} {
+		if !strings.Contains(info.String(), want) {
+			t.Errorf("Expected %s in:\n%s", want, info.String())
+		}
+	}
+	for _, title := range []string{"Root canal", "Gym with Sam"} {
+		if strings.Contains(info.String(), title) {
+			t.Errorf("Expected no source titles at the info level, got %q in:\n%s", title, info.String())
+		}
+	}
+
+	var debug strings.Builder
+	if err := newClient(&debug, slog.LevelDebug).RunSync(7, true); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if !strings.Contains(debug.String(), `"title":"Gym with Sam"`) {
+		t.Errorf("Expected the excluded event's title at the debug level in:\n%s", debug.String())
+	}
+
+	var quiet strings.Builder
+	if err := newClient(&quiet, slog.LevelError).RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+	if quiet.Len() != 0 {
+		t.Errorf("Expected nothing logged at the error level, got:\n%s", quiet.String())
+	}
+}
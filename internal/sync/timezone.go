@@ -0,0 +1,95 @@
+package sync
+
+import (
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// clock returns the time a sync runs at. Tests replace it to run syncs at
+// different times.
+var clock = time.Now
+
+// syncWindow returns the range of time to sync. The window starts at now and
+// ends at midnight after the last day, with day boundaries taken from loc so
+// that "30 days ahead" means the same thing regardless of the host's zone.
+func syncWindow(now time.Time, daysAhead int, loc *time.Location) (time.Time, time.Time) {
+	start := now.In(loc)
+	year, month, day := start.Date()
+	end := time.Date(year, month, day+daysAhead+1, 0, 0, 0, 0, loc)
+	return start, end
+}
+
+// zoneName returns the IANA name of loc, or an empty string when loc is the
+// process' local zone, which has no name the Calendar API would accept.
+func zoneName(loc *time.Location) string {
+	if loc == nil || loc == time.Local || loc.String() == "Local" {
+		return ""
+	}
+	return loc.String()
+}
+
+// normalizeEventDateTime rewrites a timed event boundary in loc so generated
+// blocks don't inherit the source calendar's zone. All-day boundaries only
+// carry a date and are returned without a zone.
+func normalizeEventDateTime(eventTime *calendar.EventDateTime, loc *time.Location) *calendar.EventDateTime {
+	if eventTime == nil {
+		return nil
+	}
+	if eventTime.DateTime == "" {
+		return &calendar.EventDateTime{Date: eventTime.Date}
+	}
+
+	t, err := time.Parse(time.RFC3339, eventTime.DateTime)
+	if err != nil {
+		// Leave anything we can't parse for the API to deal with
+		return eventTime
+	}
+	return &calendar.EventDateTime{
+		DateTime: t.In(loc).Format(time.RFC3339),
+		TimeZone: zoneName(loc),
+	}
+}
+
+// sameEventTime reports whether two event boundaries refer to the same moment,
+// regardless of the zone each one is written in.
+func sameEventTime(a *calendar.EventDateTime, b *calendar.EventDateTime) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.DateTime == "" || b.DateTime == "" {
+		return a.DateTime == b.DateTime && a.Date == b.Date
+	}
+
+	aTime, aErr := time.Parse(time.RFC3339, a.DateTime)
+	bTime, bErr := time.Parse(time.RFC3339, b.DateTime)
+	if aErr != nil || bErr != nil {
+		return a.DateTime == b.DateTime
+	}
+	return aTime.Equal(bTime)
+}
+
+// shiftEventDateTime moves a timed event boundary by d. All-day boundaries are
+// returned unchanged.
+func shiftEventDateTime(eventTime *calendar.EventDateTime, d time.Duration) *calendar.EventDateTime {
+	if eventTime == nil || eventTime.DateTime == "" || d == 0 {
+		return eventTime
+	}
+
+	t, err := time.Parse(time.RFC3339, eventTime.DateTime)
+	if err != nil {
+		return eventTime
+	}
+	return &calendar.EventDateTime{
+		DateTime: t.Add(d).Format(time.RFC3339),
+		TimeZone: eventTime.TimeZone,
+	}
+}
+
+// eventDateTime writes t as a timed event boundary in loc.
+func eventDateTime(t time.Time, loc *time.Location) *calendar.EventDateTime {
+	return &calendar.EventDateTime{
+		DateTime: t.In(loc).Format(time.RFC3339),
+		TimeZone: zoneName(loc),
+	}
+}
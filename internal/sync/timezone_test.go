@@ -0,0 +1,162 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("unable to load %s: %v", name, err)
+	}
+	return loc
+}
+
+func TestSyncWindowSpringForward(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	// DST starts in New York at 2026-03-08 02:00
+	now := time.Date(2026, 3, 7, 10, 0, 0, 0, newYork)
+
+	start, end := syncWindow(now, 1, newYork)
+
+	if !start.Equal(now) {
+		t.Errorf("window should start now, got %s", start)
+	}
+	expectedEnd := time.Date(2026, 3, 9, 0, 0, 0, 0, newYork)
+	if !end.Equal(expectedEnd) {
+		t.Errorf("expected window to end at %s, got %s", expectedEnd, end)
+	}
+	if end.Sub(start) != 37*time.Hour {
+		t.Errorf("expected a 37 hour window across the DST change, got %s", end.Sub(start))
+	}
+}
+
+func TestSyncWindowFallBack(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	// DST ends in New York at 2026-11-01 02:00
+	now := time.Date(2026, 10, 31, 10, 0, 0, 0, newYork)
+
+	_, end := syncWindow(now, 1, newYork)
+
+	if end.Sub(now) != 39*time.Hour {
+		t.Errorf("expected a 39 hour window across the DST change, got %s", end.Sub(now))
+	}
+	if end.Hour() != 0 || end.Minute() != 0 {
+		t.Errorf("window should end at local midnight, got %s", end)
+	}
+}
+
+func TestSyncWindowUsesLocationNotHostZone(t *testing.T) {
+	tokyo := mustLoadLocation(t, "Asia/Tokyo")
+	// Late evening in UTC is already the next day in Tokyo
+	now := time.Date(2026, 6, 1, 20, 0, 0, 0, time.UTC)
+
+	_, end := syncWindow(now, 0, tokyo)
+
+	expectedEnd := time.Date(2026, 6, 3, 0, 0, 0, 0, tokyo)
+	if !end.Equal(expectedEnd) {
+		t.Errorf("expected window to end at %s, got %s", expectedEnd, end)
+	}
+}
+
+func TestNormalizeEventDateTimeAcrossSpringForward(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	// 06:30 in London is 01:30 EST, two hours later New York has moved to EDT
+	start := &calendar.EventDateTime{DateTime: "2026-03-08T06:30:00Z", TimeZone: "Europe/London"}
+	end := &calendar.EventDateTime{DateTime: "2026-03-08T08:30:00Z", TimeZone: "Europe/London"}
+
+	normalizedStart := normalizeEventDateTime(start, newYork)
+	normalizedEnd := normalizeEventDateTime(end, newYork)
+
+	if normalizedStart.DateTime != "2026-03-08T01:30:00-05:00" {
+		t.Errorf("unexpected start %s", normalizedStart.DateTime)
+	}
+	if normalizedEnd.DateTime != "2026-03-08T04:30:00-04:00" {
+		t.Errorf("unexpected end %s", normalizedEnd.DateTime)
+	}
+	if normalizedStart.TimeZone != "America/New_York" || normalizedEnd.TimeZone != "America/New_York" {
+		t.Errorf("source time zone leaked into the block: %s / %s", normalizedStart.TimeZone, normalizedEnd.TimeZone)
+	}
+
+	// The instant itself must never move
+	for _, pair := range [][2]*calendar.EventDateTime{{start, normalizedStart}, {end, normalizedEnd}} {
+		before, _ := time.Parse(time.RFC3339, pair[0].DateTime)
+		after, _ := time.Parse(time.RFC3339, pair[1].DateTime)
+		if !before.Equal(after) {
+			t.Errorf("normalizing moved %s to %s", pair[0].DateTime, pair[1].DateTime)
+		}
+	}
+}
+
+func TestNormalizeEventDateTimeAcrossFallBack(t *testing.T) {
+	berlin := mustLoadLocation(t, "Europe/Berlin")
+	// Berlin falls back on 2026-10-25, 02:30 happens twice that night
+	first := normalizeEventDateTime(&calendar.EventDateTime{DateTime: "2026-10-25T00:30:00Z"}, berlin)
+	second := normalizeEventDateTime(&calendar.EventDateTime{DateTime: "2026-10-25T01:30:00Z"}, berlin)
+
+	if first.DateTime != "2026-10-25T02:30:00+02:00" {
+		t.Errorf("unexpected first occurrence %s", first.DateTime)
+	}
+	if second.DateTime != "2026-10-25T02:30:00+01:00" {
+		t.Errorf("unexpected second occurrence %s", second.DateTime)
+	}
+}
+
+func TestNormalizeEventDateTimeAllDay(t *testing.T) {
+	tokyo := mustLoadLocation(t, "Asia/Tokyo")
+
+	normalized := normalizeEventDateTime(&calendar.EventDateTime{Date: "2026-03-08", TimeZone: "Europe/London"}, tokyo)
+
+	if normalized.Date != "2026-03-08" || normalized.DateTime != "" {
+		t.Errorf("all-day event shouldn't be shifted, got %+v", normalized)
+	}
+	if normalized.TimeZone != "" {
+		t.Errorf("all-day event shouldn't carry a zone, got %s", normalized.TimeZone)
+	}
+}
+
+func TestNormalizeEventDateTimeLocalZoneIsOmitted(t *testing.T) {
+	normalized := normalizeEventDateTime(&calendar.EventDateTime{DateTime: "2026-03-08T06:30:00Z", TimeZone: "Europe/London"}, time.Local)
+
+	if normalized.TimeZone != "" {
+		t.Errorf("the process' local zone has no IANA name and shouldn't be sent, got %s", normalized.TimeZone)
+	}
+}
+
+func TestRunSyncWritesBlocksInConfiguredZone(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	london := mustLoadLocation(t, "Europe/London")
+	start := time.Now().Add(time.Hour).Truncate(time.Second).In(london)
+	sourceEvent := createTestEvent("123", "test summary", start, start.Add(time.Hour), nil)
+	sourceEvent.Start.TimeZone = "Europe/London"
+
+	mockSourceService := &MockCalendarEventsService{events: []*calendar.Event{sourceEvent}}
+	mockDestinationService := &MockCalendarEventsService{}
+	syncClient := &SyncClient{
+		SourceCalendarService:      mockSourceService,
+		DestinationCalendarService: mockDestinationService,
+		Location:                   newYork,
+	}
+
+	syncClient.RunSync(30, false)
+
+	if len(mockDestinationService.insertedEvents) != 1 {
+		t.Fatalf("expected 1 inserted event, got %d", len(mockDestinationService.insertedEvents))
+	}
+	inserted := mockDestinationService.insertedEvents[0]
+	if inserted.Start.TimeZone != "America/New_York" {
+		t.Errorf("expected block in America/New_York, got %q", inserted.Start.TimeZone)
+	}
+	if inserted.Start.DateTime != start.In(newYork).Format(time.RFC3339) {
+		t.Errorf("unexpected start %s", inserted.Start.DateTime)
+	}
+
+	listCall := mockSourceService.listCalls[0]
+	if listCall.endTime.Location() != newYork || listCall.endTime.Hour() != 0 {
+		t.Errorf("expected the window to end at midnight in New York, got %s", listCall.endTime)
+	}
+}
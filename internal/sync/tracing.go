@@ -0,0 +1,72 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/davidpimentel/gcal-busy-blocker/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is looked up on each use, so spans go to the provider set up last.
+func tracer() trace.Tracer {
+	return otel.Tracer("github.com/davidpimentel/gcal-busy-blocker/internal/sync")
+}
+
+// endSpan ends a span with the error of what it traced and any attributes
+// only known by then.
+type endSpan func(err error, attributes ...attribute.KeyValue)
+
+// startSpan starts the span of a command, such as a sync. Calls to the
+// calendars are traced under it until it's ended.
+func (s *SyncClient) startSpan(name string, attributes ...attribute.KeyValue) endSpan {
+	previous := s.ctx
+	attributes = append([]attribute.KeyValue{
+		attribute.String("calendar.source", s.sourceCalendar()),
+		attribute.String("calendar.destination", s.destinationCalendar()),
+	}, attributes...)
+	if s.DestinationAccount != "" {
+		attributes = append(attributes, attribute.String("auth.account", s.DestinationAccount))
+	}
+	ctx, span := tracer().Start(s.context(), name, trace.WithAttributes(attributes...))
+	s.ctx = ctx
+	return func(err error, attributes ...attribute.KeyValue) {
+		span.SetAttributes(attributes...)
+		tracing.End(span, err)
+		s.ctx = previous
+	}
+}
+
+// traceCall starts the span of a call to a calendar service. The event ID is
+// left out when the call isn't about a single event.
+func (s *SyncClient) traceCall(service any, method string, calendarId string, eventId string) endSpan {
+	attributes := []attribute.KeyValue{
+		attribute.String("calendar.service", fmt.Sprintf("%T", service)),
+		attribute.String("calendar.id", calendarId),
+	}
+	if eventId != "" {
+		attributes = append(attributes, attribute.String("calendar.event_id", eventId))
+	}
+	_, span := tracer().Start(s.context(), "calendar."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...),
+	)
+	return func(err error, attributes ...attribute.KeyValue) {
+		span.SetAttributes(attributes...)
+		tracing.End(span, err)
+	}
+}
+
+func (s *SyncClient) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// eventCount is the attribute of the number of events a list returned.
+func eventCount(n int) attribute.KeyValue {
+	return attribute.Int("calendar.events", n)
+}
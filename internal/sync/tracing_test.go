@@ -0,0 +1,148 @@
+package sync
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/api/calendar/v3"
+)
+
+// recordSpans sends the spans of a test to a recorder.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key string) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestRunSyncSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	syncClient := &SyncClient{
+		SourceCalendarService: &memoryCalendarService{name: "personal", events: []*calendar.Event{
+			createTestEvent("dentist", "Dentist", start, start.Add(time.Hour), nil),
+		}},
+		DestinationCalendarService: &memoryCalendarService{name: "work"},
+		DestinationAccount:         "me@work.example.com",
+	}
+
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	spans := recorder.Ended()
+	root := spans[len(spans)-1]
+	if root.Name() != "RunSync" || root.Parent().IsValid() {
+		t.Fatalf("Expected the RunSync span to end last, as the root, got %s", root.Name())
+	}
+	if value, _ := spanAttribute(root, "sync.created"); value.AsInt64() != 1 {
+		t.Errorf("Expected 1 created block on the sync span, got %v", value.Emit())
+	}
+	if value, _ := spanAttribute(root, "auth.account"); value.AsString() != "me@work.example.com" {
+		t.Errorf("Expected the account on the sync span, got %q", value.AsString())
+	}
+
+	calls := []string{}
+	for _, span := range spans[:len(spans)-1] {
+		if span.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("Expected %s to be a child of the sync span", span.Name())
+		}
+		calls = append(calls, span.Name())
+	}
+	expected := []string{"calendar.List", "calendar.List", "calendar.Insert"}
+	if len(calls) != len(expected) {
+		t.Fatalf("Expected calls %v, got %v", expected, calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Errorf("Expected calls %v, got %v", expected, calls)
+			break
+		}
+	}
+	if value, _ := spanAttribute(spans[0], "calendar.events"); value.AsInt64() != 1 {
+		t.Errorf("Expected the source list to return 1 event, got %v", value.Emit())
+	}
+	if value, _ := spanAttribute(spans[2], "calendar.id"); value.AsString() != defaultCalendar {
+		t.Errorf("Expected the calendar on the insert span, got %q", value.AsString())
+	}
+	if syncClient.ctx != nil {
+		t.Error("Expected the span to be cleared when the sync ended")
+	}
+}
+
+func TestTwoWaySyncSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	syncClient := &SyncClient{
+		SourceCalendarService:      &memoryCalendarService{name: "personal"},
+		DestinationCalendarService: &memoryCalendarService{name: "work"},
+	}
+
+	if err := syncClient.RunTwoWaySync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	var root sdktrace.ReadOnlySpan
+	syncs := 0
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case "RunTwoWaySync":
+			root = span
+		case "RunSync":
+			syncs++
+		}
+	}
+	if root == nil || syncs != 2 {
+		t.Fatalf("Expected a two-way span and 2 sync spans, got %d", syncs)
+	}
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() != root.SpanContext().TraceID() {
+			t.Errorf("Expected %s to be traced under the two-way sync", span.Name())
+		}
+	}
+}
+
+// failingListService can't list its events.
+type failingListService struct {
+	*memoryCalendarService
+}
+
+func (f failingListService) List(calendarId string, startTime time.Time, endTime time.Time, privateProperties map[string]string) ([]*calendar.Event, error) {
+	return nil, errors.New("backend error")
+}
+
+func TestCleanSpanRecordsErrors(t *testing.T) {
+	recorder := recordSpans(t)
+	syncClient := &SyncClient{
+		DestinationCalendarService: failingListService{&memoryCalendarService{name: "work"}},
+	}
+
+	if err := syncClient.Clean(false); err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	for _, span := range recorder.Ended() {
+		if span.Status().Code != codes.Error {
+			t.Errorf("Expected %s to be marked failed", span.Name())
+		}
+	}
+	if spans := recorder.Ended(); len(spans) != 2 || spans[1].Name() != "Clean" {
+		t.Errorf("Expected a list span under the clean span, got %d spans", len(spans))
+	}
+}
@@ -0,0 +1,30 @@
+package sync
+
+import "go.opentelemetry.io/otel/attribute"
+
+// RunTwoWaySync blocks time in both directions: the destination calendar gets
+// blocks for the source's events, then the source calendar gets blocks for the
+// destination's events. Blocks are left out when listing either calendar as a
+// source, so they never come back around. Both calendars must be writable.
+func (s *SyncClient) RunTwoWaySync(daysAhead int, dryRun bool) (err error) {
+	finishSpan := s.startSpan("RunTwoWaySync", attribute.Int("sync.days_ahead", daysAhead), attribute.Bool("sync.dry_run", dryRun))
+	defer func() { finishSpan(err) }()
+
+	s.logger().Info("Blocking the destination calendar with source events")
+	if err := s.RunSync(daysAhead, dryRun); err != nil {
+		return err
+	}
+
+	s.logger().Info("Blocking the source calendar with destination events")
+	return s.reversed().RunSync(daysAhead, dryRun)
+}
+
+// reversed returns a copy of the client that syncs the other way round.
+func (s *SyncClient) reversed() *SyncClient {
+	reversed := *s
+	reversed.SourceCalendarService, reversed.DestinationCalendarService = s.DestinationCalendarService, s.SourceCalendarService
+	reversed.SourceCalendarId, reversed.DestinationCalendarId = s.DestinationCalendarId, s.SourceCalendarId
+	reversed.SourceStateKey, reversed.DestinationStateKey = s.DestinationStateKey, s.SourceStateKey
+	reversed.SourceAccount, reversed.DestinationAccount = s.DestinationAccount, s.SourceAccount
+	return &reversed
+}
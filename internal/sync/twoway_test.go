@@ -0,0 +1,195 @@
+package sync
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// memoryCalendarService is a calendar that behaves like a real one across
+// runs: inserted events show up in later listings and deleted ones go away.
+type memoryCalendarService struct {
+	name   string
+	events []*calendar.Event
+	nextId int
+}
+
+func (m *memoryCalendarService) List(calendarId string, startTime time.Time, endTime time.Time, privateProperties map[string]string) ([]*calendar.Event, error) {
+	events := []*calendar.Event{}
+	for _, event := range m.events {
+		if hasPrivateProperties(event, privateProperties) && eventOverlaps(event, startTime, endTime) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (m *memoryCalendarService) Insert(calendarId string, event *calendar.Event) (*calendar.Event, error) {
+	m.nextId++
+	inserted := *event
+	inserted.Id = fmt.Sprintf("%s-%d", m.name, m.nextId)
+	m.events = append(m.events, &inserted)
+	return &inserted, nil
+}
+
+func (m *memoryCalendarService) Patch(calendarId string, eventId string, patch *calendar.Event) (*calendar.Event, error) {
+	for i, listed := range m.events {
+		if listed.Id == eventId {
+			// Events that were listed before stay as they were read
+			event := *listed
+			m.events[i] = &event
+			if patch.Start != nil {
+				event.Start, event.End = patch.Start, patch.End
+			}
+			// Private properties are merged, like the real calendar does
+			if patch.ExtendedProperties != nil {
+				private := map[string]string{}
+				if event.ExtendedProperties != nil {
+					maps.Copy(private, event.ExtendedProperties.Private)
+				}
+				maps.Copy(private, patch.ExtendedProperties.Private)
+				event.ExtendedProperties = &calendar.EventExtendedProperties{Private: private}
+			}
+			return &event, nil
+		}
+	}
+	return nil, fmt.Errorf("event %s not found", eventId)
+}
+
+func (m *memoryCalendarService) Delete(calendarId string, eventId string) error {
+	i := slices.IndexFunc(m.events, func(event *calendar.Event) bool { return event.Id == eventId })
+	if i < 0 {
+		return fmt.Errorf("event %s not found", eventId)
+	}
+	m.events = slices.Delete(m.events, i, i+1)
+	return nil
+}
+
+func (m *memoryCalendarService) blocks() []*calendar.Event {
+	blocks := []*calendar.Event{}
+	for _, event := range m.events {
+		if isBusyBlock(event) {
+			blocks = append(blocks, event)
+		}
+	}
+	return blocks
+}
+
+func newTwoWayTestCalendars() (*memoryCalendarService, *memoryCalendarService, time.Time) {
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).UTC()
+	work := &memoryCalendarService{name: "work", events: []*calendar.Event{
+		createTestEvent("work-standup", "Standup", start, start.Add(15*time.Minute), nil),
+		createTestEvent("work-incident", "Incident review", start.Add(2*time.Hour), start.Add(3*time.Hour), nil),
+	}}
+	personal := &memoryCalendarService{name: "personal", events: []*calendar.Event{
+		createTestEvent("personal-dentist", "Dentist", start.Add(5*time.Hour), start.Add(6*time.Hour), nil),
+	}}
+	return work, personal, start
+}
+
+func TestTwoWaySyncBlocksBothCalendars(t *testing.T) {
+	work, personal, _ := newTwoWayTestCalendars()
+	syncClient := &SyncClient{SourceCalendarService: work, DestinationCalendarService: personal, Location: time.UTC}
+
+	if err := syncClient.RunTwoWaySync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	sources := func(blocks []*calendar.Event) []string {
+		ids := []string{}
+		for _, block := range blocks {
+			ids = append(ids, block.ExtendedProperties.Private[sourceEventIdPropertyKey])
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	if ids := sources(personal.blocks()); !slices.Equal(ids, []string{"work-incident", "work-standup"}) {
+		t.Errorf("Expected the personal calendar to block the work events, got %v", ids)
+	}
+	if ids := sources(work.blocks()); !slices.Equal(ids, []string{"personal-dentist"}) {
+		t.Errorf("Expected the work calendar to block only the dentist, got %v", ids)
+	}
+}
+
+func TestTwoWaySyncDoesNotLoop(t *testing.T) {
+	work, personal, _ := newTwoWayTestCalendars()
+	syncClient := &SyncClient{SourceCalendarService: work, DestinationCalendarService: personal, Location: time.UTC}
+
+	for run := 1; run <= 5; run++ {
+		if err := syncClient.RunTwoWaySync(7, false); err != nil {
+			t.Fatalf("Run %d: function returned error: %v", run, err)
+		}
+		// Each calendar only ever holds its own events plus one block per event
+		// on the other side, no matter how often the sync runs
+		if len(work.events) != 3 || len(personal.events) != 3 {
+			t.Fatalf("Run %d: blocks are bouncing between calendars, work has %d events and personal has %d", run, len(work.events), len(personal.events))
+		}
+	}
+
+	for _, calendarService := range []*memoryCalendarService{work, personal} {
+		for _, block := range calendarService.blocks() {
+			sourceId := block.ExtendedProperties.Private[sourceEventIdPropertyKey]
+			if slices.ContainsFunc(append(work.blocks(), personal.blocks()...), func(other *calendar.Event) bool { return other.Id == sourceId }) {
+				t.Errorf("Block %s on the %s calendar was made from another block", block.Id, calendarService.name)
+			}
+		}
+	}
+}
+
+func TestTwoWaySyncRemovesBlocksBothWays(t *testing.T) {
+	work, personal, _ := newTwoWayTestCalendars()
+	syncClient := &SyncClient{SourceCalendarService: work, DestinationCalendarService: personal, Location: time.UTC}
+	if err := syncClient.RunTwoWaySync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	// The dentist appointment is cancelled
+	personal.Delete(defaultCalendar, "personal-dentist")
+	if err := syncClient.RunTwoWaySync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if len(work.blocks()) != 0 {
+		t.Errorf("Expected the dentist block to be removed from the work calendar, got %d blocks", len(work.blocks()))
+	}
+	if len(personal.blocks()) != 2 {
+		t.Errorf("Expected the work blocks to stay, got %d", len(personal.blocks()))
+	}
+}
+
+func TestSourceListingSkipsBlocks(t *testing.T) {
+	start := time.Now().Add(24 * time.Hour)
+	block := createTestEvent("block-1", "Busy", start, start.Add(time.Hour), blockProperties("other-calendar-event"))
+	source := NewMockCalendarEventsService([]*calendar.Event{
+		createTestEvent("1", "Meeting", start, start.Add(time.Hour), nil),
+		block,
+	})
+	destination := NewMockCalendarEventsService([]*calendar.Event{})
+	syncClient := &SyncClient{SourceCalendarService: source, DestinationCalendarService: destination}
+
+	if err := syncClient.RunSync(7, false); err != nil {
+		t.Fatalf("Function returned error: %v", err)
+	}
+
+	if len(destination.insertedEvents) != 1 || destination.insertedEvents[0].ExtendedProperties.Private[sourceEventIdPropertyKey] != "1" {
+		t.Errorf("Expected only the meeting to be blocked, got %v", destination.insertedEvents)
+	}
+}
+
+func TestReversed(t *testing.T) {
+	source := NewMockCalendarEventsService(nil)
+	destination := NewMockCalendarEventsService(nil)
+	syncClient := &SyncClient{SourceCalendarService: source, DestinationCalendarService: destination, SkipBusy: true}
+
+	reversed := syncClient.reversed()
+	if reversed.SourceCalendarService != destination || reversed.DestinationCalendarService != source || !reversed.SkipBusy {
+		t.Errorf("Unexpected reversed client %+v", reversed)
+	}
+	if syncClient.SourceCalendarService != source {
+		t.Error("Reversing changed the original client")
+	}
+}
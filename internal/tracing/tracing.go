@@ -0,0 +1,131 @@
+// Package tracing sends OpenTelemetry traces of syncs to an exporter, so a
+// slow sync can be followed down to the calendar calls and token refreshes
+// that held it up.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	gosync "sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+)
+
+// Exporters spans can be sent to.
+const (
+	ExporterNone    = "none"
+	ExporterConsole = "console"
+	ExporterOTLP    = "otlp"
+)
+
+const serviceName = "gcal-busy-blocker"
+
+// tracer is looked up on each use, so spans go to the provider set up last.
+func tracer() trace.Tracer {
+	return otel.Tracer("github.com/davidpimentel/gcal-busy-blocker/internal/tracing")
+}
+
+// Setup makes the global tracer provider send spans to the named exporter,
+// returning a function that flushes the spans left and stops it. The console
+// exporter writes each span as JSON to out. The OTLP exporter sends them over
+// HTTP to the collector set with the standard OTEL_EXPORTER_OTLP_*
+// environment variables, localhost:4318 by default. Nothing is traced with
+// the none exporter.
+func Setup(ctx context.Context, exporter string, out io.Writer) (func(context.Context) error, error) {
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterConsole:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(out), stdouttrace.WithPrettyPrint())
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, expected none, console or otlp", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", serviceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(spanExporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// End ends a span, marking it failed with err if there is one.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Instrument traces the token refreshes of an OAuth client. Other clients are
+// left alone.
+func Instrument(account string, client *http.Client) {
+	if transport, ok := client.Transport.(*oauth2.Transport); ok {
+		transport.Source = TokenSource(account, transport.Source)
+	}
+}
+
+// TokenSource traces the calls to source that fetch a new token. Sources such
+// as oauth2.ReuseTokenSource hand out the token they have until it expires,
+// so only the first call and the calls after the last token expired are
+// traced.
+func TokenSource(account string, source oauth2.TokenSource) oauth2.TokenSource {
+	return &tokenSource{account: account, source: source}
+}
+
+type tokenSource struct {
+	account string
+	source  oauth2.TokenSource
+
+	mu   gosync.Mutex
+	last *oauth2.Token
+}
+
+func (t *tokenSource) Token() (*oauth2.Token, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.last.Valid() {
+		return t.source.Token()
+	}
+
+	_, span := tracer().Start(context.Background(), "oauth2.Token",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("auth.account", t.account)),
+	)
+	token, err := t.source.Token()
+	if err == nil {
+		t.last = token
+		if !token.Expiry.IsZero() {
+			span.SetAttributes(attribute.String("auth.token_expiry", token.Expiry.UTC().Format(time.RFC3339)))
+		}
+	}
+	End(span, err)
+	return token, err
+}
@@ -0,0 +1,126 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/oauth2"
+)
+
+// recordSpans sends the spans of a test to a recorder.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+type fakeTokenSource struct {
+	tokens []*oauth2.Token
+	err    error
+	calls  int
+}
+
+func (f *fakeTokenSource) Token() (*oauth2.Token, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	token := f.tokens[0]
+	if !token.Valid() && len(f.tokens) > 1 {
+		f.tokens = f.tokens[1:]
+		token = f.tokens[0]
+	}
+	return token, nil
+}
+
+func TestTokenSourceTracesRefreshes(t *testing.T) {
+	recorder := recordSpans(t)
+	expiring := &oauth2.Token{AccessToken: "first", Expiry: time.Now().Add(time.Hour)}
+	source := &fakeTokenSource{tokens: []*oauth2.Token{expiring, {AccessToken: "second", Expiry: time.Now().Add(2 * time.Hour)}}}
+	traced := TokenSource("work", source)
+
+	for range 3 {
+		if _, err := traced.Token(); err != nil {
+			t.Fatalf("Token returned error: %v", err)
+		}
+	}
+	if got := len(recorder.Ended()); got != 1 {
+		t.Fatalf("Expected 1 span while the token is valid, got %d", got)
+	}
+
+	expiring.Expiry = time.Now().Add(-time.Minute)
+	token, err := traced.Token()
+	if err != nil {
+		t.Fatalf("Token returned error: %v", err)
+	}
+	if token.AccessToken != "second" {
+		t.Errorf("Expected the refreshed token, got %s", token.AccessToken)
+	}
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected a span for the refresh, got %d spans", len(spans))
+	}
+	if spans[1].Name() != "oauth2.Token" {
+		t.Errorf("Unexpected span name %s", spans[1].Name())
+	}
+	if source.calls != 4 {
+		t.Errorf("Expected every call to reach the source, got %d calls", source.calls)
+	}
+}
+
+func TestTokenSourceRecordsErrors(t *testing.T) {
+	recorder := recordSpans(t)
+	traced := TokenSource("work", &fakeTokenSource{err: errors.New("invalid_grant")})
+
+	if _, err := traced.Token(); err == nil {
+		t.Fatal("Expected the error of the source")
+	}
+	// A source that failed is traced again on the next try
+	traced.Token()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Status().Code != codes.Error || spans[0].Status().Description != "invalid_grant" {
+		t.Errorf("Expected the span to be marked failed, got %+v", spans[0].Status())
+	}
+}
+
+func TestSetup(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	var out strings.Builder
+	shutdown, err := Setup(context.Background(), ExporterConsole, &out)
+	if err != nil {
+		t.Fatalf("Setup returned error: %v", err)
+	}
+	_, span := tracer().Start(context.Background(), "RunSync")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown returned error: %v", err)
+	}
+	for _, want := range []string{`"Name": "RunSync"`, `"Value": "gcal-busy-blocker"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %s in:\n%s", want, out.String())
+		}
+	}
+
+	if _, err := Setup(context.Background(), "jaeger", &out); err == nil {
+		t.Error("Expected an error for an unknown exporter")
+	}
+	if _, err := Setup(context.Background(), ExporterNone, &out); err != nil {
+		t.Errorf("Setup returned error: %v", err)
+	}
+}
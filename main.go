@@ -1,6 +1,11 @@
 package main
 
-import "github.com/davidpimentel/gcal-busy-blocker/cmd"
+import (
+	// Embed the zone database so --tz works on hosts without one installed
+	_ "time/tzdata"
+
+	"github.com/davidpimentel/gcal-busy-blocker/cmd"
+)
 
 func main() {
 	cmd.Execute()